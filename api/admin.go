@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminEnabled reports whether API requests are authenticated, by API keys
+// or client certificates. Without either, anyone who can reach the API
+// would pass the admin scope check, so the /admin routes are left out.
+func adminEnabled(svc *Services) bool {
+	return svc.Keys.Enabled() || svc.Config.TLSClientCAFile != ""
+}
+
+// reloadHandler applies the reloadable settings on this instance, the same
+// as sending it SIGHUP.
+func reloadHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := svc.Reload(); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+				Error: "failed to reload configuration",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "configuration reloaded",
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/apikey"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+// apiKeyContextKey holds the *apikey.Key a request authenticated with.
+const apiKeyContextKey = "apiKey"
+
+// apiKeys requires API requests to carry one of API_KEYS, in an X-API-Key
+// header or as an Authorization bearer token, with the scope the route
+// needs, and counts them against the key's rate limit: 401 without a valid
+// key, 403 when it lacks the scope and 429 over the limit. Responses carry
+// the X-RateLimit-* headers of the key. It does nothing unless API_KEYS is
+// set.
+func apiKeys(svc *Services) gin.HandlerFunc {
+	if !svc.Keys.Enabled() {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		secret := c.GetHeader("X-API-Key")
+		if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && secret == "" {
+			secret = strings.TrimSpace(bearer)
+		}
+		if secret == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "API key required"})
+			return
+		}
+
+		key, err := svc.Keys.Authenticate(c.Request.Context(), secret)
+		if errors.Is(err, apikey.ErrKeyExpired) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, ErrorResponse{
+				Error:   "failed to verify API key",
+				Details: map[string]string{"reason": err.Error()},
+			})
+			return
+		}
+		if key == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "invalid API key"})
+			return
+		}
+
+		scope := requiredScope(c)
+		if !hasScope(key.Scopes, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{
+				Error:   "API key lacks the required scope",
+				Details: map[string]string{"key": key.ID, "scope": scope},
+			})
+			return
+		}
+
+		// Counting failures let the request through rather than taking the
+		// API down with Redis.
+		window, err := svc.Keys.TakeRequest(c.Request.Context(), key)
+		if err != nil {
+			svc.Logger.Warn("Failed to count API key request", "key", key.ID, "error", err)
+		} else if key.RateLimit > 0 {
+			c.Header("X-RateLimit-Limit", strconv.FormatInt(window.Limit, 10))
+			c.Header("X-RateLimit-Remaining", strconv.FormatInt(*window.Remaining, 10))
+			c.Header("X-RateLimit-Reset", strconv.FormatInt(window.ResetAt.Unix(), 10))
+			if window.Exceeded {
+				c.Header("Retry-After", strconv.Itoa(int(time.Until(window.ResetAt).Seconds())+1))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse{
+					Error:   "rate limit exceeded",
+					Details: map[string]string{"key": key.ID, "resetAt": window.ResetAt.Format(time.RFC3339)},
+				})
+				return
+			}
+		}
+
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// requestKey returns the API key the request authenticated with, or nil
+// when API keys are not in use.
+func requestKey(c *gin.Context) *apikey.Key {
+	if key, ok := c.Get(apiKeyContextKey); ok {
+		return key.(*apikey.Key)
+	}
+	return nil
+}
+
+// enqueue queues task, charging it to the daily quota of the request's API
+// key. An email that is not queued after all, a duplicate included, is
+// given back. It returns apikey.ErrQuotaExceeded when the quota is used up.
+func enqueue(c *gin.Context, svc *Services, task queue.EmailTask) (string, error) {
+	ctx := c.Request.Context()
+	key := requestKey(c)
+	// A broadcast counts once per recipient.
+	sends := int64(max(len(task.Recipients), 1))
+	if key != nil && key.DailyQuota > 0 {
+		if _, err := svc.Keys.TakeQuota(ctx, key, sends); err != nil {
+			return "", err
+		}
+	}
+
+	jobID, err := svc.Queue.EnqueueEmail(ctx, task)
+	if err != nil && key != nil && key.DailyQuota > 0 {
+		if err := svc.Keys.ReturnQuota(context.WithoutCancel(ctx), key, sends); err != nil {
+			svc.Logger.Warn("Failed to return API key quota", "key", key.ID, "error", err)
+		}
+	}
+	return jobID, err
+}
+
+// quotaRejection is the response to a send over its API key's quota.
+func quotaRejection(c *gin.Context) *rejection {
+	return &rejection{
+		status: http.StatusTooManyRequests,
+		response: ErrorResponse{
+			Error:   apikey.ErrQuotaExceeded.Error(),
+			Code:    codeQuotaExceeded,
+			Details: map[string]string{"key": requestKey(c).ID},
+		},
+	}
+}
+
+// keyAllowsTemplate rejects a send of a template outside the request's API
+// key's TEMPLATES with 403.
+func keyAllowsTemplate(c *gin.Context, name string) *rejection {
+	key := requestKey(c)
+	if key == nil || len(key.Templates) == 0 || contains(key.Templates, name) {
+		return nil
+	}
+	return &rejection{
+		status: http.StatusForbidden,
+		response: ErrorResponse{
+			Error:   "API key may not send this template",
+			Code:    codeKeyRestricted,
+			Details: map[string]string{"key": key.ID, "templateName": name},
+		},
+	}
+}
+
+// keyAllowsVariants applies keyAllowsTemplate to the templates of a batch's
+// variants.
+func keyAllowsVariants(c *gin.Context, variants []queue.Variant) *rejection {
+	for _, variant := range variants {
+		if variant.TemplateName == "" {
+			continue
+		}
+		if rejected := keyAllowsTemplate(c, variant.TemplateName); rejected != nil {
+			return rejected
+		}
+	}
+	return nil
+}
+
+// keyAllowsSender rejects a send from an address outside the request's API
+// key's SENDERS with 403. A send without from uses EMAIL_SENDER_ADDRESS,
+// which the key must then allow too.
+func keyAllowsSender(c *gin.Context, svc *Services, from string) *rejection {
+	key := requestKey(c)
+	if key == nil || len(key.Senders) == 0 {
+		return nil
+	}
+	if from == "" {
+		from = svc.Config.EmailSenderAddress
+	}
+	if matchesSender(key.Senders, from) {
+		return nil
+	}
+	return &rejection{
+		status: http.StatusForbidden,
+		response: ErrorResponse{
+			Error:   "API key may not send from this address",
+			Code:    codeKeyRestricted,
+			Details: map[string]string{"key": key.ID, "from": from},
+		},
+	}
+}
+
+// keyUsageHandler reports the calling key's request rate and daily quota:
+// what it used, what remains and when each resets.
+func keyUsageHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := requestKey(c)
+		if key == nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "API keys are not enabled"})
+			return
+		}
+
+		usage, err := svc.Keys.Usage(c.Request.Context(), key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "failed to load API key usage",
+				Details: map[string]string{"reason": err.Error()},
+			})
+			return
+		}
+		c.JSON(http.StatusOK, usage)
+	}
+}
+
+// CreateKeyRequest describes a managed API key. Scopes default to read and
+// write; a key without expiresAt does not expire, and one without templates
+// or senders may send any.
+type CreateKeyRequest struct {
+	Label      string     `json:"label" binding:"required,max=100"`
+	Tenant     string     `json:"tenant,omitempty" binding:"omitempty,max=100"`
+	Scopes     []string   `json:"scopes,omitempty" binding:"omitempty,max=7,dive,oneof=read write send bulk templates:write admin *"`
+	RateLimit  int64      `json:"rateLimit,omitempty" binding:"min=0"`
+	DailyQuota int64      `json:"dailyQuota,omitempty" binding:"min=0"`
+	Templates  []string   `json:"templates,omitempty" binding:"omitempty,max=100,dive,min=1,max=50"`
+	Senders    []string   `json:"senders,omitempty" binding:"omitempty,max=100,dive,min=2,max=254,contains=@"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+// RotateKeyRequest sets how long the previous secret keeps working, as a
+// duration such as "24h"; without it the previous secret stops at once.
+type RotateKeyRequest struct {
+	GracePeriod string `json:"gracePeriod,omitempty"`
+}
+
+// maxRotationGrace bounds how long a rotated-out secret keeps working.
+const maxRotationGrace = 30 * 24 * time.Hour
+
+// createKeyHandler creates a managed API key. The response is the only time
+// its secret is shown.
+func createKeyHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid API key request",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid API key request",
+				Details: map[string]string{"expiresAt": "must be in the future"},
+			})
+			return
+		}
+		// An admin bound to a tenant only creates keys of its own tenant.
+		tenant := strings.TrimSpace(req.Tenant)
+		if caller := requestTenant(c); caller != "" {
+			if tenant != "" && tenant != caller {
+				c.JSON(http.StatusForbidden, ErrorResponse{
+					Error:   "may not create API keys of another tenant",
+					Details: map[string]string{"tenant": tenant},
+				})
+				return
+			}
+			tenant = caller
+		}
+
+		record := apikey.Record{
+			Label:      strings.TrimSpace(req.Label),
+			Tenant:     tenant,
+			Scopes:     req.Scopes,
+			RateLimit:  req.RateLimit,
+			DailyQuota: req.DailyQuota,
+			Templates:  req.Templates,
+			Senders:    req.Senders,
+			ExpiresAt:  req.ExpiresAt,
+		}
+		if len(record.Scopes) == 0 {
+			record.Scopes = []string{scopeRead, scopeWrite}
+		}
+
+		created, secret, err := svc.Keys.Create(c.Request.Context(), record)
+		if err != nil {
+			keyError(c, "failed to create API key", err)
+			return
+		}
+		svc.Logger.Info("API key created", "id", created.ID, "label", created.Label, "by", requestKey(c).ID)
+		c.JSON(http.StatusCreated, gin.H{"key": created, "secret": secret})
+	}
+}
+
+// listKeysHandler lists the managed API keys of the request's tenant, or
+// all of them without one, without their secrets. Keys from API_KEYS are
+// not listed.
+func listKeysHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys, err := svc.Keys.List(c.Request.Context(), requestTenant(c))
+		if err != nil {
+			keyError(c, "failed to list API keys", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"keys": keys})
+	}
+}
+
+// rotateKeyHandler gives a managed key a new secret, returned once. Keys of
+// another tenant than the request's are not found.
+func rotateKeyHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RotateKeyRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, ErrorResponse{
+					Error:   "invalid API key request",
+					Details: map[string]string{"message": err.Error()},
+				})
+				return
+			}
+		}
+
+		var grace time.Duration
+		if req.GracePeriod != "" {
+			var err error
+			grace, err = time.ParseDuration(req.GracePeriod)
+			if err != nil || grace < 0 || grace > maxRotationGrace {
+				c.JSON(http.StatusBadRequest, ErrorResponse{
+					Error:   "invalid API key request",
+					Details: map[string]string{"gracePeriod": "must be a duration such as 24h, at most 720h"},
+				})
+				return
+			}
+		}
+
+		rotated, secret, err := svc.Keys.Rotate(c.Request.Context(), c.Param("id"), requestTenant(c), grace)
+		if err != nil {
+			keyError(c, "failed to rotate API key", err)
+			return
+		}
+		svc.Logger.Info("API key rotated", "id", rotated.ID, "grace", grace, "by", requestKey(c).ID)
+		c.JSON(http.StatusOK, gin.H{"key": rotated, "secret": secret})
+	}
+}
+
+// revokeKeyHandler stops a managed key from authenticating at once. Keys of
+// another tenant than the request's are not found.
+func revokeKeyHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revoked, err := svc.Keys.Revoke(c.Request.Context(), c.Param("id"), requestTenant(c))
+		if err != nil {
+			keyError(c, "failed to revoke API key", err)
+			return
+		}
+		svc.Logger.Info("API key revoked", "id", revoked.ID, "by", requestKey(c).ID)
+		c.JSON(http.StatusOK, gin.H{"key": revoked})
+	}
+}
+
+func keyError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, apikey.ErrKeyNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, apikey.ErrKeyRevoked):
+		c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: message,
+			Details: map[string]string{
+				"reason": err.Error(),
+			},
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+// heldEmailsHandler lists the emails held for approval by APPROVAL_RULES,
+// newest first, filtered by template, recipient domain, tag, metadata and
+// the time they were held.
+func heldEmailsHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := bindListQuery(c, []string{"template", "domain", "tag", "metadata", "from", "until", "sort"})
+		if !ok {
+			return
+		}
+
+		held, next, err := svc.Queue.HeldEmails(c.Request.Context(), query)
+		if err != nil {
+			pageError(c, "failed to list held emails", err)
+			return
+		}
+		respondPage(c, "held", held, next)
+	}
+}
+
+// checkHeldTenant returns queue.ErrNotHeld when held job id belongs to
+// another tenant than the request's, so its existence is not confirmed.
+func checkHeldTenant(c *gin.Context, svc *Services, id string) error {
+	if requestTenant(c) == "" {
+		return nil
+	}
+	entry, err := svc.Queue.HeldEmail(c.Request.Context(), id)
+	if err != nil {
+		return err
+	}
+	if !sameTenant(c, entry.Task.Tenant) {
+		return queue.ErrNotHeld
+	}
+	return nil
+}
+
+// approveHeldHandler releases a held email to its queue. Approving an email
+// held as a new template or as part of a large batch approves the template
+// or batch, and releases the other emails held for it, of the request's
+// tenant when it has one.
+func approveHeldHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		err := checkHeldTenant(c, svc, id)
+		released := 0
+		if err == nil {
+			released, err = svc.Queue.ApproveHeld(c.Request.Context(), id, requestTenant(c))
+		}
+		if errors.Is(err, queue.ErrNotHeld) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error(), Details: map[string]string{"id": id}})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to approve held email",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"id": id, "approved": true, "released": released})
+	}
+}
+
+// rejectHeldHandler drops a held email, recording it as canceled.
+func rejectHeldHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		err := checkHeldTenant(c, svc, id)
+		if err == nil {
+			err = svc.Queue.RejectHeld(c.Request.Context(), id)
+		}
+		if errors.Is(err, queue.ErrNotHeld) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error(), Details: map[string]string{"id": id}})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to reject held email",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"id": id, "rejected": true})
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/assets"
+)
+
+func assetHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("path")
+
+		if svc.Assets.Signed() {
+			if err := svc.Assets.Verify(name, c.Query("expires"), c.Query("sig")); err != nil {
+				if errors.Is(err, assets.ErrNotFound) {
+					c.JSON(http.StatusNotFound, ErrorResponse{Error: "asset not found"})
+					return
+				}
+				c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+				return
+			}
+		}
+
+		content, contentType, err := svc.Assets.Load(c.Request.Context(), name)
+		if errors.Is(err, assets.ErrNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "asset not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadGateway, ErrorResponse{
+				Error: "failed to load asset",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		sum := sha256.Sum256(content)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(svc.Assets.MaxAge().Seconds())))
+
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.Data(http.StatusOK, contentType, content)
+	}
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// runInBackground runs fn once the handler has returned, with a copy of c
+// whose request context is the server's instead of the request's. The work
+// outlives the response but is canceled at shutdown, which waits for it
+// through svc.Background.
+func runInBackground(c *gin.Context, svc *Services, fn func(c *gin.Context)) {
+	background := c.Copy()
+	background.Request = c.Request.Clone(svc.Context)
+
+	svc.Background.Add(1)
+	go func() {
+		defer svc.Background.Done()
+		fn(background)
+	}()
+}
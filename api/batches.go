@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+// checkBatchTenant returns queue.ErrBatchNotFound when batch id belongs to
+// another tenant than the request's, so its existence is not confirmed.
+func checkBatchTenant(c *gin.Context, svc *Services, id string) error {
+	if requestTenant(c) == "" {
+		return nil
+	}
+	progress, err := svc.Queue.BatchProgress(c.Request.Context(), id)
+	if err != nil {
+		return err
+	}
+	if !sameTenant(c, progress.Tenant) {
+		return queue.ErrBatchNotFound
+	}
+	return nil
+}
+
+func batchProgressHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		progress, err := svc.Queue.BatchProgress(c.Request.Context(), c.Param("id"))
+		if err == nil && !sameTenant(c, progress.Tenant) {
+			err = queue.ErrBatchNotFound
+		}
+		if errors.Is(err, queue.ErrBatchNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to load batch progress",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, progress)
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/apikey"
+	templates "github.com/sarthakyeole/redis-go-mailing-bulk/internal/emailTemplate"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+// BroadcastRequest is a send whose identical message goes to every address
+// in Recipients. To is only shown in the header, e.g. the list's own
+// address; the recipients don't see each other.
+type BroadcastRequest struct {
+	SendEmailRequest
+	Recipients []string `json:"recipients" binding:"required,min=1,max=1000" validate:"required,min=1,max=1000,dive,email"`
+}
+
+// broadcastHandler queues a broadcast as a single task, delivered in SMTP
+// transactions of up to EMAIL_SMTP_RCPT_BATCH_SIZE recipients each instead
+// of one per recipient. Recipients that fail the recipient checks are left
+// out and reported; the rest must share the rendered content, so tracking
+// links and calendar invites are not available, and unsubscribe links need
+// EMAIL_UNSUBSCRIBE_MAILTO.
+func broadcastHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BroadcastRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid broadcast request",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+		if req.IdempotencyKey == "" {
+			req.IdempotencyKey = c.GetHeader("Idempotency-Key")
+		}
+		if req.Event != nil {
+			rejected := validationRejection(http.StatusBadRequest, codeValidationFailed, "Event", "calendar invites name one attendee and cannot be broadcast")
+			c.JSON(rejected.status, rejected.response)
+			return
+		}
+		if err := validateRequest(&req); err != nil {
+			var invalid *ValidationError
+			if errors.As(err, &invalid) {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: "validation failed", Code: codeValidationFailed, Details: invalid.Errors})
+			} else {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: codeValidationFailed})
+			}
+			return
+		}
+
+		task, rejected := prepareTask(c, svc, &req.SendEmailRequest)
+		if rejected == nil {
+			rejected = checkBroadcastOptOut(svc, task)
+		}
+		if rejected != nil {
+			c.JSON(rejected.status, rejected.response)
+			return
+		}
+
+		skipped := []ItemResult{}
+		seen := make(map[string]struct{}, len(req.Recipients))
+		for _, address := range req.Recipients {
+			address = strings.TrimSpace(address)
+			if _, dup := seen[strings.ToLower(address)]; dup {
+				continue
+			}
+			seen[strings.ToLower(address)] = struct{}{}
+
+			if _, err := svc.Recipients.Validate(c.Request.Context(), address, task.Queue, task.Category); err != nil {
+				skipped = append(skipped, ItemResult{To: address, Status: itemRejected, Code: recipientCode(err), Error: err.Error()})
+				continue
+			}
+			task.Recipients = append(task.Recipients, address)
+		}
+		if len(task.Recipients) == 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":   "no recipient passed the recipient checks",
+				"code":    codeInvalidRecipient,
+				"skipped": skipped,
+			})
+			return
+		}
+
+		jobID, err := enqueue(c, svc, task)
+		if errors.Is(err, apikey.ErrQuotaExceeded) {
+			rejected := quotaRejection(c)
+			c.JSON(rejected.status, rejected.response)
+			return
+		}
+		if errors.Is(err, queue.ErrDuplicateTask) {
+			c.JSON(http.StatusOK, gin.H{
+				"message": "broadcast was already queued",
+				"details": gin.H{
+					"jobId":     jobID,
+					"duplicate": true,
+				},
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "failed to queue broadcast",
+				Details: map[string]string{"reason": err.Error()},
+			})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "broadcast was successfully added to the queue",
+			"details": gin.H{
+				"jobId":      jobID,
+				"to":         task.To,
+				"subject":    task.Subject,
+				"queue":      task.Queue,
+				"recipients": len(task.Recipients),
+				"skipped":    skipped,
+			},
+		})
+	}
+}
+
+// checkBroadcastOptOut rejects a broadcast whose content links to the
+// unsubscribe page while EMAIL_UNSUBSCRIBE_MAILTO is unset: the link cannot
+// be signed for every recipient, and there would be no List-Unsubscribe
+// header to fall back on.
+func checkBroadcastOptOut(svc *Services, task queue.EmailTask) *rejection {
+	if svc.Config.EmailUnsubscribeMailto != "" {
+		return nil
+	}
+	body := task.Body
+	if body == "" {
+		rendered, err := svc.Templates.RenderWithSafeURLs(task.TemplateName, task.Data, templates.WithLocale(task.Locale))
+		if err != nil {
+			return validationRejection(http.StatusUnprocessableEntity, codeRenderFailed, "TemplateName", err.Error())
+		}
+		body = rendered
+	}
+	if strings.Contains(body, templates.UnsubscribePlaceholder) {
+		return validationRejection(http.StatusUnprocessableEntity, codeValidationFailed, "TemplateName", "broadcasts can only offer the unsubscribe link with EMAIL_UNSUBSCRIBE_MAILTO set")
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+type RescheduleCampaignRequest struct {
+	SendAt time.Time `json:"sendAt" binding:"required"`
+}
+
+// campaignVisible checks that the campaign in the path belongs to the
+// request's tenant, answering 404 when it does not, or 500 when the check
+// failed.
+func campaignVisible(c *gin.Context, svc *Services) bool {
+	err := checkBatchTenant(c, svc, c.Param("id"))
+	if errors.Is(err, queue.ErrBatchNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "campaign not found"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "failed to load campaign",
+			Details: map[string]string{
+				"reason": err.Error(),
+			},
+		})
+		return false
+	}
+	return true
+}
+
+// campaignAnalyticsHandler returns the engagement rollup of a campaign,
+// identified by the batchId its bulk requests were sent with.
+func campaignAnalyticsHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !campaignVisible(c, svc) {
+			return
+		}
+		analytics, err := svc.Stats.Campaign(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to load campaign analytics",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+		if analytics == nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "campaign not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, analytics)
+	}
+}
+
+// cancelCampaignHandler drops the sends of a campaign that are still queued
+// or waiting for a retry.
+func cancelCampaignHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !campaignVisible(c, svc) {
+			return
+		}
+		removed, err := svc.Queue.CancelBatch(c.Request.Context(), c.Param("id"))
+		if errors.Is(err, queue.ErrBatchNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "campaign not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to cancel campaign",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "canceled": removed})
+	}
+}
+
+// rescheduleCampaignHandler moves the remaining sends of a campaign to a new
+// send time.
+func rescheduleCampaignHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RescheduleCampaignRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid reschedule request",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+		if !req.SendAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid reschedule request",
+				Details: map[string]string{"sendAt": "must be in the future"},
+			})
+			return
+		}
+		if !campaignVisible(c, svc) {
+			return
+		}
+
+		moved, err := svc.Queue.RescheduleBatch(c.Request.Context(), c.Param("id"), req.SendAt)
+		switch {
+		case errors.Is(err, queue.ErrBatchNotFound):
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "campaign not found"})
+			return
+		case errors.Is(err, queue.ErrBatchCanceled):
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to reschedule campaign",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "rescheduled": moved, "sendAt": req.SendAt.UTC()})
+	}
+}
+
+// campaignsHandler lists batches, newest first, filtered by status
+// (queueing, running, completed or canceled) and the time their first
+// email was queued.
+func campaignsHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := bindListQuery(c, []string{"status", "from", "until", "sort"},
+			queue.BatchQueueing, queue.BatchRunning, queue.BatchCompleted, queue.BatchCanceled)
+		if !ok {
+			return
+		}
+
+		campaigns, next, err := svc.Queue.Campaigns(c.Request.Context(), query)
+		if err != nil {
+			pageError(c, "failed to list campaigns", err)
+			return
+		}
+		respondPage(c, "campaigns", campaigns, next)
+	}
+}
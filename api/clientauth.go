@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+)
+
+// clientContextKey holds the ClientIdentity of a request authenticated
+// with a client certificate.
+const clientContextKey = "client"
+
+// Client scopes. GET requests need read, /admin routes admin, the routes
+// in routeScopes their own scope and every other request write; write
+// also grants the scopes of routeScopes, and * grants all of them.
+const (
+	scopeRead           = "read"
+	scopeWrite          = "write"
+	scopeSend           = "send"
+	scopeBulk           = "bulk"
+	scopeTemplatesWrite = "templates:write"
+	scopeAdmin          = "admin"
+	scopeAll            = "*"
+)
+
+// routeScopes are the narrower scopes of the routes that send email or
+// change templates, keyed by method and route below the API prefix, so a
+// key can be limited to sending.
+var routeScopes = map[string]string{
+	"POST /send":                      scopeSend,
+	"POST /broadcast":                 scopeBulk,
+	"POST /bulk-send":                 scopeBulk,
+	"POST /bulk-send/stream":          scopeBulk,
+	"POST /lists/:id/send":            scopeBulk,
+	"POST /templates/import":          scopeTemplatesWrite,
+	"POST /templates/preview":         scopeTemplatesWrite,
+	"POST /templates/:name/test-send": scopeTemplatesWrite,
+}
+
+// ClientIdentity is the configured client a request's certificate maps to.
+type ClientIdentity struct {
+	Name   string
+	Tenant string
+	Scopes []string
+}
+
+// clientCertificates requires API requests to present a client certificate
+// that verified against TLS_CLIENT_CA_FILE and maps to one of TLS_CLIENTS,
+// with the scope the route needs: 401 without a certificate, 403 when it
+// maps to no client or the client lacks the scope. It does nothing unless
+// TLS_CLIENT_CA_FILE is set.
+func clientCertificates(cfg *config.ApplicationConfig) gin.HandlerFunc {
+	if cfg.TLSClientCAFile == "" {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	clients := make(map[string]*ClientIdentity)
+	for _, client := range cfg.TLSClients {
+		identity := &ClientIdentity{Name: client.Name, Tenant: client.Tenant, Scopes: client.Scopes}
+		for _, subject := range client.Subjects {
+			clients[strings.ToLower(subject)] = identity
+		}
+	}
+
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "client certificate required"})
+			return
+		}
+
+		leaf := c.Request.TLS.VerifiedChains[0][0]
+		var identity *ClientIdentity
+		for _, name := range certificateNames(leaf) {
+			if identity = clients[strings.ToLower(name)]; identity != nil {
+				break
+			}
+		}
+		if identity == nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{
+				Error:   "client certificate is not authorized",
+				Details: map[string]string{"subject": leaf.Subject.CommonName},
+			})
+			return
+		}
+
+		scope := requiredScope(c)
+		if !hasScope(identity.Scopes, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{
+				Error:   "client lacks the required scope",
+				Details: map[string]string{"client": identity.Name, "scope": scope},
+			})
+			return
+		}
+
+		c.Set(clientContextKey, identity)
+		c.Next()
+	}
+}
+
+// certificateNames returns the names a client certificate can be matched
+// on: its common name and DNS, email and URI SANs.
+func certificateNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// requiredScope returns the scope the matched route needs.
+func requiredScope(c *gin.Context) string {
+	route := strings.TrimPrefix(strings.TrimPrefix(c.FullPath(), "/api"), "/v1")
+	if scope, ok := routeScopes[c.Request.Method+" "+route]; ok {
+		return scope
+	}
+	switch {
+	case strings.HasPrefix(route, "/admin/"):
+		return scopeAdmin
+	case c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead:
+		return scopeRead
+	default:
+		return scopeWrite
+	}
+}
+
+// hasScope reports whether scopes grant scope.
+func hasScope(scopes []string, scope string) bool {
+	if contains(scopes, scope) || contains(scopes, scopeAll) {
+		return true
+	}
+	switch scope {
+	case scopeSend, scopeBulk, scopeTemplatesWrite:
+		return contains(scopes, scopeWrite)
+	}
+	return false
+}
+
+// requestTenant returns the tenant of the request's client certificate,
+// else of its API key, or "" when neither is in use.
+func requestTenant(c *gin.Context) string {
+	if identity, ok := c.Get(clientContextKey); ok {
+		return identity.(*ClientIdentity).Tenant
+	}
+	if key := requestKey(c); key != nil {
+		return key.Tenant
+	}
+	return ""
+}
+
+// sameTenant reports whether a job, batch or event of tenant is visible to
+// the request: a request without a tenant sees every tenant's, one with a
+// tenant only its own.
+func sameTenant(c *gin.Context, tenant string) bool {
+	caller := requestTenant(c)
+	return caller == "" || caller == tenant
+}
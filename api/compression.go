@@ -0,0 +1,133 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// minGzipResponseBytes is the smallest JSON response worth compressing.
+	minGzipResponseBytes = 1024
+
+	// maxBulkRequestBytes bounds the bodies of bulk sends and contact
+	// additions, which are bound as JSON in memory. Their attachments are
+	// URLs, so even 1000 contacts stay well below it.
+	maxBulkRequestBytes = 4 << 20
+
+	// maxStreamRequestBytes bounds a streaming bulk send. Its lines are
+	// read one at a time, so only maxStreamLineBytes is held in memory.
+	maxStreamRequestBytes = 1 << 30
+)
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+// gzipRequestBody decompresses request bodies sent with
+// Content-Encoding: gzip, and limits bodies to maxBytes, counted after
+// decompression so a small upload cannot inflate past the route's limit.
+// It is used on the bulk endpoints, whose bodies can be large.
+func gzipRequestBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.EqualFold(strings.TrimSpace(c.GetHeader("Content-Encoding")), "gzip") {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+			c.Next()
+			return
+		}
+
+		body, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid gzip request body",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+		defer body.Close()
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, body, maxBytes)
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Del("Content-Length")
+		c.Request.ContentLength = -1
+		c.Next()
+	}
+}
+
+// gzipResponses compresses JSON responses of at least
+// minGzipResponseBytes for clients that accept gzip. Handlers write a JSON
+// response in one call, so its first write decides; streamed responses,
+// whose lines are written one at a time, are left alone.
+func gzipResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		c.Next()
+
+		if writer.gz != nil {
+			writer.gz.Close()
+			gzipWriters.Put(writer.gz)
+		}
+	}
+}
+
+func acceptsGzip(header string) bool {
+	for _, encoding := range strings.Split(header, ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(encoding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		header := w.Header()
+		if len(data) >= minGzipResponseBytes && header.Get("Content-Encoding") == "" &&
+			strings.HasPrefix(header.Get("Content-Type"), "application/json") {
+			header.Set("Content-Encoding", "gzip")
+			header.Del("Content-Length")
+			w.gz = gzipWriters.Get().(*gzip.Writer)
+			w.gz.Reset(w.ResponseWriter)
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
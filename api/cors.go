@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+)
+
+// corsMiddleware applies the CORS_* settings. Allowed origins are "*", an
+// exact origin such as https://app.example.com, or https://*.example.com for
+// any subdomain. Preflight requests from other origins are refused with 403;
+// other requests from them get no CORS headers, so browsers block the
+// response. With CORS_ENABLED=false no CORS headers are sent at all.
+func corsMiddleware(cfg *config.ApplicationConfig) (gin.HandlerFunc, error) {
+	if !cfg.CORSEnabled {
+		return func(c *gin.Context) { c.Next() }, nil
+	}
+
+	anyOrigin := false
+	for _, origin := range cfg.CORSAllowedOrigins {
+		if origin == "*" {
+			anyOrigin = true
+			continue
+		}
+		parsed, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Path != "" || parsed.RawQuery != "" {
+			return nil, fmt.Errorf("invalid CORS_ALLOWED_ORIGINS entry %q: expected scheme://host[:port]", origin)
+		}
+	}
+	if anyOrigin && cfg.CORSAllowCredentials {
+		return nil, fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS=*; list the origins instead")
+	}
+	if cfg.CORSMaxAge < 0 {
+		return nil, fmt.Errorf("CORS_MAX_AGE must not be negative")
+	}
+
+	methods := strings.Join(cfg.CORSAllowedMethods, ", ")
+	headers := strings.Join(cfg.CORSAllowedHeaders, ", ")
+	exposed := strings.Join(cfg.CORSExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.CORSMaxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		if !anyOrigin {
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		if !anyOrigin && !originAllowed(cfg.CORSAllowedOrigins, origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if anyOrigin {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.CORSAllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			c.Header("Access-Control-Allow-Methods", methods)
+			c.Header("Access-Control-Allow-Headers", headers)
+			c.Header("Access-Control-Max-Age", maxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		if exposed != "" {
+			c.Header("Access-Control-Expose-Headers", exposed)
+		}
+		c.Next()
+	}, nil
+}
+
+// originAllowed reports whether origin matches an allowed origin exactly or,
+// for https://*.example.com, is a subdomain of example.com over the same
+// scheme.
+func originAllowed(allowed []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, candidate := range allowed {
+		candidate = strings.ToLower(candidate)
+		if candidate == origin {
+			return true
+		}
+		scheme, domain, ok := strings.Cut(candidate, "://*.")
+		if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
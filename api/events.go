@@ -0,0 +1,62 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const eventStreamHeartbeat = 15 * time.Second
+
+// eventStreamHandler pushes job lifecycle events as Server-Sent Events.
+// ?type=sent,failed and ?jobId=... narrow the stream. A request with a
+// tenant only gets the events of that tenant's jobs.
+func eventStreamHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		types := make(map[string]struct{})
+		for _, eventType := range strings.Split(c.Query("type"), ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				types[eventType] = struct{}{}
+			}
+		}
+		jobID := c.Query("jobId")
+
+		stream, unsubscribe := svc.Hub.Subscribe()
+		defer unsubscribe()
+
+		heartbeat := time.NewTicker(eventStreamHeartbeat)
+		defer heartbeat.Stop()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-heartbeat.C:
+				io.WriteString(w, ": heartbeat\n\n")
+				return true
+			case event, ok := <-stream:
+				if !ok {
+					return false
+				}
+				if _, wanted := types[event.Type]; len(types) > 0 && !wanted {
+					return true
+				}
+				if jobID != "" && event.JobID != jobID || !sameTenant(c, event.Tenant) {
+					return true
+				}
+				c.SSEvent(event.Type, event)
+				return true
+			}
+		})
+	}
+}
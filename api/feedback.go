@@ -0,0 +1,257 @@
+package api
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/feedback"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+	recipient "github.com/sarthakyeole/redis-go-mailing-bulk/internal/recipientCheck"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+// maxFeedbackBody bounds webhook payloads; ARF reports may carry the whole
+// original message.
+const maxFeedbackBody = 10 << 20
+
+var complaintsReceived = metrics.NewCounter(
+	"mailqueue_complaints_total",
+	"Spam complaints received by source.",
+	"source",
+)
+
+// feedbackTokenRequired rejects requests whose token query parameter does
+// not match FEEDBACK_WEBHOOK_TOKEN. SES and ARF forwarders cannot sign their
+// requests, so the token goes in the subscribed URL.
+func feedbackTokenRequired(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given := c.Query("token")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(svc.Config.FeedbackWebhookToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "invalid webhook token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// sesFeedbackHandler accepts SES notifications and subscription
+// confirmations delivered by SNS, after checking the SNS signature.
+func sesFeedbackHandler(svc *Services, verifier *feedback.SNSVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, ok := readFeedbackBody(c)
+		if !ok {
+			return
+		}
+
+		if err := verifier.Verify(c.Request.Context(), body); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, feedback.ErrInvalidSignature) {
+				status = http.StatusUnauthorized
+			}
+			c.JSON(status, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		reports, subscribeURL, err := feedback.ParseSES(body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		if subscribeURL != "" {
+			if err := confirmSubscription(c, subscribeURL); err != nil {
+				c.JSON(http.StatusBadGateway, ErrorResponse{
+					Error: "failed to confirm SNS subscription",
+					Details: map[string]string{
+						"reason": err.Error(),
+					},
+				})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"confirmed": true})
+			return
+		}
+
+		processFeedback(c, svc, reports)
+	}
+}
+
+func mailgunFeedbackHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, ok := readFeedbackBody(c)
+		if !ok {
+			return
+		}
+
+		reports, token, err := feedback.ParseMailgun(body, svc.Config.MailgunWebhookSigningKey)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, feedback.ErrInvalidSignature) || errors.Is(err, feedback.ErrStaleSignature) {
+				status = http.StatusUnauthorized
+			}
+			c.JSON(status, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		// Tokens are kept twice as long as timestamps are accepted, so
+		// a replay within the window always finds its token.
+		ctx := c.Request.Context()
+		first, err := svc.Queue.ClaimWebhookToken(ctx, feedback.SourceMailgun, token, 2*feedback.MaxSignatureAge)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to record webhook",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+		if !first {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "webhook token was already used"})
+			return
+		}
+
+		if !processFeedback(c, svc, reports) {
+			if err := svc.Queue.ReleaseWebhookToken(ctx, feedback.SourceMailgun, token); err != nil {
+				svc.Logger.Error("failed to release Mailgun webhook token", "error", err)
+			}
+		}
+	}
+}
+
+// arfFeedbackHandler accepts a raw ARF email, as forwarded by a mailbox
+// rule or a mail-to-webhook relay receiving a provider's feedback loop.
+func arfFeedbackHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, ok := readFeedbackBody(c)
+		if !ok {
+			return
+		}
+
+		report, err := feedback.ParseARF(bytes.NewReader(body))
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, feedback.ErrNoRecipient) {
+				status = http.StatusUnprocessableEntity
+			}
+			c.JSON(status, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		processFeedback(c, svc, []feedback.Report{*report})
+	}
+}
+
+func readFeedbackBody(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxFeedbackBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "failed to read request body"})
+		return nil, false
+	}
+	return body, true
+}
+
+// confirmSubscription visits the SubscribeURL of an SNS subscription
+// confirmation. Only SNS endpoints are followed.
+func confirmSubscription(c *gin.Context, subscribeURL string) error {
+	parsed, err := url.Parse(subscribeURL)
+	if err != nil || parsed.Scheme != "https" || !strings.HasSuffix(parsed.Hostname(), ".amazonaws.com") {
+		return errors.New("subscribe URL is not an SNS endpoint")
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// processFeedback marks each reported job as complained or bounced and
+// publishes the event. Complaints suppress the recipient here; bounces are
+// counted towards suppression by the recipient.BounceTracker fed by the
+// event. A report without a recipient falls back to the one recorded on its
+// job. It reports whether every report was recorded.
+func processFeedback(c *gin.Context, svc *Services, reports []feedback.Report) bool {
+	ctx := c.Request.Context()
+
+	processed := 0
+	for _, report := range reports {
+		event := events.Event{
+			Type:      events.TypeComplained,
+			JobID:     report.JobID,
+			Recipient: report.Recipient,
+			Timestamp: time.Now().UTC(),
+		}
+		if report.Kind == feedback.KindBounce {
+			event.Type = events.TypeBounced
+			event.Bounce = report.Bounce
+			event.Error = report.Detail
+		}
+
+		if report.JobID != "" {
+			status, err := svc.Queue.MarkJob(ctx, report.JobID, event.Type)
+			if err != nil && !errors.Is(err, queue.ErrJobNotFound) {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error: "failed to record feedback",
+					Details: map[string]string{
+						"reason": err.Error(),
+					},
+				})
+				return false
+			}
+			if status != nil {
+				if event.Recipient == "" {
+					event.Recipient = status.Recipient
+				}
+				event.Subject = status.Subject
+				event.Template = status.Template
+				event.Queue = status.Queue
+				event.BatchID = status.BatchID
+				event.Tenant = status.Tenant
+				event.Tags = status.Tags
+				event.Metadata = status.Metadata
+			}
+		}
+
+		if event.Recipient == "" {
+			continue
+		}
+
+		if report.Kind == feedback.KindComplaint {
+			if err := svc.Recipients.Suppress(ctx, event.Recipient, recipient.SuppressionComplaint); err != nil {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error: "failed to suppress recipient",
+					Details: map[string]string{
+						"reason": err.Error(),
+					},
+				})
+				return false
+			}
+			complaintsReceived.Inc(report.Source)
+		}
+
+		if svc.Events != nil {
+			svc.Events.Publish(ctx, event)
+		}
+		processed++
+	}
+
+	c.JSON(http.StatusOK, gin.H{"processed": processed})
+	return true
+}
@@ -1,71 +1,292 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"regexp"
+	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/apikey"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/assets"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/contacts"
+	templates "github.com/sarthakyeole/redis-go-mailing-bulk/internal/emailTemplate"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/feedback"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+	storage "github.com/sarthakyeole/redis-go-mailing-bulk/internal/objectStore"
+	recipient "github.com/sarthakyeole/redis-go-mailing-bulk/internal/recipientCheck"
 	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+	email "github.com/sarthakyeole/redis-go-mailing-bulk/internal/senderSide"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/stats"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/token"
 )
 
 var validate = validator.New()
 
+// metadataKeyPattern limits metadata keys to characters that are valid in
+// the header names some providers carry them in.
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// poolPattern limits pool names to characters every provider accepts in its
+// pool, stream or configuration set names.
+var poolPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 type ErrorResponse struct {
 	Error     string            `json:"error"`
+	Code      string            `json:"code,omitempty"`
 	Details   map[string]string `json:"details,omitempty"`
 	RequestID string            `json:"requestId,omitempty"`
 }
 
 type SendEmailRequest struct {
-	To           string                 `json:"to" binding:"required,email" validate:"required,email"`
-	Subject      string                 `json:"subject" binding:"required" validate:"required,min=1,max=200"`
-	TemplateName string                 `json:"templateName" binding:"required" validate:"required,min=1,max=50"`
-	Data         map[string]interface{} `json:"data" binding:"required" validate:"required"`
+	To             string                 `json:"to" binding:"required,email" validate:"required,email"`
+	From           string                 `json:"from,omitempty" validate:"omitempty,email,max=254"`
+	FromName       string                 `json:"fromName,omitempty" validate:"omitempty,max=100"`
+	Subject        string                 `json:"subject" binding:"required" validate:"required,min=1,max=200"`
+	TemplateName   string                 `json:"templateName" binding:"required" validate:"required,min=1,max=50"`
+	Data           map[string]interface{} `json:"data" binding:"required" validate:"required"`
+	Queue          string                 `json:"queue,omitempty" validate:"omitempty,max=50"`
+	Attachments    []AttachmentRequest    `json:"attachments,omitempty" validate:"omitempty,max=10,dive"`
+	Event          *EventRequest          `json:"event,omitempty" validate:"omitempty"`
+	Preheader      string                 `json:"preheader,omitempty" validate:"omitempty,max=250"`
+	Locale         string                 `json:"locale,omitempty" validate:"omitempty,bcp47_language_tag"`
+	IdempotencyKey string                 `json:"idempotencyKey,omitempty" validate:"omitempty,max=255,printascii"`
+	InReplyTo      string                 `json:"inReplyTo,omitempty" validate:"omitempty,max=998,printascii"`
+	References     []string               `json:"references,omitempty" validate:"omitempty,max=50,dive,max=998,printascii"`
+	UTM            *UTMRequest            `json:"utm,omitempty" validate:"omitempty"`
+	Category       string                 `json:"category,omitempty" validate:"omitempty,max=50"`
+	Tags           []string               `json:"tags,omitempty" validate:"omitempty,max=10,dive,min=1,max=50,printascii"`
+	Metadata       map[string]string      `json:"metadata,omitempty" validate:"omitempty,max=20,dive,keys,min=1,max=50,endkeys,max=500,printascii"`
+	Pool           string                 `json:"pool,omitempty" validate:"omitempty,max=64"`
+	Priority       string                 `json:"priority,omitempty" validate:"omitempty,oneof=high normal low"`
+	SendAt         *time.Time             `json:"sendAt,omitempty"`
+	ExpiresAt      *time.Time             `json:"expiresAt,omitempty"`
 }
 
-func RegisterHandlers(router *gin.Engine, redisQueue *queue.RedisQueue) {
-	router.Use(corsMiddleware())
+type EventRequest struct {
+	UID           string    `json:"uid,omitempty" validate:"omitempty,max=255"`
+	Title         string    `json:"title" validate:"required,min=1,max=200"`
+	Description   string    `json:"description,omitempty" validate:"omitempty,max=2000"`
+	Location      string    `json:"location,omitempty" validate:"omitempty,max=200"`
+	Start         time.Time `json:"start" validate:"required"`
+	End           time.Time `json:"end" validate:"required,gtfield=Start"`
+	Organizer     string    `json:"organizer,omitempty" validate:"omitempty,email"`
+	OrganizerName string    `json:"organizerName,omitempty" validate:"omitempty,max=100"`
+}
 
-	router.Use(globalErrorHandler())
+// UTMRequest overrides UTM_SOURCE, UTM_MEDIUM and UTM_CAMPAIGN for the
+// links of one email, or of every email of a bulk request.
+type UTMRequest struct {
+	Source   string `json:"source,omitempty" validate:"omitempty,max=100"`
+	Medium   string `json:"medium,omitempty" validate:"omitempty,max=100"`
+	Campaign string `json:"campaign,omitempty" validate:"omitempty,max=100"`
+}
 
-	router.GET("/health", healthCheck)
+type AttachmentRequest struct {
+	URL         string `json:"url" validate:"required,url,max=2048"`
+	Filename    string `json:"filename,omitempty" validate:"omitempty,max=255"`
+	ContentType string `json:"contentType,omitempty" validate:"omitempty,max=100"`
+}
 
-	api := router.Group("/api")
-	{
-		api.POST("/send", sendEmailHandler(redisQueue))
-		api.POST("/bulk-send", bulkEmailHandler(redisQueue))
-	}
+// Services bundles the dependencies shared by the HTTP handlers.
+type Services struct {
+	// Context is canceled at shutdown, which waits for the work counted
+	// in Background, such as list sends queueing after their response.
+	Context    context.Context
+	Background *sync.WaitGroup
+
+	Config        *config.ApplicationConfig
+	Queue         *queue.RedisQueue
+	Recipients    *recipient.Validator
+	Templates     *templates.Manager
+	TemplateStore *templates.Store
+	Assets        *assets.Server
+	Store         *storage.Store
+	Tokens        *token.Signer
+	Events        events.Publisher
+	Hub           *events.Hub
+	Stats         *stats.Recorder
+	Contacts      *contacts.Store
+	Keys          *apikey.Store
+	Reload        func() error
+	Logger        *slog.Logger
 }
 
-func corsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+// RegisterHandlers adds the middleware and routes to router, each group with
+// its own security headers, header size limit and accepted content types.
+// It fails when the CORS settings are invalid.
+func RegisterHandlers(router *gin.Engine, svc *Services) error {
+	cors, err := corsMiddleware(svc.Config)
+	if err != nil {
+		return err
+	}
+	router.Use(requestID())
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusOK)
-			return
-		}
+	router.Use(cors)
 
-		c.Next()
+	router.Use(globalErrorHandler(svc))
+
+	router.Use(gzipResponses())
+
+	router.Use(requestTimeout(svc.Config.RequestTimeout))
+
+	cfg := svc.Config
+	router.GET("/health", securityHeaders(cfg, apiHeaders), healthCheck)
+	router.GET("/healthz", securityHeaders(cfg, apiHeaders), healthCheck)
+	router.GET("/readyz", securityHeaders(cfg, apiHeaders), readinessHandler(svc))
+	router.GET("/metrics", securityHeaders(cfg, apiHeaders), gin.WrapH(metrics.Handler()))
+
+	// Routes linked from emails face the internet; they get tighter header
+	// limits than the API.
+	resources := router.Group("", securityHeaders(cfg, resourceHeaders), limitHeaderBytes(cfg.PublicMaxHeaderBytes))
+	if svc.Assets.Enabled() {
+		resources.GET("/assets/*path", assetHandler(svc))
+	}
+
+	if svc.Tokens != nil {
+		resources.GET("/t/open/:token", openPixelHandler(svc))
+		resources.GET("/t/click/:token", clickHandler(svc))
+
+		pages := router.Group("", securityHeaders(cfg, pageHeaders), limitHeaderBytes(cfg.PublicMaxHeaderBytes),
+			requireContentType("application/x-www-form-urlencoded", "multipart/form-data"))
+		pages.GET("/unsubscribe/:token", unsubscribeHandler(svc))
+		pages.POST("/unsubscribe/:token", unsubscribeHandler(svc))
+		pages.GET("/preferences/:token", preferencesPageHandler(svc))
+		pages.POST("/preferences/:token", preferencesPageHandler(svc))
+	}
+
+	// Feedback providers post JSON, SNS text/plain and raw ARF messages, so
+	// webhook content types are left to the handlers.
+	webhooks := router.Group("/webhooks", securityHeaders(cfg, apiHeaders), limitHeaderBytes(cfg.PublicMaxHeaderBytes))
+	if cfg.FeedbackWebhookToken != "" {
+		feedbackWebhooks := webhooks.Group("", feedbackTokenRequired(svc))
+		feedbackWebhooks.POST("/ses", sesFeedbackHandler(svc, feedback.NewSNSVerifier()))
+		feedbackWebhooks.POST("/arf", arfFeedbackHandler(svc))
+	}
+	if cfg.MailgunWebhookSigningKey != "" {
+		webhooks.POST("/mailgun", mailgunFeedbackHandler(svc))
 	}
+
+	// /api/v1 is the versioned API. The unversioned /api routes are the same
+	// handlers, kept for existing clients and marked deprecated; they will
+	// keep serving version 1 when later versions are added.
+	apiMiddleware := []gin.HandlerFunc{
+		securityHeaders(cfg, apiHeaders),
+		limitHeaderBytes(cfg.HTTPMaxHeaderBytes),
+		clientCertificates(cfg),
+		apiKeys(svc),
+		requireContentType("application/json", "application/x-ndjson", "text/csv",
+			"application/gzip", "application/x-gzip", "application/octet-stream"),
+	}
+	registerAPIRoutes(router.Group("/api/v1", append(apiMiddleware, apiVersion(currentAPIVersion, false))...), svc)
+	registerAPIRoutes(router.Group("/api", append(apiMiddleware, apiVersion(currentAPIVersion, true))...), svc)
+	return nil
 }
 
-func globalErrorHandler() gin.HandlerFunc {
+// registerAPIRoutes adds the routes of API version 1 to api.
+func registerAPIRoutes(api *gin.RouterGroup, svc *Services) {
+	api.POST("/send", sendEmailHandler(svc))
+	api.POST("/broadcast", broadcastHandler(svc))
+	api.POST("/bulk-send", gzipRequestBody(maxBulkRequestBytes), bulkEmailHandler(svc))
+	api.POST("/bulk-send/stream", gzipRequestBody(maxStreamRequestBytes), bulkStreamHandler(svc))
+	api.GET("/templates", templatesHandler(svc))
+	api.GET("/templates/lint", templateLintHandler(svc))
+	api.POST("/templates/preview", templatePreviewHandler(svc))
+	api.GET("/templates/export", templateExportHandler(svc))
+	api.POST("/templates/import", templateImportHandler(svc))
+	api.GET("/templates/:name/variables", templateVariablesHandler(svc))
+	api.POST("/templates/:name/test-send", templateTestSendHandler(svc))
+	api.GET("/events/stream", eventStreamHandler(svc))
+	api.GET("/batches/:id/progress", batchProgressHandler(svc))
+	api.GET("/batches/:id/variants", batchVariantsHandler(svc))
+	api.GET("/campaigns", campaignsHandler(svc))
+	api.GET("/campaigns/:id/analytics", campaignAnalyticsHandler(svc))
+	api.POST("/campaigns/:id/cancel", cancelCampaignHandler(svc))
+	api.POST("/campaigns/:id/reschedule", rescheduleCampaignHandler(svc))
+	api.POST("/lists", createListHandler(svc))
+	api.GET("/lists", listsHandler(svc))
+	api.GET("/lists/:id", getListHandler(svc))
+	api.DELETE("/lists/:id", deleteListHandler(svc))
+	api.POST("/lists/:id/contacts", gzipRequestBody(maxBulkRequestBytes), addContactsHandler(svc))
+	api.DELETE("/lists/:id/contacts", removeContactsHandler(svc))
+	api.GET("/lists/:id/contacts", listContactsHandler(svc))
+	api.POST("/lists/:id/import", gzipRequestBody(maxImportBytes), importContactsHandler(svc))
+	api.GET("/lists/:id/imports/:importId", importReportHandler(svc))
+	api.POST("/lists/:id/send", listSendHandler(svc))
+	api.GET("/preferences/:email", getPreferencesHandler(svc))
+	api.PUT("/preferences/:email", updatePreferencesHandler(svc))
+	api.GET("/jobs", jobsHandler(svc))
+	api.GET("/jobs/:id", jobStatusHandler(svc))
+	api.DELETE("/jobs/:id", cancelJobHandler(svc))
+	api.POST("/jobs/status", jobStatusesHandler(svc))
+	api.GET("/history", historyHandler(svc))
+	api.GET("/dead-letters", deadLettersHandler(svc))
+	api.GET("/stats/export", statsExportHandler(svc))
+	api.GET("/workers", workersHandler(svc))
+	if adminEnabled(svc) {
+		api.POST("/admin/reload", reloadHandler(svc))
+		api.GET("/admin/held", heldEmailsHandler(svc))
+		api.POST("/admin/held/:id/approve", approveHeldHandler(svc))
+		api.DELETE("/admin/held/:id", rejectHeldHandler(svc))
+	}
+	api.GET("/keys/self/usage", keyUsageHandler(svc))
+	if svc.Keys.Enabled() {
+		api.POST("/admin/keys", createKeyHandler(svc))
+		api.GET("/admin/keys", listKeysHandler(svc))
+		api.POST("/admin/keys/:id/rotate", rotateKeyHandler(svc))
+		api.DELETE("/admin/keys/:id", revokeKeyHandler(svc))
+	}
+}
+
+var httpPanics = metrics.NewCounter(
+	"mailqueue_http_panics_total",
+	"HTTP handlers that panicked, by route.",
+	"route",
+)
+
+// globalErrorHandler recovers handler panics, logging them with their
+// stack and request context, and answers 500 unless the handler had
+// already started its response.
+func globalErrorHandler(svc *Services) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if err := recover(); err != nil {
-				c.JSON(http.StatusInternalServerError, ErrorResponse{
-					Error: "internal server error",
-					Details: map[string]string{
-						"message": "an unexpected error occurred",
-					},
-				})
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				httpPanics.Inc(c.FullPath())
+				svc.Logger.Error("HTTP handler panic",
+					"method", c.Request.Method,
+					"path", c.Request.URL.Path,
+					"route", c.FullPath(),
+					"requestId", requestIDOf(c),
+					"tenant", requestTenant(c),
+					"panic", err,
+					"stack", string(debug.Stack()),
+				)
+
+				if !c.Writer.Written() {
+					c.JSON(http.StatusInternalServerError, ErrorResponse{
+						Error: "internal server error",
+						Details: map[string]string{
+							"message": "an unexpected error occurred",
+						},
+						RequestID: requestIDOf(c),
+					})
+				}
 				c.Abort()
 			}
 		}()
@@ -73,6 +294,17 @@ func globalErrorHandler() gin.HandlerFunc {
 	}
 }
 
+// RegisterWorkerHandlers adds only the probe and metrics routes to router,
+// for worker-mode instances that do not serve the API.
+func RegisterWorkerHandlers(router *gin.Engine, svc *Services) {
+	cfg := svc.Config
+	router.Use(requestID())
+	router.GET("/health", securityHeaders(cfg, apiHeaders), healthCheck)
+	router.GET("/healthz", securityHeaders(cfg, apiHeaders), healthCheck)
+	router.GET("/readyz", securityHeaders(cfg, apiHeaders), readinessHandler(svc))
+	router.GET("/metrics", securityHeaders(cfg, apiHeaders), gin.WrapH(metrics.Handler()))
+}
+
 func healthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status": "ok",
@@ -102,6 +334,14 @@ func validateRequest(req interface{}) error {
 				errorDetails[e.Field()] = "value is too short"
 			case "max":
 				errorDetails[e.Field()] = "value is too long"
+			case "gtfield":
+				errorDetails[e.Field()] = "value must be after " + e.Param()
+			case "bcp47_language_tag":
+				errorDetails[e.Field()] = "must be a locale such as en-US or de-DE"
+			case "printascii":
+				errorDetails[e.Field()] = "must contain printable ASCII characters only"
+			case "oneof":
+				errorDetails[e.Field()] = "must be one of: " + e.Param()
 			default:
 				errorDetails[e.Field()] = "validation failed"
 			}
@@ -126,7 +366,217 @@ func (e *ValidationError) Error() string {
 	return strings.Join(errStrings, "; ")
 }
 
-func sendEmailHandler(redisQueue *queue.RedisQueue) gin.HandlerFunc {
+// rejection describes why a send request was refused: the HTTP status and the
+// error body returned to the caller.
+type rejection struct {
+	status   int
+	response ErrorResponse
+}
+
+// Machine-readable reasons a send request is rejected, returned as the
+// code of the error response and of failed bulk items.
+const (
+	codeValidationFailed    = "validation_failed"
+	codeInvalidJSON         = "invalid_json"
+	codeUnknownQueue        = "unknown_queue"
+	codeUnknownCategory     = "unknown_category"
+	codeInvalidRecipient    = "invalid_recipient"
+	codeRecipientDenied     = "recipient_denied"
+	codeRecipientSuppressed = "recipient_suppressed"
+	codeRecipientOptedOut   = "recipient_opted_out"
+	codeInvalidAttachment   = "invalid_attachment"
+	codeInvalidData         = "invalid_data"
+	codeRenderFailed        = "render_failed"
+	codeInvalidVariants     = "invalid_variants"
+	codeEnqueueFailed       = "enqueue_failed"
+	codeQuotaExceeded       = "quota_exceeded"
+	codeSenderNotAllowed    = "sender_not_allowed"
+	codeKeyRestricted       = "key_restricted"
+)
+
+func validationRejection(status int, code, field, message string) *rejection {
+	return &rejection{
+		status: status,
+		response: ErrorResponse{
+			Error:   "validation failed",
+			Code:    code,
+			Details: map[string]string{field: message},
+		},
+	}
+}
+
+// recipientCode maps a recipient check failure to its rejection code.
+func recipientCode(err error) string {
+	switch {
+	case errors.Is(err, recipient.ErrRecipientDenied), errors.Is(err, recipient.ErrRecipientNotAllowed):
+		return codeRecipientDenied
+	case errors.Is(err, recipient.ErrRecipientSuppressed):
+		return codeRecipientSuppressed
+	case errors.Is(err, recipient.ErrRecipientOptedOut):
+		return codeRecipientOptedOut
+	default:
+		return codeInvalidRecipient
+	}
+}
+
+// prepareTask validates a single send request and builds the queue task for
+// it. It is shared by the single and bulk send endpoints.
+func prepareTask(c *gin.Context, svc *Services, req *SendEmailRequest) (queue.EmailTask, *rejection) {
+	if err := validateRequest(req); err != nil {
+		switch e := err.(type) {
+		case *ValidationError:
+			return queue.EmailTask{}, &rejection{
+				status:   http.StatusBadRequest,
+				response: ErrorResponse{Error: "validation failed", Code: codeValidationFailed, Details: e.Errors},
+			}
+		default:
+			return queue.EmailTask{}, &rejection{
+				status:   http.StatusBadRequest,
+				response: ErrorResponse{Error: err.Error(), Code: codeValidationFailed},
+			}
+		}
+	}
+
+	from := strings.TrimSpace(req.From)
+	if strings.ContainsAny(req.FromName, "\r\n") {
+		return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeValidationFailed, "FromName", "must not contain line breaks")
+	}
+	if from != "" && !senderAllowed(svc.Config, from) {
+		return queue.EmailTask{}, validationRejection(http.StatusForbidden, codeSenderNotAllowed, "From", "not one of EMAIL_ALLOWED_SENDERS")
+	}
+	if rejected := keyAllowsTemplate(c, strings.TrimSpace(req.TemplateName)); rejected != nil {
+		return queue.EmailTask{}, rejected
+	}
+	if rejected := keyAllowsSender(c, svc, from); rejected != nil {
+		return queue.EmailTask{}, rejected
+	}
+
+	queueName := strings.TrimSpace(req.Queue)
+	if !svc.Queue.HasQueue(queueName) {
+		return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeUnknownQueue, "Queue", "unknown queue")
+	}
+	if queueName == "" {
+		queueName = svc.Queue.DefaultQueue()
+	}
+
+	category := strings.TrimSpace(req.Category)
+	if category != "" && !svc.Recipients.HasCategory(category) && !svc.Queue.HasSendCategory(category) {
+		return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeUnknownCategory, "Category", "unknown subscription or send category")
+	}
+
+	flags, err := svc.Recipients.Validate(c.Request.Context(), strings.TrimSpace(req.To), queueName, category)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, recipient.ErrRecipientDenied) || errors.Is(err, recipient.ErrRecipientNotAllowed) ||
+			errors.Is(err, recipient.ErrRecipientSuppressed) || errors.Is(err, recipient.ErrRecipientOptedOut) {
+			status = http.StatusForbidden
+		}
+		return queue.EmailTask{}, validationRejection(status, recipientCode(err), "To", err.Error())
+	}
+
+	for key := range req.Metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeValidationFailed, "Metadata", "keys may contain only letters, digits, '.', '_' and '-'")
+		}
+	}
+
+	pool := strings.TrimSpace(req.Pool)
+	if pool != "" {
+		if !poolPattern.MatchString(pool) {
+			return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeValidationFailed, "Pool", "may contain only letters, digits, '_' and '-'")
+		}
+		if svc.Config.EmailCustomArgs == email.CustomArgsNone {
+			return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeValidationFailed, "Pool", "no provider is configured to route pools; set EMAIL_CUSTOM_ARGS")
+		}
+	}
+
+	if req.SendAt != nil && !req.SendAt.After(time.Now()) {
+		return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeValidationFailed, "SendAt", "must be in the future")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeValidationFailed, "ExpiresAt", "must be in the future")
+	}
+	if req.SendAt != nil && req.ExpiresAt != nil && !req.ExpiresAt.After(*req.SendAt) {
+		return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeValidationFailed, "ExpiresAt", "must be after sendAt")
+	}
+
+	var attachments []email.Attachment
+	for _, att := range req.Attachments {
+		if err := svc.Store.CheckURL(c.Request.Context(), strings.TrimSpace(att.URL)); err != nil {
+			return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeInvalidAttachment, "Attachments", err.Error())
+		}
+		attachments = append(attachments, email.Attachment{
+			URL:         strings.TrimSpace(att.URL),
+			Filename:    strings.TrimSpace(att.Filename),
+			ContentType: strings.TrimSpace(att.ContentType),
+		})
+	}
+
+	sanitizedData := sanitizeTemplateData(req.Data)
+
+	if err := svc.Templates.ValidateData(strings.TrimSpace(req.TemplateName), sanitizedData); err != nil {
+		return queue.EmailTask{}, validationRejection(http.StatusBadRequest, codeInvalidData, "Data", err.Error())
+	}
+
+	task := queue.EmailTask{
+		From:           from,
+		FromName:       strings.TrimSpace(req.FromName),
+		To:             strings.TrimSpace(req.To),
+		Subject:        strings.TrimSpace(req.Subject),
+		TemplateName:   strings.TrimSpace(req.TemplateName),
+		Data:           sanitizedData,
+		Queue:          queueName,
+		Flags:          flags,
+		Attachments:    attachments,
+		Preheader:      strings.TrimSpace(req.Preheader),
+		Locale:         strings.TrimSpace(req.Locale),
+		IdempotencyKey: strings.TrimSpace(req.IdempotencyKey),
+		InReplyTo:      strings.TrimSpace(req.InReplyTo),
+		References:     req.References,
+		Category:       category,
+		Tags:           normalizeTags(req.Tags),
+		Metadata:       req.Metadata,
+		Pool:           pool,
+		Priority:       strings.TrimSpace(req.Priority),
+		SendAt:         req.SendAt,
+		ExpiresAt:      req.ExpiresAt,
+		Tenant:         requestTenant(c),
+		RequestID:      requestIDOf(c),
+		TraceParent:    requestTraceParent(c),
+	}
+	if req.Event != nil {
+		task.Event = &email.Event{
+			UID:           strings.TrimSpace(req.Event.UID),
+			Title:         strings.TrimSpace(req.Event.Title),
+			Description:   strings.TrimSpace(req.Event.Description),
+			Location:      strings.TrimSpace(req.Event.Location),
+			Start:         req.Event.Start,
+			End:           req.Event.End,
+			Organizer:     strings.TrimSpace(req.Event.Organizer),
+			OrganizerName: strings.TrimSpace(req.Event.OrganizerName),
+		}
+	}
+	if req.UTM != nil {
+		task.UTM = &email.UTM{
+			Source:   strings.TrimSpace(req.UTM.Source),
+			Medium:   strings.TrimSpace(req.UTM.Medium),
+			Campaign: strings.TrimSpace(req.UTM.Campaign),
+		}
+	}
+	// In prerender mode the body is rendered now, so rendering errors reach
+	// the caller and later template changes don't alter queued mail.
+	if svc.Config.TemplatePrerender {
+		body, err := svc.Templates.RenderWithSafeURLs(task.TemplateName, task.Data, templates.WithLocale(task.Locale))
+		if err != nil {
+			return queue.EmailTask{}, validationRejection(http.StatusUnprocessableEntity, codeRenderFailed, "TemplateName", err.Error())
+		}
+		task.Body = body
+	}
+
+	return task, nil
+}
+
+func sendEmailHandler(svc *Services) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req SendEmailRequest
 
@@ -140,31 +590,37 @@ func sendEmailHandler(redisQueue *queue.RedisQueue) gin.HandlerFunc {
 			return
 		}
 
-		if err := validateRequest(&req); err != nil {
-			switch e := err.(type) {
-			case *ValidationError:
-				c.JSON(http.StatusBadRequest, ErrorResponse{
-					Error:   "validation failed",
-					Details: e.Errors,
-				})
-			default:
-				c.JSON(http.StatusBadRequest, ErrorResponse{
-					Error: err.Error(),
-				})
-			}
-			return
+		// The Idempotency-Key header is an alternative to the body field
+		if req.IdempotencyKey == "" {
+			req.IdempotencyKey = c.GetHeader("Idempotency-Key")
 		}
 
-		sanitizedData := sanitizeTemplateData(req.Data)
-
-		task := queue.EmailTask{
-			To:           strings.TrimSpace(req.To),
-			Subject:      strings.TrimSpace(req.Subject),
-			TemplateName: strings.TrimSpace(req.TemplateName),
-			Data:         sanitizedData,
+		task, rejected := prepareTask(c, svc, &req)
+		if rejected != nil {
+			c.JSON(rejected.status, rejected.response)
+			return
 		}
 
-		if err := redisQueue.EnqueueEmail(c.Request.Context(), task); err != nil {
+		jobID, err := enqueue(c, svc, task)
+		if errors.Is(err, apikey.ErrQuotaExceeded) {
+			rejected := quotaRejection(c)
+			c.JSON(rejected.status, rejected.response)
+			return
+		}
+		if errors.Is(err, queue.ErrDuplicateTask) {
+			c.JSON(http.StatusOK, gin.H{
+				"message": "email was already queued",
+				"details": gin.H{
+					"jobId":     jobID,
+					"recipient": task.To,
+					"subject":   task.Subject,
+					"queue":     task.Queue,
+					"duplicate": true,
+				},
+			})
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, ErrorResponse{
 				Error: "failed to queue email",
 				Details: map[string]string{
@@ -177,16 +633,23 @@ func sendEmailHandler(redisQueue *queue.RedisQueue) gin.HandlerFunc {
 		c.JSON(http.StatusAccepted, gin.H{
 			"message": "email was successfully added to the queue",
 			"details": gin.H{
+				"jobId":     jobID,
 				"recipient": task.To,
 				"subject":   task.Subject,
+				"queue":     task.Queue,
+				"flags":     task.Flags,
 			},
 		})
 	}
 }
 
-func bulkEmailHandler(redisQueue *queue.RedisQueue) gin.HandlerFunc {
+func bulkEmailHandler(svc *Services) gin.HandlerFunc {
 	type BulkEmailRequest struct {
-		Emails []SendEmailRequest `json:"emails" binding:"required,min=1,max=50" validate:"required,min=1,max=50"`
+		Emails   []SendEmailRequest `json:"emails" binding:"required,min=1,max=50" validate:"required,min=1,max=50"`
+		BatchID  string             `json:"batchId,omitempty" binding:"omitempty,max=64,printascii"`
+		BatchKey string             `json:"batchKey,omitempty" binding:"omitempty,max=255,printascii"`
+		UTM      *UTMRequest        `json:"utm,omitempty"`
+		Variants []VariantRequest   `json:"variants,omitempty" binding:"omitempty,min=2,max=10,dive"`
 	}
 
 	return func(c *gin.Context) {
@@ -200,45 +663,243 @@ func bulkEmailHandler(redisQueue *queue.RedisQueue) gin.HandlerFunc {
 			return
 		}
 
+		// A repeated batchKey (e.g. a retried cron job) gets the response of
+		// the first submission instead of queueing every email again.
+		if req.BatchKey != "" {
+			previous, err := svc.Queue.ClaimBatchKey(c.Request.Context(), requestTenant(c), req.BatchKey, requestHash(req))
+			switch {
+			case errors.Is(err, queue.ErrBatchKeyInProgress):
+				c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+				return
+			case errors.Is(err, queue.ErrBatchKeyMismatch):
+				c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: err.Error()})
+				return
+			case err != nil:
+				c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error:   "failed to check batch key",
+					Details: map[string]string{"reason": err.Error()},
+				})
+				return
+			case previous != nil:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(previous.Status, "application/json; charset=utf-8", previous.Response)
+				return
+			}
+		}
+		reject := func(status int, response ErrorResponse) {
+			if req.BatchKey != "" {
+				svc.Queue.ReleaseBatchKey(c.Request.Context(), requestTenant(c), req.BatchKey)
+			}
+			c.JSON(status, response)
+		}
+
+		// Requests sharing a batchId (e.g. the pages of one campaign) add up
+		// to a single progress record.
+		batchID := req.BatchID
+		if batchID == "" {
+			batchID = queue.NewBatchID()
+		} else if canceled, err := svc.Queue.BatchCanceled(c.Request.Context(), batchID); err == nil && canceled {
+			reject(http.StatusConflict, ErrorResponse{Error: queue.ErrBatchCanceled.Error()})
+			return
+		}
+
+		variants, rejected := prepareVariants(svc, req.Variants)
+		if rejected == nil {
+			rejected = keyAllowsVariants(c, variants)
+		}
+		if rejected != nil {
+			reject(rejected.status, rejected.response)
+			return
+		}
+
+		if err := svc.Queue.PlanBatch(c.Request.Context(), batchID, len(req.Emails)); err != nil {
+			reject(http.StatusInternalServerError, ErrorResponse{
+				Error:   "failed to queue bulk emails",
+				Details: map[string]string{"reason": err.Error()},
+			})
+			return
+		}
+
 		var failedEmails []string
 		var successEmails []string
+		var jobIDs []string
+		results := make([]BulkItemResult, 0, len(req.Emails))
 
-		for _, emailReq := range req.Emails {
-			if err := validateRequest(&emailReq); err != nil {
+		for i, emailReq := range req.Emails {
+			if emailReq.UTM == nil {
+				emailReq.UTM = req.UTM
+			}
+			result := enqueueBatchEmail(c, svc, &emailReq, batchID, variants)
+			results = append(results, BulkItemResult{Index: i, ItemResult: result})
+			if !result.ok() {
 				failedEmails = append(failedEmails, emailReq.To)
 				continue
 			}
+			successEmails = append(successEmails, result.To)
+			jobIDs = append(jobIDs, result.JobID)
+		}
 
-			task := queue.EmailTask{
-				To:           strings.TrimSpace(emailReq.To),
-				Subject:      strings.TrimSpace(emailReq.Subject),
-				TemplateName: strings.TrimSpace(emailReq.TemplateName),
-				Data:         sanitizeTemplateData(emailReq.Data),
-			}
+		status := http.StatusAccepted
+		response := gin.H{
+			"message":       "all emails successfully queued",
+			"batchId":       batchID,
+			"successCount":  len(successEmails),
+			"successEmails": successEmails,
+			"jobIds":        jobIDs,
+			"results":       results,
+		}
+		if len(failedEmails) > 0 {
+			status = http.StatusMultiStatus
+			response["message"] = "partial success in queueing emails"
+			response["failedCount"] = len(failedEmails)
+			response["failedEmails"] = failedEmails
+		}
 
-			if err := redisQueue.EnqueueEmail(c.Request.Context(), task); err != nil {
-				failedEmails = append(failedEmails, task.To)
-			} else {
-				successEmails = append(successEmails, task.To)
+		// The response is stored even if the request timed out meanwhile.
+		// Should that fail, repeats of the key are answered as in progress
+		// until the claim's lease runs out, and queued again after.
+		if req.BatchKey != "" {
+			body, err := json.Marshal(response)
+			if err == nil {
+				err = svc.Queue.CompleteBatchKey(context.WithoutCancel(c.Request.Context()), requestTenant(c), req.BatchKey, queue.BatchSubmission{
+					RequestHash: requestHash(req),
+					Status:      status,
+					Response:    body,
+				})
+			}
+			if err != nil {
+				svc.Logger.Error("Failed to store bulk send response for its batchKey",
+					"batchKey", req.BatchKey, "batch", batchID, "error", err)
 			}
 		}
+		c.JSON(status, response)
+	}
+}
 
-		if len(failedEmails) > 0 {
-			c.JSON(http.StatusMultiStatus, gin.H{
-				"message":       "partial success in queueing emails",
-				"successCount":  len(successEmails),
-				"failedCount":   len(failedEmails),
-				"successEmails": successEmails,
-				"failedEmails":  failedEmails,
-			})
-		} else {
-			c.JSON(http.StatusAccepted, gin.H{
-				"message":       "all emails successfully queued",
-				"successCount":  len(successEmails),
-				"successEmails": successEmails,
-			})
+// requestHash fingerprints a request body so a reused idempotency key can
+// be told apart from a repeat of the same request.
+func requestHash(req interface{}) string {
+	content, _ := json.Marshal(req)
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Outcomes of one email of a bulk request.
+const (
+	itemQueued    = "queued"
+	itemDuplicate = "duplicate"
+	itemRejected  = "rejected"
+	itemFailed    = "failed"
+)
+
+// ItemResult is the outcome of one email of a bulk request: its job ID when
+// queued (or, for a duplicate, the original job's), otherwise the code,
+// message and field details of the rejection.
+type ItemResult struct {
+	To      string            `json:"to,omitempty"`
+	Status  string            `json:"status"`
+	JobID   string            `json:"jobId,omitempty"`
+	Code    string            `json:"code,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// BulkItemResult is an ItemResult with the email's index in the request.
+type BulkItemResult struct {
+	Index int `json:"index"`
+	ItemResult
+}
+
+// ok reports whether the email is queued, including as a duplicate.
+func (r ItemResult) ok() bool {
+	return r.Status == itemQueued || r.Status == itemDuplicate
+}
+
+// enqueueBatchEmail validates and queues one email of a batch. A duplicate
+// counts as queued with the original job ID.
+func enqueueBatchEmail(c *gin.Context, svc *Services, req *SendEmailRequest, batchID string, variants []queue.Variant) ItemResult {
+	// Past the request deadline the rest of a bulk request is reported
+	// as not queued, so the client can retry just those.
+	if err := c.Request.Context().Err(); err != nil {
+		return ItemResult{
+			To:      strings.TrimSpace(req.To),
+			Status:  itemFailed,
+			Code:    codeEnqueueFailed,
+			Error:   "request timed out before the email was queued",
+			Details: map[string]string{"reason": err.Error()},
+		}
+	}
+
+	task, rejected := prepareTask(c, svc, req)
+	if rejected == nil {
+		rejected = validateVariantData(svc, variants, task)
+	}
+	if rejected != nil {
+		return ItemResult{
+			To:      strings.TrimSpace(req.To),
+			Status:  itemRejected,
+			Code:    rejected.response.Code,
+			Error:   rejected.response.Error,
+			Details: rejected.response.Details,
+		}
+	}
+	task.BatchID = batchID
+	task.Variants = variants
+
+	jobID, err := enqueue(c, svc, task)
+	if errors.Is(err, apikey.ErrQuotaExceeded) {
+		rejected := quotaRejection(c)
+		return ItemResult{
+			To:      task.To,
+			Status:  itemRejected,
+			Code:    rejected.response.Code,
+			Error:   rejected.response.Error,
+			Details: rejected.response.Details,
+		}
+	}
+	if errors.Is(err, queue.ErrDuplicateTask) {
+		return ItemResult{To: task.To, Status: itemDuplicate, JobID: jobID}
+	}
+	if err != nil {
+		return ItemResult{
+			To:      task.To,
+			Status:  itemFailed,
+			Code:    codeEnqueueFailed,
+			Error:   "failed to queue email",
+			Details: map[string]string{"reason": err.Error()},
+		}
+	}
+	return ItemResult{To: task.To, Status: itemQueued, JobID: jobID}
+}
+
+// senderAllowed reports whether a send may use address as its from: the
+// configured sender, or one of EMAIL_ALLOWED_SENDERS.
+func senderAllowed(cfg *config.ApplicationConfig, address string) bool {
+	return strings.EqualFold(address, cfg.EmailSenderAddress) || matchesSender(cfg.EmailAllowedSenders, address)
+}
+
+// matchesSender reports whether address is one of allowed, which lists
+// addresses and @domains, compared without regard to case.
+func matchesSender(allowed []string, address string) bool {
+	_, domain, _ := strings.Cut(address, "@")
+	for _, entry := range allowed {
+		if strings.EqualFold(entry, address) || (strings.HasPrefix(entry, "@") && strings.EqualFold(entry[1:], domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeTags trims tags and drops repeats, keeping the caller's order.
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !contains(normalized, tag) {
+			normalized = append(normalized, tag)
 		}
 	}
+	return normalized
 }
 
 func sanitizeTemplateData(data map[string]interface{}) map[string]interface{} {
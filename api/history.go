@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// historyHandler lists the jobs sent to one recipient (?to=), newest first,
+// so support can check what was sent without grepping logs. It takes the
+// parameters of the other listings; see bindListQuery.
+func historyHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		to := strings.TrimSpace(c.Query("to"))
+		if err := validate.Var(to, "required,email"); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid history request",
+				Details: map[string]string{"to": "a valid email address is required"},
+			})
+			return
+		}
+
+		query, ok := bindListQuery(c, []string{"status", "template", "tag", "metadata", "from", "until", "sort"}, jobStatuses...)
+		if !ok {
+			return
+		}
+		query.Recipient = to
+
+		jobs, next, err := svc.Queue.Jobs(c.Request.Context(), query)
+		if err != nil {
+			pageError(c, "failed to load history", err)
+			return
+		}
+		respondPage(c, "jobs", jobs, next)
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/contacts"
+)
+
+const (
+	maxImportContacts = 100000
+	maxImportBytes    = 32 << 20
+)
+
+var errImportTooLarge = fmt.Errorf("imports are limited to %d contacts", maxImportContacts)
+
+// ImportContactsRequest is the JSON form of an import. Unlike
+// AddContactsRequest, rows are validated one by one so that a bad address
+// is reported rather than failing the whole import.
+type ImportContactsRequest struct {
+	Contacts []ImportContactRequest `json:"contacts" binding:"required,min=1"`
+}
+
+type ImportContactRequest struct {
+	Email      string            `json:"email"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// importContactsHandler checks the syntax of every row of a CSV or JSON
+// import and queues the valid ones to be added to the list, responding with
+// the import report to poll.
+func importContactsHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+		var parsed []ImportContactRequest
+		var err error
+		switch c.ContentType() {
+		case "text/csv":
+			parsed, err = parseImportCSV(c.Request.Body)
+		case "application/json":
+			var req ImportContactsRequest
+			if err = c.ShouldBindJSON(&req); err == nil {
+				parsed = req.Contacts
+			}
+		default:
+			err = errors.New("content type must be text/csv or application/json")
+		}
+		if err == nil && len(parsed) > maxImportContacts {
+			err = errImportTooLarge
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid import request",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+
+		rows := make([]contacts.ImportRow, 0, len(parsed))
+		var invalid []contacts.ImportError
+		for i, contact := range parsed {
+			address := strings.TrimSpace(contact.Email)
+			if reason := importRowError(address, contact.Attributes); reason != "" {
+				invalid = append(invalid, contacts.ImportError{Row: i + 1, Email: address, Reason: reason})
+				continue
+			}
+			rows = append(rows, contacts.ImportRow{
+				Row:     i + 1,
+				Contact: contacts.Contact{Email: address, Attributes: contact.Attributes},
+			})
+		}
+
+		report, err := svc.Contacts.StartImport(c.Request.Context(), c.Param("id"), rows, invalid)
+		if err != nil {
+			listError(c, "failed to start contact import", err)
+			return
+		}
+		runInBackground(c, svc, func(c *gin.Context) {
+			svc.Contacts.RunImport(c.Request.Context(), *report, rows, svc.Recipients.Suppressed)
+		})
+		c.JSON(http.StatusAccepted, report)
+	}
+}
+
+func importReportHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report, err := svc.Contacts.Import(c.Request.Context(), c.Param("id"), c.Param("importId"))
+		if errors.Is(err, contacts.ErrImportNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			listError(c, "failed to load contact import", err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// parseImportCSV reads contacts from CSV with a header row. The email
+// column is required; every other column is an attribute, and empty cells
+// are left out.
+func parseImportCSV(r io.Reader) ([]ImportContactRequest, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	emailColumn := -1
+	for i, name := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if strings.EqualFold(header[i], "email") {
+			emailColumn = i
+		}
+	}
+	if emailColumn < 0 {
+		return nil, errors.New("CSV header has no email column")
+	}
+
+	var parsed []ImportContactRequest
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return parsed, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if len(parsed) == maxImportContacts {
+			return nil, errImportTooLarge
+		}
+
+		contact := ImportContactRequest{}
+		for i, value := range record {
+			if i >= len(header) || value == "" {
+				continue
+			}
+			if i == emailColumn {
+				contact.Email = value
+				continue
+			}
+			if contact.Attributes == nil {
+				contact.Attributes = make(map[string]string)
+			}
+			contact.Attributes[header[i]] = value
+		}
+		parsed = append(parsed, contact)
+	}
+}
+
+// importRowError applies the checks of ContactRequest to one row, returning
+// why it is invalid or "".
+func importRowError(address string, attributes map[string]string) string {
+	if err := validate.Var(address, "required,email"); err != nil {
+		return "invalid email address"
+	}
+	if err := validate.Var(attributes, "omitempty,max=50,dive,keys,min=1,max=50,endkeys,max=500"); err != nil {
+		return "attributes must be at most 50, with names up to 50 and values up to 500 characters"
+	}
+	return ""
+}
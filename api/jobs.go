@@ -0,0 +1,185 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+// JobStatusRequest asks for up to 500 jobs at once.
+type JobStatusRequest struct {
+	IDs []string `json:"ids" binding:"required,min=1,max=500,dive,required,max=64"`
+}
+
+// checkJobTenant returns queue.ErrJobNotFound when job id belongs to
+// another tenant than the request's, so its existence is not confirmed.
+func checkJobTenant(c *gin.Context, svc *Services, id string) error {
+	if requestTenant(c) == "" {
+		return nil
+	}
+	status, err := svc.Queue.JobStatus(c.Request.Context(), id)
+	if err != nil {
+		return err
+	}
+	if !sameTenant(c, status.Tenant) {
+		return queue.ErrJobNotFound
+	}
+	return nil
+}
+
+func jobStatusHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, err := svc.Queue.JobStatus(c.Request.Context(), c.Param("id"))
+		if err == nil && !sameTenant(c, status.Tenant) {
+			err = queue.ErrJobNotFound
+		}
+		if errors.Is(err, queue.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to load job status",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+// cancelJobHandler cancels a job scheduled with sendAt, or waiting for a
+// retry, before it is moved to its queue. 409 means it is no longer
+// waiting: most often the cancellation lost the race with the promoter and
+// the email is being or has been sent.
+func cancelJobHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		err := checkJobTenant(c, svc, id)
+		if errors.Is(err, queue.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err == nil {
+			err = svc.Queue.CancelScheduled(c.Request.Context(), id)
+		}
+		if errors.Is(err, queue.ErrNotScheduled) {
+			status, statusErr := svc.Queue.JobStatus(c.Request.Context(), id)
+			if errors.Is(statusErr, queue.ErrJobNotFound) {
+				c.JSON(http.StatusNotFound, ErrorResponse{Error: statusErr.Error()})
+				return
+			}
+			details := map[string]string{"id": id}
+			if statusErr == nil {
+				details["status"] = status.Status
+			}
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error(), Details: details})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to cancel job",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"id": id, "canceled": true})
+	}
+}
+
+// jobStatusesHandler answers many status polls in one call. IDs that are
+// unknown, have expired or belong to another tenant are listed under
+// notFound.
+func jobStatusesHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req JobStatusRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "invalid job status request",
+				Details: map[string]string{
+					"message": err.Error(),
+				},
+			})
+			return
+		}
+
+		statuses, err := svc.Queue.JobStatuses(c.Request.Context(), req.IDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to load job statuses",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		found := make(map[string]struct{}, len(statuses))
+		visible := statuses[:0]
+		for _, status := range statuses {
+			if !sameTenant(c, status.Tenant) {
+				continue
+			}
+			found[status.ID] = struct{}{}
+			visible = append(visible, status)
+		}
+		statuses = visible
+		notFound := []string{}
+		for _, id := range req.IDs {
+			if _, ok := found[id]; !ok {
+				notFound = append(notFound, id)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"jobs":     statuses,
+			"notFound": notFound,
+		})
+	}
+}
+
+// jobsHandler lists jobs across recipients, newest first, filtered by
+// status, template, recipient domain, tag, metadata and the time they were
+// queued.
+func jobsHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := bindListQuery(c, []string{"status", "template", "domain", "tag", "metadata", "from", "until", "sort"}, jobStatuses...)
+		if !ok {
+			return
+		}
+
+		jobs, next, err := svc.Queue.Jobs(c.Request.Context(), query)
+		if err != nil {
+			pageError(c, "failed to list jobs", err)
+			return
+		}
+		respondPage(c, "jobs", jobs, next)
+	}
+}
+
+// deadLettersHandler lists tasks that exhausted their retries, newest
+// failure first, filtered by template, recipient domain, tag, metadata and
+// failure time.
+func deadLettersHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := bindListQuery(c, []string{"template", "domain", "tag", "metadata", "from", "until", "sort"})
+		if !ok {
+			return
+		}
+
+		entries, next, err := svc.Queue.DeadLetters(c.Request.Context(), query)
+		if err != nil {
+			pageError(c, "failed to list dead letters", err)
+			return
+		}
+		respondPage(c, "deadLetters", entries, next)
+	}
+}
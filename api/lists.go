@@ -0,0 +1,362 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/contacts"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+const maxListedContacts = 1000
+
+type CreateListRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+type ContactRequest struct {
+	Email      string            `json:"email" binding:"required,email"`
+	Attributes map[string]string `json:"attributes,omitempty" binding:"omitempty,max=50,dive,keys,min=1,max=50,endkeys,max=500"`
+}
+
+type AddContactsRequest struct {
+	Contacts []ContactRequest `json:"contacts" binding:"required,min=1,max=1000,dive"`
+}
+
+type RemoveContactsRequest struct {
+	Emails []string `json:"emails" binding:"required,min=1,max=1000,dive,email"`
+}
+
+// ListSendRequest sends one email to every contact of a list, or of a
+// segment of it. Contact attributes are merged over data.
+type ListSendRequest struct {
+	Subject      string                 `json:"subject" binding:"required,max=200"`
+	TemplateName string                 `json:"templateName" binding:"required,max=50"`
+	From         string                 `json:"from,omitempty" binding:"omitempty,email,max=254"`
+	FromName     string                 `json:"fromName,omitempty" binding:"omitempty,max=100"`
+	Data         map[string]interface{} `json:"data"`
+	Segment      string                 `json:"segment,omitempty" binding:"omitempty,max=1000"`
+	Queue        string                 `json:"queue,omitempty" binding:"omitempty,max=50"`
+	BatchID      string                 `json:"batchId,omitempty" binding:"omitempty,max=64,printascii"`
+	Preheader    string                 `json:"preheader,omitempty" binding:"omitempty,max=250"`
+	Locale       string                 `json:"locale,omitempty"`
+	UTM          *UTMRequest            `json:"utm,omitempty"`
+	Variants     []VariantRequest       `json:"variants,omitempty" binding:"omitempty,min=2,max=10,dive"`
+	Category     string                 `json:"category,omitempty" binding:"omitempty,max=50"`
+	Tags         []string               `json:"tags,omitempty"`
+	Metadata     map[string]string      `json:"metadata,omitempty"`
+	Pool         string                 `json:"pool,omitempty"`
+}
+
+func createListHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateListRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid list request",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+
+		list, err := svc.Contacts.CreateList(c.Request.Context(), strings.TrimSpace(req.Name))
+		if err != nil {
+			listError(c, "failed to create contact list", err)
+			return
+		}
+		c.JSON(http.StatusCreated, list)
+	}
+}
+
+func listsHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lists, err := svc.Contacts.Lists(c.Request.Context())
+		if err != nil {
+			listError(c, "failed to load contact lists", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"lists": lists})
+	}
+}
+
+func getListHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := svc.Contacts.GetList(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			listError(c, "failed to load contact list", err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+func deleteListHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := svc.Contacts.DeleteList(c.Request.Context(), c.Param("id")); err != nil {
+			listError(c, "failed to delete contact list", err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func addContactsHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AddContactsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid contacts request",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+
+		batch := make([]contacts.Contact, len(req.Contacts))
+		for i, contact := range req.Contacts {
+			batch[i] = contacts.Contact{Email: strings.TrimSpace(contact.Email), Attributes: contact.Attributes}
+		}
+
+		added, err := svc.Contacts.AddContacts(c.Request.Context(), c.Param("id"), batch)
+		if err != nil {
+			listError(c, "failed to add contacts", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"added": added, "updated": int64(len(batch)) - added})
+	}
+}
+
+func removeContactsHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RemoveContactsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid contacts request",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+
+		removed, err := svc.Contacts.RemoveContacts(c.Request.Context(), c.Param("id"), req.Emails)
+		if err != nil {
+			listError(c, "failed to remove contacts", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"removed": removed})
+	}
+}
+
+// listContactsHandler returns up to limit contacts of the list in the
+// segment query parameter, and how many match in total.
+func listContactsHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		segment, err := contacts.ParseSegment(c.Query("segment"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid contacts request",
+				Details: map[string]string{"segment": err.Error()},
+			})
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit < 1 || limit > maxListedContacts {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid contacts request",
+				Details: map[string]string{"limit": "must be between 1 and " + strconv.Itoa(maxListedContacts)},
+			})
+			return
+		}
+
+		matched := 0
+		found := []contacts.Contact{}
+		err = svc.Contacts.Each(c.Request.Context(), c.Param("id"), segment, func(contact contacts.Contact) error {
+			matched++
+			if len(found) < limit {
+				found = append(found, contact)
+			}
+			return nil
+		})
+		if err != nil {
+			listError(c, "failed to load contacts", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"matched": matched, "contacts": found})
+	}
+}
+
+// listSendHandler queues one email per contact in the segment as a batch,
+// so progress, analytics, cancelling and A/B variants work as for bulk
+// sends. It responds with the batch ID once the request is validated and
+// queues the emails in the background; the batch reports queueing until
+// every contact was queued, or shutdown stopped it.
+func listSendHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ListSendRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid list send request",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+
+		segment, err := contacts.ParseSegment(req.Segment)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid list send request",
+				Details: map[string]string{"segment": err.Error()},
+			})
+			return
+		}
+
+		if _, err := svc.Contacts.GetList(c.Request.Context(), c.Param("id")); err != nil {
+			listError(c, "failed to load contact list", err)
+			return
+		}
+
+		batchID := req.BatchID
+		if batchID == "" {
+			batchID = queue.NewBatchID()
+		} else if canceled, err := svc.Queue.BatchCanceled(c.Request.Context(), batchID); err == nil && canceled {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: queue.ErrBatchCanceled.Error()})
+			return
+		}
+
+		variants, rejected := prepareVariants(svc, req.Variants)
+		if rejected == nil {
+			rejected = keyAllowsVariants(c, variants)
+		}
+		if rejected != nil {
+			c.JSON(rejected.status, rejected.response)
+			return
+		}
+
+		if err := svc.Queue.StartBatchQueueing(c.Request.Context(), batchID, requestTenant(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to start list send",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		runInBackground(c, svc, func(c *gin.Context) {
+			queueListSend(c, svc, &req, segment, batchID, variants)
+		})
+		c.JSON(http.StatusAccepted, gin.H{"batchId": batchID, "status": queue.BatchQueueing})
+	}
+}
+
+// queueListSend queues the emails of a list send, stopping when the server
+// shuts down, and records in the batch how many were rejected.
+func queueListSend(c *gin.Context, svc *Services, req *ListSendRequest, segment contacts.Segment, batchID string, variants []queue.Variant) {
+	ctx := c.Request.Context()
+	if err := planListSend(c, svc, segment, batchID); err != nil {
+		svc.Logger.Warn("List send stopped", "batch", batchID, "list", c.Param("id"), "error", err)
+		if err := svc.Queue.FinishBatchQueueing(context.WithoutCancel(ctx), batchID, 0, "stopped before queueing: "+err.Error()); err != nil {
+			svc.Logger.Error("Failed to finish list send", "batch", batchID, "error", err)
+		}
+		return
+	}
+
+	templateName := strings.TrimSpace(req.TemplateName)
+	matched, queued := 0, 0
+	seen := make(map[string]struct{})
+	err := svc.Contacts.Each(ctx, c.Param("id"), segment, func(contact contacts.Contact) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// HSCAN may return an entry twice while the hash is rehashed.
+		if _, dup := seen[contact.Email]; dup {
+			return nil
+		}
+		seen[contact.Email] = struct{}{}
+		matched++
+
+		emailReq := SendEmailRequest{
+			To:           contact.Email,
+			From:         req.From,
+			FromName:     req.FromName,
+			Subject:      req.Subject,
+			TemplateName: req.TemplateName,
+			Data:         contactData(svc, templateName, req.Data, contact),
+			Queue:        req.Queue,
+			Preheader:    req.Preheader,
+			Locale:       req.Locale,
+			UTM:          req.UTM,
+			Category:     req.Category,
+			Tags:         req.Tags,
+			Metadata:     req.Metadata,
+			Pool:         req.Pool,
+		}
+		if result := enqueueBatchEmail(c, svc, &emailReq, batchID, variants); result.ok() {
+			queued++
+		}
+		return nil
+	})
+
+	reason := ""
+	if err != nil {
+		reason = "stopped after " + strconv.Itoa(matched) + " contacts: " + err.Error()
+		svc.Logger.Warn("List send stopped", "batch", batchID, "list", c.Param("id"), "matched", matched, "queued", queued, "error", err)
+	}
+	// The server context may be canceled by now; the batch must still
+	// leave the queueing state.
+	if err := svc.Queue.FinishBatchQueueing(context.WithoutCancel(ctx), batchID, matched-queued, reason); err != nil {
+		svc.Logger.Error("Failed to finish list send", "batch", batchID, "error", err)
+	}
+}
+
+// planListSend counts the contacts a list send goes to and records the
+// total on its batch before any email is queued, so the recipients
+// approval rule holds all of the batch or none of it. Without that rule the
+// list is not read twice.
+func planListSend(c *gin.Context, svc *Services, segment contacts.Segment, batchID string) error {
+	if !svc.Queue.ApprovesBatches() {
+		return nil
+	}
+	seen := make(map[string]struct{})
+	err := svc.Contacts.Each(c.Request.Context(), c.Param("id"), segment, func(contact contacts.Contact) error {
+		seen[contact.Email] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return svc.Queue.PlanBatch(c.Request.Context(), batchID, len(seen))
+}
+
+// contactData merges the contact's attributes over the request data. In
+// strict mode only attributes the template reads are merged, since it
+// rejects unknown keys.
+func contactData(svc *Services, templateName string, data map[string]interface{}, contact contacts.Contact) map[string]interface{} {
+	merged := make(map[string]interface{}, len(data)+len(contact.Attributes))
+	for key, value := range data {
+		merged[key] = value
+	}
+	for key, value := range contact.Attributes {
+		if svc.Config.TemplateStrict && !svc.Templates.ReadsField(templateName, key) {
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+func listError(c *gin.Context, message string, err error) {
+	if errors.Is(err, contacts.ErrListNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, ErrorResponse{
+		Error: message,
+		Details: map[string]string{
+			"reason": err.Error(),
+		},
+	})
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// ListRequest holds the pagination, sorting and filter parameters shared by
+// the listing endpoints. Each endpoint names the filters it supports.
+type ListRequest struct {
+	Limit    int       `form:"limit" binding:"omitempty,min=1,max=200"`
+	Cursor   string    `form:"cursor"`
+	Sort     string    `form:"sort" binding:"omitempty,oneof=newest oldest"`
+	Status   string    `form:"status" binding:"omitempty,max=50"`
+	Template string    `form:"template" binding:"omitempty,max=50"`
+	Domain   string    `form:"domain" binding:"omitempty,fqdn"`
+	Tag      string    `form:"tag" binding:"omitempty,max=50"`
+	From     time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until    time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// listFilters are the ListRequest query parameters that only some
+// endpoints support. metadata is given as metadata[key]=value, once per
+// pair an item must carry.
+var listFilters = []string{"status", "template", "domain", "tag", "metadata", "from", "until", "sort"}
+
+// jobStatuses are the statuses a job can be listed by.
+var jobStatuses = []string{
+	events.TypeQueued, events.TypeSending, events.TypeSent, events.TypeRetried, events.TypeFailed,
+	events.TypeDeadLettered, events.TypeCanceled, events.TypeExpired, events.TypeOverflowed, events.TypeHeld, events.TypeApproved, events.TypeUnsubscribed, events.TypeComplained, events.TypeBounced,
+}
+
+// bindListQuery reads the shared listing parameters, rejecting filters the
+// endpoint does not support and statuses outside statuses, when given, and
+// limits the listing to the caller's tenant. It writes the error response
+// and returns false on invalid input.
+func bindListQuery(c *gin.Context, supported []string, statuses ...string) (queue.ListQuery, bool) {
+	var req ListRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid list request",
+			Details: map[string]string{"message": err.Error()},
+		})
+		return queue.ListQuery{}, false
+	}
+
+	metadata := c.QueryMap("metadata")
+	for _, filter := range listFilters {
+		given := c.Query(filter) != ""
+		if filter == "metadata" {
+			given = len(metadata) > 0
+		}
+		if given && !contains(supported, filter) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid list request",
+				Details: map[string]string{filter: fmt.Sprintf("not supported here; supported: %s", strings.Join(supported, ", "))},
+			})
+			return queue.ListQuery{}, false
+		}
+	}
+	if req.Status != "" && len(statuses) > 0 && !contains(statuses, req.Status) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid list request",
+			Details: map[string]string{"status": "must be one of " + strings.Join(statuses, ", ")},
+		})
+		return queue.ListQuery{}, false
+	}
+	for key, value := range metadata {
+		if key == "" || len(key) > 50 || len(value) > 500 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid list request",
+				Details: map[string]string{"metadata": "keys must be 1-50 characters and values at most 500"},
+			})
+			return queue.ListQuery{}, false
+		}
+	}
+	if !req.From.IsZero() && !req.Until.IsZero() && req.Until.Before(req.From) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid list request",
+			Details: map[string]string{"until": "must not be before from"},
+		})
+		return queue.ListQuery{}, false
+	}
+
+	query := queue.ListQuery{
+		Tenant:   requestTenant(c),
+		Status:   req.Status,
+		Template: strings.TrimSpace(req.Template),
+		Domain:   strings.TrimSpace(req.Domain),
+		Tag:      strings.TrimSpace(req.Tag),
+		Metadata: metadata,
+		From:     req.From,
+		Until:    req.Until,
+		Oldest:   req.Sort == "oldest",
+		Limit:    req.Limit,
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultPageLimit
+	}
+	if req.Cursor != "" {
+		after, err := queue.ParseCursor(req.Cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return queue.ListQuery{}, false
+		}
+		query.After = after
+	}
+	return query, true
+}
+
+// respondPage writes one page of a listing under key, with the cursor of the
+// next page when there is one.
+func respondPage(c *gin.Context, key string, items interface{}, next *queue.Cursor) {
+	response := gin.H{key: items}
+	if next != nil {
+		response["nextCursor"] = next.String()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func pageError(c *gin.Context, message string, err error) {
+	c.JSON(http.StatusInternalServerError, ErrorResponse{
+		Error:   message,
+		Details: map[string]string{"reason": err.Error()},
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
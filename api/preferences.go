@@ -0,0 +1,159 @@
+package api
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	recipient "github.com/sarthakyeole/redis-go-mailing-bulk/internal/recipientCheck"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/token"
+)
+
+// UpdatePreferencesRequest maps categories to whether the recipient is
+// subscribed to them; categories left out are unchanged.
+type UpdatePreferencesRequest struct {
+	Categories map[string]bool `json:"categories" binding:"required,min=1"`
+}
+
+type preferenceRow struct {
+	Category   string
+	Label      string
+	Subscribed bool
+}
+
+var preferencesPage = template.Must(template.New("preferences").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Email preferences</title></head>
+<body style="font-family: sans-serif; max-width: 480px; margin: 2em auto;">
+<h1>Email preferences</h1>
+<p>Choose which emails {{.Recipient}} receives.</p>
+{{if .Saved}}<p><strong>Your preferences have been saved.</strong></p>{{end}}
+<form method="post">
+{{range .Rows}}<p><label><input type="checkbox" name="category" value="{{.Category}}"{{if .Subscribed}} checked{{end}}> {{.Label}}</label></p>
+{{end}}<p><button type="submit">Save preferences</button></p>
+</form>
+</body></html>`))
+
+// preferencesPageHandler serves the hosted preference page linked from
+// preferencesURL (GET) and saves the submitted form (POST), where every
+// category left unchecked is opted out of.
+func preferencesPageHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := svc.Tokens.Verify(c.Param("token"), token.PurposePreferences)
+		if err != nil {
+			status := http.StatusNotFound
+			if errors.Is(err, token.ErrExpiredToken) {
+				status = http.StatusGone
+			}
+			c.JSON(status, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		saved := c.Request.Method == http.MethodPost
+		if saved {
+			checked := make(map[string]struct{})
+			for _, category := range c.PostFormArray("category") {
+				checked[category] = struct{}{}
+			}
+			preferences := make(map[string]bool, len(svc.Recipients.Categories()))
+			for _, category := range svc.Recipients.Categories() {
+				_, subscribed := checked[category]
+				preferences[category] = subscribed
+			}
+			if err := svc.Recipients.SetPreferences(c.Request.Context(), claims.Recipient, preferences); err != nil {
+				preferencesError(c, "failed to save preferences", err)
+				return
+			}
+		}
+
+		preferences, err := svc.Recipients.Preferences(c.Request.Context(), claims.Recipient)
+		if err != nil {
+			preferencesError(c, "failed to load preferences", err)
+			return
+		}
+
+		rows := make([]preferenceRow, 0, len(preferences))
+		for _, category := range svc.Recipients.Categories() {
+			rows = append(rows, preferenceRow{
+				Category:   category,
+				Label:      categoryLabel(category),
+				Subscribed: preferences[category],
+			})
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		preferencesPage.Execute(c.Writer, gin.H{"Recipient": claims.Recipient, "Rows": rows, "Saved": saved})
+	}
+}
+
+func getPreferencesHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		address := strings.TrimSpace(c.Param("email"))
+		if err := validate.Var(address, "required,email"); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid email address"})
+			return
+		}
+		preferences, err := svc.Recipients.Preferences(c.Request.Context(), address)
+		if err != nil {
+			preferencesError(c, "failed to load preferences", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"email": address, "categories": preferences})
+	}
+}
+
+func updatePreferencesHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req UpdatePreferencesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid preferences request",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+
+		address := strings.TrimSpace(c.Param("email"))
+		if err := validate.Var(address, "required,email"); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid email address"})
+			return
+		}
+		if err := svc.Recipients.SetPreferences(c.Request.Context(), address, req.Categories); err != nil {
+			preferencesError(c, "failed to save preferences", err)
+			return
+		}
+
+		preferences, err := svc.Recipients.Preferences(c.Request.Context(), address)
+		if err != nil {
+			preferencesError(c, "failed to load preferences", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"email": address, "categories": preferences})
+	}
+}
+
+// categoryLabel turns a category such as product_updates into "Product
+// updates" for the preference page.
+func categoryLabel(category string) string {
+	label := strings.NewReplacer("_", " ", "-", " ").Replace(category)
+	if label == "" {
+		return label
+	}
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+func preferencesError(c *gin.Context, message string, err error) {
+	if errors.Is(err, recipient.ErrUnknownCategory) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, ErrorResponse{
+		Error: message,
+		Details: map[string]string{
+			"reason": err.Error(),
+		},
+	})
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+)
+
+// ReadinessCheck is the outcome of one readiness check.
+type ReadinessCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// backlogTracker remembers since when each queue has been over
+// READY_MAX_QUEUE_DEPTH, as seen by the readiness probes.
+type backlogTracker struct {
+	mu        sync.Mutex
+	overSince map[string]time.Time
+}
+
+// observe records the queue depths seen at now and returns the queues that
+// have been over max for at least window, with how long.
+func (t *backlogTracker) observe(depths map[string]int64, max int64, window time.Duration, now time.Time) map[string]time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	backlogged := make(map[string]time.Duration)
+	for name, depth := range depths {
+		if depth <= max {
+			delete(t.overSince, name)
+			continue
+		}
+		since, ok := t.overSince[name]
+		if !ok {
+			since = now
+			t.overSince[name] = since
+		}
+		if over := now.Sub(since); over >= window {
+			backlogged[name] = over
+		}
+	}
+	return backlogged
+}
+
+// readinessHandler answers 503 when this instance should not get traffic:
+// Redis is unreachable, its worker has not heartbeated within
+// READY_MAX_HEARTBEAT_AGE, or a queue has held more than
+// READY_MAX_QUEUE_DEPTH tasks for READY_BACKLOG_DURATION. Unlike /health,
+// which only reports the process is up, it lets orchestrators and load
+// balancers react to stalled workers.
+func readinessHandler(svc *Services) gin.HandlerFunc {
+	cfg := svc.Config
+	backlog := &backlogTracker{overSince: make(map[string]time.Time)}
+
+	return func(c *gin.Context) {
+		now := time.Now()
+		checks := make(map[string]ReadinessCheck)
+
+		depths, err := svc.Queue.Depths(c.Request.Context())
+		if err != nil {
+			checks["redis"] = ReadinessCheck{Detail: err.Error()}
+		} else {
+			checks["redis"] = ReadinessCheck{OK: true}
+		}
+
+		// An api-mode instance runs no worker, so there is no loop to check.
+		if cfg.ReadyMaxHeartbeatAge > 0 && cfg.Mode != config.ModeAPI {
+			last := svc.Queue.LastPoll()
+			switch age := now.Sub(last); {
+			case last.IsZero():
+				checks["worker"] = ReadinessCheck{Detail: "worker has not polled its queues yet"}
+			case age > cfg.ReadyMaxHeartbeatAge:
+				checks["worker"] = ReadinessCheck{Detail: fmt.Sprintf("last queue poll %s ago", age.Round(time.Second))}
+			default:
+				checks["worker"] = ReadinessCheck{OK: true}
+			}
+		}
+
+		if cfg.ReadyMaxQueueDepth > 0 && depths != nil {
+			backlogged := backlog.observe(depths, cfg.ReadyMaxQueueDepth, cfg.ReadyBacklogDuration, now)
+			names := make([]string, 0, len(backlogged))
+			for name := range backlogged {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			if len(names) == 0 {
+				checks["backlog"] = ReadinessCheck{OK: true}
+			} else {
+				name := names[0]
+				checks["backlog"] = ReadinessCheck{Detail: fmt.Sprintf("queue %s has held %d tasks (over %d) for %s",
+					name, depths[name], cfg.ReadyMaxQueueDepth, backlogged[name].Round(time.Second))}
+			}
+		}
+
+		status, state := http.StatusOK, "ready"
+		for _, check := range checks {
+			if !check.OK {
+				status, state = http.StatusServiceUnavailable, "not ready"
+				break
+			}
+		}
+		c.JSON(status, gin.H{
+			"status": state,
+			"checks": checks,
+			"depths": depths,
+		})
+	}
+}
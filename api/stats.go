@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/stats"
+)
+
+const maxStatsRangeDays = 366
+
+// statsExportHandler returns per-day, per-template event counts as CSV (the
+// default) or JSON for spreadsheets and BI tools, optionally only for emails
+// with a tag.
+func statsExportHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		days, err := parseDayRange(c.DefaultQuery("range", "30d"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid stats request",
+				Details: map[string]string{"range": err.Error()},
+			})
+			return
+		}
+
+		format := c.DefaultQuery("format", "csv")
+		if format != "csv" && format != "json" {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid stats request",
+				Details: map[string]string{"format": "must be csv or json"},
+			})
+			return
+		}
+
+		tag := strings.TrimSpace(c.Query("tag"))
+		if len(tag) > 50 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid stats request",
+				Details: map[string]string{"tag": "must be at most 50 characters"},
+			})
+			return
+		}
+
+		rows, err := svc.Stats.Daily(c.Request.Context(), days, tag)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to load stats",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		if format == "json" {
+			if rows == nil {
+				rows = []stats.Row{}
+			}
+			response := gin.H{"days": days, "rows": rows}
+			if tag != "" {
+				response["tag"] = tag
+			}
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		filename := fmt.Sprintf("mail-stats-%s-%dd.csv", time.Now().UTC().Format("2006-01-02"), days)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+
+		w := csv.NewWriter(c.Writer)
+		w.Write(append([]string{"date", "template"}, stats.Counted...))
+		for _, row := range rows {
+			record := []string{row.Date, row.Template}
+			for _, eventType := range stats.Counted {
+				record = append(record, strconv.FormatInt(row.Counts[eventType], 10))
+			}
+			w.Write(record)
+		}
+		w.Flush()
+	}
+}
+
+// parseDayRange accepts a number of days written as "30d".
+func parseDayRange(value string) (int, error) {
+	days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+	if err != nil || !strings.HasSuffix(value, "d") {
+		return 0, fmt.Errorf("must be a number of days such as 30d")
+	}
+	if days < 1 || days > maxStatsRangeDays {
+		return 0, fmt.Errorf("must be between 1d and %dd", maxStatsRangeDays)
+	}
+	return days, nil
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+const (
+	// maxStreamLineBytes bounds one NDJSON line of a streaming bulk send.
+	maxStreamLineBytes = 1 << 20
+
+	// streamFlushEvery is how many results are written between flushes.
+	streamFlushEvery = 100
+)
+
+// StreamResult reports the outcome of one line of a streaming bulk send.
+type StreamResult struct {
+	Line int `json:"line"`
+	ItemResult
+}
+
+// StreamSummary is the last line of a streaming bulk send response.
+type StreamSummary struct {
+	Done       bool   `json:"done"`
+	BatchID    string `json:"batchId"`
+	Queued     int    `json:"queued"`
+	Duplicates int    `json:"duplicates"`
+	Rejected   int    `json:"rejected"`
+	Failed     int    `json:"failed"`
+	Error      string `json:"error,omitempty"`
+}
+
+// bulkStreamHandler reads newline-delimited send requests, queueing each as
+// it arrives and writing back one result line per request, then a summary.
+// There is no cap on the number of lines; ?batchId= groups them as in
+// /api/bulk-send, and ?utmSource=, ?utmMedium= and ?utmCampaign= apply to
+// every line without its own utm.
+func bulkStreamHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		batchID := c.Query("batchId")
+		if batchID == "" {
+			batchID = queue.NewBatchID()
+		} else if len(batchID) > 64 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "batchId is limited to 64 characters"})
+			return
+		} else if canceled, err := svc.Queue.BatchCanceled(c.Request.Context(), batchID); err == nil && canceled {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: queue.ErrBatchCanceled.Error()})
+			return
+		}
+
+		// Its size is only known once the body is read, so with the
+		// recipients approval rule on the whole batch is held.
+		if err := svc.Queue.PlanBatch(c.Request.Context(), batchID, queue.UnknownBatchTotal); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "failed to start bulk stream",
+				Details: map[string]string{"reason": err.Error()},
+			})
+			return
+		}
+
+		var utm *UTMRequest
+		if c.Query("utmSource") != "" || c.Query("utmMedium") != "" || c.Query("utmCampaign") != "" {
+			utm = &UTMRequest{Source: c.Query("utmSource"), Medium: c.Query("utmMedium"), Campaign: c.Query("utmCampaign")}
+		}
+
+		// Results are written while the body is still being read, which
+		// HTTP/1.1 servers only allow in full-duplex mode. HTTP/2 always can.
+		http.NewResponseController(c.Writer).EnableFullDuplex()
+
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Writer)
+		summary := StreamSummary{BatchID: batchID}
+
+		scanner := bufio.NewScanner(c.Request.Body)
+		scanner.Buffer(make([]byte, 64*1024), maxStreamLineBytes)
+		line := 0
+		for scanner.Scan() {
+			line++
+			raw := strings.TrimSpace(scanner.Text())
+			if raw == "" {
+				continue
+			}
+
+			result := streamSend(c, svc, line, raw, batchID, utm)
+			switch result.Status {
+			case itemQueued:
+				summary.Queued++
+			case itemDuplicate:
+				summary.Duplicates++
+			case itemRejected:
+				summary.Rejected++
+			default:
+				summary.Failed++
+			}
+			encoder.Encode(result)
+
+			if line%streamFlushEvery == 0 {
+				c.Writer.Flush()
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			if errors.Is(err, bufio.ErrTooLong) {
+				summary.Error = fmt.Sprintf("line %d is longer than %d bytes", line+1, maxStreamLineBytes)
+			} else {
+				summary.Error = err.Error()
+			}
+		}
+
+		summary.Done = true
+		encoder.Encode(summary)
+		c.Writer.Flush()
+	}
+}
+
+// streamSend validates and queues one line of a streaming bulk send.
+func streamSend(c *gin.Context, svc *Services, line int, raw, batchID string, utm *UTMRequest) StreamResult {
+	var req SendEmailRequest
+	if err := json.Unmarshal([]byte(raw), &req); err != nil {
+		return StreamResult{Line: line, ItemResult: ItemResult{
+			Status:  itemRejected,
+			Code:    codeInvalidJSON,
+			Error:   "invalid JSON",
+			Details: map[string]string{"message": err.Error()},
+		}}
+	}
+	if req.UTM == nil {
+		req.UTM = utm
+	}
+	return StreamResult{Line: line, ItemResult: enqueueBatchEmail(c, svc, &req, batchID, nil)}
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/apikey"
+	templates "github.com/sarthakyeole/redis-go-mailing-bulk/internal/emailTemplate"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+func templateLintHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		issues := svc.Templates.Lint()
+		if issues == nil {
+			issues = []templates.LintIssue{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"valid":  !templates.HasLintErrors(issues),
+			"issues": issues,
+		})
+	}
+}
+
+// PreviewRequest renders a template without sending it. Without data the
+// template's sample data is used.
+type PreviewRequest struct {
+	TemplateName string                 `json:"templateName" binding:"required" validate:"required,min=1,max=50"`
+	Data         map[string]interface{} `json:"data"`
+	Preheader    string                 `json:"preheader,omitempty" validate:"omitempty,max=250"`
+	Locale       string                 `json:"locale,omitempty" validate:"omitempty,bcp47_language_tag"`
+}
+
+// templatePreviewHandler returns the rendered HTML with its size against the
+// clipping limit. Tracking links are not applied, so sent messages are a
+// little larger.
+func templatePreviewHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req PreviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "invalid request",
+				Details: map[string]string{
+					"message": err.Error(),
+				},
+			})
+			return
+		}
+		if err := validateRequest(&req); err != nil {
+			response := ErrorResponse{Error: err.Error()}
+			if e, ok := err.(*ValidationError); ok {
+				response = ErrorResponse{Error: "validation failed", Details: e.Errors}
+			}
+			c.JSON(http.StatusBadRequest, response)
+			return
+		}
+
+		name := strings.TrimSpace(req.TemplateName)
+		data := sanitizeTemplateData(req.Data)
+		sampled := false
+		if req.Data == nil {
+			data, sampled = svc.Templates.SampleData(name)
+		}
+		body, err := svc.Templates.RenderWithSafeURLs(name, data, templates.WithLocale(strings.TrimSpace(req.Locale)))
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+				Error: "failed to render template",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+		body = templates.InjectPreheader(body, strings.TrimSpace(req.Preheader))
+
+		c.JSON(http.StatusOK, gin.H{
+			"html":      body,
+			"htmlBytes": len(body),
+			"clipLimit": svc.Config.HTMLClipLimit,
+			"clipped":   svc.Config.HTMLClipLimit > 0 && len(body) > svc.Config.HTMLClipLimit,
+			"sample":    sampled,
+		})
+	}
+}
+
+// TestSendRequest sends one template, rendered with its sample data, to the
+// caller's own address.
+type TestSendRequest struct {
+	To      string `json:"to" binding:"required,email"`
+	Subject string `json:"subject,omitempty" binding:"omitempty,max=200"`
+	Locale  string `json:"locale,omitempty"`
+	Queue   string `json:"queue,omitempty"`
+}
+
+// templateTestSendHandler queues a test email of a template filled with its
+// sample data, so the real rendering in mail clients can be checked before
+// a campaign. It goes through the same recipient checks as any send.
+func templateTestSendHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TestSendRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid test send request",
+				Details: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+
+		name := c.Param("name")
+		if _, ok := svc.Templates.Variables(name); !ok {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("template '%s' not found", name)})
+			return
+		}
+		sample, ok := svc.Templates.SampleData(name)
+		if !ok {
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+				Error: fmt.Sprintf("template '%s' has no sample data", name),
+				Details: map[string]string{
+					"reason": fmt.Sprintf("add %s.sample.json next to the template", name),
+				},
+			})
+			return
+		}
+
+		subject := strings.TrimSpace(req.Subject)
+		if subject == "" {
+			subject = "[Test] " + name
+		}
+		task, rejected := prepareTask(c, svc, &SendEmailRequest{
+			To:           req.To,
+			Subject:      subject,
+			TemplateName: name,
+			Data:         sample,
+			Queue:        req.Queue,
+			Locale:       req.Locale,
+		})
+		if rejected != nil {
+			c.JSON(rejected.status, rejected.response)
+			return
+		}
+
+		jobID, err := enqueue(c, svc, task)
+		if errors.Is(err, apikey.ErrQuotaExceeded) {
+			rejected := quotaRejection(c)
+			c.JSON(rejected.status, rejected.response)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "failed to queue email",
+				Details: map[string]string{"reason": err.Error()},
+			})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "test email was successfully added to the queue",
+			"details": gin.H{
+				"jobId":     jobID,
+				"template":  name,
+				"recipient": task.To,
+				"subject":   task.Subject,
+				"queue":     task.Queue,
+			},
+		})
+	}
+}
+
+const maxTemplateBundleBytes = 16 << 20
+
+// templateExportHandler downloads every template with its sample data as a
+// gzipped tar bundle.
+func templateExportHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bundle := svc.Templates.Export()
+
+		filename := fmt.Sprintf("templates-%s.tar.gz", bundle.Manifest.ExportedAt.Format("2006-01-02"))
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Header("Content-Type", "application/gzip")
+		c.Status(http.StatusOK)
+		templates.WriteBundle(c.Writer, bundle)
+	}
+}
+
+// templateImportHandler adds or replaces the templates of an exported bundle
+// on this instance and saves them for the others, which apply them on their
+// next reload or restart. With ?dryRun=true it only reports what the import
+// would do.
+func templateImportHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bundle, err := templates.ReadBundle(http.MaxBytesReader(c.Writer, c.Request.Body, maxTemplateBundleBytes))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid template bundle",
+				Details: map[string]string{"reason": err.Error()},
+			})
+			return
+		}
+
+		names := make([]string, 0, len(bundle.Sources))
+		for name := range bundle.Sources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		issues, err := svc.Templates.CheckBundle(bundle)
+		if err == nil && svc.Config.TemplateStrict && templates.HasLintErrors(issues) {
+			err = fmt.Errorf("%w: lint failed in strict mode", templates.ErrInvalidBundle)
+		}
+		if err == nil && c.Query("dryRun") != "true" {
+			if err = svc.TemplateStore.Save(c.Request.Context(), bundle); err != nil {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error:   "failed to save templates",
+					Details: map[string]string{"reason": err.Error()},
+				})
+				return
+			}
+			issues, err = svc.Templates.Import(bundle)
+		}
+		if issues == nil {
+			issues = []templates.LintIssue{}
+		}
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":  err.Error(),
+				"issues": issues,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"templates": names,
+			"dryRun":    c.Query("dryRun") == "true",
+			"issues":    issues,
+		})
+	}
+}
+
+// templateVariablesHandler lists the data keys a template reads, so callers
+// can discover what data a send needs.
+func templateVariablesHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		variables, ok := svc.Templates.Variables(name)
+		if !ok {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("template '%s' not found", name)})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"template":  name,
+			"strict":    svc.Config.TemplateStrict,
+			"variables": variables,
+		})
+	}
+}
+
+// templatesHandler lists the templates by name with the fields they read
+// and whether they have sample data. ?template= filters on a name prefix.
+func templatesHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := bindListQuery(c, []string{"template"})
+		if !ok {
+			return
+		}
+
+		found := []templates.BundleTemplate{}
+		var next *queue.Cursor
+		for _, tmpl := range svc.Templates.Export().Manifest.Templates {
+			if !strings.HasPrefix(tmpl.Name, query.Template) || query.After != nil && tmpl.Name <= query.After.ID {
+				continue
+			}
+			if len(found) == query.Limit {
+				next = &queue.Cursor{ID: found[len(found)-1].Name}
+				break
+			}
+			found = append(found, tmpl)
+		}
+		respondPage(c, "templates", found, next)
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDKey holds the request's ID in the gin context.
+const requestIDKey = "requestId"
+
+// traceParentPattern matches a W3C Trace Context traceparent header.
+var traceParentPattern = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// maxRequestIDLength bounds X-Request-ID values accepted from clients.
+const maxRequestIDLength = 128
+
+// longRunningRoutes stream for as long as the client reads, so the request
+// timeout does not apply to them.
+var longRunningRoutes = []string{
+	"/events/stream",
+	"/bulk-send/stream",
+	"/stats/export",
+	"/templates/export",
+}
+
+// enqueueRoutes queue mail. The deadline stops them queueing more, but
+// their own response stands, since a 504 would tell the client that mail
+// already queued had failed.
+var enqueueRoutes = []string{
+	"/send",
+	"/bulk-send",
+	"/broadcast",
+}
+
+// requestID gives every request an ID, taken from an X-Request-ID header
+// when the client or a proxy sent one, and echoes it in the response.
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := strings.TrimSpace(c.GetHeader("X-Request-ID"))
+		if id == "" || len(id) > maxRequestIDLength || strings.ContainsFunc(id, func(r rune) bool { return r < 0x21 || r > 0x7e }) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Set(requestIDKey, id)
+		c.Header("X-Request-ID", id)
+		c.Next()
+	}
+}
+
+// requestIDOf returns the ID requestID gave the request.
+func requestIDOf(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// requestTraceParent returns the request's W3C traceparent header, or ""
+// when it has none or it is malformed.
+func requestTraceParent(c *gin.Context) string {
+	traceParent := strings.ToLower(strings.TrimSpace(c.GetHeader("traceparent")))
+	if !traceParentPattern.MatchString(traceParent) {
+		return ""
+	}
+	return traceParent
+}
+
+// requestTimeout bounds how long handlers run. The request context gets a
+// deadline, which Redis calls honour, and a handler still running at the
+// deadline has its response replaced by 504 Gateway Timeout, unless it
+// had already started writing. Long-running streams are exempt, and
+// enqueue routes answer for themselves; a zero timeout disables it.
+func requestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout <= 0 || hasRouteSuffix(c.FullPath(), longRunningRoutes) {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		if hasRouteSuffix(c.FullPath(), enqueueRoutes) {
+			c.Next()
+			return
+		}
+
+		writer := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Writer.Written() {
+			c.AbortWithStatusJSON(http.StatusGatewayTimeout, ErrorResponse{
+				Error:     "request timed out",
+				Details:   map[string]string{"timeout": timeout.String()},
+				RequestID: requestIDOf(c),
+			})
+		}
+	}
+}
+
+func hasRouteSuffix(path string, routes []string) bool {
+	for _, route := range routes {
+		if strings.HasSuffix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeoutWriter drops what a handler writes once the request deadline has
+// passed, so requestTimeout can answer 504 instead of the handler's error
+// about the canceled context.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx context.Context
+}
+
+func (w *timeoutWriter) expired() bool {
+	return !w.ResponseWriter.Written() && w.ctx.Err() != nil
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if !w.expired() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	if w.expired() {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	if w.expired() {
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+	recipient "github.com/sarthakyeole/redis-go-mailing-bulk/internal/recipientCheck"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/token"
+)
+
+var trackingEvents = metrics.NewCounter(
+	"mailqueue_tracking_events_total",
+	"Verified tracking requests by event.",
+	"event",
+)
+
+// transparentGIF is a 1x1 transparent GIF served as the open pixel.
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// openPixelHandler always returns the pixel so a bad token never shows a
+// broken image, but only verified tokens are counted.
+func openPixelHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims, err := svc.Tokens.Verify(c.Param("token"), token.PurposeOpen); err == nil {
+			trackingEvents.Inc("open")
+			publishTrackingEvent(c, svc, events.TypeOpened, claims)
+		}
+
+		c.Header("Cache-Control", "no-store, no-cache, must-revalidate")
+		c.Data(http.StatusOK, "image/gif", transparentGIF)
+	}
+}
+
+func clickHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := svc.Tokens.Verify(c.Param("token"), token.PurposeClick)
+		if err != nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "link not found"})
+			return
+		}
+
+		trackingEvents.Inc("click")
+		publishTrackingEvent(c, svc, events.TypeClicked, claims)
+		c.Redirect(http.StatusFound, claims.URL)
+	}
+}
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Unsubscribe</title></head>
+<body style="font-family: sans-serif; max-width: 480px; margin: 2em auto;">
+{{if .Done}}<p>{{if .Category}}You have been unsubscribed from {{.Category}} emails.{{else}}You have been unsubscribed and will no longer receive these emails.{{end}}</p>
+{{else}}<h1>Unsubscribe</h1>
+<p>{{if .Category}}Stop sending {{.Category}} emails to {{.Recipient}}?{{else}}Stop sending emails to {{.Recipient}}?{{end}}</p>
+<form method="post"><p><button type="submit">Unsubscribe</button></p></form>
+{{end}}</body></html>`))
+
+// unsubscribeHandler serves the link in the email (GET) with a page asking
+// to confirm, and unsubscribes on the confirmation and on RFC 8058 one-click
+// requests from mailbox providers (POST). GET never unsubscribes, since
+// link scanners and mail security gateways follow links in received mail.
+// Mail sent in a subscription category opts the recipient out of that
+// category only.
+func unsubscribeHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := svc.Tokens.Verify(c.Param("token"), token.PurposeUnsubscribe)
+		if err != nil {
+			status := http.StatusNotFound
+			if errors.Is(err, token.ErrExpiredToken) {
+				status = http.StatusGone
+			}
+			c.JSON(status, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		category := claims.Category
+		if !svc.Recipients.HasCategory(category) {
+			category = ""
+		}
+		page := gin.H{"Recipient": claims.Recipient, "Category": strings.ToLower(categoryLabel(category))}
+
+		if c.Request.Method != http.MethodPost {
+			renderUnsubscribePage(c, page)
+			return
+		}
+
+		if category != "" {
+			err = svc.Recipients.SetPreferences(c.Request.Context(), claims.Recipient, map[string]bool{category: false})
+		} else {
+			err = svc.Recipients.Suppress(c.Request.Context(), claims.Recipient, recipient.SuppressionUnsubscribe)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to unsubscribe",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		trackingEvents.Inc("unsubscribe")
+		publishTrackingEvent(c, svc, events.TypeUnsubscribed, claims)
+		page["Done"] = true
+		renderUnsubscribePage(c, page)
+	}
+}
+
+func renderUnsubscribePage(c *gin.Context, page gin.H) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	unsubscribePage.Execute(c.Writer, page)
+}
+
+func publishTrackingEvent(c *gin.Context, svc *Services, eventType string, claims token.Claims) {
+	if svc.Events == nil {
+		return
+	}
+	event := events.Event{
+		Type:      eventType,
+		JobID:     claims.Job,
+		Recipient: claims.Recipient,
+		Template:  claims.Template,
+		BatchID:   claims.Batch,
+		Variant:   claims.Variant,
+		URL:       claims.URL,
+		Timestamp: time.Now().UTC(),
+	}
+	// Links only carry the job ID, since recipients can read them; its
+	// labels are looked up while the job status is kept.
+	if claims.Job != "" {
+		if status, err := svc.Queue.JobStatus(c.Request.Context(), claims.Job); err == nil {
+			event.Tenant = status.Tenant
+			event.Tags = status.Tags
+			event.Metadata = status.Metadata
+		}
+	}
+	svc.Events.Publish(c.Request.Context(), event)
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+)
+
+// variantNamePattern keeps variant names usable in stats keys.
+var variantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// VariantRequest is one arm of a bulk request's A/B test.
+type VariantRequest struct {
+	Name         string `json:"name" binding:"required,max=50"`
+	Subject      string `json:"subject,omitempty" binding:"omitempty,max=200"`
+	TemplateName string `json:"templateName,omitempty" binding:"omitempty,max=50"`
+	Weight       int    `json:"weight" binding:"required,min=1,max=100"`
+}
+
+// prepareVariants checks a bulk request's A/B test: unique names, a subject
+// or template per variant, known templates and weights adding up to 100.
+func prepareVariants(svc *Services, reqs []VariantRequest) ([]queue.Variant, *rejection) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]struct{})
+	for _, name := range svc.Templates.ListAvailabletemplates() {
+		known[name] = struct{}{}
+	}
+
+	variants := make([]queue.Variant, 0, len(reqs))
+	seen := make(map[string]struct{}, len(reqs))
+	total := 0
+	for _, req := range reqs {
+		variant := queue.Variant{
+			Name:         strings.TrimSpace(req.Name),
+			Subject:      strings.TrimSpace(req.Subject),
+			TemplateName: strings.TrimSpace(req.TemplateName),
+			Weight:       req.Weight,
+		}
+
+		switch _, dup := seen[variant.Name]; {
+		case !variantNamePattern.MatchString(variant.Name):
+			return nil, validationRejection(http.StatusBadRequest, codeInvalidVariants, "Variants", fmt.Sprintf("variant name %q may only contain letters, digits, '-' and '_'", variant.Name))
+		case dup:
+			return nil, validationRejection(http.StatusBadRequest, codeInvalidVariants, "Variants", fmt.Sprintf("duplicate variant %q", variant.Name))
+		case variant.Subject == "" && variant.TemplateName == "":
+			return nil, validationRejection(http.StatusBadRequest, codeInvalidVariants, "Variants", fmt.Sprintf("variant %q needs a subject or templateName", variant.Name))
+		}
+
+		if variant.TemplateName != "" {
+			if svc.Config.TemplatePrerender {
+				return nil, validationRejection(http.StatusBadRequest, codeInvalidVariants, "Variants", "template variants cannot be used with TEMPLATE_PRERENDER")
+			}
+			if _, ok := known[variant.TemplateName]; !ok {
+				return nil, validationRejection(http.StatusBadRequest, codeInvalidVariants, "Variants", fmt.Sprintf("template '%s' not found", variant.TemplateName))
+			}
+		}
+
+		seen[variant.Name] = struct{}{}
+		total += variant.Weight
+		variants = append(variants, variant)
+	}
+
+	if total != 100 {
+		return nil, validationRejection(http.StatusBadRequest, codeInvalidVariants, "Variants", fmt.Sprintf("weights add up to %d, not 100", total))
+	}
+	return variants, nil
+}
+
+// validateVariantData checks the task's data against every variant's
+// template, since the worker may render any of them.
+func validateVariantData(svc *Services, variants []queue.Variant, task queue.EmailTask) *rejection {
+	for _, variant := range variants {
+		if variant.TemplateName == "" || variant.TemplateName == task.TemplateName {
+			continue
+		}
+		if err := svc.Templates.ValidateData(variant.TemplateName, task.Data); err != nil {
+			return validationRejection(http.StatusBadRequest, codeInvalidData, "Data", err.Error())
+		}
+	}
+	return nil
+}
+
+// batchVariantsHandler reports the per-variant counts and open and click
+// rates of a batch's A/B test.
+func batchVariantsHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := checkBatchTenant(c, svc, c.Param("id")); err != nil {
+			if errors.Is(err, queue.ErrBatchNotFound) {
+				c.JSON(http.StatusNotFound, ErrorResponse{Error: "no variant stats for batch"})
+			} else {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error:   "failed to load variant stats",
+					Details: map[string]string{"reason": err.Error()},
+				})
+			}
+			return
+		}
+		rows, err := svc.Stats.Variants(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to load variant stats",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+		if rows == nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "no variant stats for batch"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"batchId": c.Param("id"), "variants": rows})
+	}
+}
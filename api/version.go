@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// currentAPIVersion is the newest API version. Breaking changes to request
+// or response formats go into a new version under its own /api/vN prefix,
+// while older prefixes keep their behaviour.
+const currentAPIVersion = 1
+
+// supportedAPIVersions lists every version still served, oldest first.
+var supportedAPIVersions = []int{1}
+
+// apiVersion tags responses with the version that served them and rejects
+// requests asking for a different one, through the API-Version header or an
+// Accept type of application/vnd.mailqueue.vN+json, with 406 Not
+// Acceptable. Deprecated routes (the unversioned /api prefix) also get the
+// Deprecation header and a Link to their /api/vN successor.
+func apiVersion(version int, deprecated bool) gin.HandlerFunc {
+	served := strconv.Itoa(version)
+	return func(c *gin.Context) {
+		c.Header("API-Version", served)
+		if deprecated {
+			c.Header("Deprecation", "true")
+			if successor := versionedPath(c.Request.URL.Path, currentAPIVersion); successor != "" {
+				c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+			}
+		}
+
+		requested, ok := requestedAPIVersion(c.Request)
+		if ok && requested != served {
+			versions := make([]string, len(supportedAPIVersions))
+			for i, v := range supportedAPIVersions {
+				versions[i] = strconv.Itoa(v)
+			}
+			c.AbortWithStatusJSON(http.StatusNotAcceptable, ErrorResponse{
+				Error: "unsupported API version " + requested,
+				Details: map[string]string{
+					"served":    served,
+					"supported": strings.Join(versions, ","),
+				},
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestedAPIVersion returns the version a client asked for, if any. The
+// API-Version header wins over the Accept header.
+func requestedAPIVersion(r *http.Request) (string, bool) {
+	if version := strings.TrimPrefix(strings.TrimSpace(r.Header.Get("API-Version")), "v"); version != "" {
+		return version, true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(accept), ";")
+		mediaType = strings.TrimSpace(mediaType)
+		if !strings.HasPrefix(mediaType, "application/vnd.mailqueue.v") {
+			continue
+		}
+		version := strings.TrimPrefix(mediaType, "application/vnd.mailqueue.v")
+		version = strings.TrimSuffix(version, "+json")
+		return version, true
+	}
+	return "", false
+}
+
+// versionedPath maps an unversioned /api path to its /api/vN equivalent.
+func versionedPath(path string, version int) string {
+	rest, ok := strings.CutPrefix(path, "/api/")
+	if !ok {
+		return ""
+	}
+	return "/api/v" + strconv.Itoa(version) + "/" + rest
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// workersHandler lists the worker instances sharing this Redis. Stale
+// workers stopped heartbeating without deregistering; their in-flight tasks
+// are reclaimed by the leader.
+func workersHandler(svc *Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workers, err := svc.Queue.Workers(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to list workers",
+				Details: map[string]string{
+					"reason": err.Error(),
+				},
+			})
+			return
+		}
+
+		live := 0
+		for _, worker := range workers {
+			if !worker.Stale {
+				live++
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"workers": workers,
+			"live":    live,
+			"stale":   len(workers) - live,
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	templates "github.com/sarthakyeole/redis-go-mailing-bulk/internal/emailTemplate"
+)
+
+// runCommand dispatches one-off CLI commands and returns the exit code.
+func runCommand(cfg *config.ApplicationConfig, args []string) int {
+	switch strings.Join(args, " ") {
+	case "template lint":
+		return runTemplateLint(cfg)
+	}
+	if args[0] == "loadtest" {
+		return runLoadTest(cfg, args[1:])
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command: %s\n", strings.Join(args, " "))
+	fmt.Fprintln(os.Stderr, "available commands:")
+	fmt.Fprintln(os.Stderr, "  template lint   check every template against its sample data")
+	fmt.Fprintln(os.Stderr, "  selftest        check config, Redis, templates and SMTP (also --selftest)")
+	fmt.Fprintln(os.Stderr, "  loadtest        measure queue throughput with a dry-run sender (-n, -c, -template, -timeout)")
+	return 2
+}
+
+func runTemplateLint(cfg *config.ApplicationConfig) int {
+	tmpl, err := templates.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	issues := tmpl.Lint()
+	for _, issue := range issues {
+		fmt.Printf("%s: %s: %s\n", issue.Template, issue.Severity, issue.Message)
+	}
+
+	if templates.HasLintErrors(issues) {
+		return 1
+	}
+
+	fmt.Printf("%d templates checked, no errors\n", len(tmpl.ListAvailabletemplates()))
+	return 0
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	templates "github.com/sarthakyeole/redis-go-mailing-bulk/internal/emailTemplate"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	storage "github.com/sarthakyeole/redis-go-mailing-bulk/internal/objectStore"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+	email "github.com/sarthakyeole/redis-go-mailing-bulk/internal/senderSide"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/token"
+)
+
+// loadTestQueue is the queue the load test uses, so workers of a running
+// deployment sharing the Redis never pick up its tasks.
+const loadTestQueue = "loadtest"
+
+// runLoadTest enqueues synthetic tasks on a private queue, consumes them
+// with a dry-run sender and reports throughput, enqueue-to-sent latency and
+// Redis commands per second.
+func runLoadTest(cfg *config.ApplicationConfig, args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	n := flags.Int("n", 1000, "number of tasks to enqueue")
+	concurrency := flags.Int("c", 10, "workers consuming the queue, and goroutines enqueueing")
+	templateName := flags.String("template", "", "template to render (default: the first one with sample data)")
+	timeout := flags.Duration("timeout", 5*time.Minute, "give up when the tasks are not all processed by then")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *n <= 0 || *concurrency <= 0 {
+		fmt.Fprintln(os.Stderr, "error: -n and -c must be positive")
+		return 2
+	}
+
+	tmpl, err := templates.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	name, sample, err := loadTestTemplate(tmpl, *templateName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	client, err := queue.NewRedisClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+	commands := &commandCounter{}
+	client.AddHook(commands)
+
+	store, err := storage.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	tokens, err := token.New(cfg.TrackingTokenKeys, cfg.TrackingTokenTTL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	sender, err := email.NewDryRunSender(cfg, client, tmpl, store, tokens)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	// Only the load test queue is consumed. Failures are not retried, since
+	// no delayed promoter runs, and nothing throttles the workers.
+	ltCfg := *cfg
+	ltCfg.Queues = []config.QueueConfig{{Name: loadTestQueue, Concurrency: *concurrency}}
+	ltCfg.InstanceID = cfg.InstanceID + "-loadtest"
+	ltCfg.WarmupSchedule = nil
+	ltCfg.MaxSendRate = 0
+	ltCfg.ISPs = nil
+	ltCfg.SendCategories = nil
+	ltCfg.EnqueueDedupWindow = 0
+	ltCfg.SoftBounceMaxRetries = 0
+	ltCfg.SMTPOverflow = false
+	ltCfg.ApprovalRules = nil
+
+	recorder := newLoadTestRecorder(*n)
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	q := queue.NewRedisQueue(&ltCfg, client, sender, store, nil, recorder, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	workersDone := make(chan struct{})
+	go func() {
+		q.StartWorker(ctx)
+		close(workersDone)
+	}()
+
+	fmt.Printf("enqueueing %d tasks (template %s, %d workers)\n", *n, name, *concurrency)
+	batchID := queue.NewBatchID()
+	start := time.Now()
+	var next atomic.Int64
+	var enqueueFailures atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := next.Add(1) - 1; i < int64(*n); i = next.Add(1) - 1 {
+				task := queue.EmailTask{
+					ID:           fmt.Sprintf("loadtest-%s-%d", batchID, i),
+					BatchID:      batchID,
+					To:           fmt.Sprintf("loadtest+%d@example.invalid", i),
+					Subject:      "Load test",
+					TemplateName: name,
+					Data:         sample,
+					Queue:        loadTestQueue,
+				}
+				recorder.enqueued(task.ID)
+				if _, err := q.EnqueueEmail(ctx, task); err != nil {
+					enqueueFailures.Add(1)
+					recorder.lost(task.ID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	enqueueTime := time.Since(start)
+
+	finished := true
+	select {
+	case <-recorder.done:
+	case <-time.After(*timeout - time.Since(start)):
+		finished = false
+	}
+	elapsed := time.Since(start)
+	ops := commands.n.Load()
+
+	cancel()
+	<-workersDone
+
+	latencies, sent, failed := recorder.results()
+	fmt.Printf("enqueued   %d in %s (%.0f/s), %d failed to enqueue\n",
+		int64(*n)-enqueueFailures.Load(), enqueueTime.Round(time.Millisecond), float64(*n)/enqueueTime.Seconds(), enqueueFailures.Load())
+	fmt.Printf("processed  %d sent, %d failed in %s\n", sent, failed, elapsed.Round(time.Millisecond))
+	fmt.Printf("throughput %.0f emails/s\n", float64(sent+failed)/elapsed.Seconds())
+	if len(latencies) > 0 {
+		fmt.Printf("latency    p50 %s  p99 %s  max %s (enqueue to sent)\n",
+			percentile(latencies, 0.50), percentile(latencies, 0.99), latencies[len(latencies)-1])
+	}
+	fmt.Printf("redis      %d commands, %.0f ops/s\n", ops, float64(ops)/elapsed.Seconds())
+
+	if !finished {
+		fmt.Printf("timed out after %s with %d tasks unprocessed\n", *timeout, int64(*n)-enqueueFailures.Load()-int64(sent+failed))
+		return 1
+	}
+	if failed > 0 || enqueueFailures.Load() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadTestTemplate returns the named template, or the first one with sample
+// data, along with its sample data.
+func loadTestTemplate(tmpl *templates.Manager, name string) (string, map[string]interface{}, error) {
+	names := tmpl.ListAvailabletemplates()
+	if name != "" {
+		if !slices.Contains(names, name) {
+			return "", nil, fmt.Errorf("unknown template %q", name)
+		}
+		sample, _ := tmpl.SampleData(name)
+		return name, sample, nil
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		if sample, ok := tmpl.SampleData(name); ok {
+			return name, sample, nil
+		}
+	}
+	return "", nil, fmt.Errorf("no template has sample data; pick one with -template")
+}
+
+// percentile returns the p-th percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)].Round(time.Microsecond)
+}
+
+// loadTestRecorder receives the queue's events and times each task from
+// enqueue until it is sent or fails.
+type loadTestRecorder struct {
+	mu        sync.Mutex
+	started   map[string]time.Time
+	latencies []time.Duration
+	sent      int
+	failed    int
+	pending   int
+	done      chan struct{}
+}
+
+func newLoadTestRecorder(n int) *loadTestRecorder {
+	return &loadTestRecorder{
+		started: make(map[string]time.Time, n),
+		pending: n,
+		done:    make(chan struct{}),
+	}
+}
+
+func (r *loadTestRecorder) enqueued(id string) {
+	r.mu.Lock()
+	r.started[id] = time.Now()
+	r.mu.Unlock()
+}
+
+// lost counts a task that never made it onto the queue.
+func (r *loadTestRecorder) lost(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.started, id)
+	r.finish()
+}
+
+func (r *loadTestRecorder) Publish(_ context.Context, event events.Event) {
+	if event.Type != events.TypeSent && event.Type != events.TypeFailed {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	start, ok := r.started[event.JobID]
+	if !ok {
+		return
+	}
+	delete(r.started, event.JobID)
+	r.latencies = append(r.latencies, time.Since(start))
+	if event.Type == events.TypeSent {
+		r.sent++
+	} else {
+		r.failed++
+	}
+	r.finish()
+}
+
+// finish counts one task as done; the caller holds mu.
+func (r *loadTestRecorder) finish() {
+	r.pending--
+	if r.pending == 0 {
+		close(r.done)
+	}
+}
+
+// results returns the sorted latencies and the sent and failed counts.
+func (r *loadTestRecorder) results() ([]time.Duration, int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latencies := append([]time.Duration(nil), r.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies, r.sent, r.failed
+}
+
+// commandCounter counts the Redis commands sent by a client, each command
+// of a pipeline included.
+type commandCounter struct {
+	n atomic.Int64
+}
+
+func (c *commandCounter) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (c *commandCounter) AfterProcess(context.Context, redis.Cmder) error {
+	c.n.Add(1)
+	return nil
+}
+
+func (c *commandCounter) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (c *commandCounter) AfterProcessPipeline(_ context.Context, cmds []redis.Cmder) error {
+	c.n.Add(int64(len(cmds)))
+	return nil
+}
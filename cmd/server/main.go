@@ -2,73 +2,431 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sarthakyeole/redis-go-mailing-bulk/api"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/apikey"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/assets"
 	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/contacts"
 	templates "github.com/sarthakyeole/redis-go-mailing-bulk/internal/emailTemplate"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/leader"
+	storage "github.com/sarthakyeole/redis-go-mailing-bulk/internal/objectStore"
+	recipient "github.com/sarthakyeole/redis-go-mailing-bulk/internal/recipientCheck"
 	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/retention"
 	email "github.com/sarthakyeole/redis-go-mailing-bulk/internal/senderSide"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/stats"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/token"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/webhook"
 )
 
 func main() {
-	cfg := config.LoadConfiguration()
+	if err := config.LoadDotEnv(); err != nil {
+		log.Fatalf("Error loading .env file: %v", err)
+	}
+	mode, args, modeErr := parseModeFlag(os.Args[1:])
+	if modeErr != nil {
+		log.Fatal(modeErr)
+	}
+	cfg, err := config.Load()
+	if mode != "" {
+		cfg.Mode = mode
+	}
+	// The self-test reports configuration errors as one of its steps.
+	if len(args) > 0 && isSelfTest(args[0]) {
+		os.Exit(runSelfTest(cfg, err))
+	}
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	if !config.IsProfile(cfg.Environment) {
+		log.Fatalf("Unknown APP_ENV %q: expected dev, staging or prod", cfg.Environment)
+	}
 
-	tmpl, err := templates.New()
+	if len(args) > 0 {
+		os.Exit(runCommand(cfg, args))
+	}
+
+	// api instances serve HTTP and only enqueue; worker instances consume the
+	// queues and serve nothing. all does both.
+	runAPI := cfg.Mode != config.ModeWorker
+	runWorkers := cfg.Mode != config.ModeAPI
+
+	tmpl, err := templates.New(cfg)
 	if err != nil {
 		log.Fatalf("Error initializing templates: %v", err)
 	}
 
+	lintIssues := tmpl.Lint()
+	for _, issue := range lintIssues {
+		log.Printf("Template lint %s: %s: %s", issue.Severity, issue.Template, issue.Message)
+	}
+	if cfg.TemplateStrict && templates.HasLintErrors(lintIssues) {
+		log.Fatalf("Template lint failed in strict mode")
+	}
+
 	redisClient, err := queue.NewRedisClient(cfg)
 	if err != nil {
 		log.Fatalf("Error connecting to Redis: %v", err)
 	}
 	defer redisClient.Close()
 
-	emailService := email.NewSender(cfg, tmpl)
+	// Templates imported through the API are kept in Redis and applied over
+	// the built-in ones at startup and on every reload. A template that no
+	// longer parses or lints is skipped rather than failing the rest.
+	templateStore := templates.NewStore(redisClient)
+	importTemplates := func() error {
+		bundle, err := templateStore.Load(context.Background())
+		if err != nil || bundle == nil {
+			return err
+		}
+		issues, err := tmpl.Import(bundle)
+		if errors.Is(err, templates.ErrInvalidBundle) {
+			issues, err = importTemplatesEach(tmpl, bundle), nil
+		}
+		for _, issue := range issues {
+			log.Printf("Template lint %s: %s: %s", issue.Severity, issue.Template, issue.Message)
+		}
+		return err
+	}
+	if err := importTemplates(); err != nil {
+		log.Printf("Imported templates not applied, using the built-in ones: %v", err)
+	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	redisQueue := queue.NewRedisQueue(redisClient, emailService, logger)
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("Error initializing object store: %v", err)
+	}
+
+	tokens, err := token.New(cfg.TrackingTokenKeys, cfg.TrackingTokenTTL)
+	if err != nil {
+		log.Fatalf("Error initializing tracking tokens: %v", err)
+	}
+
+	emailService, err := email.NewSender(cfg, redisClient, tmpl, store, tokens)
+	if err != nil {
+		log.Fatalf("Error initializing email sender: %v", err)
+	}
+
+	logLevel := new(slog.LevelVar)
+	if err := logLevel.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		log.Fatalf("Invalid LOG_LEVEL: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+
+	templateSyncer, err := templates.NewSyncer(cfg, tmpl, store, logger)
+	if err != nil {
+		log.Fatalf("Error initializing template sync: %v", err)
+	}
+
+	var publishers events.Publishers
+	dispatcher := webhook.New(cfg, redisClient, logger)
+	if dispatcher != nil {
+		publishers = append(publishers, dispatcher)
+	}
+	redisPublisher := events.NewRedisPublisher(cfg, redisClient, logger)
+	if redisPublisher != nil {
+		publishers = append(publishers, redisPublisher)
+	}
+
+	statsRecorder := stats.NewRecorder(redisClient, cfg.StatsRetention, logger)
+	publishers = append(publishers, statsRecorder)
+
+	recipientValidator, err := recipient.NewValidator(cfg, redisClient)
+	if err != nil {
+		log.Fatalf("Error initializing recipient validation: %v", err)
+	}
+	publishers = append(publishers, recipient.NewBounceTracker(cfg, recipientValidator, logger))
+
+	// The live event stream follows EVENTS_CHANNEL when it is set, so it sees
+	// every instance; otherwise it only sees events from this process.
+	hub := events.NewHub()
+	if cfg.EventsChannel == "" {
+		publishers = append(publishers, hub)
+	}
+
+	redisQueue := queue.NewRedisQueue(cfg, redisClient, emailService, store, recipientValidator, publishers, logger)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go redisQueue.StartWorker(ctx)
+	if dispatcher != nil && runWorkers {
+		go dispatcher.Run(ctx)
+	}
+	if templateSyncer != nil {
+		go templateSyncer.Run(ctx)
+	}
+	if cfg.EventsChannel != "" && runAPI {
+		go hub.Relay(ctx, redisClient, cfg.EventsChannel, logger)
+	}
 
-	router := gin.Default()
-	api.RegisterHandlers(router, redisQueue)
+	policies := []retention.Policy{
+		{Kind: "history", MaxAge: cfg.HistoryRetention, Cleanup: redisQueue.CleanupHistory},
+		{Kind: "dead_letter", MaxAge: cfg.DeadLetterRetention, Cleanup: redisQueue.CleanupDeadLetters},
+	}
+	if dispatcher != nil {
+		policies = append(policies, retention.Policy{Kind: "webhook_dead_letter", MaxAge: cfg.DeadLetterRetention, Cleanup: dispatcher.CleanupDeadLetters})
+	}
+	if redisPublisher != nil {
+		policies = append(policies, retention.Policy{Kind: "events", MaxAge: cfg.EventsRetention, Cleanup: redisPublisher.TrimStream})
+	}
+	cleaner := retention.New(cfg.RetentionInterval, logger, policies...)
 
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", cfg.ServerPort),
-		Handler: router,
+	// Every worker instance consumes the queues, but only the elected leader
+	// among them promotes delayed tasks and webhook retries, reclaims tasks
+	// held by dead instances and runs retention cleanup.
+	workersDone := make(chan struct{})
+	if runWorkers {
+		elector := leader.New(redisClient, cfg.LeaderLockKey, cfg.InstanceID, cfg.LeaderLockTTL, logger)
+		go elector.Run(ctx, func(ctx context.Context) {
+			go redisQueue.RunDelayedPromoter(ctx)
+			go redisQueue.RunOrphanReclaimer(ctx)
+			if dispatcher != nil {
+				go dispatcher.RunPromoter(ctx)
+			}
+			cleaner.Run(ctx)
+			<-ctx.Done()
+		})
+
+		go func() {
+			redisQueue.StartWorker(ctx)
+			close(workersDone)
+		}()
+	} else {
+		close(workersDone)
+	}
+
+	// reload applies the settings that can change without a restart. Every
+	// new value is validated before any of them is applied.
+	reload := func() error {
+		newCfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(newCfg.LogLevel)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL: %w", err)
+		}
+		if err := importTemplates(); err != nil {
+			return err
+		}
+		if err := recipientValidator.ReloadPolicy(newCfg); err != nil {
+			return err
+		}
+		redisQueue.Reconfigure(newCfg.Queues)
+		redisQueue.SetMaxSendRate(newCfg.MaxSendRate)
+		logLevel.Set(level)
+		return nil
 	}
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Error starting server: %v", err)
+		for range hup {
+			if err := reload(); err != nil {
+				log.Printf("Configuration reload failed: %v", err)
+				continue
+			}
+			log.Println("Configuration reloaded")
 		}
 	}()
 
-	log.Printf("Server started on port %s", cfg.ServerPort)
+	// background counts API work that continues after its response, such
+	// as list sends, so shutdown can wait for it.
+	var background sync.WaitGroup
+
+	var srv, redirectSrv *http.Server
+	if runAPI {
+		srv, redirectSrv = startHTTP(cfg, &api.Services{
+			Context:       ctx,
+			Background:    &background,
+			Config:        cfg,
+			Queue:         redisQueue,
+			Recipients:    recipientValidator,
+			Templates:     tmpl,
+			TemplateStore: templateStore,
+			Assets:        assets.New(cfg, store),
+			Store:         store,
+			Tokens:        tokens,
+			Events:        publishers,
+			Hub:           hub,
+			Stats:         statsRecorder,
+			Contacts:      contacts.NewStore(redisClient),
+			Keys:          apikey.NewStore(redisClient, cfg),
+			Reload:        reload,
+			Logger:        logger,
+		})
+	} else {
+		srv = startHealthHTTP(cfg, &api.Services{
+			Config: cfg,
+			Queue:  redisQueue,
+			Logger: logger,
+		})
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
 
-	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Error shutting down server: %v", err)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if srv != nil {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Fatalf("Error shutting down server: %v", err)
+		}
+	}
+	if redirectSrv != nil {
+		redirectSrv.Shutdown(shutdownCtx)
+	}
+
+	// Stop the workers and background API work: tasks popped but not yet
+	// sent go back to the head of their queue and sends already in progress
+	// are given time to finish.
+	log.Println("Stopping workers...")
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		<-workersDone
+		background.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		emailService.Close()
+	case <-time.After(cfg.WorkerShutdownTimeout):
+		log.Println("Timed out waiting for in-flight emails")
 	}
 
 	log.Println("Server shut down successfully")
 }
+
+// startHTTP registers the API on a new router and starts serving it, plus
+// the HTTP to HTTPS redirect when TLS and HTTP_REDIRECT_PORT are set. The
+// redirect server is nil when it is not running.
+func startHTTP(cfg *config.ApplicationConfig, svc *api.Services) (*http.Server, *http.Server) {
+	router := gin.Default()
+	if err := api.RegisterHandlers(router, svc); err != nil {
+		log.Fatalf("Error configuring HTTP handlers: %v", err)
+	}
+
+	// Route groups enforce their own header limits below this one.
+	srv := &http.Server{
+		Addr:           fmt.Sprintf(":%s", cfg.ServerPort),
+		Handler:        router,
+		MaxHeaderBytes: max(cfg.HTTPMaxHeaderBytes, cfg.PublicMaxHeaderBytes),
+	}
+	srv.RegisterOnShutdown(svc.Hub.Close)
+
+	serverTLS, err := newServerTLS(cfg)
+	if err != nil {
+		log.Fatalf("Error configuring TLS: %v", err)
+	}
+
+	go func() {
+		var err error
+		if serverTLS != nil {
+			err = serverTLS.listen(srv)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Error starting server: %v", err)
+		}
+	}()
+
+	var redirectSrv *http.Server
+	if serverTLS != nil && cfg.HTTPRedirectPort != "" {
+		redirectSrv = &http.Server{
+			Addr:              fmt.Sprintf(":%s", cfg.HTTPRedirectPort),
+			Handler:           serverTLS.redirectHandler(cfg.ServerPort),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := redirectSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Error starting HTTP redirect server: %v", err)
+			}
+		}()
+		log.Printf("Redirecting HTTP on port %s to HTTPS", cfg.HTTPRedirectPort)
+	}
+
+	if serverTLS != nil {
+		log.Printf("Server started with TLS on port %s (mode %s)", cfg.ServerPort, cfg.Mode)
+	} else {
+		log.Printf("Server started on port %s (mode %s)", cfg.ServerPort, cfg.Mode)
+	}
+	return srv, redirectSrv
+}
+
+// startHealthHTTP serves only /healthz, /readyz and /metrics on
+// WORKER_HEALTH_PORT, so worker-mode pods can be probed and scraped without
+// running the API. It is plain HTTP, meant for the cluster network.
+func startHealthHTTP(cfg *config.ApplicationConfig, svc *api.Services) *http.Server {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	api.RegisterWorkerHandlers(router, svc)
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%s", cfg.WorkerHealthPort),
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Error starting health server: %v", err)
+		}
+	}()
+	log.Printf("Worker started without the HTTP API; health endpoints on port %s", cfg.WorkerHealthPort)
+	return srv
+}
+
+// importTemplatesEach imports the templates of a bundle that failed as a
+// whole one at a time, logging and skipping those that fail on their own.
+// Templates are retried until a pass imports none, so one that uses another
+// template of the bundle is imported once that one is.
+func importTemplatesEach(tmpl *templates.Manager, bundle *templates.Bundle) []templates.LintIssue {
+	pending := make(map[string]error, len(bundle.Sources))
+	for name := range bundle.Sources {
+		pending[name] = nil
+	}
+
+	var issues []templates.LintIssue
+	for imported := true; imported && len(pending) > 0; {
+		imported = false
+		for name := range pending {
+			single := &templates.Bundle{
+				Manifest: bundle.Manifest,
+				Sources:  map[string]string{name: bundle.Sources[name]},
+			}
+			if sample, ok := bundle.Samples[name]; ok {
+				single.Samples = map[string]map[string]interface{}{name: sample}
+			}
+			templateIssues, err := tmpl.Import(single)
+			if err != nil {
+				pending[name] = err
+				continue
+			}
+			issues = append(issues, templateIssues...)
+			delete(pending, name)
+			imported = true
+		}
+	}
+
+	for name, err := range pending {
+		log.Printf("Skipping imported template %s: %v", name, err)
+	}
+	return issues
+}
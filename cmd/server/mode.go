@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+)
+
+// parseModeFlag takes --mode=X or --mode X out of args, returning the mode
+// ("" when the flag is absent) and the remaining arguments.
+func parseModeFlag(args []string) (string, []string, error) {
+	var mode string
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case strings.HasPrefix(arg, "--mode="):
+			mode = strings.TrimPrefix(arg, "--mode=")
+		case arg == "--mode":
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("--mode needs a value: api, worker or all")
+			}
+			i++
+			mode = args[i]
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		if !config.IsMode(mode) {
+			return "", nil, fmt.Errorf("invalid --mode %q: expected api, worker or all", mode)
+		}
+	}
+	return mode, rest, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	templates "github.com/sarthakyeole/redis-go-mailing-bulk/internal/emailTemplate"
+	storage "github.com/sarthakyeole/redis-go-mailing-bulk/internal/objectStore"
+	queue "github.com/sarthakyeole/redis-go-mailing-bulk/internal/redisQueue"
+	email "github.com/sarthakyeole/redis-go-mailing-bulk/internal/senderSide"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/token"
+)
+
+// selfTestTimeout bounds each network step of the self-test.
+const selfTestTimeout = 30 * time.Second
+
+// isSelfTest reports whether arg asks for the self-test, as a command or a
+// flag.
+func isSelfTest(arg string) bool {
+	return arg == "selftest" || arg == "--selftest"
+}
+
+// runSelfTest checks that this deployment can do its job: the configuration
+// loads, Redis answers, every template renders with its sample data and the
+// SMTP server accepts a login. With SELFTEST_RECIPIENT set it also sends one
+// email there. Steps that depend on a failed one are skipped. It returns 1
+// when any step failed. loadErr is the error config.Load returned with cfg.
+func runSelfTest(cfg *config.ApplicationConfig, loadErr error) int {
+	failed := false
+	step := func(name string, fn func() error) bool {
+		start := time.Now()
+		if err := fn(); err != nil {
+			fmt.Printf("FAIL  %-9s %s\n", name, strings.ReplaceAll(err.Error(), "\n", "\n                "))
+			failed = true
+			return false
+		}
+		fmt.Printf("ok    %-9s %s\n", name, time.Since(start).Round(time.Millisecond))
+		return true
+	}
+	skip := func(name, reason string) {
+		fmt.Printf("skip  %-9s %s\n", name, reason)
+	}
+
+	var store *storage.Store
+	var tokens *token.Signer
+	configOK := step("config", func() error {
+		errs := []error{loadErr}
+		if !config.IsProfile(cfg.Environment) {
+			errs = append(errs, fmt.Errorf("unknown APP_ENV %q: expected dev, staging or prod", cfg.Environment))
+		}
+		var err error
+		if store, err = storage.New(cfg); err != nil {
+			errs = append(errs, fmt.Errorf("object store: %w", err))
+		}
+		if tokens, err = token.New(cfg.TrackingTokenKeys, cfg.TrackingTokenTTL); err != nil {
+			errs = append(errs, fmt.Errorf("tracking tokens: %w", err))
+		}
+		return errors.Join(errs...)
+	})
+
+	var client *redis.Client
+	step("redis", func() error {
+		var err error
+		client, err = queue.NewRedisClient(cfg)
+		return err
+	})
+	if client != nil {
+		defer client.Close()
+	}
+
+	var tmpl *templates.Manager
+	var unchecked []string
+	step("templates", func() error {
+		var err error
+		if tmpl, err = templates.New(cfg); err != nil {
+			return err
+		}
+		if client != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+			defer cancel()
+			bundle, err := templates.NewStore(client).Load(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to load imported templates: %w", err)
+			}
+			if bundle != nil {
+				if _, err := tmpl.Import(bundle); err != nil {
+					return fmt.Errorf("failed to apply imported templates: %w", err)
+				}
+			}
+		}
+		unchecked, err = renderSamples(tmpl)
+		return err
+	})
+	if len(unchecked) > 0 {
+		fmt.Printf("                no sample data, not rendered: %s\n", strings.Join(unchecked, ", "))
+	}
+
+	var sender *email.Sender
+	if !configOK || tmpl == nil {
+		skip("smtp", "needs a valid configuration and templates")
+		skip("send", "needs a working SMTP connection")
+	} else if step("smtp", func() error {
+		var err error
+		if sender, err = email.NewSender(cfg, client, tmpl, store, tokens); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+		defer cancel()
+		return sender.CheckSMTP(ctx)
+	}) {
+		if cfg.SelfTestRecipient == "" {
+			skip("send", "SELFTEST_RECIPIENT is not set")
+		} else {
+			step("send", func() error {
+				ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+				defer cancel()
+				_, err := sender.Send(ctx, email.Message{
+					To:      cfg.SelfTestRecipient,
+					Subject: "[Self-test] " + cfg.InstanceID,
+					Body:    "<p>This is a self-test email from instance " + cfg.InstanceID + ". Delivery works.</p>",
+				})
+				return err
+			})
+		}
+	} else {
+		skip("send", "needs a working SMTP connection")
+	}
+
+	if failed {
+		fmt.Println("self-test failed")
+		return 1
+	}
+	fmt.Println("self-test passed")
+	return 0
+}
+
+// renderSamples renders every template that declares sample data with it,
+// the way a send would. Templates without sample data cannot be checked;
+// they are returned.
+func renderSamples(tmpl *templates.Manager) ([]string, error) {
+	names := tmpl.ListAvailabletemplates()
+	sort.Strings(names)
+
+	var errs, unchecked []string
+	for _, name := range names {
+		sample, ok := tmpl.SampleData(name)
+		if !ok {
+			unchecked = append(unchecked, name)
+			continue
+		}
+		if _, err := tmpl.RenderWithSafeURLs(name, sample); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return unchecked, fmt.Errorf("%d of %d templates failed to render:\n      %s", len(errs), len(names), strings.Join(errs, "\n"))
+	}
+	return unchecked, nil
+}
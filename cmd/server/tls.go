@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsVersions maps TLS_MIN_VERSION values to their protocol versions.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// serverTLS holds how the server terminates TLS: a certificate and key from
+// files, or certificates obtained from Let's Encrypt for the configured
+// domains. It is nil when the server speaks plain HTTP.
+type serverTLS struct {
+	config   *tls.Config
+	certFile string
+	keyFile  string
+	manager  *autocert.Manager
+}
+
+// newServerTLS builds the TLS settings from cfg, or returns nil when neither
+// certificate files nor autocert domains are configured.
+func newServerTLS(cfg *config.ApplicationConfig) (*serverTLS, error) {
+	files := cfg.TLSCertFile != "" || cfg.TLSKeyFile != ""
+	autocertEnabled := len(cfg.TLSAutocertDomains) > 0
+	switch {
+	case files && autocertEnabled:
+		return nil, errors.New("TLS_CERT_FILE and TLS_AUTOCERT_DOMAINS are mutually exclusive")
+	case files && (cfg.TLSCertFile == "" || cfg.TLSKeyFile == ""):
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	case !files && !autocertEnabled:
+		if cfg.HTTPRedirectPort != "" {
+			return nil, errors.New("HTTP_REDIRECT_PORT requires TLS to be configured")
+		}
+		if cfg.TLSClientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE requires TLS to be configured")
+		}
+		return nil, nil
+	}
+
+	minVersion, ok := tlsVersions[cfg.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS_MIN_VERSION %q: expected 1.2 or 1.3", cfg.TLSMinVersion)
+	}
+	cipherSuites, err := parseCipherSuites(cfg.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &serverTLS{
+		config: &tls.Config{
+			MinVersion:   minVersion,
+			CipherSuites: cipherSuites,
+		},
+		certFile: cfg.TLSCertFile,
+		keyFile:  cfg.TLSKeyFile,
+	}
+	if cfg.TLSClientCAFile != "" {
+		pool, err := loadClientCAs(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		// Certificates are verified when given, so browsers and email
+		// clients can still reach tracking and unsubscribe links; the API
+		// routes require one.
+		s.config.ClientCAs = pool
+		s.config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if autocertEnabled {
+		s.manager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLSAutocertDomains...),
+			Cache:      autocert.DirCache(cfg.TLSAutocertCacheDir),
+			Email:      cfg.TLSAutocertEmail,
+		}
+		s.config.GetCertificate = s.manager.GetCertificate
+		// Lets the ACME tls-alpn-01 challenge be answered on the HTTPS port.
+		s.config.NextProtos = []string{"h2", "http/1.1", "acme-tls/1"}
+	}
+	return s, nil
+}
+
+// loadClientCAs reads the PEM bundle of CAs that client certificates must
+// chain to.
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS_CLIENT_CA_FILE: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("TLS_CLIENT_CA_FILE %s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// parseCipherSuites resolves TLS_CIPHER_SUITES names such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Suites Go considers insecure are
+// rejected. An empty list keeps Go's defaults; TLS 1.3 suites are not
+// configurable.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// listen serves srv over TLS until it is shut down.
+func (s *serverTLS) listen(srv *http.Server) error {
+	srv.TLSConfig = s.config
+	// With autocert the certificate comes from GetCertificate.
+	return srv.ListenAndServeTLS(s.certFile, s.keyFile)
+}
+
+// redirectHandler redirects plain HTTP requests to the same host and path
+// on httpsPort. With autocert it also answers ACME http-01 challenges.
+func (s *serverTLS) redirectHandler(httpsPort string) http.Handler {
+	var redirect http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	if s.manager != nil {
+		redirect = s.manager.HTTPHandler(redirect)
+	}
+	return redirect
+}
@@ -0,0 +1,220 @@
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+)
+
+// keyPrefix keys the counters of a key: apikey:<id>:requests:<minute>
+// counts its API requests in one minute and apikey:<id>:quota:<date> the
+// emails it queued on one UTC day.
+const keyPrefix = "apikey:"
+
+var (
+	ErrQuotaExceeded = errors.New("daily quota exceeded")
+	ErrKeyExpired    = errors.New("API key expired")
+)
+
+// Key is an API key as the API sees it; the secret is kept only as a hash.
+// Keys from API_KEYS use their name as ID and label.
+type Key struct {
+	ID         string
+	Label      string
+	Tenant     string
+	Scopes     []string
+	RateLimit  int64    // API requests per minute, 0 when unlimited
+	DailyQuota int64    // emails per UTC day, 0 when unlimited
+	Templates  []string // templates it may send, empty for any
+	Senders    []string // from addresses or @domains it may use, empty for any
+
+	hash [sha256.Size]byte
+}
+
+// Window is the state of one of a key's limits in its current window.
+// Remaining is left out when the limit is 0 (unlimited); Exceeded is set
+// once the window's allowance is used up.
+type Window struct {
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining *int64    `json:"remaining,omitempty"`
+	Exceeded  bool      `json:"exceeded"`
+	ResetAt   time.Time `json:"resetAt"`
+}
+
+func newWindow(limit, used int64, resetAt time.Time) Window {
+	window := Window{Limit: limit, Used: used, ResetAt: resetAt}
+	if limit > 0 {
+		remaining := max(limit-used, 0)
+		window.Remaining = &remaining
+		window.Exceeded = remaining == 0
+	}
+	return window
+}
+
+// Usage reports a key's consumption of its request rate limit and daily
+// email quota.
+type Usage struct {
+	Key       string   `json:"key"`
+	Label     string   `json:"label"`
+	Tenant    string   `json:"tenant"`
+	Scopes    []string `json:"scopes"`
+	Templates []string `json:"templates,omitempty"`
+	Senders   []string `json:"senders,omitempty"`
+	RateLimit Window   `json:"rateLimit"`
+	Quota     Window   `json:"quota"`
+}
+
+// Store authenticates API keys, from API_KEYS or created through the admin
+// API and kept hashed in Redis, and counts their requests and queued emails
+// in Redis, so limits hold across instances.
+type Store struct {
+	client *redis.Client
+	keys   []*Key
+}
+
+func NewStore(client *redis.Client, cfg *config.ApplicationConfig) *Store {
+	keys := make([]*Key, 0, len(cfg.APIKeys))
+	for _, key := range cfg.APIKeys {
+		keys = append(keys, &Key{
+			ID:         key.Name,
+			Label:      key.Name,
+			Tenant:     key.Tenant,
+			Scopes:     key.Scopes,
+			RateLimit:  key.RateLimit,
+			DailyQuota: key.DailyQuota,
+			Templates:  key.Templates,
+			Senders:    key.Senders,
+			hash:       sha256.Sum256([]byte(key.Secret)),
+		})
+	}
+	return &Store{client: client, keys: keys}
+}
+
+// Enabled reports whether any API key is configured in API_KEYS. Without
+// one the API does not ask for keys, and nobody could create them.
+func (s *Store) Enabled() bool {
+	return s != nil && len(s.keys) > 0
+}
+
+// Authenticate returns the key whose secret is secret, or nil when there is
+// none. Keys from API_KEYS are compared in constant time, so timing does not
+// reveal near misses; managed keys are looked up by hash. An expired managed
+// key returns ErrKeyExpired.
+func (s *Store) Authenticate(ctx context.Context, secret string) (*Key, error) {
+	hash := sha256.Sum256([]byte(secret))
+	var found *Key
+	for _, key := range s.keys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash[:]) == 1 {
+			found = key
+		}
+	}
+	if found != nil {
+		return found, nil
+	}
+	return s.managedKey(ctx, hash)
+}
+
+// TakeRequest counts one API request against the key's rate limit and
+// returns the state of its current minute; Exceeded is set when the
+// request is over the limit.
+func (s *Store) TakeRequest(ctx context.Context, key *Key) (Window, error) {
+	now := time.Now().UTC()
+	start := now.Truncate(time.Minute)
+
+	pipe := s.client.TxPipeline()
+	used := pipe.Incr(ctx, requestsKey(key, start))
+	pipe.Expire(ctx, requestsKey(key, start), 2*time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Window{}, fmt.Errorf("failed to count API request: %w", err)
+	}
+
+	window := newWindow(key.RateLimit, used.Val(), start.Add(time.Minute))
+	window.Exceeded = key.RateLimit > 0 && used.Val() > key.RateLimit
+	return window, nil
+}
+
+// quotaTakeScript takes n emails from a day's quota, leaving the counter
+// untouched when they do not fit.
+var quotaTakeScript = redis.NewScript(`
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+local limit = tonumber(ARGV[1])
+if limit > 0 and used + tonumber(ARGV[2]) > limit then
+	return {0, used}
+end
+used = redis.call('INCRBY', KEYS[1], ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return {1, used}
+`)
+
+// TakeQuota takes n emails from the key's quota for today. It returns
+// ErrQuotaExceeded, with the state of the quota, when they do not fit.
+func (s *Store) TakeQuota(ctx context.Context, key *Key, n int64) (Window, error) {
+	day := today()
+	result, err := quotaTakeScript.Run(ctx, s.client, []string{quotaKey(key, day)},
+		key.DailyQuota, n, int64((48 * time.Hour).Seconds())).Slice()
+	if err != nil {
+		return Window{}, fmt.Errorf("failed to take API key quota: %w", err)
+	}
+
+	taken, _ := result[0].(int64)
+	used, _ := result[1].(int64)
+	window := newWindow(key.DailyQuota, used, day.AddDate(0, 0, 1))
+	if taken == 0 {
+		return window, ErrQuotaExceeded
+	}
+	return window, nil
+}
+
+// ReturnQuota gives back n emails taken from today's quota that were not
+// queued after all.
+func (s *Store) ReturnQuota(ctx context.Context, key *Key, n int64) error {
+	return s.client.DecrBy(ctx, quotaKey(key, today()), n).Err()
+}
+
+// Usage returns the key's consumption without counting anything.
+func (s *Store) Usage(ctx context.Context, key *Key) (*Usage, error) {
+	now := time.Now().UTC()
+	start, day := now.Truncate(time.Minute), today()
+
+	pipe := s.client.Pipeline()
+	requests := pipe.Get(ctx, requestsKey(key, start))
+	emails := pipe.Get(ctx, quotaKey(key, day))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to load API key usage: %w", err)
+	}
+	used, _ := requests.Int64()
+	queued, _ := emails.Int64()
+
+	rate := newWindow(key.RateLimit, used, start.Add(time.Minute))
+	rate.Exceeded = key.RateLimit > 0 && used > key.RateLimit
+	return &Usage{
+		Key:       key.ID,
+		Label:     key.Label,
+		Tenant:    key.Tenant,
+		Scopes:    key.Scopes,
+		Templates: key.Templates,
+		Senders:   key.Senders,
+		RateLimit: rate,
+		Quota:     newWindow(key.DailyQuota, queued, day.AddDate(0, 0, 1)),
+	}, nil
+}
+
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+func requestsKey(key *Key, minute time.Time) string {
+	return keyPrefix + key.ID + ":requests:" + strconv.FormatInt(minute.Unix()/60, 10)
+}
+
+func quotaKey(key *Key, day time.Time) string {
+	return keyPrefix + key.ID + ":quota:" + day.Format(time.DateOnly)
+}
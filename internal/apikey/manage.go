@@ -0,0 +1,326 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// managedKeysKey is a hash of key ID to the key's JSON record.
+	managedKeysKey = "apikeys"
+
+	// hashIndexKey is a hash of the hex SHA-256 of a secret to the ID of
+	// its key, current or still in its rotation grace period.
+	hashIndexKey = "apikey_hashes"
+
+	// secretPrefix marks the secrets of managed keys, so leaked ones are
+	// easy to recognize.
+	secretPrefix = "mq_"
+)
+
+var (
+	ErrKeyNotFound = errors.New("API key not found")
+	ErrKeyRevoked  = errors.New("API key is revoked")
+)
+
+// Record describes a managed key. The secret itself is shown only when the
+// key is created or rotated; Prefix is its start, to tell keys apart.
+type Record struct {
+	ID         string     `json:"id"`
+	Label      string     `json:"label"`
+	Tenant     string     `json:"tenant,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int64      `json:"rateLimit"`
+	DailyQuota int64      `json:"dailyQuota"`
+	Templates  []string   `json:"templates,omitempty"`
+	Senders    []string   `json:"senders,omitempty"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	RotatedAt  *time.Time `json:"rotatedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Status is active, expired or revoked.
+func (r Record) Status() string {
+	switch {
+	case r.RevokedAt != nil:
+		return "revoked"
+	case r.ExpiresAt != nil && !time.Now().Before(*r.ExpiresAt):
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+// MarshalJSON adds the key's status.
+func (r Record) MarshalJSON() ([]byte, error) {
+	type fields Record
+	return json.Marshal(struct {
+		fields
+		Status string `json:"status"`
+	}{fields(r), r.Status()})
+}
+
+// storedKey is a managed key as kept in Redis: its record and the hashes of
+// its current secret and, during a rotation grace period, the previous one.
+type storedKey struct {
+	Record         Record     `json:"record"`
+	Hash           string     `json:"hash"`
+	PreviousHash   string     `json:"previousHash,omitempty"`
+	PreviousExpiry *time.Time `json:"previousExpiry,omitempty"`
+}
+
+func (k storedKey) key() *Key {
+	return &Key{
+		ID:         k.Record.ID,
+		Label:      k.Record.Label,
+		Tenant:     k.Record.Tenant,
+		Scopes:     k.Record.Scopes,
+		RateLimit:  k.Record.RateLimit,
+		DailyQuota: k.Record.DailyQuota,
+		Templates:  k.Record.Templates,
+		Senders:    k.Record.Senders,
+	}
+}
+
+// newSecret returns a random secret and the hex SHA-256 it is stored as.
+func newSecret() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret := secretPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	return secret, hashSecret(secret), nil
+}
+
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// secretHint is the start of a secret, enough to recognize it in a list.
+func secretHint(secret string) string {
+	return secret[:len(secretPrefix)+6]
+}
+
+// Create stores a new managed key from record, filling in its ID, prefix
+// and creation time, and returns the record and the key's secret.
+func (s *Store) Create(ctx context.Context, record Record) (*Record, string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	secret, hash, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	record.ID = hex.EncodeToString(id)
+	record.Prefix = secretHint(secret)
+	record.CreatedAt = time.Now().UTC()
+	record.RotatedAt, record.RevokedAt = nil, nil
+
+	stored, err := json.Marshal(storedKey{Record: record, Hash: hash})
+	if err != nil {
+		return nil, "", err
+	}
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, managedKeysKey, record.ID, stored)
+	pipe.HSet(ctx, hashIndexKey, hash, record.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+	return &record, secret, nil
+}
+
+// List returns the managed keys of tenant, or every managed key when tenant
+// is "", revoked ones included, oldest first.
+func (s *Store) List(ctx context.Context, tenant string) ([]Record, error) {
+	values, err := s.client.HGetAll(ctx, managedKeysKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	records := make([]Record, 0, len(values))
+	for _, value := range values {
+		var stored storedKey
+		if err := json.Unmarshal([]byte(value), &stored); err != nil {
+			continue
+		}
+		if tenant != "" && stored.Record.Tenant != tenant {
+			continue
+		}
+		records = append(records, stored.Record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].CreatedAt.Before(records[j].CreatedAt)
+		}
+		return records[i].ID < records[j].ID
+	})
+	return records, nil
+}
+
+// Rotate gives a managed key a new secret and returns it. The previous
+// secret keeps working for grace, so callers can switch over; with no grace
+// it stops at once. Unless tenant is "", a key of another tenant is not
+// found.
+func (s *Store) Rotate(ctx context.Context, id, tenant string, grace time.Duration) (*Record, string, error) {
+	secret, hash, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var record Record
+	err = s.update(ctx, id, tenant, func(stored *storedKey, pipe redis.Pipeliner) error {
+		if stored.Record.RevokedAt != nil {
+			return ErrKeyRevoked
+		}
+		if stored.PreviousHash != "" {
+			pipe.HDel(ctx, hashIndexKey, stored.PreviousHash)
+		}
+
+		now := time.Now().UTC()
+		stored.PreviousHash, stored.PreviousExpiry = "", nil
+		if grace > 0 {
+			expiry := now.Add(grace)
+			stored.PreviousHash, stored.PreviousExpiry = stored.Hash, &expiry
+		} else {
+			pipe.HDel(ctx, hashIndexKey, stored.Hash)
+		}
+		stored.Hash = hash
+		stored.Record.Prefix = secretHint(secret)
+		stored.Record.RotatedAt = &now
+		pipe.HSet(ctx, hashIndexKey, hash, id)
+
+		record = stored.Record
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &record, secret, nil
+}
+
+// Revoke stops a managed key from authenticating. Its record is kept, marked
+// revoked, so it still shows in the list. Unless tenant is "", a key of
+// another tenant is not found.
+func (s *Store) Revoke(ctx context.Context, id, tenant string) (*Record, error) {
+	var record Record
+	err := s.update(ctx, id, tenant, func(stored *storedKey, pipe redis.Pipeliner) error {
+		if stored.Record.RevokedAt == nil {
+			now := time.Now().UTC()
+			stored.Record.RevokedAt = &now
+		}
+		pipe.HDel(ctx, hashIndexKey, stored.Hash)
+		if stored.PreviousHash != "" {
+			pipe.HDel(ctx, hashIndexKey, stored.PreviousHash)
+		}
+		stored.PreviousHash, stored.PreviousExpiry = "", nil
+
+		record = stored.Record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// update applies change to a managed key and writes it back, with the index
+// updates change queues on pipe, in one transaction. Concurrent updates of
+// any key retry it. A key of another tenant than tenant, unless it is "", is
+// not found.
+func (s *Store) update(ctx context.Context, id, tenant string, change func(*storedKey, redis.Pipeliner) error) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			value, err := tx.HGet(ctx, managedKeysKey, id).Result()
+			if err == redis.Nil {
+				return ErrKeyNotFound
+			}
+			if err != nil {
+				return err
+			}
+			var stored storedKey
+			if err := json.Unmarshal([]byte(value), &stored); err != nil {
+				return fmt.Errorf("invalid API key record: %w", err)
+			}
+			if tenant != "" && stored.Record.Tenant != tenant {
+				return ErrKeyNotFound
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if err := change(&stored, pipe); err != nil {
+					return err
+				}
+				encoded, err := json.Marshal(stored)
+				if err != nil {
+					return err
+				}
+				pipe.HSet(ctx, managedKeysKey, id, encoded)
+				return nil
+			})
+			return err
+		}, managedKeysKey)
+		if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrKeyRevoked) {
+			return err
+		}
+		if err != redis.TxFailedErr {
+			if err != nil {
+				return fmt.Errorf("failed to update API key: %w", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to update API key: too many concurrent changes")
+}
+
+// managedKey looks up the managed key a secret hash belongs to. A previous
+// secret past its grace period is dropped from the index on the way.
+func (s *Store) managedKey(ctx context.Context, hash [sha256.Size]byte) (*Key, error) {
+	hexHash := hex.EncodeToString(hash[:])
+	id, err := s.client.HGet(ctx, hashIndexKey, hexHash).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+
+	value, err := s.client.HGet(ctx, managedKeysKey, id).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	var stored storedKey
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return nil, fmt.Errorf("invalid API key record: %w", err)
+	}
+
+	switch {
+	case stored.Record.RevokedAt != nil:
+		return nil, nil
+	case hexHash == stored.Hash:
+	case hexHash == stored.PreviousHash && stored.PreviousExpiry != nil && time.Now().Before(*stored.PreviousExpiry):
+	default:
+		s.client.HDel(ctx, hashIndexKey, hexHash)
+		return nil, nil
+	}
+	if stored.Record.ExpiresAt != nil && !time.Now().Before(*stored.Record.ExpiresAt) {
+		return nil, ErrKeyExpired
+	}
+	return stored.key(), nil
+}
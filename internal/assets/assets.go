@@ -0,0 +1,170 @@
+package assets
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	storage "github.com/sarthakyeole/redis-go-mailing-bulk/internal/objectStore"
+)
+
+// maxAssetBytes bounds how much of an asset is read into memory.
+const maxAssetBytes = 10 << 20
+
+var (
+	ErrNotFound         = errors.New("asset not found")
+	ErrInvalidSignature = errors.New("asset URL signature is invalid")
+	ErrExpired          = errors.New("asset URL has expired")
+)
+
+// Server loads template images and other assets from ASSETS_DIR or, when
+// that is unset, from ASSETS_BUCKET in the object store, and builds the
+// (optionally signed) public URLs templates link to.
+type Server struct {
+	dir        string
+	bucket     string
+	store      *storage.Store
+	baseURL    string
+	signingKey []byte
+	urlTTL     time.Duration
+	maxAge     time.Duration
+}
+
+func New(cfg *config.ApplicationConfig, store *storage.Store) *Server {
+	return &Server{
+		dir:        cfg.AssetsDir,
+		bucket:     cfg.AssetsBucket,
+		store:      store,
+		baseURL:    cfg.AssetsBaseURL,
+		signingKey: []byte(cfg.AssetsSigningKey),
+		urlTTL:     cfg.AssetsURLTTL,
+		maxAge:     cfg.AssetsCacheMaxAge,
+	}
+}
+
+// Enabled reports whether an asset source is configured.
+func (s *Server) Enabled() bool {
+	return s != nil && (s.dir != "" || (s.bucket != "" && s.store != nil))
+}
+
+// Signed reports whether asset URLs must carry a valid signature.
+func (s *Server) Signed() bool {
+	return len(s.signingKey) > 0
+}
+
+// MaxAge is how long clients and proxies may cache a served asset.
+func (s *Server) MaxAge() time.Duration {
+	return s.maxAge
+}
+
+// Load returns the asset at name and its content type.
+func (s *Server) Load(ctx context.Context, name string) ([]byte, string, error) {
+	name, ok := cleanName(name)
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+
+	var (
+		content     []byte
+		contentType string
+		err         error
+	)
+	if s.dir != "" {
+		content, err = os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(name)))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", ErrNotFound
+		}
+	} else {
+		ref := (&url.URL{Scheme: "s3", Host: s.bucket, Path: "/" + name}).String()
+		content, contentType, err = s.store.Fetch(ctx, ref, maxAssetBytes)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, "", ErrNotFound
+		}
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load asset %s: %w", name, err)
+	}
+
+	if ext := mime.TypeByExtension(path.Ext(name)); ext != "" {
+		contentType = ext
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+
+	return content, contentType, nil
+}
+
+// URL returns the public URL of the asset at name, signed and expiring after
+// ASSETS_URL_TTL when ASSETS_SIGNING_KEY is set. It is registered as the
+// assetURL template function.
+func (s *Server) URL(name string) (string, error) {
+	name, ok := cleanName(name)
+	if !ok {
+		return "", fmt.Errorf("invalid asset path %q", name)
+	}
+
+	u := s.baseURL + "/assets/" + (&url.URL{Path: name}).EscapedPath()
+	if !s.Signed() {
+		return u, nil
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(s.urlTTL).Unix(), 10)
+	return u + "?expires=" + expires + "&sig=" + s.sign(name, expires), nil
+}
+
+// Verify checks the expires and sig query parameters of a signed asset URL.
+func (s *Server) Verify(name, expires, signature string) error {
+	name, ok := cleanName(name)
+	if !ok {
+		return ErrNotFound
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(s.sign(name, expires))) {
+		return ErrInvalidSignature
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+func (s *Server) sign(name, expires string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(name + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cleanName normalizes an asset path and rejects anything that would escape
+// the asset root.
+func cleanName(name string) (string, bool) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" || name == "." || strings.Contains(name, "\\") {
+		return "", false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", false
+		}
+	}
+	return name, true
+}
@@ -0,0 +1,272 @@
+package awsauth
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ecsCredentialsHost = "http://169.254.170.2"
+	imdsEndpoint       = "http://169.254.169.254"
+
+	// refreshBefore renews temporary credentials this long before they
+	// expire, so a request never goes out with keys about to lapse.
+	refreshBefore = 5 * time.Minute
+)
+
+var ErrNoCredentials = errors.New("no AWS credentials found")
+
+// Credentials sign requests. Temporary credentials carry a session token and
+// an expiry; static keys never expire.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// EnvCredentials reads the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN variables.
+func EnvCredentials() Credentials {
+	return Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// Provider resolves credentials the way the AWS SDKs do, so an IAM role
+// works without keys in the environment: static environment keys, then a
+// web identity token (EKS IRSA), then the ECS container endpoint, then the
+// EC2 instance metadata service. Temporary credentials are cached until
+// shortly before they expire.
+type Provider struct {
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	cached Credentials
+}
+
+func NewProvider(httpClient *http.Client) *Provider {
+	return &Provider{httpClient: httpClient}
+}
+
+func (p *Provider) Retrieve(ctx context.Context) (Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached.AccessKeyID != "" && (p.cached.Expires.IsZero() || time.Until(p.cached.Expires) > refreshBefore) {
+		return p.cached, nil
+	}
+
+	creds, err := p.resolve(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	p.cached = creds
+	return creds, nil
+}
+
+func (p *Provider) resolve(ctx context.Context) (Credentials, error) {
+	if creds := EnvCredentials(); creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+		return creds, nil
+	}
+
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" && os.Getenv("AWS_ROLE_ARN") != "" {
+		return p.webIdentity(ctx, tokenFile)
+	}
+
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		return p.container(ctx, ecsCredentialsHost+relative)
+	}
+	if full := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); full != "" {
+		return p.container(ctx, full)
+	}
+
+	if disabled := os.Getenv("AWS_EC2_METADATA_DISABLED"); !strings.EqualFold(disabled, "true") {
+		if creds, err := p.instanceMetadata(ctx); err == nil {
+			return creds, nil
+		}
+	}
+
+	return Credentials{}, ErrNoCredentials
+}
+
+// roleCredentials is the JSON shape served by the ECS and EC2 endpoints.
+type roleCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+func (c roleCredentials) credentials() Credentials {
+	return Credentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.Token,
+		Expires:         c.Expiration,
+	}
+}
+
+func (p *Provider) webIdentity(ctx context.Context, tokenFile string) (Credentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read web identity token: %w", err)
+	}
+
+	endpoint := "https://sts.amazonaws.com/"
+	if region := Region(); region != "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com/", region)
+	}
+
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "mailqueue"
+	}
+
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {os.Getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	body, err := p.do(req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to assume role with web identity: %w", err)
+	}
+
+	var response struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &response); err != nil {
+		return Credentials{}, fmt.Errorf("invalid STS response: %w", err)
+	}
+
+	return Credentials{
+		AccessKeyID:     response.Credentials.AccessKeyID,
+		SecretAccessKey: response.Credentials.SecretAccessKey,
+		SessionToken:    response.Credentials.SessionToken,
+		Expires:         response.Credentials.Expiration,
+	}, nil
+}
+
+func (p *Provider) container(ctx context.Context, endpoint string) (Credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	authorization := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("failed to read container authorization token: %w", err)
+		}
+		authorization = strings.TrimSpace(string(token))
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	body, err := p.do(req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to load container credentials: %w", err)
+	}
+
+	var creds roleCredentials
+	if err := json.Unmarshal(body, &creds); err != nil {
+		return Credentials{}, fmt.Errorf("invalid container credentials: %w", err)
+	}
+	return creds.credentials(), nil
+}
+
+// instanceMetadata reads the instance role's credentials with IMDSv2. The
+// metadata service only answers on EC2, so it gets a short timeout.
+func (p *Provider) instanceMetadata(ctx context.Context) (Credentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imdsEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := p.do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsEndpoint+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return p.do(req)
+	}
+
+	role, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return Credentials{}, err
+	}
+	name, _, _ := strings.Cut(strings.TrimSpace(string(role)), "\n")
+
+	body, err := get("/latest/meta-data/iam/security-credentials/" + name)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var creds roleCredentials
+	if err := json.Unmarshal(body, &creds); err != nil {
+		return Credentials{}, fmt.Errorf("invalid instance credentials: %w", err)
+	}
+	return creds.credentials(), nil
+}
+
+func (p *Provider) do(req *http.Request) ([]byte, error) {
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return body, nil
+}
+
+// Region is the region from AWS_REGION or AWS_DEFAULT_REGION.
+func Region() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
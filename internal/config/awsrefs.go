@@ -0,0 +1,202 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/awsauth"
+)
+
+// Settings may reference AWS-held secrets instead of carrying them:
+//
+//	ssm:///mailqueue/prod/smtp-password     SSM Parameter Store (decrypted)
+//	aws-sm://mailqueue/prod#smtp_password   Secrets Manager, optionally one JSON key
+//
+// References are resolved when the configuration is loaded, signed with the
+// instance's IAM role or environment keys, and cached for
+// AWS_SECRETS_CACHE_TTL so reloads don't refetch every secret.
+const (
+	ssmPrefix            = "ssm://"
+	secretsManagerPrefix = "aws-sm://"
+
+	awsRequestTimeout = 10 * time.Second
+)
+
+var awsSecrets = &secretResolver{
+	httpClient: &http.Client{Timeout: awsRequestTimeout},
+	cache:      map[string]cachedSecret{},
+}
+
+type cachedSecret struct {
+	value     string
+	fetchedAt time.Time
+}
+
+type secretResolver struct {
+	httpClient *http.Client
+
+	providerOnce sync.Once
+	provider     *awsauth.Provider
+
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+}
+
+// resolveReference returns value unchanged unless it is a secret
+// reference. A reference that cannot be resolved is recorded for Load to
+// report and resolves to an empty value.
+func resolveReference(key, value string) string {
+	if !strings.HasPrefix(value, ssmPrefix) && !strings.HasPrefix(value, secretsManagerPrefix) {
+		return value
+	}
+
+	resolved, err := awsSecrets.resolve(value)
+	if err != nil {
+		recordLoadError(fmt.Errorf("failed to resolve %s: %w", key, err))
+		return ""
+	}
+	return resolved
+}
+
+func (r *secretResolver) resolve(ref string) (string, error) {
+	ttl, err := time.ParseDuration(getEnvironmentVariable("AWS_SECRETS_CACHE_TTL", "5m"))
+	if err != nil {
+		ttl = 5 * time.Minute
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cached, ok := r.cache[ref]; ok && time.Since(cached.fetchedAt) < ttl {
+		return cached.value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), awsRequestTimeout)
+	defer cancel()
+
+	var value string
+	if name, ok := strings.CutPrefix(ref, ssmPrefix); ok {
+		value, err = r.getParameter(ctx, name)
+	} else {
+		id, field, _ := strings.Cut(strings.TrimPrefix(ref, secretsManagerPrefix), "#")
+		value, err = r.getSecret(ctx, id, field)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	r.cache[ref] = cachedSecret{value: value, fetchedAt: time.Now()}
+	return value, nil
+}
+
+func (r *secretResolver) getParameter(ctx context.Context, name string) (string, error) {
+	var response struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	err := r.call(ctx, "ssm", "AmazonSSM.GetParameter", regionOf(name), map[string]interface{}{
+		"Name":           name,
+		"WithDecryption": true,
+	}, &response)
+	if err != nil {
+		return "", fmt.Errorf("ssm parameter %s: %w", name, err)
+	}
+	return response.Parameter.Value, nil
+}
+
+// getSecret returns the secret string, or one field of it when the secret
+// holds a JSON object.
+func (r *secretResolver) getSecret(ctx context.Context, id, field string) (string, error) {
+	var response struct {
+		SecretString string `json:"SecretString"`
+	}
+	err := r.call(ctx, "secretsmanager", "secretsmanager.GetSecretValue", regionOf(id), map[string]interface{}{
+		"SecretId": id,
+	}, &response)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", id, err)
+	}
+
+	if field == "" {
+		return response.SecretString, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(response.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object", id)
+	}
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %q", id, field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// call makes a signed AWS JSON 1.1 API request.
+func (r *secretResolver) call(ctx context.Context, service, target, region string, input, output interface{}) error {
+	if region == "" {
+		return fmt.Errorf("AWS_REGION is not set")
+	}
+
+	r.providerOnce.Do(func() {
+		r.provider = awsauth.NewProvider(r.httpClient)
+	})
+	creds, err := r.provider.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("https://%s.%s.amazonaws.com/", service, region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	awsauth.SignRequest(req, creds, region, service, awsauth.HashHex(body), time.Now())
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(respBody, &apiErr)
+		return fmt.Errorf("%s returned %d: %s %s", target, resp.StatusCode, apiErr.Type, apiErr.Message)
+	}
+
+	return json.Unmarshal(respBody, output)
+}
+
+// regionOf takes the region from an ARN, falling back to AWS_REGION.
+func regionOf(id string) string {
+	if parts := strings.Split(id, ":"); len(parts) > 3 && parts[0] == "arn" && parts[3] != "" {
+		return parts[3]
+	}
+	return awsauth.Region()
+}
@@ -1,13 +1,67 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/mail"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// Run modes: an instance serves the HTTP API, consumes the queues, or
+// both, so the two can be scaled separately from the same binary.
+const (
+	ModeAll    = "all"
+	ModeAPI    = "api"
+	ModeWorker = "worker"
+)
+
+// IsMode reports whether name is a run mode.
+func IsMode(name string) bool {
+	return name == ModeAll || name == ModeAPI || name == ModeWorker
+}
+
 type ApplicationConfig struct {
 	// Server Configuration
-	ServerPort string
+	Environment string
+	Mode        string // api, worker or all
+	ServerPort  string
+	LogLevel    string
+
+	// TLS Configuration
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSAutocertDomains  []string
+	TLSAutocertCacheDir string
+	TLSAutocertEmail    string
+	TLSMinVersion       string
+	TLSCipherSuites     []string
+	HTTPRedirectPort    string
+	TLSClientCAFile     string
+	TLSClients          []TLSClientConfig
+
+	// API Key Configuration
+	APIKeys []APIKeyConfig
+
+	// HTTP Hardening Configuration
+	SecurityHeaders      bool
+	SecurityHSTSMaxAge   time.Duration
+	HTTPMaxHeaderBytes   int
+	PublicMaxHeaderBytes int
+	RequestTimeout       time.Duration
+
+	// CORS Configuration
+	CORSEnabled          bool
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSExposedHeaders   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
 
 	// Redis Database Configuration
 	CacheHost          string
@@ -20,18 +74,417 @@ type ApplicationConfig struct {
 	EmailSMTPServerPort    int
 	EmailSMTPUsername      string
 	EmailSMTPPassword      string
+	EmailSMTPRcptBatchSize int           // most RCPT TO commands in one transaction of a broadcast
+	EmailSMTPIdleTimeout   time.Duration // how long an idle SMTP session is kept for the next send; 0 closes it after each send
+	EmailSMTPHeloName      string        // name given in EHLO/HELO
+
+	// SMTP accounts sends rotate across. With SMTP_ACCOUNTS unset there is
+	// one, unnamed, from the EMAIL_SMTP_* settings.
+	SMTPAccounts           []SMTPAccountConfig
+	SMTPAccountRotation    string        // round-robin or weighted
+	SMTPAccountMaxFailures int           // consecutive connection or login failures that take an account out of rotation
+	SMTPAccountCooldown    time.Duration // how long an account stays out of rotation
+	SMTPAccountErrorWindow time.Duration // span of the rolling failure rate checked against each account's error budget
+	SMTPAccountMinSends    int           // sends in the window before the error budget applies
+	SMTPOverflow           bool          // hold tasks in the overflow queue while no account is usable, instead of sending anyway
 	EmailSenderAddress     string
 	EmailSenderDisplayName string
+	EmailAllowedSenders    []string // addresses or @domains a send's from may use
+	EmailInlineImages      bool
+	EmailReturnPathDomain  string
+	EmailReturnPathPrefix  string
+	EmailReturnPath        string // envelope sender (MAIL FROM) when it differs from the From address
+	EmailUnsubscribeMailto string // mailbox broadcasts offer in List-Unsubscribe and their unsubscribe links
+	EmailCustomArgs        string
+	Tenants                []TenantConfig
+
+	// Queue Configuration
+	DefaultQueue         string
+	Queues               []QueueConfig
+	MaxSendRate          float64 // emails per second across every queue of an instance, 0 disables the cap
+	ISPs                 []ISPConfig
+	SendCategories       []SendCategoryConfig
+	DeferralDefaultDelay time.Duration
+	DeferralMaxDelay     time.Duration
+	SoftBounceDelay      time.Duration
+	SoftBounceMaxDelay   time.Duration
+	SoftBounceMaxRetries int
+	BatchTTL             time.Duration
+	JobStatusTTL         time.Duration
+
+	WorkerShutdownTimeout time.Duration
+	InstanceID            string
+	WorkerHeartbeatTTL    time.Duration
+
+	// Readiness: /readyz fails when no worker loop of this instance has
+	// polled its queue within ReadyMaxHeartbeatAge, or a queue has held
+	// more than ReadyMaxQueueDepth tasks for ReadyBacklogDuration. 0
+	// disables a check.
+	ReadyMaxHeartbeatAge time.Duration
+	ReadyMaxQueueDepth   int64
+	ReadyBacklogDuration time.Duration
+
+	// WorkerHealthPort serves /healthz, /readyz and /metrics in worker mode,
+	// where the API is not running.
+	WorkerHealthPort string
+
+	// SelfTestRecipient receives a test email from the selftest command;
+	// empty skips the send.
+	SelfTestRecipient string
+
+	IdempotencyTTL     time.Duration
+	EnqueueDedupWindow time.Duration
+
+	// Spam Check Configuration
+	SpamCheckProvider  string
+	SpamCheckEndpoint  string
+	SpamCheckThreshold float64
+	SpamCheckAction    string
+	SpamCheckTimeout   time.Duration
+
+	// Approval Configuration: emails matching ApprovalRules are held until
+	// an admin approves them.
+	ApprovalRules              []string // new_template, spam_flagged or recipients
+	ApprovalRecipientThreshold int      // recipients of a broadcast or emails of a batch held by the recipients rule
+
+	// Link Check Configuration
+	LinkCheckMode     string
+	LinkCheckTimeout  time.Duration
+	LinkCheckCacheTTL time.Duration
+
+	// Warm-up Configuration
+	WarmupSchedule []int64
+	WarmupStart    time.Time
+
+	// Recipient Validation Configuration
+	RecipientMXCheck    bool
+	RecipientMXCacheTTL time.Duration
+
+	RecipientDisposableMode        string
+	RecipientDisposableDomainsFile string
+
+	RecipientAllowlist []string
+	RecipientDenylist  []string
+
+	MarketingQueues []string
+
+	PreferenceCategories []string
+
+	BounceHardThreshold int
+	BounceSoftThreshold int
+	BounceWindow        time.Duration
+
+	// Template Configuration
+	TemplateHTMLSanitizer string
+	TemplateStrict        bool
+
+	TemplateRenderCacheSize int
+	TemplateRenderCacheTTL  time.Duration
+
+	TemplatePrerender bool
+	TemplatePlugins   []string
+
+	TemplateInlineCSS []string
+
+	HTMLClipLimit int
+	HTMLClipMode  string
+
+	TemplateDefaultLocale string
+
+	TemplateSyncSource   string
+	TemplateSyncRef      string
+	TemplateSyncPath     string
+	TemplateSyncInterval time.Duration
+
+	// Attachment and Object Store Configuration
+	AttachmentMaxBytes      int64
+	AttachmentMaxTotalBytes int64
+	AttachmentAllowedHosts  []string // hosts and .domains https attachments may come from, empty for any public host
+	ObjectStoreEndpoint     string
+	ObjectStoreRegion       string
+	ObjectStorePathStyle    bool
+	ObjectStoreTimeout      time.Duration
+	ObjectStoreBucket       string
+	BodyOffloadThreshold    int
+
+	// Asset Configuration
+	AssetsDir         string
+	AssetsBucket      string
+	AssetsBaseURL     string
+	AssetsCacheMaxAge time.Duration
+	AssetsSigningKey  string
+	AssetsURLTTL      time.Duration
+
+	// Tracking Configuration
+	TrackingBaseURL   string
+	TrackingTokenKeys []string
+	TrackingTokenTTL  time.Duration
+	TrackingOpens     bool
+	TrackingClicks    bool
+
+	UTMSource   string
+	UTMMedium   string
+	UTMCampaign string
+	UTMDomains  []string
+
+	// Webhook Configuration
+	WebhookURLs        []string
+	WebhookSecret      string
+	WebhookEvents      []string
+	WebhookMaxAttempts int
+	WebhookBackoff     time.Duration
+	WebhookTimeout     time.Duration
+
+	// Feedback Configuration
+	FeedbackWebhookToken     string
+	MailgunWebhookSigningKey string
+
+	// Event Configuration
+	EventsChannel      string
+	EventsStream       string
+	EventsStreamMaxLen int64
+
+	// Retention Configuration
+	RetentionInterval   time.Duration
+	HistoryRetention    time.Duration
+	DeadLetterRetention time.Duration
+	EventsRetention     time.Duration
+	StatsRetention      time.Duration
+
+	// Leader Election Configuration
+	LeaderLockKey string
+	LeaderLockTTL time.Duration
+}
+
+// QueueConfig describes a named queue and the policy its workers follow.
+type QueueConfig struct {
+	Name        string
+	Concurrency int
+	RateLimit   float64 // emails per second, 0 disables limiting
+	MaxRetries  int
+	RetryDelay  time.Duration
+	TaskTTL     time.Duration // how long a task may wait to be sent, 0 disables expiry
+}
+
+// ISPConfig groups recipient domains served by one mailbox provider, which
+// enforces its own limits across all of them.
+type ISPConfig struct {
+	Name        string
+	Domains     []string
+	Concurrency int     // simultaneous sends, 0 disables the cap
+	RateLimit   float64 // emails per second, 0 disables limiting
 }
 
+// APIKeyConfig is an API key, its tenant and scopes, and the limits of the
+// caller holding it.
+type APIKeyConfig struct {
+	Name       string
+	Secret     string
+	Tenant     string
+	Scopes     []string // read, write, send, bulk, templates:write, admin or *
+	RateLimit  int64    // API requests per minute, 0 disables limiting
+	DailyQuota int64    // emails queued per UTC day, 0 disables the quota
+	Templates  []string // templates the key may send, empty for any
+	Senders    []string // from addresses or @domains the key may use, empty for any
+}
+
+// SendCategoryConfig describes a kind of mail (e.g. otp, receipts,
+// marketing) selected by a send's category, with a rate limit of its own
+// and the priority its tasks get unless the send sets one.
+type SendCategoryConfig struct {
+	Name      string
+	RateLimit float64 // emails per second, 0 disables limiting
+	Priority  string  // high, normal or low
+}
+
+// Approval rules: emails of templates no admin has approved yet, emails the
+// spam check flags, and broadcasts or batches with many recipients are held
+// for approval.
+const (
+	ApprovalNewTemplate = "new_template"
+	ApprovalSpamFlagged = "spam_flagged"
+	ApprovalRecipients  = "recipients"
+)
+
+// SMTP account rotations: sends take turns on the accounts, or are spread
+// in proportion to their weights.
+const (
+	RotationRoundRobin = "round-robin"
+	RotationWeighted   = "weighted"
+)
+
+// SMTPAccountConfig is an SMTP server and login sends can go out through,
+// with the sender identity used for sends that don't name their own.
+type SMTPAccountConfig struct {
+	Name          string
+	Server        string
+	Port          int
+	Username      string
+	Password      string
+	SenderAddress string  // From for sends without one, EMAIL_SENDER_ADDRESS when empty
+	SenderName    string  // display name with SenderAddress, EMAIL_SENDER_NAME when empty
+	Weight        int     // share of sends with weighted rotation
+	RateLimit     float64 // emails per second, 0 disables limiting
+	ErrorBudget   float64 // share of failed sends in the error window that takes the account out of rotation, 0 disables it
+	Standby       bool    // only used while every other account is out of rotation
+}
+
+// TenantConfig holds the send settings of a tenant, as named by the API keys
+// and client certificates its requests come with.
+type TenantConfig struct {
+	Name       string
+	ReturnPath string // envelope sender for the tenant's mail, EMAIL_RETURN_PATH when empty
+}
+
+// TLSClientConfig maps client certificates to the tenant and scopes their
+// API requests act with.
+type TLSClientConfig struct {
+	Name     string
+	Subjects []string // certificate common names or DNS, email or URI SANs
+	Tenant   string
+	Scopes   []string // read, write, send, bulk, templates:write, admin or *
+}
+
+// defaultISPDomains are used for the well-known providers when
+// ISP_<NAME>_DOMAINS is unset.
+var defaultISPDomains = map[string][]string{
+	"google":    {"gmail.com", "googlemail.com"},
+	"microsoft": {"outlook.com", "hotmail.com", "live.com", "msn.com", "hotmail.co.uk", "outlook.fr", "live.co.uk"},
+	"yahoo":     {"yahoo.com", "ymail.com", "rocketmail.com", "yahoo.co.uk", "aol.com"},
+}
+
+// LoadConfiguration reads the configuration from the environment, with the
+// entries of CONFIG_FILE taking precedence. Files that cannot be read are
+// ignored here; Load reports them.
 func LoadConfiguration() *ApplicationConfig {
+	cfg, _ := Load()
+	return cfg
+}
+
+func buildConfiguration() *ApplicationConfig {
 	// Convert string environment variables to appropriate types
 	cacheDatabaseIndex, _ := strconv.Atoi(getEnvironmentVariable("CACHE_DB_INDEX", "0"))
 	smtpServerPort, _ := strconv.Atoi(getEnvironmentVariable("EMAIL_SMTP_PORT", "587"))
+	smtpRcptBatchSize, _ := strconv.Atoi(getEnvironmentVariable("EMAIL_SMTP_RCPT_BATCH_SIZE", "50"))
+	smtpIdleTimeout, _ := time.ParseDuration(getEnvironmentVariable("EMAIL_SMTP_IDLE_TIMEOUT", "30s"))
+	smtpAccount := SMTPAccountConfig{
+		Server:      getEnvironmentVariable("EMAIL_SMTP_SERVER", "smtp.gmail.com"),
+		Port:        smtpServerPort,
+		Username:    getEnvironmentVariable("EMAIL_SMTP_USERNAME", "sarthakyeole25@gmail.com"),
+		Password:    getEnvironmentVariable("EMAIL_SMTP_PASSWORD", "owtu kivm oidv pqdm"),
+		Weight:      1,
+		ErrorBudget: loadErrorBudget("SMTP_ACCOUNT_ERROR_BUDGET", 0),
+	}
+	smtpAccountMaxFailures, _ := strconv.Atoi(getEnvironmentVariable("SMTP_ACCOUNT_MAX_FAILURES", "3"))
+	smtpAccountCooldown, _ := time.ParseDuration(getEnvironmentVariable("SMTP_ACCOUNT_COOLDOWN", "1m"))
+	smtpAccountErrorWindow, _ := time.ParseDuration(getEnvironmentVariable("SMTP_ACCOUNT_ERROR_WINDOW", "5m"))
+	smtpAccountMinSends, _ := strconv.Atoi(getEnvironmentVariable("SMTP_ACCOUNT_ERROR_MIN_SENDS", "20"))
+	smtpOverflow, _ := strconv.ParseBool(getEnvironmentVariable("SMTP_OVERFLOW", "false"))
+
+	queues := loadQueueConfigs()
+	deferralDefaultDelay, _ := time.ParseDuration(getEnvironmentVariable("EMAIL_DEFERRAL_DELAY", "5m"))
+	deferralMaxDelay, _ := time.ParseDuration(getEnvironmentVariable("EMAIL_DEFERRAL_MAX_DELAY", "1h"))
+	softBounceDelay, _ := time.ParseDuration(getEnvironmentVariable("EMAIL_SOFT_BOUNCE_DELAY", "1h"))
+	softBounceMaxDelay, _ := time.ParseDuration(getEnvironmentVariable("EMAIL_SOFT_BOUNCE_MAX_DELAY", "12h"))
+	softBounceMaxRetries, _ := strconv.Atoi(getEnvironmentVariable("EMAIL_SOFT_BOUNCE_MAX_RETRIES", "5"))
+	batchTTL, _ := time.ParseDuration(getEnvironmentVariable("BATCH_TTL", "168h"))
+	jobStatusTTL, _ := time.ParseDuration(getEnvironmentVariable("JOB_STATUS_TTL", "168h"))
+	workerShutdownTimeout, _ := time.ParseDuration(getEnvironmentVariable("WORKER_SHUTDOWN_TIMEOUT", "30s"))
+	idempotencyTTL, _ := time.ParseDuration(getEnvironmentVariable("IDEMPOTENCY_TTL", "24h"))
+	enqueueDedupWindow, _ := time.ParseDuration(getEnvironmentVariable("ENQUEUE_DEDUP_WINDOW", "0s"))
+	workerHeartbeatTTL, _ := time.ParseDuration(getEnvironmentVariable("WORKER_HEARTBEAT_TTL", "30s"))
+	readyMaxHeartbeatAge, _ := time.ParseDuration(getEnvironmentVariable("READY_MAX_HEARTBEAT_AGE", "1m"))
+	readyMaxQueueDepth, _ := strconv.ParseInt(getEnvironmentVariable("READY_MAX_QUEUE_DEPTH", "0"), 10, 64)
+	readyBacklogDuration, _ := time.ParseDuration(getEnvironmentVariable("READY_BACKLOG_DURATION", "5m"))
+	instanceID := getEnvironmentVariable("INSTANCE_ID", "")
+	if instanceID == "" {
+		instanceID = defaultInstanceID()
+	}
+	recipientMXCheck, _ := strconv.ParseBool(getEnvironmentVariable("RECIPIENT_MX_CHECK", "false"))
+	recipientMXCacheTTL, _ := time.ParseDuration(getEnvironmentVariable("RECIPIENT_MX_CACHE_TTL", "10m"))
+	templateStrict, _ := strconv.ParseBool(getEnvironmentVariable("TEMPLATE_STRICT", "false"))
+	templateRenderCacheSize, _ := strconv.Atoi(getEnvironmentVariable("TEMPLATE_RENDER_CACHE_SIZE", "1000"))
+	templateRenderCacheTTL, _ := time.ParseDuration(getEnvironmentVariable("TEMPLATE_RENDER_CACHE_TTL", "10m"))
+	templatePrerender, _ := strconv.ParseBool(getEnvironmentVariable("TEMPLATE_PRERENDER", "false"))
+	templateSyncInterval, _ := time.ParseDuration(getEnvironmentVariable("TEMPLATE_SYNC_INTERVAL", "5m"))
+	htmlClipLimit, _ := strconv.Atoi(getEnvironmentVariable("HTML_CLIP_LIMIT", "102400"))
+	attachmentMaxBytes, _ := strconv.ParseInt(getEnvironmentVariable("ATTACHMENT_MAX_BYTES", "10485760"), 10, 64)
+	attachmentMaxTotalBytes, _ := strconv.ParseInt(getEnvironmentVariable("ATTACHMENT_MAX_TOTAL_BYTES", "20971520"), 10, 64)
+	objectStorePathStyle, _ := strconv.ParseBool(getEnvironmentVariable("OBJECT_STORE_PATH_STYLE", "false"))
+	objectStoreTimeout, _ := time.ParseDuration(getEnvironmentVariable("OBJECT_STORE_TIMEOUT", "30s"))
+	emailInlineImages, _ := strconv.ParseBool(getEnvironmentVariable("EMAIL_INLINE_IMAGES", "false"))
+	bodyOffloadThreshold, _ := strconv.Atoi(getEnvironmentVariable("BODY_OFFLOAD_THRESHOLD", "0"))
+	assetsCacheMaxAge, _ := time.ParseDuration(getEnvironmentVariable("ASSETS_CACHE_MAX_AGE", "24h"))
+	assetsURLTTL, _ := time.ParseDuration(getEnvironmentVariable("ASSETS_URL_TTL", "720h"))
+	trackingTokenTTL, _ := time.ParseDuration(getEnvironmentVariable("TRACKING_TOKEN_TTL", "0s"))
+	trackingOpens, _ := strconv.ParseBool(getEnvironmentVariable("TRACKING_OPENS", "false"))
+	trackingClicks, _ := strconv.ParseBool(getEnvironmentVariable("TRACKING_CLICKS", "false"))
+	spamCheckThreshold, _ := strconv.ParseFloat(getEnvironmentVariable("SPAM_CHECK_THRESHOLD", "5"), 64)
+	approvalRecipientThreshold, _ := strconv.Atoi(getEnvironmentVariable("APPROVAL_RECIPIENT_THRESHOLD", "1000"))
+	maxSendRate, _ := strconv.ParseFloat(getEnvironmentVariable("MAX_SEND_RATE", "0"), 64)
+	spamCheckTimeout, _ := time.ParseDuration(getEnvironmentVariable("SPAM_CHECK_TIMEOUT", "5s"))
+	linkCheckTimeout, _ := time.ParseDuration(getEnvironmentVariable("LINK_CHECK_TIMEOUT", "5s"))
+	linkCheckCacheTTL, _ := time.ParseDuration(getEnvironmentVariable("LINK_CHECK_CACHE_TTL", "10m"))
+	bounceHardThreshold, _ := strconv.Atoi(getEnvironmentVariable("BOUNCE_HARD_THRESHOLD", "1"))
+	bounceSoftThreshold, _ := strconv.Atoi(getEnvironmentVariable("BOUNCE_SOFT_THRESHOLD", "3"))
+	bounceWindow, _ := time.ParseDuration(getEnvironmentVariable("BOUNCE_WINDOW", "168h"))
+	webhookURLs := getEnvironmentList("WEBHOOK_URLS")
+	webhookMaxAttempts, _ := strconv.Atoi(getEnvironmentVariable("WEBHOOK_MAX_ATTEMPTS", "8"))
+	webhookBackoff, _ := time.ParseDuration(getEnvironmentVariable("WEBHOOK_BACKOFF", "10s"))
+	webhookTimeout, _ := time.ParseDuration(getEnvironmentVariable("WEBHOOK_TIMEOUT", "10s"))
+	retentionInterval, _ := time.ParseDuration(getEnvironmentVariable("RETENTION_INTERVAL", "1h"))
+	historyRetention, _ := time.ParseDuration(getEnvironmentVariable("HISTORY_RETENTION", "168h"))
+	deadLetterRetention, _ := time.ParseDuration(getEnvironmentVariable("DEAD_LETTER_RETENTION", "720h"))
+	eventsRetention, _ := time.ParseDuration(getEnvironmentVariable("EVENTS_RETENTION", "0s"))
+	statsRetention, _ := time.ParseDuration(getEnvironmentVariable("STATS_RETENTION", "9600h"))
+	leaderLockTTL, _ := time.ParseDuration(getEnvironmentVariable("LEADER_LOCK_TTL", "15s"))
+	eventsStreamMaxLen, _ := strconv.ParseInt(getEnvironmentVariable("EVENTS_STREAM_MAX_LEN", "10000"), 10, 64)
+	securityHeaders, _ := strconv.ParseBool(getEnvironmentVariable("SECURITY_HEADERS", "true"))
+	securityHSTSMaxAge, _ := time.ParseDuration(getEnvironmentVariable("SECURITY_HSTS_MAX_AGE", "0s"))
+	httpMaxHeaderBytes, _ := strconv.Atoi(getEnvironmentVariable("HTTP_MAX_HEADER_BYTES", "65536"))
+	publicMaxHeaderBytes, _ := strconv.Atoi(getEnvironmentVariable("PUBLIC_MAX_HEADER_BYTES", "16384"))
+	requestTimeout, _ := time.ParseDuration(getEnvironmentVariable("REQUEST_TIMEOUT", "30s"))
+	corsEnabled, _ := strconv.ParseBool(getEnvironmentVariable("CORS_ENABLED", "true"))
+	corsAllowCredentials, _ := strconv.ParseBool(getEnvironmentVariable("CORS_ALLOW_CREDENTIALS", "false"))
+	corsMaxAge, _ := time.ParseDuration(getEnvironmentVariable("CORS_MAX_AGE", "10m"))
 
-	return &ApplicationConfig{
+	cfg := &ApplicationConfig{
 		// Server Configuration
-		ServerPort: getEnvironmentVariable("SERVER_PORT", "8080"),
+		Environment: activeProfile(),
+		Mode:        loadMode(),
+		ServerPort:  getEnvironmentVariable("SERVER_PORT", "8080"),
+		LogLevel:    getEnvironmentVariable("LOG_LEVEL", "info"),
+
+		// TLS Configuration
+		TLSCertFile:         getEnvironmentVariable("TLS_CERT_FILE", ""),
+		TLSKeyFile:          getEnvironmentVariable("TLS_KEY_FILE", ""),
+		TLSAutocertDomains:  splitList(getEnvironmentVariable("TLS_AUTOCERT_DOMAINS", "")),
+		TLSAutocertCacheDir: getEnvironmentVariable("TLS_AUTOCERT_CACHE_DIR", "autocert-cache"),
+		TLSAutocertEmail:    getEnvironmentVariable("TLS_AUTOCERT_EMAIL", ""),
+		TLSMinVersion:       getEnvironmentVariable("TLS_MIN_VERSION", "1.2"),
+		TLSCipherSuites:     splitList(getEnvironmentVariable("TLS_CIPHER_SUITES", "")),
+		HTTPRedirectPort:    getEnvironmentVariable("HTTP_REDIRECT_PORT", ""),
+		TLSClientCAFile:     getEnvironmentVariable("TLS_CLIENT_CA_FILE", ""),
+		TLSClients:          loadTLSClientConfigs(),
+
+		// API Key Configuration
+		APIKeys: loadAPIKeyConfigs(),
+
+		// HTTP Hardening Configuration
+		SecurityHeaders:      securityHeaders,
+		SecurityHSTSMaxAge:   securityHSTSMaxAge,
+		HTTPMaxHeaderBytes:   httpMaxHeaderBytes,
+		PublicMaxHeaderBytes: publicMaxHeaderBytes,
+		RequestTimeout:       requestTimeout,
+
+		// CORS Configuration
+		CORSEnabled:          corsEnabled,
+		CORSAllowedOrigins:   splitList(getEnvironmentVariable("CORS_ALLOWED_ORIGINS", "*")),
+		CORSAllowedMethods:   splitList(getEnvironmentVariable("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS")),
+		CORSAllowedHeaders:   splitList(getEnvironmentVariable("CORS_ALLOWED_HEADERS", "Content-Type,Content-Encoding,Authorization,Idempotency-Key,API-Version,X-Request-ID,X-API-Key")),
+		CORSExposedHeaders:   splitList(getEnvironmentVariable("CORS_EXPOSED_HEADERS", "API-Version,Deprecation,Link,Idempotent-Replayed,X-Request-ID,X-RateLimit-Limit,X-RateLimit-Remaining,X-RateLimit-Reset,Retry-After")),
+		CORSAllowCredentials: corsAllowCredentials,
+		CORSMaxAge:           corsMaxAge,
 
 		// Redis Cache Configuration
 		CacheHost:          getEnvironmentVariable("CACHE_HOST", "localhost"),
@@ -40,18 +493,575 @@ func LoadConfiguration() *ApplicationConfig {
 		CacheDatabaseIndex: cacheDatabaseIndex,
 
 		// Email SMTP Configuration
-		EmailSMTPServer:        getEnvironmentVariable("EMAIL_SMTP_SERVER", "smtp.gmail.com"),
-		EmailSMTPServerPort:    smtpServerPort,
-		EmailSMTPUsername:      getEnvironmentVariable("EMAIL_SMTP_USERNAME", "sarthakyeole25@gmail.com"),
-		EmailSMTPPassword:      getEnvironmentVariable("EMAIL_SMTP_PASSWORD", "owtu kivm oidv pqdm"),
+		EmailSMTPServer:        smtpAccount.Server,
+		EmailSMTPServerPort:    smtpAccount.Port,
+		EmailSMTPUsername:      smtpAccount.Username,
+		EmailSMTPPassword:      smtpAccount.Password,
+		EmailSMTPRcptBatchSize: max(smtpRcptBatchSize, 1),
+		EmailSMTPIdleTimeout:   max(smtpIdleTimeout, 0),
+		EmailSMTPHeloName:      loadSMTPHeloName(),
+		SMTPAccounts:           loadSMTPAccountConfigs(smtpAccount),
+		SMTPAccountRotation:    loadSMTPAccountRotation(),
+		SMTPAccountMaxFailures: max(smtpAccountMaxFailures, 0),
+		SMTPAccountCooldown:    max(smtpAccountCooldown, 0),
+		SMTPAccountErrorWindow: max(smtpAccountErrorWindow, time.Second),
+		SMTPAccountMinSends:    max(smtpAccountMinSends, 1),
+		SMTPOverflow:           smtpOverflow,
 		EmailSenderAddress:     getEnvironmentVariable("EMAIL_SENDER_ADDRESS", "sarthakyeole25@gmail.com"),
 		EmailSenderDisplayName: getEnvironmentVariable("EMAIL_SENDER_NAME", "Sarthak"),
+		EmailAllowedSenders:    getEnvironmentList("EMAIL_ALLOWED_SENDERS"),
+		EmailInlineImages:      emailInlineImages,
+		EmailReturnPathDomain:  getEnvironmentVariable("EMAIL_RETURN_PATH_DOMAIN", ""),
+		EmailReturnPathPrefix:  getEnvironmentVariable("EMAIL_RETURN_PATH_PREFIX", "bounce"),
+		EmailReturnPath:        loadAddress("EMAIL_RETURN_PATH", "bounces@bounce.example.com"),
+		EmailUnsubscribeMailto: loadAddress("EMAIL_UNSUBSCRIBE_MAILTO", "unsubscribe@example.com"),
+		EmailCustomArgs:        getEnvironmentVariable("EMAIL_CUSTOM_ARGS", "none"),
+		Tenants:                loadTenantConfigs(),
+
+		// Queue Configuration
+		DefaultQueue:         loadDefaultQueue(queues),
+		Queues:               queues,
+		MaxSendRate:          max(maxSendRate, 0),
+		ISPs:                 loadISPConfigs(),
+		SendCategories:       loadSendCategoryConfigs(),
+		DeferralDefaultDelay: deferralDefaultDelay,
+		DeferralMaxDelay:     deferralMaxDelay,
+		SoftBounceDelay:      softBounceDelay,
+		SoftBounceMaxDelay:   softBounceMaxDelay,
+		SoftBounceMaxRetries: softBounceMaxRetries,
+		BatchTTL:             batchTTL,
+		JobStatusTTL:         jobStatusTTL,
+
+		WorkerShutdownTimeout: workerShutdownTimeout,
+		InstanceID:            instanceID,
+		WorkerHeartbeatTTL:    max(workerHeartbeatTTL, time.Second),
+
+		ReadyMaxHeartbeatAge: max(readyMaxHeartbeatAge, 0),
+		ReadyMaxQueueDepth:   max(readyMaxQueueDepth, 0),
+		ReadyBacklogDuration: max(readyBacklogDuration, 0),
+
+		WorkerHealthPort: getEnvironmentVariable("WORKER_HEALTH_PORT", getEnvironmentVariable("SERVER_PORT", "8080")),
+
+		SelfTestRecipient: getEnvironmentVariable("SELFTEST_RECIPIENT", ""),
+
+		IdempotencyTTL:     idempotencyTTL,
+		EnqueueDedupWindow: enqueueDedupWindow,
+
+		// Spam Check Configuration
+		SpamCheckProvider:  getEnvironmentVariable("SPAM_CHECK_PROVIDER", "off"),
+		SpamCheckEndpoint:  getEnvironmentVariable("SPAM_CHECK_ENDPOINT", ""),
+		SpamCheckThreshold: spamCheckThreshold,
+		SpamCheckAction:    getEnvironmentVariable("SPAM_CHECK_ACTION", "flag"),
+		SpamCheckTimeout:   spamCheckTimeout,
+
+		// Approval Configuration
+		ApprovalRules:              loadApprovalRules(),
+		ApprovalRecipientThreshold: max(approvalRecipientThreshold, 1),
+
+		// Link Check Configuration
+		LinkCheckMode:     getEnvironmentVariable("LINK_CHECK_MODE", "off"),
+		LinkCheckTimeout:  linkCheckTimeout,
+		LinkCheckCacheTTL: linkCheckCacheTTL,
+
+		// Warm-up Configuration
+		WarmupSchedule: loadWarmupSchedule(),
+		WarmupStart:    loadWarmupStart(),
+
+		// Recipient Validation Configuration
+		RecipientMXCheck:    recipientMXCheck,
+		RecipientMXCacheTTL: recipientMXCacheTTL,
+
+		RecipientDisposableMode:        getEnvironmentVariable("RECIPIENT_DISPOSABLE_MODE", "off"),
+		RecipientDisposableDomainsFile: getEnvironmentVariable("RECIPIENT_DISPOSABLE_DOMAINS_FILE", ""),
+
+		RecipientAllowlist: getEnvironmentList("RECIPIENT_ALLOWLIST"),
+		RecipientDenylist:  getEnvironmentList("RECIPIENT_DENYLIST"),
+
+		MarketingQueues: getEnvironmentList("MARKETING_QUEUES"),
+
+		PreferenceCategories: getEnvironmentList("PREFERENCE_CATEGORIES"),
+
+		BounceHardThreshold: bounceHardThreshold,
+		BounceSoftThreshold: bounceSoftThreshold,
+		BounceWindow:        bounceWindow,
+
+		// Template Configuration
+		TemplateHTMLSanitizer: getEnvironmentVariable("TEMPLATE_HTML_SANITIZER", "off"),
+		TemplateStrict:        templateStrict,
+
+		TemplateRenderCacheSize: templateRenderCacheSize,
+		TemplateRenderCacheTTL:  templateRenderCacheTTL,
+
+		TemplatePrerender: templatePrerender,
+		TemplatePlugins:   getEnvironmentList("TEMPLATE_PLUGINS"),
+
+		TemplateInlineCSS: getEnvironmentList("TEMPLATE_INLINE_CSS"),
+
+		HTMLClipLimit: htmlClipLimit,
+		HTMLClipMode:  getEnvironmentVariable("HTML_CLIP_MODE", "warn"),
+
+		TemplateDefaultLocale: getEnvironmentVariable("TEMPLATE_DEFAULT_LOCALE", "en-US"),
+
+		TemplateSyncSource:   getEnvironmentVariable("TEMPLATE_SYNC_SOURCE", ""),
+		TemplateSyncRef:      getEnvironmentVariable("TEMPLATE_SYNC_REF", "main"),
+		TemplateSyncPath:     strings.Trim(getEnvironmentVariable("TEMPLATE_SYNC_PATH", ""), "/"),
+		TemplateSyncInterval: templateSyncInterval,
+
+		// Attachment and Object Store Configuration
+		AttachmentMaxBytes:      attachmentMaxBytes,
+		AttachmentMaxTotalBytes: attachmentMaxTotalBytes,
+		AttachmentAllowedHosts:  getEnvironmentList("ATTACHMENT_ALLOWED_HOSTS"),
+		ObjectStoreEndpoint:     getEnvironmentVariable("OBJECT_STORE_ENDPOINT", ""),
+		ObjectStoreRegion:       getEnvironmentVariable("OBJECT_STORE_REGION", "us-east-1"),
+		ObjectStorePathStyle:    objectStorePathStyle,
+		ObjectStoreTimeout:      objectStoreTimeout,
+		ObjectStoreBucket:       getEnvironmentVariable("OBJECT_STORE_BUCKET", ""),
+		BodyOffloadThreshold:    bodyOffloadThreshold,
+
+		// Asset Configuration
+		AssetsDir:         getEnvironmentVariable("ASSETS_DIR", ""),
+		AssetsBucket:      getEnvironmentVariable("ASSETS_BUCKET", ""),
+		AssetsBaseURL:     strings.TrimSuffix(getEnvironmentVariable("ASSETS_BASE_URL", ""), "/"),
+		AssetsCacheMaxAge: assetsCacheMaxAge,
+		AssetsSigningKey:  getEnvironmentVariable("ASSETS_SIGNING_KEY", ""),
+		AssetsURLTTL:      assetsURLTTL,
+
+		// Tracking Configuration
+		TrackingBaseURL:   strings.TrimSuffix(getEnvironmentVariable("TRACKING_BASE_URL", ""), "/"),
+		TrackingTokenKeys: getEnvironmentList("TRACKING_TOKEN_KEYS"),
+		TrackingTokenTTL:  trackingTokenTTL,
+		TrackingOpens:     trackingOpens,
+		TrackingClicks:    trackingClicks,
+
+		UTMSource:   getEnvironmentVariable("UTM_SOURCE", ""),
+		UTMMedium:   getEnvironmentVariable("UTM_MEDIUM", "email"),
+		UTMCampaign: getEnvironmentVariable("UTM_CAMPAIGN", ""),
+		UTMDomains:  getEnvironmentList("UTM_DOMAINS"),
+
+		// Webhook Configuration
+		WebhookURLs:        webhookURLs,
+		WebhookSecret:      loadWebhookSecret(webhookURLs),
+		WebhookEvents:      getEnvironmentList("WEBHOOK_EVENTS"),
+		WebhookMaxAttempts: webhookMaxAttempts,
+		WebhookBackoff:     webhookBackoff,
+		WebhookTimeout:     webhookTimeout,
+
+		// Feedback Configuration
+		FeedbackWebhookToken:     getEnvironmentVariable("FEEDBACK_WEBHOOK_TOKEN", ""),
+		MailgunWebhookSigningKey: getEnvironmentVariable("MAILGUN_WEBHOOK_SIGNING_KEY", ""),
+
+		// Event Configuration
+		EventsChannel:      getEnvironmentVariable("EVENTS_CHANNEL", ""),
+		EventsStream:       getEnvironmentVariable("EVENTS_STREAM", ""),
+		EventsStreamMaxLen: eventsStreamMaxLen,
+
+		// Retention Configuration
+		RetentionInterval:   retentionInterval,
+		HistoryRetention:    historyRetention,
+		DeadLetterRetention: deadLetterRetention,
+		EventsRetention:     eventsRetention,
+		StatsRetention:      statsRetention,
+
+		// Leader Election Configuration
+		LeaderLockKey: getEnvironmentVariable("LEADER_LOCK_KEY", "mailqueue:leader"),
+		LeaderLockTTL: max(leaderLockTTL, time.Second),
+	}
+	requireProfileAuthentication(cfg)
+	return cfg
+}
+
+// loadQueueConfigs reads QUEUE_NAMES and the per-queue QUEUE_<NAME>_* overrides.
+// TASK_TTL is the default of every queue's QUEUE_<NAME>_TASK_TTL.
+func loadQueueConfigs() []QueueConfig {
+	defaultTaskTTL := getEnvironmentVariable("TASK_TTL", "24h")
+	taskTTL, _ := time.ParseDuration(defaultTaskTTL)
+
+	var queues []QueueConfig
+	for _, name := range strings.Split(getEnvironmentVariable("QUEUE_NAMES", "default"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := fmt.Sprintf("QUEUE_%s_", strings.ToUpper(name))
+		concurrency, _ := strconv.Atoi(getEnvironmentVariable(prefix+"CONCURRENCY", "1"))
+		rateLimit, _ := strconv.ParseFloat(getEnvironmentVariable(prefix+"RATE_LIMIT", "0"), 64)
+		maxRetries, _ := strconv.Atoi(getEnvironmentVariable(prefix+"MAX_RETRIES", "3"))
+		retryDelay, _ := time.ParseDuration(getEnvironmentVariable(prefix+"RETRY_DELAY", "5s"))
+		taskTTL, _ := time.ParseDuration(getEnvironmentVariable(prefix+"TASK_TTL", defaultTaskTTL))
+
+		queues = append(queues, QueueConfig{
+			Name:        name,
+			Concurrency: max(concurrency, 1),
+			RateLimit:   rateLimit,
+			MaxRetries:  maxRetries,
+			RetryDelay:  retryDelay,
+			TaskTTL:     taskTTL,
+		})
+	}
+
+	if len(queues) == 0 {
+		queues = append(queues, QueueConfig{
+			Name:        "default",
+			Concurrency: 1,
+			MaxRetries:  3,
+			RetryDelay:  5 * time.Second,
+			TaskTTL:     taskTTL,
+		})
+	}
+
+	return queues
+}
+
+// loadDefaultQueue reads QUEUE_DEFAULT, which must name one of queues:
+// sends to a queue no worker consumes would never go out. It falls back to
+// the first queue.
+func loadDefaultQueue(queues []QueueConfig) string {
+	name := strings.TrimSpace(getEnvironmentVariable("QUEUE_DEFAULT", queues[0].Name))
+	for _, qc := range queues {
+		if qc.Name == name {
+			return name
+		}
+	}
+	recordLoadError(fmt.Errorf("invalid QUEUE_DEFAULT %q: expected one of QUEUE_NAMES", name))
+	return queues[0].Name
+}
+
+// loadISPConfigs reads ISP_NAMES and the per-ISP ISP_<NAME>_* settings.
+func loadISPConfigs() []ISPConfig {
+	var isps []ISPConfig
+	for _, name := range getEnvironmentList("ISP_NAMES") {
+		prefix := fmt.Sprintf("ISP_%s_", strings.ToUpper(name))
+		concurrency, _ := strconv.Atoi(getEnvironmentVariable(prefix+"CONCURRENCY", "0"))
+		rateLimit, _ := strconv.ParseFloat(getEnvironmentVariable(prefix+"RATE_LIMIT", "0"), 64)
+
+		domains := getEnvironmentList(prefix + "DOMAINS")
+		if len(domains) == 0 {
+			domains = defaultISPDomains[strings.ToLower(name)]
+		}
+
+		isps = append(isps, ISPConfig{
+			Name:        name,
+			Domains:     domains,
+			Concurrency: max(concurrency, 0),
+			RateLimit:   rateLimit,
+		})
 	}
+	return isps
 }
 
+// loadAPIKeyConfigs reads API_KEYS and the per-key API_KEY_<NAME>_*
+// settings. A key without a secret fails Load rather than leaving the API
+// open to anyone who guesses its name.
+func loadAPIKeyConfigs() []APIKeyConfig {
+	var keys []APIKeyConfig
+	for _, name := range getEnvironmentList("API_KEYS") {
+		prefix := fmt.Sprintf("API_KEY_%s_", strings.ToUpper(name))
+		secret := getEnvironmentVariable(prefix+"SECRET", "")
+		if secret == "" {
+			recordLoadError(fmt.Errorf("%sSECRET is required", prefix))
+			continue
+		}
+		rateLimit, _ := strconv.ParseInt(getEnvironmentVariable(prefix+"RATE_LIMIT", "0"), 10, 64)
+		dailyQuota, _ := strconv.ParseInt(getEnvironmentVariable(prefix+"DAILY_QUOTA", "0"), 10, 64)
+
+		keys = append(keys, APIKeyConfig{
+			Name:       name,
+			Secret:     secret,
+			Tenant:     getEnvironmentVariable(prefix+"TENANT", name),
+			Scopes:     splitList(getEnvironmentVariable(prefix+"SCOPES", "read,write")),
+			RateLimit:  max(rateLimit, 0),
+			DailyQuota: max(dailyQuota, 0),
+			Templates:  getEnvironmentList(prefix + "TEMPLATES"),
+			Senders:    getEnvironmentList(prefix + "SENDERS"),
+		})
+	}
+	return keys
+}
+
+// loadWebhookSecret reads WEBHOOK_SECRET, which is required with
+// WEBHOOK_URLS: events signed with an empty key can be forged by anyone.
+func loadWebhookSecret(urls []string) string {
+	secret := getEnvironmentVariable("WEBHOOK_SECRET", "")
+	if secret == "" && len(urls) > 0 {
+		recordLoadError(fmt.Errorf("WEBHOOK_SECRET is required with WEBHOOK_URLS"))
+	}
+	return secret
+}
+
+// loadMode reads RUN_MODE, falling back to all when it is invalid.
+func loadMode() string {
+	mode := strings.ToLower(strings.TrimSpace(getEnvironmentVariable("RUN_MODE", ModeAll)))
+	if !IsMode(mode) {
+		recordLoadError(fmt.Errorf("invalid RUN_MODE %q: expected api, worker or all", mode))
+		return ModeAll
+	}
+	return mode
+}
+
+// loadSMTPAccountConfigs reads SMTP_ACCOUNTS and the per-account
+// SMTP_ACCOUNT_<NAME>_* settings, whose server and login default to
+// fallback, the EMAIL_SMTP_* settings. Without SMTP_ACCOUNTS, fallback is
+// the only account.
+func loadSMTPAccountConfigs(fallback SMTPAccountConfig) []SMTPAccountConfig {
+	names := getEnvironmentList("SMTP_ACCOUNTS")
+	if len(names) == 0 {
+		return []SMTPAccountConfig{fallback}
+	}
+
+	var accounts []SMTPAccountConfig
+	for _, name := range names {
+		prefix := fmt.Sprintf("SMTP_ACCOUNT_%s_", strings.ToUpper(name))
+		port, _ := strconv.Atoi(getEnvironmentVariable(prefix+"PORT", strconv.Itoa(fallback.Port)))
+		weight, _ := strconv.Atoi(getEnvironmentVariable(prefix+"WEIGHT", "1"))
+		rateLimit, _ := strconv.ParseFloat(getEnvironmentVariable(prefix+"RATE_LIMIT", "0"), 64)
+		standby, _ := strconv.ParseBool(getEnvironmentVariable(prefix+"STANDBY", "false"))
+
+		accounts = append(accounts, SMTPAccountConfig{
+			Name:          name,
+			Server:        getEnvironmentVariable(prefix+"SERVER", fallback.Server),
+			Port:          port,
+			Username:      getEnvironmentVariable(prefix+"USERNAME", fallback.Username),
+			Password:      getEnvironmentVariable(prefix+"PASSWORD", fallback.Password),
+			SenderAddress: getEnvironmentVariable(prefix+"SENDER_ADDRESS", ""),
+			SenderName:    getEnvironmentVariable(prefix+"SENDER_NAME", ""),
+			Weight:        max(weight, 0),
+			RateLimit:     max(rateLimit, 0),
+			ErrorBudget:   loadErrorBudget(prefix+"ERROR_BUDGET", fallback.ErrorBudget),
+			Standby:       standby,
+		})
+	}
+	return accounts
+}
+
+// loadErrorBudget reads an error budget, the share of failed sends from 0
+// to 1 an account may have before it is taken out of rotation.
+func loadErrorBudget(name string, fallback float64) float64 {
+	budget, err := strconv.ParseFloat(getEnvironmentVariable(name, strconv.FormatFloat(fallback, 'f', -1, 64)), 64)
+	if err != nil || budget < 0 || budget > 1 {
+		recordLoadError(fmt.Errorf("invalid %s %q: expected a share of sends from 0 to 1", name, getEnvironmentVariable(name, "")))
+		return fallback
+	}
+	return budget
+}
+
+// loadApprovalRules reads APPROVAL_RULES, the rules that hold emails for an
+// admin's approval. Unknown rules are reported and left out.
+func loadApprovalRules() []string {
+	var rules []string
+	for _, rule := range getEnvironmentList("APPROVAL_RULES") {
+		rule = strings.ToLower(rule)
+		switch rule {
+		case ApprovalNewTemplate, ApprovalSpamFlagged, ApprovalRecipients:
+			rules = append(rules, rule)
+		default:
+			recordLoadError(fmt.Errorf("invalid APPROVAL_RULES entry %q: expected new_template, spam_flagged or recipients", rule))
+		}
+	}
+	return rules
+}
+
+// loadSMTPAccountRotation reads SMTP_ACCOUNT_ROTATION, falling back to
+// round-robin when it is invalid.
+func loadSMTPAccountRotation() string {
+	rotation := strings.ToLower(strings.TrimSpace(getEnvironmentVariable("SMTP_ACCOUNT_ROTATION", RotationRoundRobin)))
+	if rotation != RotationRoundRobin && rotation != RotationWeighted {
+		recordLoadError(fmt.Errorf("invalid SMTP_ACCOUNT_ROTATION %q: expected round-robin or weighted", rotation))
+		return RotationRoundRobin
+	}
+	return rotation
+}
+
+// loadSMTPHeloName reads EMAIL_SMTP_HELO_NAME, the name the SMTP handshake
+// introduces this host by. It must be a hostname or an address literal such
+// as [192.0.2.1], since relays reject malformed names.
+func loadSMTPHeloName() string {
+	name := strings.TrimSpace(getEnvironmentVariable("EMAIL_SMTP_HELO_NAME", "localhost"))
+	if !isHeloName(name) {
+		recordLoadError(fmt.Errorf("invalid EMAIL_SMTP_HELO_NAME %q: expected a hostname or an address literal like [192.0.2.1]", name))
+		return "localhost"
+	}
+	return name
+}
+
+// isHeloName reports whether name is a valid EHLO argument (RFC 5321): a
+// domain of letters, digits and hyphens, or an IPv4 or IPv6 address literal.
+func isHeloName(name string) bool {
+	if literal, ok := strings.CutPrefix(name, "["); ok {
+		literal, ok = strings.CutSuffix(literal, "]")
+		if !ok {
+			return false
+		}
+		if v6, isV6 := strings.CutPrefix(literal, "IPv6:"); isV6 {
+			ip := net.ParseIP(v6)
+			return ip != nil && ip.To4() == nil
+		}
+		ip := net.ParseIP(literal)
+		return ip != nil && ip.To4() != nil && !strings.Contains(literal, ":")
+	}
+
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// loadSendCategoryConfigs reads SEND_CATEGORIES and the per-category
+// SEND_CATEGORY_<NAME>_* settings.
+func loadSendCategoryConfigs() []SendCategoryConfig {
+	var categories []SendCategoryConfig
+	for _, name := range getEnvironmentList("SEND_CATEGORIES") {
+		prefix := fmt.Sprintf("SEND_CATEGORY_%s_", strings.ToUpper(name))
+		rateLimit, _ := strconv.ParseFloat(getEnvironmentVariable(prefix+"RATE_LIMIT", "0"), 64)
+
+		priority := getEnvironmentVariable(prefix+"PRIORITY", "normal")
+		switch priority {
+		case "high", "normal", "low":
+		default:
+			recordLoadError(fmt.Errorf("invalid %sPRIORITY %q", prefix, priority))
+		}
+
+		categories = append(categories, SendCategoryConfig{
+			Name:      name,
+			RateLimit: max(rateLimit, 0),
+			Priority:  priority,
+		})
+	}
+	return categories
+}
+
+// loadTenantConfigs reads TENANTS and the per-tenant TENANT_<NAME>_*
+// settings.
+func loadTenantConfigs() []TenantConfig {
+	var tenants []TenantConfig
+	for _, name := range getEnvironmentList("TENANTS") {
+		prefix := fmt.Sprintf("TENANT_%s_", strings.ToUpper(name))
+		tenants = append(tenants, TenantConfig{
+			Name:       name,
+			ReturnPath: loadAddress(prefix+"RETURN_PATH", "bounces@bounce.example.com"),
+		})
+	}
+	return tenants
+}
+
+// loadAddress reads an email address from key. It must be a bare address,
+// without a display name or angle brackets; example is shown when it is not.
+func loadAddress(key, example string) string {
+	value := strings.TrimSpace(getEnvironmentVariable(key, ""))
+	if value == "" {
+		return ""
+	}
+	if parsed, err := mail.ParseAddress(value); err != nil || parsed.Address != value {
+		recordLoadError(fmt.Errorf("invalid %s %q: expected an address like %s", key, value, example))
+		return ""
+	}
+	return value
+}
+
+// loadTLSClientConfigs reads TLS_CLIENTS and the per-client TLS_CLIENT_<NAME>_*
+// settings.
+func loadTLSClientConfigs() []TLSClientConfig {
+	var clients []TLSClientConfig
+	for _, name := range getEnvironmentList("TLS_CLIENTS") {
+		prefix := fmt.Sprintf("TLS_CLIENT_%s_", strings.ToUpper(name))
+		subjects := getEnvironmentList(prefix + "SUBJECTS")
+		if len(subjects) == 0 {
+			subjects = []string{name}
+		}
+
+		clients = append(clients, TLSClientConfig{
+			Name:     name,
+			Subjects: subjects,
+			Tenant:   getEnvironmentVariable(prefix+"TENANT", name),
+			Scopes:   splitList(getEnvironmentVariable(prefix+"SCOPES", "read,write")),
+		})
+	}
+	return clients
+}
+
+// loadWarmupSchedule reads WARMUP_SCHEDULE, the daily send caps for days 1,
+// 2, ... of the warm-up. Invalid entries fail Load rather than leaving a
+// schedule that sends more than intended.
+func loadWarmupSchedule() []int64 {
+	var schedule []int64
+	for _, item := range getEnvironmentList("WARMUP_SCHEDULE") {
+		limit, err := strconv.ParseInt(item, 10, 64)
+		if err != nil || limit < 0 {
+			recordLoadError(fmt.Errorf("invalid WARMUP_SCHEDULE entry %q", item))
+			return nil
+		}
+		schedule = append(schedule, limit)
+	}
+	return schedule
+}
+
+// loadWarmupStart reads WARMUP_START (YYYY-MM-DD, UTC), the first day of the
+// warm-up. Unset, the warm-up starts on the first day mail is sent.
+func loadWarmupStart() time.Time {
+	value := getEnvironmentVariable("WARMUP_START", "")
+	if value == "" {
+		return time.Time{}
+	}
+	start, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		recordLoadError(fmt.Errorf("invalid WARMUP_START: %w", err))
+	}
+	return start
+}
+
+// getEnvironmentVariable resolves a setting from CONFIG_FILE, then the
+// environment, then a KEY_FILE secret, then the APP_ENV profile, then
+// defaultValue. ssm:// and aws-sm:// references are resolved.
 func getEnvironmentVariable(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
+	if value, exists := lookupEnvironment(key); exists {
+		return resolveReference(key, value)
+	}
+	if value, exists := profileDefault(key); exists {
 		return value
 	}
 	return defaultValue
 }
+
+func lookupEnvironment(key string) (string, bool) {
+	if value, exists := lookupOverride(key); exists {
+		return value, true
+	}
+	if value, exists := os.LookupEnv(key); exists {
+		return value, true
+	}
+	return lookupSecretFile(key)
+}
+
+// defaultInstanceID combines the hostname with a random suffix, so replicas
+// sharing a hostname and restarts of the same pod get distinct IDs.
+func defaultInstanceID() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// getEnvironmentList splits a comma-separated variable, dropping empty items.
+func getEnvironmentList(key string) []string {
+	return splitList(getEnvironmentVariable(key, ""))
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+const defaultDotEnvPath = ".env"
+
+// LoadDotEnv copies the entries of a .env file into the environment so local
+// development doesn't need a dozen exported variables. Variables that are
+// already set win over the file. DOTENV_PATH picks the file and
+// DOTENV_ENABLED=false turns loading off for production. A missing default
+// .env is not an error; a missing DOTENV_PATH is.
+func LoadDotEnv() error {
+	if enabled, err := strconv.ParseBool(getEnvironmentVariable("DOTENV_ENABLED", "true")); err == nil && !enabled {
+		return nil
+	}
+
+	path, explicit := os.LookupEnv("DOTENV_PATH")
+	if !explicit {
+		path = defaultDotEnvPath
+	}
+	if path == "" {
+		return nil
+	}
+
+	values, err := parseEnvFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for key, value := range values {
+		if _, exists := os.LookupEnv(key); !exists {
+			os.Setenv(key, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// overrides holds the entries of CONFIG_FILE. They take precedence over the
+// process environment, so settings can be changed on disk and applied by a
+// reload without restarting.
+var (
+	overridesMu sync.RWMutex
+	overrides   map[string]string
+)
+
+// buildMu serializes loads, which share the overrides and load errors.
+var buildMu sync.Mutex
+
+// Load reads CONFIG_FILE and builds a fresh configuration. Unlike
+// LoadConfiguration it reports a CONFIG_FILE, *_FILE secret or ssm:// /
+// aws-sm:// reference that cannot be resolved, so startup fails loudly and
+// a bad edit is not applied by a reload.
+func Load() (*ApplicationConfig, error) {
+	buildMu.Lock()
+	defer buildMu.Unlock()
+
+	takeLoadErrors()
+	errs := []error{loadOverrides(os.Getenv("CONFIG_FILE"))}
+	cfg := buildConfiguration()
+	errs = append(errs, takeLoadErrors()...)
+
+	return cfg, errors.Join(errs...)
+}
+
+// loadOverrides replaces the overrides with the entries of path. The
+// previous overrides stay in place if the file cannot be read.
+func loadOverrides(path string) error {
+	values := map[string]string{}
+	if path != "" {
+		var err error
+		if values, err = parseEnvFile(path); err != nil {
+			return err
+		}
+	}
+
+	overridesMu.Lock()
+	overrides = values
+	overridesMu.Unlock()
+	return nil
+}
+
+func lookupOverride(key string) (string, bool) {
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+	value, ok := overrides[key]
+	return value, ok
+}
+
+// parseEnvFile reads KEY=VALUE lines. Blank lines and lines starting with #
+// are skipped, and values may be wrapped in single or double quotes.
+func parseEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(text, "=")
+		key = strings.TrimSpace(strings.TrimPrefix(key, "export "))
+		if !found || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, line)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return values, nil
+}
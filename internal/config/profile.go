@@ -0,0 +1,67 @@
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// Profiles selected with APP_ENV. Each one changes the defaults of a few
+// settings; anything set in the environment or CONFIG_FILE still wins.
+const (
+	ProfileDev     = "dev"
+	ProfileStaging = "staging"
+	ProfileProd    = "prod"
+)
+
+var profileDefaults = map[string]map[string]string{
+	ProfileDev: {
+		"LOG_LEVEL": "debug",
+	},
+	ProfileStaging: {
+		"TEMPLATE_STRICT":    "true",
+		"RECIPIENT_MX_CHECK": "true",
+	},
+	// Production never falls back to the built-in SMTP account, doesn't
+	// read a stray .env file from the working directory and allows no
+	// browser origins until CORS_ALLOWED_ORIGINS lists them.
+	ProfileProd: {
+		"DOTENV_ENABLED":       "false",
+		"CORS_ALLOWED_ORIGINS": "",
+		"TEMPLATE_STRICT":      "true",
+		"RECIPIENT_MX_CHECK":   "true",
+		"EMAIL_SMTP_SERVER":    "",
+		"EMAIL_SMTP_USERNAME":  "",
+		"EMAIL_SMTP_PASSWORD":  "",
+		"EMAIL_SENDER_ADDRESS": "",
+		"EMAIL_SENDER_NAME":    "",
+	},
+}
+
+// IsProfile reports whether name is a known APP_ENV profile. An empty name
+// selects no profile.
+func IsProfile(name string) bool {
+	_, ok := profileDefaults[name]
+	return ok || name == ""
+}
+
+func activeProfile() string {
+	profile, _ := lookupEnvironment("APP_ENV")
+	return strings.ToLower(strings.TrimSpace(profile))
+}
+
+func profileDefault(key string) (string, bool) {
+	value, ok := profileDefaults[activeProfile()][key]
+	return value, ok
+}
+
+// requireProfileAuthentication fails Load under the prod profile when the
+// API would serve requests without API keys or client certificates.
+// Worker-only instances serve no API and are not checked.
+func requireProfileAuthentication(cfg *ApplicationConfig) {
+	if cfg.Environment != ProfileProd || cfg.Mode == ModeWorker {
+		return
+	}
+	if len(cfg.APIKeys) == 0 && cfg.TLSClientCAFile == "" {
+		recordLoadError(errors.New("APP_ENV=prod requires API_KEYS or TLS_CLIENT_CA_FILE so the API is not open to anyone"))
+	}
+}
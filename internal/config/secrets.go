@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// loadErrors collects *_FILE secrets and secret references that could not
+// be resolved while a configuration is built, so Load can report them all
+// at once.
+var (
+	loadErrorsMu sync.Mutex
+	loadErrors   []error
+)
+
+// lookupSecretFile resolves KEY from the file named by KEY_FILE, the
+// convention for Docker secrets and mounted Kubernetes secrets. A trailing
+// newline is dropped.
+func lookupSecretFile(key string) (string, bool) {
+	path, ok := lookupOverride(key + "_FILE")
+	if !ok {
+		path, ok = os.LookupEnv(key + "_FILE")
+	}
+	if !ok || path == "" {
+		return "", false
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		recordLoadError(fmt.Errorf("failed to read %s_FILE: %w", key, err))
+		return "", false
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), true
+}
+
+func recordLoadError(err error) {
+	loadErrorsMu.Lock()
+	loadErrors = append(loadErrors, err)
+	loadErrorsMu.Unlock()
+}
+
+func takeLoadErrors() []error {
+	loadErrorsMu.Lock()
+	defer loadErrorsMu.Unlock()
+	errs := loadErrors
+	loadErrors = nil
+	return errs
+}
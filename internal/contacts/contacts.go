@@ -0,0 +1,221 @@
+package contacts
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// listsKey is a hash of list ID to the list's JSON metadata.
+	listsKey = "contact_lists"
+
+	// contactsKeyPrefix keys a hash per list (contact_list:<id>) of
+	// lowercased address to the contact's JSON attributes.
+	contactsKeyPrefix = "contact_list:"
+
+	scanBatch = 500
+)
+
+var ErrListNotFound = errors.New("contact list not found")
+
+// List is a named set of contacts. Contacts is filled in on reads.
+type List struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Contacts  int64     `json:"contacts"`
+}
+
+// Contact is one address of a list with free-form attributes (plan,
+// country, firstName, ...) used by segments and merged into template data.
+type Contact struct {
+	Email      string            `json:"email"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Store keeps contact lists in Redis.
+type Store struct {
+	client *redis.Client
+}
+
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client}
+}
+
+func (s *Store) CreateList(ctx context.Context, name string) (*List, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	list := &List{ID: hex.EncodeToString(id), Name: name, CreatedAt: time.Now().UTC()}
+	listJSON, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.client.HSet(ctx, listsKey, list.ID, listJSON).Err(); err != nil {
+		return nil, fmt.Errorf("failed to create contact list: %w", err)
+	}
+	return list, nil
+}
+
+func (s *Store) GetList(ctx context.Context, id string) (*List, error) {
+	pipe := s.client.Pipeline()
+	listJSON := pipe.HGet(ctx, listsKey, id)
+	count := pipe.HLen(ctx, contactsKeyPrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to load contact list: %w", err)
+	}
+	if listJSON.Err() == redis.Nil {
+		return nil, ErrListNotFound
+	}
+
+	var list List
+	if err := json.Unmarshal([]byte(listJSON.Val()), &list); err != nil {
+		return nil, fmt.Errorf("invalid contact list %s: %w", id, err)
+	}
+	list.Contacts = count.Val()
+	return &list, nil
+}
+
+// Lists returns every list, oldest first.
+func (s *Store) Lists(ctx context.Context) ([]List, error) {
+	all, err := s.client.HGetAll(ctx, listsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load contact lists: %w", err)
+	}
+
+	lists := make([]List, 0, len(all))
+	pipe := s.client.Pipeline()
+	counts := make([]*redis.IntCmd, 0, len(all))
+	for _, listJSON := range all {
+		var list List
+		if err := json.Unmarshal([]byte(listJSON), &list); err != nil {
+			continue
+		}
+		lists = append(lists, list)
+		counts = append(counts, pipe.HLen(ctx, contactsKeyPrefix+list.ID))
+	}
+	if len(lists) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("failed to load contact lists: %w", err)
+		}
+	}
+	for i := range lists {
+		lists[i].Contacts = counts[i].Val()
+	}
+
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].CreatedAt.Before(lists[j].CreatedAt)
+	})
+	return lists, nil
+}
+
+func (s *Store) DeleteList(ctx context.Context, id string) error {
+	pipe := s.client.TxPipeline()
+	deleted := pipe.HDel(ctx, listsKey, id)
+	pipe.Unlink(ctx, contactsKeyPrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete contact list: %w", err)
+	}
+	if deleted.Val() == 0 {
+		return ErrListNotFound
+	}
+	return nil
+}
+
+// AddContacts adds contacts to a list, replacing the attributes of those
+// already on it. It returns how many were new.
+func (s *Store) AddContacts(ctx context.Context, id string, contacts []Contact) (int64, error) {
+	if err := s.ensureList(ctx, id); err != nil {
+		return 0, err
+	}
+
+	values := make([]interface{}, 0, 2*len(contacts))
+	for _, contact := range contacts {
+		attributes, err := json.Marshal(contact.Attributes)
+		if err != nil {
+			return 0, err
+		}
+		values = append(values, strings.ToLower(contact.Email), attributes)
+	}
+
+	added, err := s.client.HSet(ctx, contactsKeyPrefix+id, values...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to add contacts: %w", err)
+	}
+	return added, nil
+}
+
+// RemoveContacts removes addresses from a list and returns how many were on
+// it.
+func (s *Store) RemoveContacts(ctx context.Context, id string, emails []string) (int64, error) {
+	if err := s.ensureList(ctx, id); err != nil {
+		return 0, err
+	}
+
+	fields := make([]string, len(emails))
+	for i, email := range emails {
+		fields[i] = strings.ToLower(email)
+	}
+
+	removed, err := s.client.HDel(ctx, contactsKeyPrefix+id, fields...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove contacts: %w", err)
+	}
+	return removed, nil
+}
+
+// Each calls fn for every contact of the list in segment, in no particular
+// order, stopping at the first error fn returns.
+func (s *Store) Each(ctx context.Context, id string, segment Segment, fn func(Contact) error) error {
+	if err := s.ensureList(ctx, id); err != nil {
+		return err
+	}
+
+	var cursor uint64
+	for {
+		fields, next, err := s.client.HScan(ctx, contactsKeyPrefix+id, cursor, "", scanBatch).Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan contacts: %w", err)
+		}
+
+		for i := 0; i+1 < len(fields); i += 2 {
+			contact := Contact{Email: fields[i]}
+			if err := json.Unmarshal([]byte(fields[i+1]), &contact.Attributes); err != nil {
+				continue
+			}
+			if !segment.Matches(contact) {
+				continue
+			}
+			if err := fn(contact); err != nil {
+				return err
+			}
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (s *Store) ensureList(ctx context.Context, id string) error {
+	exists, err := s.client.HExists(ctx, listsKey, id).Result()
+	if err != nil {
+		return fmt.Errorf("failed to load contact list: %w", err)
+	}
+	if !exists {
+		return ErrListNotFound
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package contacts
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// importKeyPrefix keys the JSON report of each import
+	// (contact_import:<id>).
+	importKeyPrefix = "contact_import:"
+
+	importReportTTL = 7 * 24 * time.Hour
+
+	// importChunk is how many contacts are checked and written per round
+	// trip; the report is saved after each chunk.
+	importChunk = 1000
+
+	// maxReportedErrors bounds the rejected rows listed in a report.
+	maxReportedErrors = 100
+)
+
+const (
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+var ErrImportNotFound = errors.New("contact import not found")
+
+// ImportReport tracks one import. Every row counts once, as imported,
+// duplicate (repeated in the import or already on the list), suppressed or
+// invalid.
+type ImportReport struct {
+	ID         string        `json:"id"`
+	ListID     string        `json:"listId"`
+	Status     string        `json:"status"`
+	Total      int           `json:"total"`
+	Imported   int           `json:"imported"`
+	Duplicates int           `json:"duplicates"`
+	Suppressed int           `json:"suppressed"`
+	Invalid    int           `json:"invalid"`
+	Errors     []ImportError `json:"errors,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+}
+
+// ImportError describes a rejected row; Row counts from 1, not counting a
+// CSV header.
+type ImportError struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ImportRow is one row of an import, before its address is checked against
+// the list and the suppression list.
+type ImportRow struct {
+	Row     int
+	Contact Contact
+}
+
+// SuppressionLookup returns the suppressed addresses among addresses, keyed
+// by lowercased address.
+type SuppressionLookup func(ctx context.Context, addresses []string) (map[string]string, error)
+
+// StartImport saves a running report for rows, plus those already rejected
+// as invalid. The caller then adds the rows with RunImport, usually in the
+// background.
+func (s *Store) StartImport(ctx context.Context, listID string, rows []ImportRow, invalid []ImportError) (*ImportReport, error) {
+	if err := s.ensureList(ctx, listID); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{
+		ID:        hex.EncodeToString(id),
+		ListID:    listID,
+		Status:    ImportRunning,
+		Total:     len(rows) + len(invalid),
+		Invalid:   len(invalid),
+		CreatedAt: time.Now().UTC(),
+	}
+	for _, rejected := range invalid {
+		report.reject(rejected)
+	}
+	if err := s.saveImport(ctx, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// Import returns the report of an import of the list.
+func (s *Store) Import(ctx context.Context, listID, id string) (*ImportReport, error) {
+	reportJSON, err := s.client.Get(ctx, importKeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrImportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load contact import: %w", err)
+	}
+
+	var report ImportReport
+	if err := json.Unmarshal(reportJSON, &report); err != nil {
+		return nil, fmt.Errorf("invalid contact import %s: %w", id, err)
+	}
+	if report.ListID != listID {
+		return nil, ErrImportNotFound
+	}
+	return &report, nil
+}
+
+// RunImport adds the rows of the import started with report to the list,
+// saving the report after each chunk. Addresses already on the list are
+// left as they are, and suppressed ones are skipped. When ctx is canceled,
+// as at shutdown, the import stops and its report is marked failed.
+func (s *Store) RunImport(ctx context.Context, report ImportReport, rows []ImportRow, suppressed SuppressionLookup) {
+	seen := make(map[string]struct{}, len(rows))
+	unique := rows[:0:0]
+	for _, row := range rows {
+		address := strings.ToLower(row.Contact.Email)
+		if _, dup := seen[address]; dup {
+			report.Duplicates++
+			continue
+		}
+		seen[address] = struct{}{}
+		unique = append(unique, row)
+	}
+
+	for start := 0; start < len(unique); start += importChunk {
+		end := start + importChunk
+		if end > len(unique) {
+			end = len(unique)
+		}
+		err := ctx.Err()
+		if err == nil {
+			err = s.importChunk(ctx, &report, unique[start:end], suppressed)
+		}
+		if err != nil {
+			report.Status = ImportFailed
+			report.Error = err.Error()
+			break
+		}
+		s.saveImport(ctx, &report)
+	}
+
+	if report.Status == ImportRunning {
+		report.Status = ImportCompleted
+	}
+	finishedAt := time.Now().UTC()
+	report.FinishedAt = &finishedAt
+	// Saved even when ctx was canceled, so the report doesn't stay running.
+	s.saveImport(context.WithoutCancel(ctx), &report)
+}
+
+func (s *Store) importChunk(ctx context.Context, report *ImportReport, rows []ImportRow, suppressed SuppressionLookup) error {
+	// A list deleted mid-import must not be recreated by the writes below.
+	if err := s.ensureList(ctx, report.ListID); err != nil {
+		return err
+	}
+
+	addresses := make([]string, len(rows))
+	for i, row := range rows {
+		addresses[i] = strings.ToLower(row.Contact.Email)
+	}
+
+	existing, err := s.client.HMGet(ctx, contactsKeyPrefix+report.ListID, addresses...).Result()
+	if err != nil {
+		return fmt.Errorf("failed to check existing contacts: %w", err)
+	}
+	reasons, err := suppressed(ctx, addresses)
+	if err != nil {
+		return fmt.Errorf("failed to check suppressed contacts: %w", err)
+	}
+
+	values := make([]interface{}, 0, 2*len(rows))
+	for i, row := range rows {
+		if existing[i] != nil {
+			report.Duplicates++
+			continue
+		}
+		if reason, ok := reasons[addresses[i]]; ok {
+			report.Suppressed++
+			report.reject(ImportError{Row: row.Row, Email: row.Contact.Email, Reason: "suppressed: " + reason})
+			continue
+		}
+
+		attributes, err := json.Marshal(row.Contact.Attributes)
+		if err != nil {
+			return err
+		}
+		values = append(values, addresses[i], attributes)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	added, err := s.client.HSet(ctx, contactsKeyPrefix+report.ListID, values...).Result()
+	if err != nil {
+		return fmt.Errorf("failed to add contacts: %w", err)
+	}
+	report.Imported += int(added)
+	report.Duplicates += len(values)/2 - int(added)
+	return nil
+}
+
+func (r *ImportReport) reject(rejected ImportError) {
+	if len(r.Errors) < maxReportedErrors {
+		r.Errors = append(r.Errors, rejected)
+	}
+}
+
+func (s *Store) saveImport(ctx context.Context, report *ImportReport) error {
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Set(ctx, importKeyPrefix+report.ID, reportJSON, importReportTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save contact import: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package contacts
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	OpEquals   = "equals"
+	OpContains = "contains"
+)
+
+// Condition tests one contact attribute. Both operators ignore case. The
+// attribute "email" tests the contact's address.
+type Condition struct {
+	Attribute string `json:"attribute"`
+	Op        string `json:"op"`
+	Value     string `json:"value"`
+}
+
+// Segment selects the contacts matching every condition; an empty segment
+// selects the whole list.
+type Segment []Condition
+
+var andSeparator = regexp.MustCompile(`(?i)\s+AND\s+`)
+
+// ParseSegment parses conditions joined by AND, written attribute=value for
+// equals and attribute~value for contains, e.g. "plan=pro AND country=IN".
+func ParseSegment(expr string) (Segment, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, nil
+	}
+
+	var segment Segment
+	for _, term := range andSeparator.Split(expr, -1) {
+		i := strings.IndexAny(term, "=~")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid segment condition %q: expected attribute=value or attribute~value", term)
+		}
+
+		condition := Condition{
+			Attribute: strings.TrimSpace(term[:i]),
+			Op:        OpEquals,
+			Value:     strings.TrimSpace(term[i+1:]),
+		}
+		if term[i] == '~' {
+			condition.Op = OpContains
+		}
+		if condition.Attribute == "" || condition.Value == "" {
+			return nil, fmt.Errorf("invalid segment condition %q: expected attribute=value or attribute~value", term)
+		}
+		segment = append(segment, condition)
+	}
+	return segment, nil
+}
+
+// Matches reports whether contact satisfies every condition.
+func (s Segment) Matches(contact Contact) bool {
+	for _, condition := range s {
+		value, ok := contact.Attributes[condition.Attribute]
+		if condition.Attribute == "email" {
+			value, ok = contact.Email, true
+		}
+		if !ok {
+			return false
+		}
+
+		switch condition.Op {
+		case OpEquals:
+			if !strings.EqualFold(value, condition.Value) {
+				return false
+			}
+		case OpContains:
+			if !strings.Contains(strings.ToLower(value), strings.ToLower(condition.Value)) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,308 @@
+package templates
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	bundleVersion  = 1
+	bundleManifest = "manifest.json"
+	bundleDir      = "templates/"
+
+	// maxBundleBytes bounds the uncompressed size of an imported bundle.
+	maxBundleBytes = 64 << 20
+)
+
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,50}$`)
+
+var ErrInvalidBundle = errors.New("invalid template bundle")
+
+// Bundle is a portable copy of templates and their sample data, used to
+// promote templates between deployments and to back them up.
+type Bundle struct {
+	Manifest BundleManifest
+	Sources  map[string]string
+	Samples  map[string]map[string]interface{}
+}
+
+// BundleManifest describes a bundle; it is informational and not needed to
+// import one.
+type BundleManifest struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exportedAt"`
+	Templates  []BundleTemplate `json:"templates"`
+}
+
+type BundleTemplate struct {
+	Name      string   `json:"name"`
+	SHA256    string   `json:"sha256"`
+	Fields    []string `json:"fields"`
+	Sample    bool     `json:"sample"`
+	InlineCSS bool     `json:"inlineCss,omitempty"`
+}
+
+// Export returns every current template with its sample data.
+func (m *Manager) Export() *Bundle {
+	set := m.current()
+
+	bundle := &Bundle{
+		Manifest: BundleManifest{Version: bundleVersion, ExportedAt: time.Now().UTC()},
+		Sources:  make(map[string]string, len(set.sources)),
+		Samples:  make(map[string]map[string]interface{}, len(set.samples)),
+	}
+	for name, source := range set.sources {
+		bundle.Sources[name] = source
+		sum := sha256.Sum256([]byte(source))
+
+		fields := make([]string, 0, len(set.fields[name]))
+		for field := range set.fields[name] {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		sample, ok := set.samples[name]
+		if ok {
+			bundle.Samples[name] = sample
+		}
+		bundle.Manifest.Templates = append(bundle.Manifest.Templates, BundleTemplate{
+			Name:      name,
+			SHA256:    hex.EncodeToString(sum[:]),
+			Fields:    fields,
+			Sample:    ok,
+			InlineCSS: m.inlinesCSS(name),
+		})
+	}
+	sort.Slice(bundle.Manifest.Templates, func(i, j int) bool {
+		return bundle.Manifest.Templates[i].Name < bundle.Manifest.Templates[j].Name
+	})
+	return bundle
+}
+
+// CheckBundle parses the current templates with the bundle's added or
+// replacing them and lints the bundle's templates, without applying it.
+func (m *Manager) CheckBundle(bundle *Bundle) ([]LintIssue, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, issues, err := m.parseBundle(bundle)
+	return issues, err
+}
+
+// Import adds the bundle's templates, replacing those of the same name along
+// with their sample data. Templates it leaves out are kept. It returns the
+// lint issues of the bundle's templates; in strict mode lint errors reject
+// the bundle.
+func (m *Manager) Import(bundle *Bundle) ([]LintIssue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	set, issues, err := m.parseBundle(bundle)
+	if err != nil {
+		return issues, err
+	}
+	if m.strict && HasLintErrors(issues) {
+		return issues, fmt.Errorf("%w: lint failed in strict mode", ErrInvalidBundle)
+	}
+
+	m.set = set
+	m.cache.Purge()
+	return issues, nil
+}
+
+// parseBundle must be called with m.mu held.
+func (m *Manager) parseBundle(bundle *Bundle) (*templateSet, []LintIssue, error) {
+	sources := make(map[string]string, len(m.set.sources)+len(bundle.Sources))
+	for name, source := range m.set.sources {
+		sources[name] = source
+	}
+	samples := make(map[string]map[string]interface{}, len(m.set.samples)+len(bundle.Samples))
+	for name, sample := range m.set.samples {
+		samples[name] = sample
+	}
+	for name, source := range bundle.Sources {
+		sources[name] = source
+		delete(samples, name)
+		if sample, ok := bundle.Samples[name]; ok {
+			samples[name] = sample
+		}
+	}
+
+	set, err := m.parse(sources, samples, m.funcs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+
+	names := make([]string, 0, len(bundle.Sources))
+	for name := range bundle.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var issues []LintIssue
+	for _, name := range names {
+		issues = append(issues, m.lintTemplate(set, name)...)
+	}
+	return set, issues, nil
+}
+
+// WriteBundle writes bundle as a gzipped tar of manifest.json,
+// templates/<name>.html and templates/<name>.sample.json.
+func WriteBundle(w io.Writer, bundle *Bundle) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	modTime := bundle.Manifest.ExportedAt
+	write := func(name string, content []byte) error {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), ModTime: modTime}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+
+	manifest, err := json.MarshalIndent(bundle.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := write(bundleManifest, manifest); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(bundle.Sources))
+	for name := range bundle.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := write(bundleDir+name+".html", []byte(bundle.Sources[name])); err != nil {
+			return err
+		}
+		sample, ok := bundle.Samples[name]
+		if !ok {
+			continue
+		}
+		content, err := json.MarshalIndent(sample, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := write(bundleDir+name+".sample.json", content); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ReadBundle reads a bundle written by WriteBundle. Sample data must belong
+// to a template in the same bundle.
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	defer gz.Close()
+
+	bundle := newBundle()
+	tr := tar.NewReader(io.LimitReader(gz, maxBundleBytes))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+
+		entry := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if entry == bundleManifest {
+			if err := json.Unmarshal(content, &bundle.Manifest); err != nil {
+				return nil, fmt.Errorf("%w: manifest: %v", ErrInvalidBundle, err)
+			}
+			continue
+		}
+
+		file := strings.TrimPrefix(entry, bundleDir)
+		if file == entry || strings.Contains(file, "/") {
+			return nil, fmt.Errorf("%w: unexpected file %s", ErrInvalidBundle, header.Name)
+		}
+		added, err := bundle.addFile(file, content)
+		if err != nil {
+			return nil, err
+		}
+		if !added {
+			return nil, fmt.Errorf("%w: unexpected file %s", ErrInvalidBundle, header.Name)
+		}
+	}
+
+	if err := bundle.validate(); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+func newBundle() *Bundle {
+	return &Bundle{
+		Sources: make(map[string]string),
+		Samples: make(map[string]map[string]interface{}),
+	}
+}
+
+// addFile adds a <name>.html template or <name>.sample.json sample data
+// file, reporting false for any other file.
+func (b *Bundle) addFile(file string, content []byte) (bool, error) {
+	switch {
+	case strings.HasSuffix(file, ".sample.json"):
+		name := strings.TrimSuffix(file, ".sample.json")
+		sample, err := parseSampleData(content)
+		if err != nil {
+			return false, fmt.Errorf("%w: sample data %s: %v", ErrInvalidBundle, name, err)
+		}
+		b.Samples[name] = sample
+	case strings.HasSuffix(file, ".html"):
+		name := strings.TrimSuffix(file, ".html")
+		if !templateNamePattern.MatchString(name) {
+			return false, fmt.Errorf("%w: template name %q must be 1-50 letters, digits, _ or -", ErrInvalidBundle, name)
+		}
+		b.Sources[name] = string(content)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func (b *Bundle) validate() error {
+	if len(b.Sources) == 0 {
+		return fmt.Errorf("%w: no templates", ErrInvalidBundle)
+	}
+	for name := range b.Samples {
+		if _, ok := b.Sources[name]; !ok {
+			return fmt.Errorf("%w: sample data for %s has no template", ErrInvalidBundle, name)
+		}
+	}
+	return nil
+}
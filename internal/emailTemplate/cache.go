@@ -0,0 +1,128 @@
+package templates
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+var renderCacheLookups = metrics.NewCounter(
+	"mailqueue_template_render_cache_total",
+	"Render cache lookups by result.",
+	"result",
+)
+
+// renderCache is a bounded LRU of rendered bodies keyed by template name and
+// a hash of the data, so broadcasts where many recipients share identical data
+// render the HTML once.
+type renderCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type renderCacheEntry struct {
+	key     string
+	body    string
+	expires time.Time
+}
+
+func newRenderCache(size int, ttl time.Duration) *renderCache {
+	if size <= 0 {
+		return nil
+	}
+	return &renderCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// renderCacheKey hashes the data as JSON; encoding/json sorts map keys, so
+// equal maps always produce the same key. The locale is part of the key since
+// it changes how dates and numbers render.
+func renderCacheKey(name, locale string, data map[string]interface{}) (string, bool) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(encoded)
+	return name + ":" + locale + ":" + hex.EncodeToString(sum[:]), true
+}
+
+func (c *renderCache) Get(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		renderCacheLookups.Inc("miss")
+		return "", false
+	}
+
+	entry := elem.Value.(*renderCacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		renderCacheLookups.Inc("miss")
+		return "", false
+	}
+
+	c.order.MoveToFront(elem)
+	renderCacheLookups.Inc("hit")
+	return entry.body, true
+}
+
+func (c *renderCache) Put(key, body string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*renderCacheEntry)
+		entry.body = body
+		entry.expires = time.Now().Add(c.ttl)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&renderCacheEntry{
+		key:     key,
+		body:    body,
+		expires: time.Now().Add(c.ttl),
+	})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*renderCacheEntry).key)
+	}
+}
+
+// Purge drops every cached body, e.g. after templates were re-parsed.
+func (c *renderCache) Purge() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
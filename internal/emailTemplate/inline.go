@@ -0,0 +1,357 @@
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aymerick/douceur/css"
+	"github.com/aymerick/douceur/parser"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// InlineCSSAll in TEMPLATE_INLINE_CSS inlines the styles of every template.
+const InlineCSSAll = "*"
+
+// cssMatch is one stylesheet declaration that applies to an element.
+type cssMatch struct {
+	decl        *css.Declaration
+	specificity [3]int
+	order       int
+}
+
+// InlineCSS copies the rules of the <style> blocks in document into the style
+// attribute of every element they match, premailer-style, since many clients
+// drop style blocks. Declarations already in a style attribute win over the
+// stylesheet unless the stylesheet's are !important.
+//
+// Only type, class, id and universal selectors joined by descendant or child
+// combinators are inlined. Rules with other selectors (:hover, [attr], ...)
+// and at-rules such as @media stay in the <style> block; a block left empty
+// is removed. Blocks marked data-inline="false" or with a media attribute
+// are left as they are.
+func InlineCSS(document string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	var styles, elements []*html.Node
+	collectElements(doc, false, &styles, &elements)
+
+	matches := make(map[*html.Node][]cssMatch)
+	order := 0
+	for _, style := range styles {
+		sheet, err := parser.Parse(nodeText(style))
+		if err != nil {
+			// Leave CSS we can't parse for the client to deal with.
+			continue
+		}
+
+		var kept []*css.Rule
+		for _, rule := range sheet.Rules {
+			if rule.Kind != css.QualifiedRule {
+				kept = append(kept, rule)
+				continue
+			}
+
+			var unsupported []string
+			for _, raw := range rule.Selectors {
+				selector, ok := parseSelector(raw)
+				if !ok {
+					unsupported = append(unsupported, raw)
+					continue
+				}
+				for _, element := range elements {
+					if !selector.matches(element) {
+						continue
+					}
+					for _, decl := range rule.Declarations {
+						matches[element] = append(matches[element], cssMatch{
+							decl:        decl,
+							specificity: selector.specificity,
+							order:       order,
+						})
+						order++
+					}
+				}
+			}
+			if len(unsupported) > 0 {
+				kept = append(kept, &css.Rule{
+					Kind:         css.QualifiedRule,
+					Prelude:      strings.Join(unsupported, ", "),
+					Selectors:    unsupported,
+					Declarations: rule.Declarations,
+				})
+			}
+		}
+
+		if len(kept) == 0 {
+			style.Parent.RemoveChild(style)
+			continue
+		}
+		text := make([]string, len(kept))
+		for i, rule := range kept {
+			text[i] = rule.String()
+		}
+		for child := style.FirstChild; child != nil; child = style.FirstChild {
+			style.RemoveChild(child)
+		}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: "\n" + strings.Join(text, "\n") + "\n"})
+	}
+
+	for element, matched := range matches {
+		applyStyles(element, matched)
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return "", fmt.Errorf("failed to render HTML: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// collectElements gathers the inlinable <style> blocks and the elements
+// styles may be applied to, in document order. The contents of <head> are
+// never styled.
+func collectElements(n *html.Node, inHead bool, styles, elements *[]*html.Node) {
+	if n.Type == html.ElementNode {
+		switch {
+		case n.DataAtom == atom.Style:
+			if attr(n, "data-inline") != "false" && attr(n, "media") == "" {
+				*styles = append(*styles, n)
+			}
+			return
+		case n.DataAtom == atom.Head:
+			inHead = true
+		case !inHead:
+			*elements = append(*elements, n)
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		collectElements(child, inHead, styles, elements)
+	}
+}
+
+// applyStyles merges the matched declarations into the element's style
+// attribute in cascade order: specificity, then source order, with
+// !important declarations above the rest.
+func applyStyles(element *html.Node, matched []cssMatch) {
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.decl.Important != b.decl.Important {
+			return !a.decl.Important
+		}
+		for k := range a.specificity {
+			if a.specificity[k] != b.specificity[k] {
+				return a.specificity[k] < b.specificity[k]
+			}
+		}
+		return a.order < b.order
+	})
+
+	values := make(map[string]*css.Declaration)
+	var properties []string
+	set := func(decl *css.Declaration) {
+		if _, ok := values[decl.Property]; !ok {
+			properties = append(properties, decl.Property)
+		}
+		values[decl.Property] = decl
+	}
+
+	for _, m := range matched {
+		set(m.decl)
+	}
+	inline, _ := parser.ParseDeclarations(attr(element, "style"))
+	for _, decl := range inline {
+		if current, ok := values[decl.Property]; ok && current.Important && !decl.Important {
+			continue
+		}
+		set(decl)
+	}
+
+	parts := make([]string, len(properties))
+	for i, property := range properties {
+		parts[i] = values[property].StringWithImportant(false)
+	}
+	setAttr(element, "style", strings.Join(parts, " "))
+}
+
+// selector is a parsed complex selector, stored right to left: the first
+// compound must match the element itself and each following one an
+// ancestor (or, when child is set, the parent) of the previous match.
+type selector struct {
+	compounds   []compound
+	specificity [3]int
+}
+
+type compound struct {
+	tag     string
+	id      string
+	classes []string
+	// child means this compound's element must be the direct child of the
+	// element matched by the next compound.
+	child bool
+}
+
+// parseSelector parses selectors made of type, class, id and universal
+// parts joined by descendant or child combinators, and reports false for
+// anything else.
+func parseSelector(raw string) (selector, bool) {
+	fields := strings.Fields(strings.ReplaceAll(raw, ">", " > "))
+	if len(fields) == 0 {
+		return selector{}, false
+	}
+
+	var sel selector
+	child := false
+	for i := len(fields) - 1; i >= 0; i-- {
+		field := fields[i]
+		if field == ">" {
+			if child || i == 0 || i == len(fields)-1 {
+				return selector{}, false
+			}
+			child = true
+			continue
+		}
+
+		c, ok := parseCompound(field)
+		if !ok {
+			return selector{}, false
+		}
+		if len(sel.compounds) > 0 {
+			sel.compounds[len(sel.compounds)-1].child = child
+		}
+		child = false
+
+		if c.id != "" {
+			sel.specificity[0]++
+		}
+		sel.specificity[1] += len(c.classes)
+		if c.tag != "" {
+			sel.specificity[2]++
+		}
+		sel.compounds = append(sel.compounds, c)
+	}
+	return sel, true
+}
+
+func parseCompound(field string) (compound, bool) {
+	if strings.ContainsAny(field, ":[]+~()\\\"'") {
+		return compound{}, false
+	}
+
+	var c compound
+	rest := field
+	if end := strings.IndexAny(rest, ".#"); end != 0 {
+		if end < 0 {
+			end = len(rest)
+		}
+		if tag := rest[:end]; tag != "*" {
+			c.tag = strings.ToLower(tag)
+		}
+		rest = rest[end:]
+	}
+
+	for rest != "" {
+		kind := rest[0]
+		rest = rest[1:]
+		end := strings.IndexAny(rest, ".#")
+		if end < 0 {
+			end = len(rest)
+		}
+		name := rest[:end]
+		rest = rest[end:]
+		if name == "" {
+			return compound{}, false
+		}
+		if kind == '#' {
+			if c.id != "" {
+				return compound{}, false
+			}
+			c.id = name
+		} else {
+			c.classes = append(c.classes, name)
+		}
+	}
+	return c, true
+}
+
+func (s selector) matches(n *html.Node) bool {
+	return s.matchFrom(n, 0)
+}
+
+func (s selector) matchFrom(n *html.Node, i int) bool {
+	if !s.compounds[i].matches(n) {
+		return false
+	}
+	if i == len(s.compounds)-1 {
+		return true
+	}
+
+	for parent := n.Parent; parent != nil && parent.Type == html.ElementNode; parent = parent.Parent {
+		if s.matchFrom(parent, i+1) {
+			return true
+		}
+		if s.compounds[i].child {
+			return false
+		}
+	}
+	return false
+}
+
+func (c compound) matches(n *html.Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	if len(c.classes) > 0 {
+		classes := strings.Fields(attr(n, "class"))
+		for _, want := range c.classes {
+			found := false
+			for _, class := range classes {
+				if class == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func nodeText(n *html.Node) string {
+	var text strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.TextNode {
+			text.WriteString(child.Data)
+		}
+	}
+	return text.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, value string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
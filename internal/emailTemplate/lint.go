@@ -0,0 +1,175 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/template/parse"
+)
+
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+type LintIssue struct {
+	Template string `json:"template"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Lint checks every template: {{template}} calls must reference defined
+// templates, and each template must execute against its declared sample data
+// (html/<name>.sample.json) with missingkey=error so undefined variables are
+// reported instead of rendering as blanks.
+func (m *Manager) Lint() []LintIssue {
+	set := m.current()
+
+	names := make([]string, 0, len(set.templates))
+	for name := range set.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var issues []LintIssue
+	for _, name := range names {
+		issues = append(issues, m.lintTemplate(set, name)...)
+	}
+	return issues
+}
+
+// HasLintErrors reports whether any issue is an error rather than a warning.
+func HasLintErrors(issues []LintIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == LintError {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) lintTemplate(set *templateSet, name string) []LintIssue {
+	tmpl := set.templates[name]
+
+	var issues []LintIssue
+	report := func(severity, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{
+			Template: name,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	defined := make(map[string]struct{})
+	for _, t := range tmpl.Templates() {
+		defined[t.Name()] = struct{}{}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		for _, ref := range templateReferences(t.Tree.Root) {
+			if _, ok := defined[ref]; !ok {
+				report(LintError, "{{template %q}} references an undefined template", ref)
+			}
+		}
+	}
+
+	sample, ok := set.samples[name]
+	if !ok {
+		report(LintWarning, "no sample data declared (add html/%s.sample.json)", name)
+		sample = make(map[string]interface{})
+		for field := range set.fields[name] {
+			sample[field] = "sample " + field
+		}
+	}
+
+	var missing, unused []string
+	for field := range set.fields[name] {
+		if _, ok := sample[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	for key := range sample {
+		if _, ok := set.fields[name][key]; !ok {
+			unused = append(unused, key)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(unused)
+
+	for _, field := range missing {
+		report(LintError, "variable %q is used but not defined in the sample data", field)
+	}
+	for _, key := range unused {
+		report(LintWarning, "sample data key %q is not used by the template", key)
+	}
+
+	strictTmpl, err := tmpl.Clone()
+	if err == nil {
+		strictTmpl.Option("missingkey=error")
+		err = strictTmpl.Execute(io.Discard, sample)
+	}
+	if err != nil && len(missing) == 0 {
+		report(LintError, "failed to execute with sample data: %v", err)
+	}
+
+	return issues
+}
+
+func templateReferences(node parse.Node) []string {
+	var refs []string
+
+	var walk func(parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.TemplateNode:
+			refs = append(refs, n.Name)
+		case *parse.IfNode:
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.List)
+			walk(n.ElseList)
+		}
+	}
+	walk(node)
+
+	return refs
+}
+
+// SampleData returns a copy of the sample data declared for a template.
+func (m *Manager) SampleData(name string) (map[string]interface{}, bool) {
+	sample, ok := m.current().samples[name]
+	if !ok {
+		return nil, false
+	}
+
+	copied := make(map[string]interface{}, len(sample))
+	for key, value := range sample {
+		copied[key] = value
+	}
+	return copied, true
+}
+
+func parseSampleData(content []byte) (map[string]interface{}, error) {
+	var sample map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&sample); err != nil {
+		return nil, err
+	}
+	return sample, nil
+}
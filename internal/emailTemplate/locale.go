@@ -0,0 +1,180 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// monthNames holds localized month names for the languages formatDate
+// supports; anything else falls back to English.
+var monthNames = map[string][12]string{
+	"de": {"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+	"es": {"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+	"fr": {"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+	"it": {"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+	"nl": {"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+	"pt": {"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+}
+
+// dateLayouts maps a language (or language-region) to its short and long
+// date layouts, written with Go's reference time and English month names.
+var dateLayouts = map[string][2]string{
+	"en-US": {"01/02/2006", "January 2, 2006"},
+	"en":    {"02/01/2006", "2 January 2006"},
+	"de":    {"02.01.2006", "2. January 2006"},
+	"es":    {"02/01/2006", "2 de January de 2006"},
+	"fr":    {"02/01/2006", "2 January 2006"},
+	"it":    {"02/01/2006", "2 January 2006"},
+	"nl":    {"02-01-2006", "2 January 2006"},
+	"pt":    {"02/01/2006", "2 de January de 2006"},
+}
+
+// localeFuncs returns formatDate, formatNumber and formatCurrency bound to
+// a locale such as "en-US" or "de-DE".
+func localeFuncs(locale string) template.FuncMap {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		tag = language.AmericanEnglish
+	}
+	printer := message.NewPrinter(tag)
+
+	return template.FuncMap{
+		// formatDate accepts a time.Time or an RFC 3339 / YYYY-MM-DD string
+		// and an optional style: "short", "long" (default) or "datetime".
+		"formatDate": func(value interface{}, style ...string) (string, error) {
+			t, err := toTime(value)
+			if err != nil {
+				return "", err
+			}
+			return formatDate(tag, t, firstOr(style, "long")), nil
+		},
+		// formatNumber groups digits the locale's way, with an optional
+		// maximum number of fraction digits (default 2).
+		"formatNumber": func(value interface{}, decimals ...int) (string, error) {
+			n, err := toFloat(value)
+			if err != nil {
+				return "", err
+			}
+			digits := 2
+			if len(decimals) > 0 {
+				digits = decimals[0]
+			}
+			return printer.Sprint(number.Decimal(n, number.MaxFractionDigits(digits))), nil
+		},
+		// formatCurrency formats an amount in the given ISO 4217 currency,
+		// or the locale's own currency when none is given.
+		"formatCurrency": func(value interface{}, code ...string) (string, error) {
+			n, err := toFloat(value)
+			if err != nil {
+				return "", err
+			}
+
+			unit, confidence := currency.FromTag(tag)
+			if confidence == language.No {
+				unit = currency.USD
+			}
+			if len(code) > 0 && code[0] != "" {
+				if unit, err = currency.ParseISO(code[0]); err != nil {
+					return "", fmt.Errorf("invalid currency code %q", code[0])
+				}
+			}
+
+			return printer.Sprint(currency.Symbol(unit.Amount(n))), nil
+		},
+	}
+}
+
+func formatDate(tag language.Tag, t time.Time, style string) string {
+	base, _ := tag.Base()
+	region, _ := tag.Region()
+
+	layouts, ok := dateLayouts[base.String()+"-"+region.String()]
+	if !ok {
+		layouts, ok = dateLayouts[base.String()]
+	}
+	if !ok {
+		layouts = [2]string{"2006-01-02", "2 January 2006"}
+	}
+
+	var formatted string
+	switch style {
+	case "short":
+		formatted = t.Format(layouts[0])
+	case "datetime":
+		clock := "15:04"
+		if tag == language.AmericanEnglish {
+			clock = "3:04 PM"
+		}
+		formatted = t.Format(layouts[1] + " " + clock)
+	default:
+		formatted = t.Format(layouts[1])
+	}
+
+	if names, ok := monthNames[base.String()]; ok {
+		formatted = strings.Replace(formatted, t.Month().String(), names[t.Month()-1], 1)
+	}
+	return formatted
+}
+
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("cannot parse %q as a date", v)
+	case float64:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case int64:
+		return time.Unix(v, 0).UTC(), nil
+	case int:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse %q as a timestamp", v)
+		}
+		return time.Unix(n, 0).UTC(), nil
+	default:
+		return time.Time{}, fmt.Errorf("cannot format %T as a date", value)
+	}
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	default:
+		return 0, fmt.Errorf("cannot format %T as a number", value)
+	}
+}
+
+func firstOr(values []string, fallback string) string {
+	if len(values) > 0 && values[0] != "" {
+		return values[0]
+	}
+	return fallback
+}
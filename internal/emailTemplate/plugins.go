@@ -0,0 +1,44 @@
+package templates
+
+import (
+	"fmt"
+	"html/template"
+	"plugin"
+)
+
+// loadPluginFuncs opens Go plugins (built with -buildmode=plugin) that export
+// a `Funcs` variable of type template.FuncMap and merges their functions.
+//
+//	package main
+//
+//	import "html/template"
+//
+//	var Funcs = template.FuncMap{
+//		"brandURL": func(path string) string { return "https://example.com" + path },
+//	}
+func loadPluginFuncs(paths []string) (template.FuncMap, error) {
+	funcs := make(template.FuncMap)
+
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open template plugin %s: %w", path, err)
+		}
+
+		symbol, err := p.Lookup("Funcs")
+		if err != nil {
+			return nil, fmt.Errorf("template plugin %s does not export Funcs: %w", path, err)
+		}
+
+		pluginFuncs, ok := symbol.(*template.FuncMap)
+		if !ok {
+			return nil, fmt.Errorf("template plugin %s: Funcs must be a template.FuncMap, got %T", path, symbol)
+		}
+
+		for name, fn := range *pluginFuncs {
+			funcs[name] = fn
+		}
+	}
+
+	return funcs, nil
+}
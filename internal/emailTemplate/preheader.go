@@ -0,0 +1,31 @@
+package templates
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var bodyOpenTag = regexp.MustCompile(`(?i)<body[^>]*>`)
+
+// preheaderFiller pads the hidden snippet so inbox previews don't pull in
+// the visible body text after the preheader.
+var preheaderFiller = strings.Repeat("&#847;&zwnj;&nbsp;", 90)
+
+// InjectPreheader inserts text as the hidden preview snippet right after
+// the opening <body> tag (or at the start of a fragment without one).
+func InjectPreheader(html, text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return html
+	}
+
+	snippet := `<div style="display:none;font-size:1px;color:#ffffff;line-height:1px;max-height:0;max-width:0;opacity:0;overflow:hidden;mso-hide:all;">` +
+		template.HTMLEscapeString(text) + preheaderFiller + `</div>`
+
+	loc := bodyOpenTag.FindStringIndex(html)
+	if loc == nil {
+		return snippet + html
+	}
+	return html[:loc[1]] + snippet + html[loc[1]:]
+}
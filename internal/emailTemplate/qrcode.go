@@ -0,0 +1,38 @@
+package templates
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRCodeSize = 256
+	maxQRCodeSize     = 1024
+)
+
+// qrcodeDataURI renders content as a PNG QR code and returns it as a data URI
+// for use in <img src>. With EMAIL_INLINE_IMAGES the sender turns it into a
+// CID-referenced inline part, since some clients (Gmail) strip data URIs.
+func qrcodeDataURI(content string, size ...int) (template.URL, error) {
+	if content == "" {
+		return "", fmt.Errorf("qrcode: content cannot be empty")
+	}
+
+	pixels := defaultQRCodeSize
+	if len(size) > 0 {
+		pixels = size[0]
+	}
+	if pixels <= 0 || pixels > maxQRCodeSize {
+		return "", fmt.Errorf("qrcode: size must be between 1 and %d pixels", maxQRCodeSize)
+	}
+
+	png, err := qrcode.Encode(content, qrcode.Medium, pixels)
+	if err != nil {
+		return "", fmt.Errorf("qrcode: %w", err)
+	}
+
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
@@ -0,0 +1,131 @@
+package templates
+
+import (
+	"fmt"
+	"html/template"
+	"text/template/parse"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const (
+	SanitizerOff    = "off"
+	SanitizerBasic  = "basic"
+	SanitizerStrict = "strict"
+)
+
+// newSanitizer builds the policy applied to values rendered through safeHTML.
+// The basic policy keeps simple formatting and links but drops scripts,
+// styles, images and event handlers, so user content can neither run code nor
+// smuggle tracking pixels into an email.
+func newSanitizer(mode string) (*bluemonday.Policy, error) {
+	switch mode {
+	case "", SanitizerOff:
+		return nil, nil
+	case SanitizerStrict:
+		return bluemonday.StrictPolicy(), nil
+	case SanitizerBasic:
+		p := bluemonday.NewPolicy()
+		p.AllowElements("p", "br", "b", "strong", "i", "em", "u", "s", "small",
+			"ul", "ol", "li", "blockquote", "code", "pre",
+			"h1", "h2", "h3", "h4", "h5", "h6", "span", "div")
+		p.AllowStandardURLs()
+		p.AllowAttrs("href").OnElements("a")
+		p.RequireNoFollowOnLinks(true)
+		p.AddTargetBlankToFullyQualifiedLinks(true)
+		return p, nil
+	default:
+		return nil, fmt.Errorf("invalid HTML sanitizer mode %q", mode)
+	}
+}
+
+// htmlFields returns the top-level data keys a template passes to safeHTML,
+// either as an argument ({{safeHTML .body}}) or through a pipeline
+// ({{.body | safeHTML}}).
+func htmlFields(tmpl *template.Template) map[string]struct{} {
+	fields := make(map[string]struct{})
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && t.Tree.Root != nil {
+			collectHTMLFields(t.Tree.Root, fields)
+		}
+	}
+	return fields
+}
+
+func collectHTMLFields(node parse.Node, fields map[string]struct{}) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectHTMLFields(child, fields)
+		}
+	case *parse.ActionNode:
+		collectPipeFields(n.Pipe, fields)
+	case *parse.IfNode:
+		collectBranchFields(&n.BranchNode, fields)
+	case *parse.RangeNode:
+		collectBranchFields(&n.BranchNode, fields)
+	case *parse.WithNode:
+		collectBranchFields(&n.BranchNode, fields)
+	case *parse.TemplateNode:
+		collectPipeFields(n.Pipe, fields)
+	}
+}
+
+func collectBranchFields(n *parse.BranchNode, fields map[string]struct{}) {
+	collectPipeFields(n.Pipe, fields)
+	collectHTMLFields(n.List, fields)
+	collectHTMLFields(n.ElseList, fields)
+}
+
+func collectPipeFields(pipe *parse.PipeNode, fields map[string]struct{}) {
+	if pipe == nil {
+		return
+	}
+
+	for i, cmd := range pipe.Cmds {
+		if len(cmd.Args) == 0 {
+			continue
+		}
+
+		ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+		if !ok || ident.Ident != "safeHTML" {
+			continue
+		}
+
+		for _, arg := range cmd.Args[1:] {
+			addField(arg, fields)
+		}
+
+		if len(cmd.Args) == 1 && i > 0 && len(pipe.Cmds[i-1].Args) == 1 {
+			addField(pipe.Cmds[i-1].Args[0], fields)
+		}
+	}
+}
+
+func addField(node parse.Node, fields map[string]struct{}) {
+	if field, ok := node.(*parse.FieldNode); ok && len(field.Ident) > 0 {
+		fields[field.Ident[0]] = struct{}{}
+	}
+}
+
+// sanitizeHTMLFields returns a copy of data with every string value rendered
+// through safeHTML passed through the sanitizer policy.
+func (m *Manager) sanitizeHTMLFields(fields map[string]struct{}, data map[string]interface{}) map[string]interface{} {
+	if m.sanitizer == nil || len(fields) == 0 {
+		return data
+	}
+
+	sanitized := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if s, ok := value.(string); ok {
+			if _, isHTML := fields[key]; isHTML {
+				value = m.sanitizer.Sanitize(s)
+			}
+		}
+		sanitized[key] = value
+	}
+	return sanitized
+}
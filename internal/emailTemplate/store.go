@@ -0,0 +1,79 @@
+package templates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// importedSourcesKey and importedSamplesKey are hashes of template name
+	// to the source and JSON sample data of every imported template.
+	importedSourcesKey = "template_imports"
+	importedSamplesKey = "template_import_samples"
+)
+
+// Store keeps imported templates in Redis so every instance applies them at
+// startup and on reload.
+type Store struct {
+	client *redis.Client
+}
+
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client}
+}
+
+// Save records the bundle's templates, replacing imported templates of the
+// same name and their sample data.
+func (s *Store) Save(ctx context.Context, bundle *Bundle) error {
+	pipe := s.client.TxPipeline()
+	for name, source := range bundle.Sources {
+		pipe.HSet(ctx, importedSourcesKey, name, source)
+
+		sample, ok := bundle.Samples[name]
+		if !ok {
+			pipe.HDel(ctx, importedSamplesKey, name)
+			continue
+		}
+		sampleJSON, err := json.Marshal(sample)
+		if err != nil {
+			return err
+		}
+		pipe.HSet(ctx, importedSamplesKey, name, sampleJSON)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save imported templates: %w", err)
+	}
+	return nil
+}
+
+// Load returns the imported templates, or nil when there are none.
+func (s *Store) Load(ctx context.Context) (*Bundle, error) {
+	pipe := s.client.Pipeline()
+	sources := pipe.HGetAll(ctx, importedSourcesKey)
+	samples := pipe.HGetAll(ctx, importedSamplesKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load imported templates: %w", err)
+	}
+	if len(sources.Val()) == 0 {
+		return nil, nil
+	}
+
+	bundle := &Bundle{
+		Sources: sources.Val(),
+		Samples: make(map[string]map[string]interface{}, len(samples.Val())),
+	}
+	for name, sampleJSON := range samples.Val() {
+		if _, ok := bundle.Sources[name]; !ok {
+			continue
+		}
+		sample, err := parseSampleData([]byte(sampleJSON))
+		if err != nil {
+			return nil, fmt.Errorf("invalid imported sample data %s: %w", name, err)
+		}
+		bundle.Samples[name] = sample
+	}
+	return bundle, nil
+}
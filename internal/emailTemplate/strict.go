@@ -0,0 +1,118 @@
+package templates
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// referencedFields returns the top-level data keys a template reads. Fields
+// inside range/with bodies are relative to a different dot and are skipped,
+// while $.field references are always counted.
+func referencedFields(tmpl *template.Template) map[string]struct{} {
+	fields := make(map[string]struct{})
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && t.Tree.Root != nil {
+			collectReferencedFields(t.Tree.Root, fields, true)
+		}
+	}
+	return fields
+}
+
+func collectReferencedFields(node parse.Node, fields map[string]struct{}, topLevel bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectReferencedFields(child, fields, topLevel)
+		}
+	case *parse.ActionNode:
+		collectPipeReferences(n.Pipe, fields, topLevel)
+	case *parse.TemplateNode:
+		collectPipeReferences(n.Pipe, fields, topLevel)
+	case *parse.IfNode:
+		collectPipeReferences(n.Pipe, fields, topLevel)
+		collectReferencedFields(n.List, fields, topLevel)
+		collectReferencedFields(n.ElseList, fields, topLevel)
+	case *parse.RangeNode:
+		collectPipeReferences(n.Pipe, fields, topLevel)
+		collectReferencedFields(n.List, fields, false)
+		collectReferencedFields(n.ElseList, fields, topLevel)
+	case *parse.WithNode:
+		collectPipeReferences(n.Pipe, fields, topLevel)
+		collectReferencedFields(n.List, fields, false)
+		collectReferencedFields(n.ElseList, fields, topLevel)
+	}
+}
+
+func collectPipeReferences(pipe *parse.PipeNode, fields map[string]struct{}, topLevel bool) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			collectArgReferences(arg, fields, topLevel)
+		}
+	}
+}
+
+func collectArgReferences(node parse.Node, fields map[string]struct{}, topLevel bool) {
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		if topLevel && len(n.Ident) > 0 {
+			fields[n.Ident[0]] = struct{}{}
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			fields[n.Ident[1]] = struct{}{}
+		}
+	case *parse.ChainNode:
+		collectArgReferences(n.Node, fields, topLevel)
+	case *parse.PipeNode:
+		collectPipeReferences(n, fields, topLevel)
+	}
+}
+
+// ValidateData checks that name is a known template and, in strict mode,
+// that data carries no keys the template never reads. Missing keys are caught
+// at render time through missingkey=error.
+func (m *Manager) ValidateData(name string, data map[string]interface{}) error {
+	return m.validateData(m.current(), name, data)
+}
+
+// ReadsField reports whether template name reads the top-level data key
+// field. In strict mode only such keys may be passed.
+func (m *Manager) ReadsField(name, field string) bool {
+	_, ok := m.current().fields[name][field]
+	return ok
+}
+
+func (m *Manager) validateData(set *templateSet, name string, data map[string]interface{}) error {
+	if _, ok := set.templates[name]; !ok {
+		return fmt.Errorf("template '%s' not found", name)
+	}
+
+	if !m.strict {
+		return nil
+	}
+
+	known := set.fields[name]
+
+	var unknown []string
+	for key := range data {
+		if _, ok := known[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown variables for template '%s': %s", name, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package templates
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+	storage "github.com/sarthakyeole/redis-go-mailing-bulk/internal/objectStore"
+)
+
+const (
+	// maxSyncedFileBytes bounds each file fetched from a sync source.
+	maxSyncedFileBytes = 1 << 20
+
+	gitTimeout = 2 * time.Minute
+)
+
+var templateSyncs = metrics.NewCounter(
+	"mailqueue_template_syncs_total",
+	"Template syncs from TEMPLATE_SYNC_SOURCE, by result.",
+	"result",
+)
+
+// Syncer periodically pulls templates and their sample data from an S3
+// prefix or a git repository and imports them into the Manager, so content
+// changes ship without a redeploy. Every instance syncs on its own.
+type Syncer struct {
+	manager  *Manager
+	store    *storage.Store
+	logger   *slog.Logger
+	source   string
+	ref      string
+	path     string
+	interval time.Duration
+
+	// version identifies the last imported content (object ETags or the
+	// git commit), so unchanged sources are not re-imported.
+	version string
+	gitDir  string
+}
+
+// NewSyncer returns nil when TEMPLATE_SYNC_SOURCE is not set. Sources are
+// s3://bucket/prefix or a git remote (https://, ssh:// or git@host:path).
+func NewSyncer(cfg *config.ApplicationConfig, manager *Manager, store *storage.Store, logger *slog.Logger) (*Syncer, error) {
+	if cfg.TemplateSyncSource == "" {
+		return nil, nil
+	}
+	if cfg.TemplateSyncInterval <= 0 {
+		return nil, fmt.Errorf("TEMPLATE_SYNC_INTERVAL must be positive")
+	}
+
+	source := cfg.TemplateSyncSource
+	switch {
+	case strings.HasPrefix(source, "s3://"):
+		if strings.Trim(strings.TrimPrefix(source, "s3://"), "/") == "" {
+			return nil, fmt.Errorf("invalid TEMPLATE_SYNC_SOURCE %q: expected s3://bucket/prefix", source)
+		}
+	case strings.HasPrefix(source, "https://"), strings.HasPrefix(source, "ssh://"), strings.HasPrefix(source, "git@"):
+		if _, err := exec.LookPath("git"); err != nil {
+			return nil, fmt.Errorf("TEMPLATE_SYNC_SOURCE is a git remote but git is not installed: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid TEMPLATE_SYNC_SOURCE %q: use s3://bucket/prefix or a git remote", source)
+	}
+
+	return &Syncer{
+		manager:  manager,
+		store:    store,
+		logger:   logger,
+		source:   source,
+		ref:      cfg.TemplateSyncRef,
+		path:     cfg.TemplateSyncPath,
+		interval: cfg.TemplateSyncInterval,
+	}, nil
+}
+
+// Run syncs once at start and then every interval until ctx is cancelled.
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	defer func() {
+		if s.gitDir != "" {
+			os.RemoveAll(s.gitDir)
+		}
+	}()
+
+	for {
+		s.sync(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Syncer) sync(ctx context.Context) {
+	var bundle *Bundle
+	var version string
+	var err error
+	if strings.HasPrefix(s.source, "s3://") {
+		bundle, version, err = s.fetchS3(ctx)
+	} else {
+		bundle, version, err = s.fetchGit(ctx)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			templateSyncs.Inc("failed")
+			s.logger.Error("Template sync failed", "source", s.source, "error", err)
+		}
+		return
+	}
+	if bundle == nil {
+		templateSyncs.Inc("unchanged")
+		return
+	}
+
+	issues, err := s.manager.Import(bundle)
+	for _, issue := range issues {
+		s.logger.Warn("Template lint", "severity", issue.Severity, "template", issue.Template, "message", issue.Message)
+	}
+	if err != nil {
+		templateSyncs.Inc("failed")
+		s.logger.Error("Template sync failed", "source", s.source, "version", version, "error", err)
+		return
+	}
+
+	s.version = version
+	templateSyncs.Inc("updated")
+	s.logger.Info("Templates synced", "source", s.source, "version", version, "templates", len(bundle.Sources))
+}
+
+// fetchS3 lists the prefix and, when any object changed since the last
+// sync, downloads the templates and sample data in it. It returns a nil
+// bundle when nothing changed.
+func (s *Syncer) fetchS3(ctx context.Context) (*Bundle, string, error) {
+	prefix := strings.TrimSuffix(s.source, "/") + "/"
+	if s.path != "" {
+		prefix += s.path + "/"
+	}
+
+	objects, err := s.store.List(ctx, prefix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := sha256.New()
+	var keys []string
+	for _, object := range objects {
+		file := path.Base(object.Key)
+		if !strings.HasSuffix(file, ".html") && !strings.HasSuffix(file, ".sample.json") {
+			continue
+		}
+		keys = append(keys, object.Key)
+		fmt.Fprintf(hash, "%s %s\n", object.Key, object.ETag)
+	}
+	version := hex.EncodeToString(hash.Sum(nil))
+	if version == s.version {
+		return nil, version, nil
+	}
+
+	bucket := strings.SplitN(strings.TrimPrefix(s.source, "s3://"), "/", 2)[0]
+	bundle := newBundle()
+	for _, key := range keys {
+		content, _, err := s.store.Fetch(ctx, "s3://"+bucket+"/"+key, maxSyncedFileBytes)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to fetch %s: %w", key, err)
+		}
+		if _, err := bundle.addFile(path.Base(key), content); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := bundle.validate(); err != nil {
+		return nil, "", err
+	}
+	return bundle, version, nil
+}
+
+// fetchGit sets up a repository in a temporary directory once and then
+// shallow-fetches TEMPLATE_SYNC_REF into it, reading the templates when the
+// commit changed. It returns a nil bundle when it did not.
+func (s *Syncer) fetchGit(ctx context.Context) (*Bundle, string, error) {
+	if s.gitDir == "" {
+		dir, err := os.MkdirTemp("", "mailqueue-templates-")
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := s.git(ctx, dir, "init", "--quiet"); err != nil {
+			os.RemoveAll(dir)
+			return nil, "", err
+		}
+		if _, err := s.git(ctx, dir, "remote", "add", "origin", s.source); err != nil {
+			os.RemoveAll(dir)
+			return nil, "", err
+		}
+		s.gitDir = dir
+	}
+
+	if _, err := s.git(ctx, s.gitDir, "fetch", "--quiet", "--depth", "1", "origin", s.ref); err != nil {
+		return nil, "", err
+	}
+	commit, err := s.git(ctx, s.gitDir, "rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return nil, "", err
+	}
+	if commit == s.version {
+		return nil, commit, nil
+	}
+	if _, err := s.git(ctx, s.gitDir, "checkout", "--quiet", "--force", "FETCH_HEAD"); err != nil {
+		return nil, "", err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.gitDir, filepath.FromSlash(s.path)))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read TEMPLATE_SYNC_PATH: %w", err)
+	}
+
+	bundle := newBundle()
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasSuffix(name, ".html") && !strings.HasSuffix(name, ".sample.json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, "", err
+		}
+		if info.Size() > maxSyncedFileBytes {
+			return nil, "", fmt.Errorf("%s is larger than %d bytes", name, maxSyncedFileBytes)
+		}
+		content, err := os.ReadFile(filepath.Join(s.gitDir, filepath.FromSlash(s.path), name))
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := bundle.addFile(name, content); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := bundle.validate(); err != nil {
+		return nil, "", err
+	}
+	return bundle, commit, nil
+}
+
+// git runs a git command in dir and returns its trimmed output. Prompts are
+// disabled so a remote asking for credentials fails instead of hanging.
+func (s *Syncer) git(ctx context.Context, dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
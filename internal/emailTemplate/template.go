@@ -9,25 +9,129 @@ import (
 	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/assets"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+	"golang.org/x/text/language"
 )
 
-//go:embed html/*.html
+//go:embed html/*.html html/*.sample.json
 var templateFS embed.FS
 
+var renderSeconds = metrics.NewHistogram(
+	"mailqueue_template_render_seconds",
+	"Time to render a template, CSS inlining included, by template. Render cache hits are not observed.",
+	[]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	"template",
+)
+
 type Manager struct {
-	templates map[string]*template.Template
+	mu      sync.RWMutex
+	funcs   template.FuncMap
+	builtin template.FuncMap
+	set     *templateSet
+
+	sanitizer     *bluemonday.Policy
+	strict        bool
+	cache         *renderCache
+	defaultLocale string
+	inlineCSS     map[string]struct{}
+}
+
+// templateSet is one parse of every template source, with the sample data
+// declared for them. It is never mutated; registering functions or importing
+// templates builds a new set and swaps it in.
+type templateSet struct {
+	sources    map[string]string
+	samples    map[string]map[string]interface{}
+	templates  map[string]*template.Template
+	htmlFields map[string]map[string]struct{}
+	fields     map[string]map[string]struct{}
+
+	funcs   template.FuncMap
+	locales sync.Map // locale -> *templateSet bound to that locale's helpers
+}
+
+// RenderOption adjusts a single Render call.
+type RenderOption func(*renderOptions)
+
+type renderOptions struct {
+	locale string
+}
+
+// WithLocale renders with the date, number and currency helpers of locale
+// (e.g. "de-DE") instead of the configured default.
+func WithLocale(locale string) RenderOption {
+	return func(o *renderOptions) {
+		o.locale = locale
+	}
 }
 
-func New() (*Manager, error) {
+// UnsubscribePlaceholder is what unsubscribeURL renders; the sender replaces
+// it with the recipient's signed unsubscribe link at send time.
+const UnsubscribePlaceholder = "urn:mailqueue:unsubscribe"
+
+// PreferencesPlaceholder is what preferencesURL renders; the sender replaces
+// it with the recipient's signed preference page link.
+const PreferencesPlaceholder = "urn:mailqueue:preferences"
+
+func builtinFuncs(cfg *config.ApplicationConfig) template.FuncMap {
+	funcs := template.FuncMap{
+		"safeHTML": func(s string) template.HTML {
+			return template.HTML(s)
+		},
+		"safeURL": func(s string) template.URL {
+			return template.URL(s)
+		},
+		"escapeHTML": func(s string) string {
+			return template.HTMLEscapeString(s)
+		},
+		"qrcode":   qrcodeDataURI,
+		"assetURL": assets.New(cfg, nil).URL,
+		"unsubscribeURL": func() template.URL {
+			return template.URL(UnsubscribePlaceholder)
+		},
+		"preferencesURL": func() template.URL {
+			return template.URL(PreferencesPlaceholder)
+		},
+	}
+	for name, fn := range localeFuncs(cfg.TemplateDefaultLocale) {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+func New(cfg *config.ApplicationConfig) (*Manager, error) {
+	sanitizer, err := newSanitizer(cfg.TemplateHTMLSanitizer)
+	if err != nil {
+		return nil, err
+	}
+
 	manager := &Manager{
-		templates: make(map[string]*template.Template),
+		funcs:         builtinFuncs(cfg),
+		builtin:       builtinFuncs(cfg),
+		sanitizer:     sanitizer,
+		strict:        cfg.TemplateStrict,
+		cache:         newRenderCache(cfg.TemplateRenderCacheSize, cfg.TemplateRenderCacheTTL),
+		defaultLocale: cfg.TemplateDefaultLocale,
+		inlineCSS:     make(map[string]struct{}, len(cfg.TemplateInlineCSS)),
+	}
+	for _, name := range cfg.TemplateInlineCSS {
+		manager.inlineCSS[name] = struct{}{}
 	}
 
+	sources := make(map[string]string)
+	samples := make(map[string]map[string]interface{})
+
 	if _, err := fs.Stat(templateFS, "html"); err != nil {
 		return nil, fmt.Errorf("html template directory not found: %w", err)
 	}
 
-	err := fs.WalkDir(templateFS, "html", func(path string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(templateFS, "html", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return fmt.Errorf("error walking template directory: %w", err)
 		}
@@ -36,6 +140,19 @@ func New() (*Manager, error) {
 			return nil
 		}
 
+		if strings.HasSuffix(path, ".sample.json") {
+			content, err := templateFS.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read sample data %s: %w", path, err)
+			}
+			sample, err := parseSampleData(content)
+			if err != nil {
+				return fmt.Errorf("invalid sample data %s: %w", path, err)
+			}
+			samples[strings.TrimSuffix(filepath.Base(path), ".sample.json")] = sample
+			return nil
+		}
+
 		if !strings.HasSuffix(path, ".html") {
 			return nil
 		}
@@ -47,24 +164,7 @@ func New() (*Manager, error) {
 			return fmt.Errorf("failed to read template %s: %w", path, err)
 		}
 
-		tmpl, err := template.New(name).
-			Funcs(template.FuncMap{
-				"safeHTML": func(s string) template.HTML {
-					return template.HTML(s)
-				},
-				"safeURL": func(s string) template.URL {
-					return template.URL(s)
-				},
-				"escapeHTML": func(s string) string {
-					return template.HTMLEscapeString(s)
-				},
-			}).
-			Parse(string(content))
-		if err != nil {
-			return fmt.Errorf("failed to parse template %s: %w", path, err)
-		}
-
-		manager.templates[name] = tmpl
+		sources[name] = string(content)
 		return nil
 	})
 
@@ -72,33 +172,198 @@ func New() (*Manager, error) {
 		return nil, fmt.Errorf("template loading failed: %w", err)
 	}
 
-	if len(manager.templates) == 0 {
+	if len(sources) == 0 {
 		return nil, fmt.Errorf("no templates found in html directory")
 	}
 
+	if manager.set, err = manager.parse(sources, samples, manager.funcs); err != nil {
+		return nil, fmt.Errorf("template loading failed: %w", err)
+	}
+
+	if len(cfg.TemplatePlugins) > 0 {
+		funcs, err := loadPluginFuncs(cfg.TemplatePlugins)
+		if err != nil {
+			return nil, err
+		}
+		if err := manager.RegisterFuncs(funcs); err != nil {
+			return nil, err
+		}
+	}
+
 	return manager, nil
 }
 
-func (m *Manager) Render(name string, data map[string]interface{}) (string, error) {
-	tmpl, ok := m.templates[name]
+func (m *Manager) parse(sources map[string]string, samples map[string]map[string]interface{}, funcs template.FuncMap) (*templateSet, error) {
+	missingKey := "default"
+	if m.strict {
+		missingKey = "error"
+	}
+
+	set := &templateSet{
+		sources:    sources,
+		samples:    samples,
+		templates:  make(map[string]*template.Template, len(sources)),
+		htmlFields: make(map[string]map[string]struct{}, len(sources)),
+		fields:     make(map[string]map[string]struct{}, len(sources)),
+		funcs:      funcs,
+	}
+
+	for name, content := range sources {
+		tmpl, err := template.New(name).
+			Option("missingkey=" + missingKey).
+			Funcs(funcs).
+			Parse(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+
+		set.templates[name] = tmpl
+		set.htmlFields[name] = htmlFields(tmpl)
+		set.fields[name] = referencedFields(tmpl)
+	}
+
+	return set, nil
+}
+
+func (m *Manager) current() *templateSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.set
+}
+
+// localized returns set re-parsed with locale's formatting helpers. Each
+// locale is parsed once per set and reused until the set is replaced.
+func (m *Manager) localized(set *templateSet, locale string) (*templateSet, error) {
+	if locale == "" || strings.EqualFold(locale, m.defaultLocale) {
+		return set, nil
+	}
+
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return nil, fmt.Errorf("invalid locale %q: %w", locale, err)
+	}
+	locale = tag.String()
+
+	if cached, ok := set.locales.Load(locale); ok {
+		return cached.(*templateSet), nil
+	}
+
+	funcs := make(template.FuncMap, len(set.funcs))
+	for name, fn := range set.funcs {
+		funcs[name] = fn
+	}
+	for name, fn := range localeFuncs(locale) {
+		funcs[name] = fn
+	}
+
+	localizedSet, err := m.parse(set.sources, set.samples, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, _ := set.locales.LoadOrStore(locale, localizedSet)
+	return cached.(*templateSet), nil
+}
+
+// RegisterFuncs adds helper functions (currency formatting, branded URLs, ...)
+// available to every template, then re-parses the templates so they can use
+// them. Built-in functions cannot be replaced.
+func (m *Manager) RegisterFuncs(funcs template.FuncMap) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	merged := make(template.FuncMap, len(m.funcs)+len(funcs))
+	for name, fn := range m.funcs {
+		merged[name] = fn
+	}
+	for name, fn := range funcs {
+		if _, ok := m.builtin[name]; ok {
+			return fmt.Errorf("template function %q is built in and cannot be replaced", name)
+		}
+		merged[name] = fn
+	}
+
+	set, err := m.parse(m.set.sources, m.set.samples, merged)
+	if err != nil {
+		return err
+	}
+
+	m.funcs = merged
+	m.set = set
+	m.cache.Purge()
+	return nil
+}
+
+func (m *Manager) Render(name string, data map[string]interface{}, opts ...RenderOption) (string, error) {
+	var options renderOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	set := m.current()
+
+	tmpl, ok := set.templates[name]
 	if !ok {
-		availabletemplates := make([]string, 0, len(m.templates))
-		for t := range m.templates {
+		availabletemplates := make([]string, 0, len(set.templates))
+		for t := range set.templates {
 			availabletemplates = append(availabletemplates, t)
 		}
 		return "", fmt.Errorf("template '%s' not found. Available templates: %v",
 			name, availabletemplates)
 	}
 
+	if err := m.validateData(set, name, data); err != nil {
+		return "", err
+	}
+
+	cacheKey, cacheable := renderCacheKey(name, options.locale, data)
+	if cacheable {
+		if body, ok := m.cache.Get(cacheKey); ok {
+			return body, nil
+		}
+	}
+
+	start := time.Now()
+	set, err := m.localized(set, options.locale)
+	if err != nil {
+		return "", err
+	}
+	tmpl = set.templates[name]
+
+	data = m.sanitizeHTMLFields(set.htmlFields[name], data)
+
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
 		return "", fmt.Errorf("failed to render template '%s': %w", name, err)
 	}
 
-	return buf.String(), nil
+	body := buf.String()
+	if m.inlinesCSS(name) {
+		if body, err = InlineCSS(body); err != nil {
+			return "", fmt.Errorf("failed to inline CSS of template '%s': %w", name, err)
+		}
+	}
+
+	renderSeconds.Observe(time.Since(start).Seconds(), name)
+
+	if cacheable {
+		m.cache.Put(cacheKey, body)
+	}
+
+	return body, nil
+}
+
+// inlinesCSS reports whether TEMPLATE_INLINE_CSS lists the template, by name
+// or with InlineCSSAll.
+func (m *Manager) inlinesCSS(name string) bool {
+	if _, ok := m.inlineCSS[name]; ok {
+		return true
+	}
+	_, ok := m.inlineCSS[InlineCSSAll]
+	return ok
 }
 
-func (m *Manager) RenderWithSafeURLs(name string, data map[string]interface{}) (string, error) {
+func (m *Manager) RenderWithSafeURLs(name string, data map[string]interface{}, opts ...RenderOption) (string, error) {
 	safeData := make(map[string]interface{})
 	for key, value := range data {
 		safeData[key] = value
@@ -116,12 +381,13 @@ func (m *Manager) RenderWithSafeURLs(name string, data map[string]interface{}) (
 		}
 	}
 
-	return m.Render(name, safeData)
+	return m.Render(name, safeData, opts...)
 }
 
 func (m *Manager) ListAvailabletemplates() []string {
-	templates := make([]string, 0, len(m.templates))
-	for name := range m.templates {
+	set := m.current()
+	templates := make([]string, 0, len(set.templates))
+	for name := range set.templates {
 		templates = append(templates, name)
 	}
 	return templates
@@ -0,0 +1,180 @@
+package templates
+
+import (
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+const (
+	VariableValue  = "value"
+	VariableObject = "object"
+	VariableList   = "list"
+)
+
+// Variable describes a top-level data key a template reads. Kind is "list"
+// when the template ranges over it, "object" when it reads fields of it and
+// "value" otherwise. Fields lists the nested fields read, relative to the
+// variable for objects and to each item for lists. HTML is set when the
+// value is rendered unescaped through safeHTML, and Example is the value in
+// the template's sample data, if any.
+type Variable struct {
+	Name    string      `json:"name"`
+	Kind    string      `json:"kind"`
+	Fields  []string    `json:"fields,omitempty"`
+	HTML    bool        `json:"html,omitempty"`
+	Example interface{} `json:"example,omitempty"`
+}
+
+// Variables returns the data keys template name reads, sorted by name, and
+// false when there is no such template.
+func (m *Manager) Variables(name string) ([]Variable, bool) {
+	set := m.current()
+	tmpl, ok := set.templates[name]
+	if !ok {
+		return nil, false
+	}
+
+	collector := &variableCollector{kinds: make(map[string]string), fields: make(map[string]map[string]struct{})}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && t.Tree.Root != nil {
+			collector.walk(t.Tree.Root, "")
+		}
+	}
+
+	variables := make([]Variable, 0, len(set.fields[name]))
+	for field := range set.fields[name] {
+		variable := Variable{Name: field, Kind: VariableValue}
+		if kind, ok := collector.kinds[field]; ok {
+			variable.Kind = kind
+		}
+		for nested := range collector.fields[field] {
+			variable.Fields = append(variable.Fields, nested)
+		}
+		sort.Strings(variable.Fields)
+		_, variable.HTML = set.htmlFields[name][field]
+		if sample, ok := set.samples[name]; ok {
+			variable.Example = sample[field]
+		}
+		variables = append(variables, variable)
+	}
+	sort.Slice(variables, func(i, j int) bool {
+		return variables[i].Name < variables[j].Name
+	})
+	return variables, true
+}
+
+// variableCollector walks a template tracking what dot refers to: "" at the
+// top level, the variable's name inside {{range .items}} or {{with .user}},
+// and "-" where dot is something else, whose fields are not attributed.
+type variableCollector struct {
+	kinds  map[string]string
+	fields map[string]map[string]struct{}
+}
+
+func (c *variableCollector) walk(node parse.Node, dot string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(child, dot)
+		}
+	case *parse.ActionNode:
+		c.pipe(n.Pipe, dot)
+	case *parse.TemplateNode:
+		c.pipe(n.Pipe, dot)
+	case *parse.IfNode:
+		c.pipe(n.Pipe, dot)
+		c.walk(n.List, dot)
+		c.walk(n.ElseList, dot)
+	case *parse.RangeNode:
+		c.pipe(n.Pipe, dot)
+		inner := c.pipeVariable(n.Pipe, dot)
+		if inner != "-" {
+			c.kinds[inner] = VariableList
+		}
+		c.walk(n.List, inner)
+		c.walk(n.ElseList, dot)
+	case *parse.WithNode:
+		c.pipe(n.Pipe, dot)
+		inner := c.pipeVariable(n.Pipe, dot)
+		if inner != "-" && c.kinds[inner] != VariableList {
+			c.kinds[inner] = VariableObject
+		}
+		c.walk(n.List, inner)
+		c.walk(n.ElseList, dot)
+	}
+}
+
+func (c *variableCollector) pipe(pipe *parse.PipeNode, dot string) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			c.arg(arg, dot)
+		}
+	}
+}
+
+func (c *variableCollector) arg(node parse.Node, dot string) {
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		switch dot {
+		case "":
+			c.field(n.Ident)
+		case "-":
+		default:
+			c.nested(dot, strings.Join(n.Ident, "."))
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			c.field(n.Ident[1:])
+		}
+	case *parse.ChainNode:
+		c.arg(n.Node, dot)
+	case *parse.PipeNode:
+		c.pipe(n, dot)
+	}
+}
+
+// field records a reference to a top-level variable and the path read
+// below it.
+func (c *variableCollector) field(ident []string) {
+	if len(ident) < 2 {
+		return
+	}
+	if c.kinds[ident[0]] != VariableList {
+		c.kinds[ident[0]] = VariableObject
+	}
+	c.nested(ident[0], strings.Join(ident[1:], "."))
+}
+
+func (c *variableCollector) nested(variable, path string) {
+	if c.fields[variable] == nil {
+		c.fields[variable] = make(map[string]struct{})
+	}
+	c.fields[variable][path] = struct{}{}
+}
+
+// pipeVariable returns the top-level variable a range or with pipeline is
+// over when it is a plain field ({{range .items}}, {{range $.items}} or
+// {{range $i, $item := .items}}), or "-".
+func (c *variableCollector) pipeVariable(pipe *parse.PipeNode, dot string) string {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return "-"
+	}
+	switch n := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		if dot == "" && len(n.Ident) == 1 {
+			return n.Ident[0]
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) == 2 && n.Ident[0] == "$" {
+			return n.Ident[1]
+		}
+	}
+	return "-"
+}
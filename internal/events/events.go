@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// Lifecycle and engagement event types.
+const (
+	TypeQueued       = "queued"
+	TypeSending      = "sending"
+	TypeSent         = "sent"
+	TypeRetried      = "retried"
+	TypeFailed       = "failed"
+	TypeDeadLettered = "dead_lettered"
+	TypeCanceled     = "canceled"
+	TypeExpired      = "expired"
+	TypeOverflowed   = "overflowed"
+	TypeHeld         = "held"
+	TypeApproved     = "approved"
+	TypeOpened       = "opened"
+	TypeClicked      = "clicked"
+	TypeUnsubscribed = "unsubscribed"
+	TypeComplained   = "complained"
+	TypeBounced      = "bounced"
+)
+
+// Bounce classes. Hard bounces are permanent (unknown mailbox or domain);
+// soft bounces are temporary (mailbox full or unavailable).
+const (
+	BounceHard = "hard"
+	BounceSoft = "soft"
+)
+
+// Event describes something that happened to an email.
+type Event struct {
+	Type        string            `json:"type"`
+	JobID       string            `json:"jobId,omitempty"`
+	Recipient   string            `json:"recipient"`
+	Subject     string            `json:"subject,omitempty"`
+	Template    string            `json:"template,omitempty"`
+	Queue       string            `json:"queue,omitempty"`
+	BatchID     string            `json:"batchId,omitempty"`
+	Tenant      string            `json:"tenant,omitempty"`
+	Variant     string            `json:"variant,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	Attempt     int               `json:"attempt,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Bounce      string            `json:"bounce,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	RequestID   string            `json:"requestId,omitempty"`
+	TraceParent string            `json:"traceparent,omitempty"`
+	Timestamp   time.Time         `json:"timestamp"`
+}
+
+// Publisher delivers events to an external consumer. Publish must not block
+// on the consumer; failures are the publisher's to retry or log.
+type Publisher interface {
+	Publish(ctx context.Context, event Event)
+}
+
+// Publishers fans an event out to every publisher in the list.
+type Publishers []Publisher
+
+func (p Publishers) Publish(ctx context.Context, event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	for _, publisher := range p {
+		publisher.Publish(ctx, event)
+	}
+}
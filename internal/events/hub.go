@@ -0,0 +1,100 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// subscriberBuffer is how many events a slow subscriber may lag behind
+// before further events are dropped for it.
+const subscriberBuffer = 64
+
+// Hub fans events out to in-process subscribers such as the SSE stream.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[chan Event]struct{})}
+}
+
+// Publish hands event to every subscriber without blocking; subscribers that
+// are not keeping up miss the event.
+func (h *Hub) Publish(ctx context.Context, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events and a function that unsubscribes
+// and closes it. The channel is also closed when the hub is closed.
+func (h *Hub) Subscribe() (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close ends every subscription, letting long-lived streams finish during
+// server shutdown.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Relay feeds the hub from a Redis pub/sub channel so subscribers see events
+// from every instance, not just this one. It returns when ctx is cancelled.
+func (h *Hub) Relay(ctx context.Context, client *redis.Client, channel string, logger *slog.Logger) {
+	sub := client.Subscribe(ctx, channel)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-sub.Channel():
+			if !ok {
+				return
+			}
+
+			var event Event
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				logger.Warn("Discarding malformed event", "channel", channel, "error", err)
+				continue
+			}
+			h.Publish(ctx, event)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+)
+
+// RedisPublisher publishes events to a Redis pub/sub channel, a Redis
+// stream, or both, so other services can react without polling the API.
+// Pub/sub is fire-and-forget; the stream keeps the last EVENTS_STREAM_MAX_LEN
+// events for consumers that need to catch up.
+type RedisPublisher struct {
+	client       *redis.Client
+	logger       *slog.Logger
+	channel      string
+	stream       string
+	streamMaxLen int64
+}
+
+// NewRedisPublisher returns nil when neither EVENTS_CHANNEL nor
+// EVENTS_STREAM is set.
+func NewRedisPublisher(cfg *config.ApplicationConfig, client *redis.Client, logger *slog.Logger) *RedisPublisher {
+	if cfg.EventsChannel == "" && cfg.EventsStream == "" {
+		return nil
+	}
+
+	return &RedisPublisher{
+		client:       client,
+		logger:       logger,
+		channel:      cfg.EventsChannel,
+		stream:       cfg.EventsStream,
+		streamMaxLen: cfg.EventsStreamMaxLen,
+	}
+}
+
+func (p *RedisPublisher) Publish(ctx context.Context, event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		p.logger.Error("Failed to serialize event", "type", event.Type, "error", err)
+		return
+	}
+
+	if p.channel != "" {
+		if err := p.client.Publish(ctx, p.channel, payload).Err(); err != nil {
+			p.logger.Warn("Failed to publish event", "type", event.Type, "channel", p.channel, "error", err)
+		}
+	}
+
+	if p.stream != "" {
+		if err := p.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: p.stream,
+			MaxLen: p.streamMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{
+				"type":  event.Type,
+				"event": payload,
+			},
+		}).Err(); err != nil {
+			p.logger.Warn("Failed to append event to stream", "type", event.Type, "stream", p.stream, "error", err)
+		}
+	}
+}
+
+// TrimStream removes stream entries added before the cutoff.
+func (p *RedisPublisher) TrimStream(ctx context.Context, before time.Time) (int64, error) {
+	if p.stream == "" {
+		return 0, nil
+	}
+	return p.client.XTrimMinID(ctx, p.stream, strconv.FormatInt(before.UnixMilli(), 10)+"-0").Result()
+}
@@ -0,0 +1,73 @@
+package feedback
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// ParseARF reads an Abuse Reporting Format (RFC 5965) feedback email, the
+// format mailbox providers use for feedback loops. The recipient comes from
+// the report's Original-Rcpt-To, or the To header of the returned original
+// message when the provider includes it; many providers redact both and
+// only the job ID from the original Message-ID is left.
+func ParseARF(r io.Reader) (*Report, error) {
+	message, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feedback email: %w", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["report-type"] != "feedback-report" {
+		return nil, fmt.Errorf("not an ARF feedback report")
+	}
+
+	report := &Report{Kind: KindComplaint, Source: SourceARF}
+
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid feedback email: %w", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/feedback-report":
+			fields, err := textproto.NewReader(bufio.NewReader(part)).ReadMIMEHeader()
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("invalid feedback report: %w", err)
+			}
+			report.Detail = fields.Get("Feedback-Type")
+			if recipient := fields.Get("Original-Rcpt-To"); recipient != "" {
+				report.Recipient = strings.TrimPrefix(recipient, "rfc822;")
+			}
+
+		case "message/rfc822", "text/rfc822-headers":
+			original, err := mail.ReadMessage(part)
+			if err != nil {
+				continue
+			}
+			report.JobID = JobIDFromMessageID(original.Header.Get("Message-ID"))
+			if report.Recipient == "" {
+				if to, err := mail.ParseAddress(original.Header.Get("To")); err == nil {
+					report.Recipient = to.Address
+				}
+			}
+		}
+	}
+
+	report.Recipient = strings.TrimSpace(report.Recipient)
+	if report.Recipient == "" && report.JobID == "" {
+		return nil, ErrNoRecipient
+	}
+	return report, nil
+}
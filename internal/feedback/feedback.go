@@ -0,0 +1,234 @@
+package feedback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+)
+
+// Report kinds.
+const (
+	KindComplaint = "complaint"
+	KindBounce    = "bounce"
+)
+
+// Report sources.
+const (
+	SourceSES     = "ses"
+	SourceMailgun = "mailgun"
+	SourceARF     = "arf"
+)
+
+// MaxSignatureAge is how old a Mailgun webhook's timestamp may be. Tokens
+// must be remembered for at least as long to catch replays.
+const MaxSignatureAge = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrStaleSignature   = errors.New("webhook signature timestamp is too old")
+	ErrNoRecipient      = errors.New("report has no recipient or job ID")
+)
+
+// Report is a complaint or bounce received from a provider webhook or an ARF
+// feedback email. JobID is recovered from the Message-ID the queue sent the
+// email with and may be empty when the provider strips it. Bounce is
+// events.BounceHard or events.BounceSoft for bounce reports.
+type Report struct {
+	Kind      string
+	Source    string
+	Recipient string
+	JobID     string
+	Bounce    string
+	Detail    string
+}
+
+// JobIDFromMessageID extracts the job ID from a Message-ID of the form
+// <jobID@domain>. IDs the queue did not generate return "".
+func JobIDFromMessageID(messageID string) string {
+	id := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(messageID), "<"), ">")
+	local, _, found := strings.Cut(id, "@")
+	if !found || len(local) != 32 {
+		return ""
+	}
+	if _, err := hex.DecodeString(local); err != nil {
+		return ""
+	}
+	return local
+}
+
+// snsEnvelope is how SES notifications arrive through an SNS HTTPS
+// subscription.
+type snsEnvelope struct {
+	Type         string `json:"Type"`
+	Message      string `json:"Message"`
+	SubscribeURL string `json:"SubscribeURL"`
+}
+
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	Bounce           struct {
+		BounceType        string `json:"bounceType"`
+		BounceSubType     string `json:"bounceSubType"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint struct {
+		ComplainedRecipients []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+	} `json:"complaint"`
+	Mail struct {
+		Headers []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"headers"`
+	} `json:"mail"`
+}
+
+// ParseSES reads an SNS-delivered SES notification. A subscription
+// confirmation returns its SubscribeURL and no reports; notification types
+// other than complaints and bounces are ignored, as are bounces SES could
+// not classify.
+func ParseSES(body []byte) ([]Report, string, error) {
+	var envelope snsEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, "", fmt.Errorf("invalid SNS message: %w", err)
+	}
+
+	switch envelope.Type {
+	case "SubscriptionConfirmation":
+		return nil, envelope.SubscribeURL, nil
+	case "Notification":
+	default:
+		return nil, "", nil
+	}
+
+	var notification sesNotification
+	if err := json.Unmarshal([]byte(envelope.Message), &notification); err != nil {
+		return nil, "", fmt.Errorf("invalid SES notification: %w", err)
+	}
+	var jobID string
+	for _, header := range notification.Mail.Headers {
+		if strings.EqualFold(header.Name, "Message-ID") {
+			jobID = JobIDFromMessageID(header.Value)
+		}
+	}
+
+	var reports []Report
+	switch notification.NotificationType {
+	case "Complaint":
+		for _, recipient := range notification.Complaint.ComplainedRecipients {
+			reports = append(reports, Report{
+				Kind:      KindComplaint,
+				Source:    SourceSES,
+				Recipient: recipient.EmailAddress,
+				JobID:     jobID,
+				Detail:    notification.Complaint.ComplaintFeedbackType,
+			})
+		}
+
+	case "Bounce":
+		var bounce string
+		switch notification.Bounce.BounceType {
+		case "Permanent":
+			bounce = events.BounceHard
+		case "Transient":
+			bounce = events.BounceSoft
+		default:
+			return nil, "", nil
+		}
+		for _, recipient := range notification.Bounce.BouncedRecipients {
+			reports = append(reports, Report{
+				Kind:      KindBounce,
+				Source:    SourceSES,
+				Recipient: recipient.EmailAddress,
+				JobID:     jobID,
+				Bounce:    bounce,
+				Detail:    recipient.DiagnosticCode,
+			})
+		}
+	}
+	return reports, "", nil
+}
+
+type mailgunWebhook struct {
+	Signature struct {
+		Timestamp string `json:"timestamp"`
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+	EventData struct {
+		Event          string `json:"event"`
+		Severity       string `json:"severity"`
+		Recipient      string `json:"recipient"`
+		DeliveryStatus struct {
+			Description string `json:"description"`
+		} `json:"delivery-status"`
+		Message struct {
+			Headers struct {
+				MessageID string `json:"message-id"`
+			} `json:"headers"`
+		} `json:"message"`
+	} `json:"event-data"`
+}
+
+// ParseMailgun verifies a Mailgun webhook with the account's webhook signing
+// key and reads it, returning its signature token so the caller can reject
+// replays. Webhooks signed more than MaxSignatureAge ago fail with
+// ErrStaleSignature. Events other than complaints and failed deliveries are
+// ignored.
+func ParseMailgun(body []byte, signingKey string) ([]Report, string, error) {
+	var webhook mailgunWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, "", fmt.Errorf("invalid Mailgun webhook: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(webhook.Signature.Timestamp + webhook.Signature.Token))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(webhook.Signature.Signature)) {
+		return nil, "", ErrInvalidSignature
+	}
+
+	timestamp, err := strconv.ParseInt(webhook.Signature.Timestamp, 10, 64)
+	if err != nil {
+		return nil, "", ErrInvalidSignature
+	}
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > MaxSignatureAge || age < -MaxSignatureAge {
+		return nil, "", ErrStaleSignature
+	}
+	token := webhook.Signature.Token
+
+	report := Report{
+		Source:    SourceMailgun,
+		Recipient: webhook.EventData.Recipient,
+		JobID:     JobIDFromMessageID(webhook.EventData.Message.Headers.MessageID),
+	}
+
+	switch webhook.EventData.Event {
+	case "complained":
+		report.Kind = KindComplaint
+	case "failed":
+		report.Kind = KindBounce
+		report.Bounce = events.BounceSoft
+		if webhook.EventData.Severity == "permanent" {
+			report.Bounce = events.BounceHard
+		}
+		report.Detail = webhook.EventData.DeliveryStatus.Description
+	default:
+		return nil, token, nil
+	}
+	return []Report{report}, token, nil
+}
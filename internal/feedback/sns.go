@@ -0,0 +1,167 @@
+package feedback
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCertificateBytes bounds a downloaded SNS signing certificate.
+const maxCertificateBytes = 64 << 10
+
+// snsCertHost matches the hosts SNS serves its signing certificates from.
+var snsCertHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// snsSignedMessage holds the fields of an SNS message that its signature
+// covers. Subject is a pointer because an absent subject is left out of the
+// signed string while an empty one is not.
+type snsSignedMessage struct {
+	Type             string  `json:"Type"`
+	MessageID        string  `json:"MessageId"`
+	Token            string  `json:"Token"`
+	TopicArn         string  `json:"TopicArn"`
+	Subject          *string `json:"Subject"`
+	Message          string  `json:"Message"`
+	SubscribeURL     string  `json:"SubscribeURL"`
+	Timestamp        string  `json:"Timestamp"`
+	SignatureVersion string  `json:"SignatureVersion"`
+	Signature        string  `json:"Signature"`
+	SigningCertURL   string  `json:"SigningCertURL"`
+}
+
+// SNSVerifier checks the signatures of SNS messages against the signing
+// certificate each names, which must be served by SNS over HTTPS.
+// Certificates are cached by URL.
+type SNSVerifier struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// NewSNSVerifier returns an SNSVerifier with an empty certificate cache.
+func NewSNSVerifier() *SNSVerifier {
+	return &SNSVerifier{
+		client: &http.Client{Timeout: 10 * time.Second},
+		certs:  make(map[string]*x509.Certificate),
+	}
+}
+
+// Verify checks the signature of the SNS message in body. It returns an
+// error wrapping ErrInvalidSignature when the message is unsigned, signed by
+// a certificate not served by SNS, or does not match its signature.
+func (v *SNSVerifier) Verify(ctx context.Context, body []byte) error {
+	var message snsSignedMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		return fmt.Errorf("invalid SNS message: %w", err)
+	}
+
+	algorithm := x509.SHA1WithRSA
+	switch message.SignatureVersion {
+	case "1":
+	case "2":
+		algorithm = x509.SHA256WithRSA
+	default:
+		return fmt.Errorf("%w: unsupported SNS signature version %q", ErrInvalidSignature, message.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(message.Signature)
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("%w: malformed SNS signature", ErrInvalidSignature)
+	}
+
+	cert, err := v.certificate(ctx, message.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignature(algorithm, []byte(message.stringToSign()), signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+// stringToSign builds the string SNS signs: the covered fields for the
+// message type, in alphabetical order, each as its name and value on their
+// own lines.
+func (m snsSignedMessage) stringToSign() string {
+	var b strings.Builder
+	field := func(name, value string) {
+		b.WriteString(name + "\n" + value + "\n")
+	}
+
+	field("Message", m.Message)
+	field("MessageId", m.MessageID)
+	if m.Type == "Notification" {
+		if m.Subject != nil {
+			field("Subject", *m.Subject)
+		}
+	} else {
+		field("SubscribeURL", m.SubscribeURL)
+	}
+	field("Timestamp", m.Timestamp)
+	if m.Type != "Notification" {
+		field("Token", m.Token)
+	}
+	field("TopicArn", m.TopicArn)
+	field("Type", m.Type)
+	return b.String()
+}
+
+// certificate returns the certificate at rawURL, downloading it the first
+// time it is asked for.
+func (v *SNSVerifier) certificate(ctx context.Context, rawURL string) (*x509.Certificate, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" || !snsCertHost.MatchString(parsed.Hostname()) ||
+		parsed.Port() != "" || !strings.HasSuffix(parsed.Path, ".pem") {
+		return nil, fmt.Errorf("%w: signing certificate is not served by SNS", ErrInvalidSignature)
+	}
+
+	v.mu.Lock()
+	cert, ok := v.certs[rawURL]
+	v.mu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download SNS signing certificate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download SNS signing certificate: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCertificateBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download SNS signing certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("SNS signing certificate is not PEM encoded")
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SNS signing certificate: %w", err)
+	}
+
+	v.mu.Lock()
+	v.certs[rawURL] = cert
+	v.mu.Unlock()
+	return cert, nil
+}
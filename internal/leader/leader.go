@@ -0,0 +1,121 @@
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+var isLeader = metrics.NewGauge(
+	"mailqueue_leader",
+	"1 while this instance holds the scheduler leader lock.",
+)
+
+// renewScript extends the lock only while it is still held by this
+// instance, so a leader that stalled past the TTL cannot steal it back.
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Elector holds a Redis lock that elects one instance to run the loops that
+// must not run concurrently: delayed task promotion, webhook retry promotion,
+// orphan reclaim and retention cleanup. Every instance keeps consuming the queues.
+type Elector struct {
+	client  *redis.Client
+	key     string
+	id      string
+	ttl     time.Duration
+	logger  *slog.Logger
+	leading atomic.Bool
+}
+
+func New(client *redis.Client, key, id string, ttl time.Duration, logger *slog.Logger) *Elector {
+	return &Elector{
+		client: client,
+		key:    key,
+		id:     id,
+		ttl:    ttl,
+		logger: logger,
+	}
+}
+
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Run campaigns for the lock until ctx is cancelled. While this instance
+// leads, lead runs with a context that is cancelled as soon as leadership
+// is lost; the lock is released on shutdown so another instance can take
+// over without waiting for it to expire.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	var stop context.CancelFunc
+	var done chan struct{}
+
+	stepDown := func() {
+		if stop == nil {
+			return
+		}
+		stop()
+		<-done
+		stop, done = nil, nil
+		e.leading.Store(false)
+		isLeader.Set(0)
+	}
+
+	for {
+		if stop == nil {
+			acquired, err := e.client.SetNX(ctx, e.key, e.id, e.ttl).Result()
+			if err != nil && ctx.Err() == nil {
+				e.logger.Error("Leader election error", "error", err)
+			}
+			if acquired {
+				e.logger.Info("Acquired scheduler leadership", "instance", e.id)
+				e.leading.Store(true)
+				isLeader.Set(1)
+
+				var leadCtx context.Context
+				leadCtx, stop = context.WithCancel(ctx)
+				done = make(chan struct{})
+				go func() {
+					defer close(done)
+					lead(leadCtx)
+				}()
+			}
+		} else {
+			renewed, err := renewScript.Run(ctx, e.client, []string{e.key}, e.id, e.ttl.Milliseconds()).Int()
+			if (err != nil || renewed == 0) && ctx.Err() == nil {
+				e.logger.Warn("Lost scheduler leadership", "instance", e.id, "error", err)
+				stepDown()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if stop != nil {
+				stepDown()
+				releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				releaseScript.Run(releaseCtx, e.client, []string{e.key}, e.id)
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
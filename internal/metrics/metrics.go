@@ -0,0 +1,287 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A small Prometheus-compatible metrics registry. Metrics register themselves
+// on creation and are exposed in the text exposition format by Handler.
+
+type collector interface {
+	name() string
+	write(b *strings.Builder)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, existing := range registry {
+		if existing.name() == c.name() {
+			panic(fmt.Sprintf("metrics: duplicate metric %q", c.name()))
+		}
+	}
+	registry = append(registry, c)
+}
+
+// vec stores one float value per label combination.
+type vec struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+	return &vec{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		values:     make(map[string]float64),
+		keys:       make(map[string][]string),
+	}
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	key := v.key(labelValues)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] += delta
+}
+
+func (v *vec) set(value float64, labelValues []string) {
+	key := v.key(labelValues)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] = value
+}
+
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metricName, len(v.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	if _, ok := v.keys[key]; !ok {
+		v.keys[key] = append([]string(nil), labelValues...)
+	}
+	v.mu.Unlock()
+
+	return key
+}
+
+func (v *vec) write(b *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", v.metricName, v.help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", v.metricName, v.kind)
+
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s%s %s\n", v.metricName, formatLabels(v.labels, v.keys[key]), formatValue(v.values[key]))
+	}
+}
+
+type Counter struct {
+	*vec
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newVec(name, help, "counter", labels)}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.add(delta, labelValues)
+}
+
+type Gauge struct {
+	*vec
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newVec(name, help, "gauge", labels)}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
+
+// DefaultBuckets are histogram bucket bounds in seconds suited to request
+// latencies, from 5ms to 10s.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations, such as latencies in seconds, in
+// cumulative buckets per label combination, with their sum and count.
+type Histogram struct {
+	metricName string
+	help       string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+	keys   map[string][]string
+}
+
+type histogramSeries struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds,
+// DefaultBuckets when nil; a +Inf bucket is always added.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		buckets:    buckets,
+		series:     make(map[string]*histogramSeries),
+		keys:       make(map[string][]string),
+	}
+	register(h)
+	return h
+}
+
+func (h *Histogram) name() string {
+	return h.metricName
+}
+
+// Observe records value in the series of labelValues.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", h.metricName, len(h.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	bucket := sort.SearchFloat64s(h.buckets, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = series
+		h.keys[key] = append([]string(nil), labelValues...)
+	}
+	series.counts[bucket]++
+	series.sum += value
+	series.count++
+}
+
+func (h *Histogram) write(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", h.metricName, h.help)
+	fmt.Fprintf(b, "# TYPE %s histogram\n", h.metricName)
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range keys {
+		series, values := h.series[key], h.keys[key]
+		var cumulative uint64
+		for i, count := range series.counts {
+			cumulative += count
+			bound := "+Inf"
+			if i < len(h.buckets) {
+				bound = formatValue(h.buckets[i])
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.metricName, formatLabels(bucketLabels, append(append([]string(nil), values...), bound)), cumulative)
+		}
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.metricName, formatLabels(h.labels, values), formatValue(series.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.metricName, formatLabels(h.labels, values), series.count)
+	}
+}
+
+// Handler serves every registered metric in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+
+		registryMu.Lock()
+		collectors := append([]collector(nil), registry...)
+		registryMu.Unlock()
+
+		sort.Slice(collectors, func(i, j int) bool {
+			return collectors[i].name() < collectors[j].name()
+		})
+		for _, c := range collectors {
+			c.write(&b)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(b.String()))
+	})
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%s", name, strconv.Quote(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,186 @@
+// Package netguard keeps requests to caller-supplied URLs, such as
+// attachment downloads and link checks, away from the host itself, the
+// internal network and cloud metadata endpoints.
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxRedirects matches the limit of the standard library's default client.
+const maxRedirects = 10
+
+var (
+	// ErrBlocked is returned for hosts that resolve to an address that is
+	// not publicly routable.
+	ErrBlocked = errors.New("host resolves to a non-public address")
+
+	// ErrHostNotAllowed is returned for hosts outside the allowlist.
+	ErrHostNotAllowed = errors.New("host is not in the allowed hosts")
+)
+
+// blockedNets are the ranges, besides those net.IP classifies as loopback,
+// private, link-local or multicast, that a guarded request must not reach.
+var blockedNets = mustParseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"100.64.0.0/10",  // carrier-grade NAT, also some cloud metadata services
+	"192.0.0.0/24",   // IETF protocol assignments
+	"198.18.0.0/15",  // benchmarking
+	"240.0.0.0/4",    // reserved, and broadcast
+	"64:ff9b::/96",   // NAT64, which can map to any IPv4 address
+	"64:ff9b:1::/48", // local-use NAT64
+	"2002::/16",      // 6to4, which embeds an IPv4 address
+	"2001::/32",      // Teredo
+	"fec0::/10",      // deprecated site-local
+	"100::/64",       // discard-only
+	"2001:db8::/32",  // documentation
+)
+
+// Guard checks the hosts of outgoing requests. A request is refused when
+// its host resolves to a blocked address, or, with an allowlist, when the
+// host is not on it. The zero Guard allows any public host.
+type Guard struct {
+	allowedHosts []string
+}
+
+// New returns a Guard limited to allowedHosts, which lists host names and
+// .domain suffixes matching any subdomain. An empty list allows any host
+// with a public address.
+func New(allowedHosts []string) *Guard {
+	hosts := make([]string, 0, len(allowedHosts))
+	for _, host := range allowedHosts {
+		hosts = append(hosts, strings.ToLower(strings.TrimPrefix(host, "*")))
+	}
+	return &Guard{allowedHosts: hosts}
+}
+
+// Blocked reports whether ip is loopback, private, link-local (which
+// includes the 169.254.169.254 metadata endpoint), multicast, unspecified
+// or otherwise not publicly routable.
+func Blocked(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range blockedNets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether host (without a port) is on the allowlist, or
+// whether there is none.
+func (g *Guard) Allowed(host string) bool {
+	if len(g.allowedHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, entry := range g.allowedHosts {
+		if host == entry || strings.HasPrefix(entry, ".") && strings.HasSuffix(host, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckHost checks host (without a port) against the allowlist and
+// resolves it, failing when any of its addresses is blocked. Clients from
+// Client check the address they connect to again, since DNS may answer
+// differently by then.
+func (g *Guard) CheckHost(ctx context.Context, host string) error {
+	if !g.Allowed(host) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		if Blocked(ip) {
+			return fmt.Errorf("%w: %s", ErrBlocked, host)
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if Blocked(addr.IP) {
+			return fmt.Errorf("%w: %s (%s)", ErrBlocked, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// Client returns an HTTP client that checks every request's host against
+// the allowlist, refuses to connect to blocked addresses whatever DNS
+// answered earlier, ignores proxy settings and only follows redirects
+// within the same host and scheme.
+func (g *Guard) Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		// Control sees the address actually being connected to, after
+		// resolution, so a host re-resolving to an internal address
+		// between CheckHost and the request is still refused.
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || Blocked(ip) {
+				return fmt.Errorf("%w: %s", ErrBlocked, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if !g.Allowed(host) {
+			return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			first := via[0].URL
+			if !strings.EqualFold(req.URL.Host, first.Host) || req.URL.Scheme != first.Scheme {
+				return fmt.Errorf("refusing redirect from %s to another host: %s", first.Host, req.URL.Redacted())
+			}
+			return nil
+		},
+	}
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/awsauth"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/netguard"
+)
+
+var (
+	ErrTooLarge = errors.New("object exceeds the size limit")
+	ErrNotFound = errors.New("object not found")
+)
+
+// Store fetches objects referenced by https:// or s3:// URLs. S3 requests are
+// signed with the standard AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY
+// credentials, and any S3-compatible service (minio, GCS interoperability)
+// works through OBJECT_STORE_ENDPOINT. https:// URLs come from API callers,
+// so they are fetched through a guard that keeps them off internal
+// addresses and, with ATTACHMENT_ALLOWED_HOSTS, off other hosts.
+type Store struct {
+	httpClient *http.Client
+	guard      *netguard.Guard
+	webClient  *http.Client // guarded, for https:// URLs
+	creds      awsauth.Credentials
+	region     string
+	endpoint   *url.URL
+	pathStyle  bool
+	bucket     string
+}
+
+func New(cfg *config.ApplicationConfig) (*Store, error) {
+	endpoint := cfg.ObjectStoreEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.ObjectStoreRegion)
+	}
+
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return nil, fmt.Errorf("invalid object store endpoint %q", endpoint)
+	}
+
+	guard := netguard.New(cfg.AttachmentAllowedHosts)
+	return &Store{
+		httpClient: &http.Client{Timeout: cfg.ObjectStoreTimeout},
+		guard:      guard,
+		webClient:  guard.Client(cfg.ObjectStoreTimeout),
+		creds:      awsauth.EnvCredentials(),
+		region:     cfg.ObjectStoreRegion,
+		endpoint:   endpointURL,
+		pathStyle:  cfg.ObjectStorePathStyle,
+		bucket:     cfg.ObjectStoreBucket,
+	}, nil
+}
+
+// ValidateURL checks that rawURL is a reference the store can fetch.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid object URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "https":
+		if u.Host == "" {
+			return fmt.Errorf("object URL has no host")
+		}
+	case "s3":
+		if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			return fmt.Errorf("s3 URL must look like s3://bucket/key")
+		}
+	default:
+		return fmt.Errorf("unsupported object URL scheme %q (use https or s3)", u.Scheme)
+	}
+
+	return nil
+}
+
+// CheckURL is ValidateURL plus, for https:// URLs, the guard's check of the
+// host: it must be in ATTACHMENT_ALLOWED_HOSTS, when set, and resolve only
+// to public addresses.
+func (s *Store) CheckURL(ctx context.Context, rawURL string) error {
+	if err := ValidateURL(rawURL); err != nil {
+		return err
+	}
+	u, _ := url.Parse(rawURL)
+	if u.Scheme != "https" {
+		return nil
+	}
+	return s.guard.CheckHost(ctx, u.Hostname())
+}
+
+// Fetch downloads the object at rawURL, failing with ErrTooLarge once more
+// than maxBytes have been read. It returns the content and its content type.
+// https:// URLs are checked with CheckURL, again on connecting, and may only
+// redirect within their host.
+func (s *Store) Fetch(ctx context.Context, rawURL string, maxBytes int64) ([]byte, string, error) {
+	if err := s.CheckURL(ctx, rawURL); err != nil {
+		return nil, "", err
+	}
+
+	u, _ := url.Parse(rawURL)
+
+	client := s.webClient
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build request: %w", err)
+	}
+
+	if u.Scheme == "s3" {
+		client = s.httpClient
+		req, err = s.newS3Request(ctx, http.MethodGet, u.Host, strings.TrimPrefix(u.Path, "/"), nil, nil, "")
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch %s: unexpected status %d", rawURL, resp.StatusCode)
+	}
+
+	if maxBytes > 0 && resp.ContentLength > maxBytes {
+		return nil, "", ErrTooLarge
+	}
+
+	data, err := readLimited(resp.Body, maxBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// ObjectInfo describes one listed object.
+type ObjectInfo struct {
+	Key  string
+	ETag string
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		ETag string `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the objects directly under an s3://bucket/prefix/ reference,
+// leaving out those in deeper "directories".
+func (s *Store) List(ctx context.Context, rawURL string) ([]ObjectInfo, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("s3 prefix must look like s3://bucket/prefix")
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+
+	var objects []ObjectInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	for {
+		req, err := s.newS3Request(ctx, http.MethodGet, u.Host, "", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", rawURL, err)
+		}
+		var result listBucketResult
+		if resp.StatusCode == http.StatusOK {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		} else {
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", rawURL, err)
+		}
+
+		for _, object := range result.Contents {
+			objects = append(objects, ObjectInfo{Key: object.Key, ETag: strings.Trim(object.ETag, `"`)})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// CanStore reports whether a bucket is configured for Put.
+func (s *Store) CanStore() bool {
+	return s != nil && s.bucket != ""
+}
+
+// Put uploads data under key in the configured bucket and returns its s3://
+// reference.
+func (s *Store) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if !s.CanStore() {
+		return "", fmt.Errorf("object store bucket is not configured")
+	}
+
+	req, err := s.newS3Request(ctx, http.MethodPut, s.bucket, key, nil, data, contentType)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to store object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to store object %s: unexpected status %d", key, resp.StatusCode)
+	}
+
+	return fmt.Sprintf("s3://%s/%s", s.bucket, key), nil
+}
+
+// Delete removes the object behind an s3:// reference.
+func (s *Store) Delete(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "s3" {
+		return fmt.Errorf("not an s3 reference: %s", rawURL)
+	}
+
+	req, err := s.newS3Request(ctx, http.MethodDelete, u.Host, strings.TrimPrefix(u.Path, "/"), nil, nil, "")
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to delete %s: unexpected status %d", rawURL, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// newS3Request builds a signed request for bucket/key against the configured
+// endpoint, using virtual-hosted or path-style addressing.
+func (s *Store) newS3Request(ctx context.Context, method, bucket, key string, query url.Values, body []byte, contentType string) (*http.Request, error) {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + bucket + "/" + key
+		u.RawPath = "/" + awsauth.Escape(bucket) + "/" + escapeKey(key)
+	} else {
+		u.Host = bucket + "." + s.endpoint.Host
+		u.Path = "/" + key
+		u.RawPath = "/" + escapeKey(key)
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	var reader io.Reader
+	payloadHash := awsauth.UnsignedPayload
+	if body != nil {
+		reader = bytes.NewReader(body)
+		payloadHash = awsauth.HashHex(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build S3 request: %w", err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if s.creds.AccessKeyID != "" {
+		awsauth.SignRequest(req, s.creds, s.region, "s3", payloadHash, time.Now())
+	}
+
+	return req, nil
+}
+
+// escapeKey encodes an object key for use in a URL path, keeping slashes.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = awsauth.Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func readLimited(r io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		return io.ReadAll(r)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,86 @@
+// Package pacer spaces sends evenly at a rate shared by every instance
+// using the same Redis key, so a cap holds for a whole deployment rather
+// than per process.
+package pacer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// reserveScript takes the next free slot of KEYS[1], which holds the
+// earliest time in microseconds the next slot may start, no earlier than
+// now (ARGV[1]), and moves it on by the interval (ARGV[2]). It returns the
+// slot. The key expires once it is in the past, so an idle pacer leaves
+// nothing behind.
+var reserveScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local slot = tonumber(redis.call('GET', KEYS[1]) or '0')
+if slot < now then
+	slot = now
+end
+local nextSlot = slot + tonumber(ARGV[2])
+redis.call('SET', KEYS[1], string.format('%.0f', nextSlot), 'PX', math.ceil((nextSlot - now) / 1000) + 1000)
+return string.format('%.0f', slot)
+`)
+
+// Pacer hands out slots a fixed interval apart. The zero rate lets every
+// send through without touching Redis.
+type Pacer struct {
+	client *redis.Client
+	key    string
+
+	mu       sync.Mutex
+	interval time.Duration
+}
+
+// New returns a pacer for perSecond sends over key; 0 disables it.
+func New(client *redis.Client, key string, perSecond float64) *Pacer {
+	p := &Pacer{client: client, key: key}
+	p.SetRate(perSecond)
+	return p
+}
+
+// SetRate changes the rate; 0 disables pacing. Slots already taken are
+// kept.
+func (p *Pacer) SetRate(perSecond float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interval = 0
+	if perSecond > 0 {
+		p.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+}
+
+// Wait takes the next slot and sleeps until it starts. A slot given up
+// when ctx is done is not handed out again.
+func (p *Pacer) Wait(ctx context.Context) error {
+	p.mu.Lock()
+	interval := p.interval
+	p.mu.Unlock()
+	if interval <= 0 {
+		return ctx.Err()
+	}
+
+	slot, err := reserveScript.Run(ctx, p.client, []string{p.key},
+		time.Now().UnixMicro(), interval.Microseconds()).Int64()
+	if err != nil {
+		return err
+	}
+
+	delay := time.Until(time.UnixMicro(slot))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
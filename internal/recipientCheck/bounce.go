@@ -0,0 +1,117 @@
+package recipient
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+// bounceKeyPrefix keys a sorted set per bounce class and address
+// (recipient_bounces:<class>:<address>) of the jobs that bounced, scored by
+// the time of the bounce.
+const bounceKeyPrefix = "recipient_bounces:"
+
+const (
+	SuppressionHardBounce = "hard_bounce"
+	SuppressionSoftBounce = "soft_bounce"
+)
+
+var bouncesRecorded = metrics.NewCounter(
+	"mailqueue_bounces_total",
+	"Recipient bounces by class.",
+	"class",
+)
+
+// BounceTracker suppresses recipients that keep bouncing. It is fed as an
+// events.Publisher: every event classified as a bounce counts once per job,
+// and a recipient is suppressed when the bounces of one class within the
+// window reach that class's threshold.
+type BounceTracker struct {
+	validator *Validator
+	logger    *slog.Logger
+
+	hardThreshold int
+	softThreshold int
+	window        time.Duration
+}
+
+func NewBounceTracker(cfg *config.ApplicationConfig, validator *Validator, logger *slog.Logger) *BounceTracker {
+	return &BounceTracker{
+		validator:     validator,
+		logger:        logger,
+		hardThreshold: cfg.BounceHardThreshold,
+		softThreshold: cfg.BounceSoftThreshold,
+		window:        cfg.BounceWindow,
+	}
+}
+
+func (t *BounceTracker) Publish(ctx context.Context, event events.Event) {
+	if event.Bounce == "" || event.Recipient == "" {
+		return
+	}
+
+	threshold, reason := t.softThreshold, SuppressionSoftBounce
+	if event.Bounce == events.BounceHard {
+		threshold, reason = t.hardThreshold, SuppressionHardBounce
+	}
+	if threshold <= 0 {
+		return
+	}
+
+	count, added, err := t.record(ctx, event)
+	if err != nil {
+		t.logger.Warn("Failed to record bounce", "to", event.Recipient, "bounce", event.Bounce, "error", err)
+		return
+	}
+	if !added || count < int64(threshold) {
+		return
+	}
+
+	if err := t.validator.Suppress(ctx, event.Recipient, reason); err != nil {
+		t.logger.Warn("Failed to suppress bouncing recipient", "to", event.Recipient, "error", err)
+		return
+	}
+	t.logger.Info("Suppressed bouncing recipient", "to", event.Recipient, "reason", reason, "bounces", count)
+}
+
+// record adds the bounce and returns how many jobs to the recipient bounced
+// with the same class within the window, and whether this job is new to it.
+func (t *BounceTracker) record(ctx context.Context, event events.Event) (int64, bool, error) {
+	at := event.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	// Repeated attempts of one job count as a single bounce.
+	member := event.JobID
+	if member == "" {
+		member = strconv.FormatInt(at.UnixNano(), 10)
+	}
+
+	key := bounceKeyPrefix + event.Bounce + ":" + strings.ToLower(event.Recipient)
+
+	pipe := t.validator.client.TxPipeline()
+	added := pipe.ZAdd(ctx, key, &redis.Z{Score: float64(at.UnixMilli()), Member: member})
+	if t.window > 0 {
+		cutoff := strconv.FormatInt(at.Add(-t.window).UnixMilli(), 10)
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+cutoff)
+		pipe.Expire(ctx, key, t.window)
+	}
+	count := pipe.ZCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, false, err
+	}
+
+	if added.Val() == 0 {
+		return count.Val(), false, nil
+	}
+	bouncesRecorded.Inc(event.Bounce)
+	return count.Val(), true, nil
+}
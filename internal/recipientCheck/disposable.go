@@ -0,0 +1,77 @@
+package recipient
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	DisposableModeOff    = "off"
+	DisposableModeReject = "reject"
+	DisposableModeFlag   = "flag"
+
+	// FlagDisposable marks tasks addressed to a disposable email provider.
+	FlagDisposable = "disposable"
+)
+
+var ErrDisposableDomain = errors.New("disposable email addresses are not allowed")
+
+//go:embed disposable_domains.txt
+var defaultDisposableDomains string
+
+// loadDisposableDomains returns the shipped list, or the contents of path
+// when an override file is configured.
+func loadDisposableDomains(path string) (map[string]struct{}, error) {
+	if path == "" {
+		return parseDomainList(strings.NewReader(defaultDisposableDomains))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open disposable domains file: %w", err)
+	}
+	defer file.Close()
+
+	return parseDomainList(file)
+}
+
+func parseDomainList(r io.Reader) (map[string]struct{}, error) {
+	domains := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[line] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read domain list: %w", err)
+	}
+
+	return domains, nil
+}
+
+// isDisposable matches the domain and each of its parent domains, so
+// "inbox.mailinator.com" is caught by a "mailinator.com" entry.
+func (v *Validator) isDisposable(domain string) bool {
+	for d := domain; d != ""; {
+		if _, ok := v.disposableDomains[d]; ok {
+			return true
+		}
+
+		dot := strings.Index(d, ".")
+		if dot < 0 {
+			break
+		}
+		d = d[dot+1:]
+	}
+	return false
+}
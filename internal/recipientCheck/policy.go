@@ -0,0 +1,102 @@
+package recipient
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+)
+
+var (
+	ErrRecipientDenied     = errors.New("recipient address is denied by policy")
+	ErrRecipientNotAllowed = errors.New("recipient address is not in the allowlist")
+)
+
+// pattern matches a recipient address. Supported forms are an exact address
+// ("ops@example.com"), a whole domain ("*@example.com" or "@example.com") and
+// a regular expression wrapped in slashes ("/^qa\+.*@example\.com$/").
+type pattern struct {
+	exact  string
+	domain string
+	regex  *regexp.Regexp
+}
+
+func parsePatterns(raw []string) ([]pattern, error) {
+	var patterns []pattern
+	for _, p := range raw {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "":
+			continue
+		case len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/"):
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient pattern %q: %w", p, err)
+			}
+			patterns = append(patterns, pattern{regex: re})
+		case strings.HasPrefix(p, "*@"):
+			patterns = append(patterns, pattern{domain: strings.ToLower(p[2:])})
+		case strings.HasPrefix(p, "@"):
+			patterns = append(patterns, pattern{domain: strings.ToLower(p[1:])})
+		default:
+			patterns = append(patterns, pattern{exact: strings.ToLower(p)})
+		}
+	}
+	return patterns, nil
+}
+
+func (p pattern) matches(address, domain string) bool {
+	switch {
+	case p.regex != nil:
+		return p.regex.MatchString(address)
+	case p.domain != "":
+		return domain == p.domain
+	default:
+		return strings.EqualFold(address, p.exact)
+	}
+}
+
+func matchAny(patterns []pattern, address, domain string) bool {
+	for _, p := range patterns {
+		if p.matches(address, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReloadPolicy replaces the allowlist and denylist. Nothing changes if
+// either list has an invalid pattern.
+func (v *Validator) ReloadPolicy(cfg *config.ApplicationConfig) error {
+	allowlist, err := parsePatterns(cfg.RecipientAllowlist)
+	if err != nil {
+		return err
+	}
+	denylist, err := parsePatterns(cfg.RecipientDenylist)
+	if err != nil {
+		return err
+	}
+
+	v.policyMu.Lock()
+	v.allowlist, v.denylist = allowlist, denylist
+	v.policyMu.Unlock()
+	return nil
+}
+
+// checkPolicy applies the denylist first, then the allowlist when one is set.
+func (v *Validator) checkPolicy(address, domain string) error {
+	v.policyMu.RLock()
+	defer v.policyMu.RUnlock()
+
+	if matchAny(v.denylist, address, domain) {
+		return ErrRecipientDenied
+	}
+
+	if len(v.allowlist) > 0 && !matchAny(v.allowlist, address, domain) {
+		return ErrRecipientNotAllowed
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package recipient
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// optOutKeyPrefix keys a set per address (recipient_optouts:<address>) of
+// the categories the recipient opted out of.
+const optOutKeyPrefix = "recipient_optouts:"
+
+var (
+	ErrRecipientOptedOut = errors.New("recipient opted out of this category")
+	ErrUnknownCategory   = errors.New("unknown subscription category")
+)
+
+// Categories returns the configured subscription categories in order.
+func (v *Validator) Categories() []string {
+	return v.categories
+}
+
+// HasCategory reports whether category is configured.
+func (v *Validator) HasCategory(category string) bool {
+	for _, known := range v.categories {
+		if known == category {
+			return true
+		}
+	}
+	return false
+}
+
+// Preferences returns whether address is subscribed to each category.
+func (v *Validator) Preferences(ctx context.Context, address string) (map[string]bool, error) {
+	optedOut, err := v.client.SMembers(ctx, optOutKeyPrefix+strings.ToLower(address)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	preferences := make(map[string]bool, len(v.categories))
+	for _, category := range v.categories {
+		preferences[category] = true
+	}
+	for _, category := range optedOut {
+		if _, ok := preferences[category]; ok {
+			preferences[category] = false
+		}
+	}
+	return preferences, nil
+}
+
+// SetPreferences subscribes address to, or opts it out of, the given
+// categories; categories left out keep their current setting.
+func (v *Validator) SetPreferences(ctx context.Context, address string, preferences map[string]bool) error {
+	var subscribe, optOut []interface{}
+	for category, subscribed := range preferences {
+		if !v.HasCategory(category) {
+			return ErrUnknownCategory
+		}
+		if subscribed {
+			subscribe = append(subscribe, category)
+		} else {
+			optOut = append(optOut, category)
+		}
+	}
+
+	key := optOutKeyPrefix + strings.ToLower(address)
+	pipe := v.client.TxPipeline()
+	if len(subscribe) > 0 {
+		pipe.SRem(ctx, key, subscribe...)
+	}
+	if len(optOut) > 0 {
+		pipe.SAdd(ctx, key, optOut...)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// optedOut reports whether address opted out of category. Mail without a
+// category cannot be opted out of this way, and Redis errors let the
+// address through, as for suppressions.
+func (v *Validator) optedOut(ctx context.Context, address, category string) bool {
+	if category == "" {
+		return false
+	}
+	optedOut, err := v.client.SIsMember(ctx, optOutKeyPrefix+strings.ToLower(address), category).Result()
+	return err == nil && optedOut
+}
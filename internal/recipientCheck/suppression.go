@@ -0,0 +1,66 @@
+package recipient
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// suppressionKey is a Redis hash of suppressed addresses to the reason they
+// were suppressed (e.g. "unsubscribe").
+const suppressionKey = "recipient_suppressed"
+
+const (
+	SuppressionUnsubscribe = "unsubscribe"
+	SuppressionComplaint   = "complaint"
+)
+
+var ErrRecipientSuppressed = errors.New("recipient address is suppressed")
+
+// Suppress stops any further mail from being accepted for address.
+func (v *Validator) Suppress(ctx context.Context, address, reason string) error {
+	return v.client.HSet(ctx, suppressionKey, strings.ToLower(address), reason).Err()
+}
+
+// isSuppressed reports whether address is suppressed for mail on queueName.
+// Unsubscribes and complaints only opt the recipient out of marketing mail,
+// so when MARKETING_QUEUES is set they do not block the other queues. Redis
+// errors let the address through rather than blocking all sends.
+func (v *Validator) isSuppressed(ctx context.Context, address, queueName string) bool {
+	reason, err := v.client.HGet(ctx, suppressionKey, strings.ToLower(address)).Result()
+	if err != nil {
+		return false
+	}
+
+	if len(v.marketingQueues) > 0 && (reason == SuppressionUnsubscribe || reason == SuppressionComplaint) {
+		_, marketing := v.marketingQueues[queueName]
+		return marketing
+	}
+	return true
+}
+
+// Suppressed returns the suppression reason of each of addresses that is
+// suppressed, keyed by lowercased address, whatever queue it would be sent on.
+func (v *Validator) Suppressed(ctx context.Context, addresses []string) (map[string]string, error) {
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+
+	fields := make([]string, len(addresses))
+	for i, address := range addresses {
+		fields[i] = strings.ToLower(address)
+	}
+
+	reasons, err := v.client.HMGet(ctx, suppressionKey, fields...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	suppressed := make(map[string]string)
+	for i, reason := range reasons {
+		if reason, ok := reason.(string); ok {
+			suppressed[fields[i]] = reason
+		}
+	}
+	return suppressed, nil
+}
@@ -0,0 +1,238 @@
+package recipient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+const (
+	mxCacheKeyPrefix = "recipient_mx:"
+
+	dnsLookupTimeout = 3 * time.Second
+)
+
+var ErrUndeliverableDomain = errors.New("recipient domain cannot receive email")
+
+var (
+	rejectedRecipients = metrics.NewCounter(
+		"mailqueue_recipient_rejected_total",
+		"Recipients rejected by pre-enqueue validation.",
+		"reason",
+	)
+	flaggedRecipients = metrics.NewCounter(
+		"mailqueue_recipient_flagged_total",
+		"Recipients accepted with a validation flag.",
+		"flag",
+	)
+)
+
+type Validator struct {
+	client   *redis.Client
+	resolver *net.Resolver
+
+	mxCheck    bool
+	mxCacheTTL time.Duration
+
+	disposableMode    string
+	disposableDomains map[string]struct{}
+
+	marketingQueues map[string]struct{}
+	categories      []string
+
+	policyMu  sync.RWMutex
+	allowlist []pattern
+	denylist  []pattern
+}
+
+func NewValidator(cfg *config.ApplicationConfig, client *redis.Client) (*Validator, error) {
+	v := &Validator{
+		client:          client,
+		resolver:        net.DefaultResolver,
+		mxCheck:         cfg.RecipientMXCheck,
+		mxCacheTTL:      cfg.RecipientMXCacheTTL,
+		disposableMode:  cfg.RecipientDisposableMode,
+		marketingQueues: make(map[string]struct{}, len(cfg.MarketingQueues)),
+		categories:      cfg.PreferenceCategories,
+	}
+	for _, name := range cfg.MarketingQueues {
+		v.marketingQueues[name] = struct{}{}
+	}
+
+	if err := v.ReloadPolicy(cfg); err != nil {
+		return nil, err
+	}
+
+	switch v.disposableMode {
+	case DisposableModeOff:
+	case DisposableModeReject, DisposableModeFlag:
+		domains, err := loadDisposableDomains(cfg.RecipientDisposableDomainsFile)
+		if err != nil {
+			return nil, err
+		}
+		v.disposableDomains = domains
+	default:
+		return nil, fmt.Errorf("invalid disposable domain mode %q", v.disposableMode)
+	}
+
+	return v, nil
+}
+
+// Validate runs the enabled pre-enqueue checks against a recipient address
+// for mail on queueName in the subscription category, if any. Checks
+// configured to flag rather than reject return the flags to attach to the
+// task.
+func (v *Validator) Validate(ctx context.Context, address, queueName, category string) ([]string, error) {
+	domain := domainOf(address)
+	if domain == "" {
+		return nil, fmt.Errorf("invalid recipient address %q", address)
+	}
+
+	var flags []string
+
+	if err := v.checkPolicy(address, domain); err != nil {
+		rejectedRecipients.Inc(rejectionReason(err))
+		return nil, err
+	}
+
+	if v.isSuppressed(ctx, address, queueName) {
+		rejectedRecipients.Inc(rejectionReason(ErrRecipientSuppressed))
+		return nil, ErrRecipientSuppressed
+	}
+
+	if v.optedOut(ctx, address, category) {
+		rejectedRecipients.Inc(rejectionReason(ErrRecipientOptedOut))
+		return nil, ErrRecipientOptedOut
+	}
+
+	if v.disposableMode != DisposableModeOff && v.isDisposable(domain) {
+		if v.disposableMode == DisposableModeReject {
+			rejectedRecipients.Inc(rejectionReason(ErrDisposableDomain))
+			return nil, ErrDisposableDomain
+		}
+		flaggedRecipients.Inc(FlagDisposable)
+		flags = append(flags, FlagDisposable)
+	}
+
+	if v.mxCheck {
+		if err := v.checkMX(ctx, domain); err != nil {
+			rejectedRecipients.Inc(rejectionReason(err))
+			return nil, err
+		}
+	}
+
+	return flags, nil
+}
+
+// Blocked returns ErrRecipientSuppressed or ErrRecipientOptedOut when
+// address was suppressed for queueName or opted out of category, so mail
+// queued before then is not sent. It skips the checks that only apply at
+// enqueue.
+func (v *Validator) Blocked(ctx context.Context, address, queueName, category string) error {
+	if v.isSuppressed(ctx, address, queueName) {
+		return ErrRecipientSuppressed
+	}
+	if v.optedOut(ctx, address, category) {
+		return ErrRecipientOptedOut
+	}
+	return nil
+}
+
+func rejectionReason(err error) string {
+	switch {
+	case errors.Is(err, ErrRecipientDenied):
+		return "denylist"
+	case errors.Is(err, ErrRecipientNotAllowed):
+		return "not_allowlisted"
+	case errors.Is(err, ErrRecipientSuppressed):
+		return "suppressed"
+	case errors.Is(err, ErrRecipientOptedOut):
+		return "opted_out"
+	case errors.Is(err, ErrDisposableDomain):
+		return "disposable"
+	case errors.Is(err, ErrUndeliverableDomain):
+		return "undeliverable_domain"
+	default:
+		return "other"
+	}
+}
+
+// checkMX verifies the domain has MX records, or A/AAAA records as the
+// implicit MX fallback. Results are cached in Redis; temporary DNS failures
+// are not cached and let the address through.
+func (v *Validator) checkMX(ctx context.Context, domain string) error {
+	cacheKey := mxCacheKeyPrefix + domain
+
+	cached, err := v.client.Get(ctx, cacheKey).Result()
+	if err == nil {
+		if cached == "0" {
+			return ErrUndeliverableDomain
+		}
+		return nil
+	}
+
+	deliverable, err := v.lookupDomain(ctx, domain)
+	if err != nil {
+		return nil
+	}
+
+	value := "1"
+	if !deliverable {
+		value = "0"
+	}
+	v.client.Set(ctx, cacheKey, value, v.mxCacheTTL)
+
+	if !deliverable {
+		return ErrUndeliverableDomain
+	}
+	return nil
+}
+
+func (v *Validator) lookupDomain(ctx context.Context, domain string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+
+	records, err := v.resolver.LookupMX(ctx, domain)
+	if err == nil {
+		// A single "." MX is a null MX (RFC 7505): the domain accepts no mail.
+		if len(records) == 1 && records[0].Host == "." {
+			return false, nil
+		}
+		if len(records) > 0 {
+			return true, nil
+		}
+	} else if !isNotFound(err) {
+		return false, err
+	}
+
+	hosts, err := v.resolver.LookupHost(ctx, domain)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return len(hosts) > 0, nil
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func domainOf(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 || at == len(address)-1 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(address[at+1:], "."))
+}
@@ -0,0 +1,377 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+const (
+	// heldKey maps the job IDs of tasks held for approval to their entries,
+	// and heldIndexKey orders them by the time they were held.
+	heldKey      = "email_held"
+	heldIndexKey = "email_held_index"
+
+	// approvedTemplatesKey is the set of templates an admin has approved,
+	// which the new_template rule no longer holds.
+	approvedTemplatesKey = "approved_templates"
+
+	// approvalRetryDelay is how long a task waits in the delayed queue when
+	// the approval check could not be made.
+	approvalRetryDelay = 30 * time.Second
+)
+
+// UnknownBatchTotal is passed to PlanBatch for a batch whose size is not
+// known before its emails are queued, e.g. a streaming bulk send.
+const UnknownBatchTotal = -1
+
+var (
+	heldEmails = metrics.NewCounter(
+		"mailqueue_held_total",
+		"Emails held for an admin's approval, by the rule that held them.",
+		"reason",
+	)
+	approvalDecisions = metrics.NewCounter(
+		"mailqueue_approval_decisions_total",
+		"Held emails approved or rejected by an admin.",
+		"decision",
+	)
+)
+
+// ErrNotHeld is returned when approving or rejecting a job that is not
+// waiting for approval.
+var ErrNotHeld = errors.New("job is not held for approval")
+
+// HeldEmail is a task waiting for an admin's approval, with the rule that
+// held it and, for the spam_flagged rule, the spam check's verdict.
+type HeldEmail struct {
+	Task   EmailTask `json:"task"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error,omitempty"`
+	HeldAt time.Time `json:"heldAt"`
+}
+
+// releaseHeldScript moves the held task of job ARGV[1] out of the held hash
+// (KEYS[1]) and its index (KEYS[2]) into the delayed ZSET (KEYS[3]) and its
+// index (KEYS[4]) as ARGV[2], due at ARGV[3], for the promoter to put back
+// on its queue. It returns 0 when the job was no longer held, so a task
+// approved twice at once is only released once.
+var releaseHeldScript = redis.NewScript(`
+if redis.call('HDEL', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[3], ARGV[2])
+redis.call('HSET', KEYS[4], ARGV[1], ARGV[2])
+return 1
+`)
+
+// planBatchScript adds ARGV[1] emails to the planned total of batch KEYS[1]
+// and, once it reaches ARGV[2], marks the batch as needing approval. A
+// negative ARGV[1], an unknown total, marks it at once. The batch expires
+// after ARGV[3] seconds unless that is 0.
+var planBatchScript = redis.NewScript(`
+local total = tonumber(ARGV[1])
+if total < 0 or redis.call('HINCRBY', KEYS[1], 'planned', total) >= tonumber(ARGV[2]) then
+	redis.call('HSET', KEYS[1], 'approvalRequired', 1)
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// PlanBatch records that total more emails are about to be queued to batch
+// id, before any of them is, so the recipients rule holds every email of a
+// batch that reaches APPROVAL_RECIPIENT_THRESHOLD, counting the requests
+// that share its ID. A total of UnknownBatchTotal holds the batch whatever
+// its size. It does nothing while the recipients rule is off.
+func (q *RedisQueue) PlanBatch(ctx context.Context, id string, total int) error {
+	if q.approvalRecipients == 0 {
+		return nil
+	}
+	err := planBatchScript.Run(ctx, q.client, []string{batchKeyPrefix + id},
+		total, q.approvalRecipients, int(q.batchTTL.Seconds())).Err()
+	if err != nil {
+		return fmt.Errorf("failed to plan batch: %w", err)
+	}
+	return nil
+}
+
+// ApprovesBatches reports whether the recipients rule is on, so batches
+// must be planned with their total before they are queued.
+func (q *RedisQueue) ApprovesBatches() bool {
+	return q.approvalRecipients > 0
+}
+
+// approvalRequired returns the rule that holds the task for approval, or ""
+// when none does. Rules an admin released the task from are skipped. The
+// recipients rule holds a broadcast with APPROVAL_RECIPIENT_THRESHOLD
+// recipients, and the emails of a batch PlanBatch marked unless the batch
+// was approved. The new_template rule holds sends of templates no admin has
+// approved yet.
+func (q *RedisQueue) approvalRequired(ctx context.Context, task EmailTask) (string, error) {
+	checkRecipients := q.approvalRecipients > 0 && !slices.Contains(task.ApprovedRules, config.ApprovalRecipients)
+	checkTemplate := q.approvalNewTemplates && !slices.Contains(task.ApprovedRules, config.ApprovalNewTemplate)
+	if checkRecipients && len(task.Recipients) >= q.approvalRecipients {
+		return config.ApprovalRecipients, nil
+	}
+
+	pipe := q.client.Pipeline()
+	var batch *redis.SliceCmd
+	if checkRecipients && task.BatchID != "" {
+		batch = pipe.HMGet(ctx, batchKeyPrefix+task.BatchID, "approvalRequired", "approvedAt")
+	}
+	var known *redis.BoolCmd
+	if checkTemplate && task.TemplateName != "" {
+		known = pipe.SIsMember(ctx, approvedTemplatesKey, task.TemplateName)
+	}
+	if batch == nil && known == nil {
+		return "", nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", err
+	}
+
+	if batch != nil {
+		fields := batch.Val()
+		if fields[0] != nil && fields[1] == nil {
+			return config.ApprovalRecipients, nil
+		}
+	}
+	if known != nil && !known.Val() {
+		return config.ApprovalNewTemplate, nil
+	}
+	return "", nil
+}
+
+// hold parks a task until an admin approves or rejects it. It does not
+// count as an attempt.
+func (q *RedisQueue) hold(ctx context.Context, task EmailTask, reason string, cause error) error {
+	entry := HeldEmail{Task: task, Reason: reason, HeldAt: time.Now().UTC()}
+	if cause != nil {
+		entry.Error = cause.Error()
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to serialize held email: %w", err)
+	}
+
+	pipe := q.client.TxPipeline()
+	pipe.HSet(ctx, heldKey, task.ID, entryJSON)
+	pipe.ZAdd(ctx, heldIndexKey, &redis.Z{Score: float64(entry.HeldAt.UnixMilli()), Member: task.ID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to hold email for approval: %w", err)
+	}
+
+	heldEmails.Inc(reason)
+	q.logger.Info("Email held for approval",
+		"id", task.ID,
+		"queue", task.Queue,
+		"template", task.TemplateName,
+		"batch", task.BatchID,
+		"reason", reason,
+	)
+	q.publish(ctx, events.TypeHeld, task, cause)
+	return nil
+}
+
+// HeldEmail returns the held task of job id.
+func (q *RedisQueue) HeldEmail(ctx context.Context, id string) (*HeldEmail, error) {
+	entryJSON, err := q.client.HGet(ctx, heldKey, id).Result()
+	if err == redis.Nil {
+		return nil, ErrNotHeld
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load held email: %w", err)
+	}
+
+	var entry HeldEmail
+	if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+		return nil, fmt.Errorf("held email deserialization error: %w", err)
+	}
+	return &entry, nil
+}
+
+// ApproveHeld releases the held task of job id to its queue and approves
+// what the rule held it for: its template for new_template, or its batch
+// for recipients, releasing the other tasks held for the same template or
+// batch too, only of tenant unless it is "". A task held by spam_flagged is
+// approved on its own. It returns the number of tasks released.
+func (q *RedisQueue) ApproveHeld(ctx context.Context, id, tenant string) (int, error) {
+	entry, err := q.HeldEmail(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	task := entry.Task
+	var related func(HeldEmail) bool
+	switch entry.Reason {
+	case config.ApprovalNewTemplate:
+		if err := q.client.SAdd(ctx, approvedTemplatesKey, task.TemplateName).Err(); err != nil {
+			return 0, fmt.Errorf("failed to approve template: %w", err)
+		}
+		related = func(other HeldEmail) bool {
+			return other.Reason == config.ApprovalNewTemplate && other.Task.TemplateName == task.TemplateName
+		}
+	case config.ApprovalRecipients:
+		if task.BatchID != "" {
+			if err := q.client.HSet(ctx, batchKeyPrefix+task.BatchID, "approvedAt", time.Now().UnixMilli()).Err(); err != nil {
+				return 0, fmt.Errorf("failed to approve batch: %w", err)
+			}
+			related = func(other HeldEmail) bool {
+				return other.Reason == config.ApprovalRecipients && other.Task.BatchID == task.BatchID
+			}
+		}
+	}
+
+	released, err := q.releaseHeld(ctx, *entry)
+	if err != nil {
+		return 0, err
+	}
+	if !released {
+		return 0, ErrNotHeld
+	}
+	count := 1
+
+	if related != nil {
+		iter := q.client.HScan(ctx, heldKey, 0, "", listScanChunk).Iterator()
+		for iter.Next(ctx) {
+			// HSCAN returns field and value in turn.
+			jobID := iter.Val()
+			if !iter.Next(ctx) {
+				break
+			}
+			var other HeldEmail
+			if jobID == id || json.Unmarshal([]byte(iter.Val()), &other) != nil || !related(other) ||
+				tenant != "" && other.Task.Tenant != tenant {
+				continue
+			}
+			released, err := q.releaseHeld(ctx, other)
+			if err != nil {
+				return count, err
+			}
+			if released {
+				count++
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return count, fmt.Errorf("failed to scan held emails: %w", err)
+		}
+	}
+
+	q.logger.Info("Held email approved", "id", id, "reason", entry.Reason, "released", count)
+	return count, nil
+}
+
+// releaseHeld moves a held task to the delayed ZSET, due now, released from
+// the rule that held it so that rule does not hold it again; the other
+// rules still apply. It reports false when the task was no longer held.
+func (q *RedisQueue) releaseHeld(ctx context.Context, entry HeldEmail) (bool, error) {
+	task := entry.Task
+	if !slices.Contains(task.ApprovedRules, entry.Reason) {
+		task.ApprovedRules = append(task.ApprovedRules, entry.Reason)
+	}
+	q.extendExpiry(&task, time.Now())
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return false, fmt.Errorf("failed to serialize email task: %w", err)
+	}
+
+	released, err := releaseHeldScript.Run(ctx, q.client,
+		[]string{heldKey, heldIndexKey, delayedQueue, delayedJobsKey},
+		task.ID,
+		taskJSON,
+		strconv.FormatInt(time.Now().UnixMilli(), 10),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to release held email: %w", err)
+	}
+	if released == 0 {
+		return false, nil
+	}
+
+	approvalDecisions.Inc("approved")
+	q.publish(ctx, events.TypeApproved, task, nil)
+	return true, nil
+}
+
+// RejectHeld drops the held task of job id, recording it as canceled.
+func (q *RedisQueue) RejectHeld(ctx context.Context, id string) error {
+	entry, err := q.HeldEmail(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	pipe := q.client.TxPipeline()
+	removed := pipe.HDel(ctx, heldKey, id)
+	pipe.ZRem(ctx, heldIndexKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to reject held email: %w", err)
+	}
+	if removed.Val() == 0 {
+		return ErrNotHeld
+	}
+
+	approvalDecisions.Inc("rejected")
+	q.logger.Info("Held email rejected", "id", id, "reason", entry.Reason)
+	q.dropCanceled(ctx, entry.Task)
+	return nil
+}
+
+// HeldEmails returns one page of tasks held for approval, ordered by when
+// they were held, and the cursor of the next page, if any.
+func (q *RedisQueue) HeldEmails(ctx context.Context, query ListQuery) ([]HeldEmail, *Cursor, error) {
+	p := &page[HeldEmail]{limit: query.Limit, items: []HeldEmail{}}
+	var loadErr error
+	stop, err := q.scanIndex(ctx, heldIndexKey, query, func(entries []redis.Z) bool {
+		ids := make([]string, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.Member.(string)
+		}
+		values, err := q.client.HMGet(ctx, heldKey, ids...).Result()
+		if err != nil {
+			loadErr = err
+			return true
+		}
+		for i, value := range values {
+			raw, ok := value.(string)
+			if !ok {
+				continue
+			}
+			var entry HeldEmail
+			if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+				continue
+			}
+			if !query.matchesTenant(entry.Task.Tenant) ||
+				query.Template != "" && entry.Task.TemplateName != query.Template ||
+				!query.matchesRecipient(entry.Task.To) ||
+				!query.matchesLabels(entry.Task.Tags, entry.Task.Metadata) {
+				continue
+			}
+			if p.add(entry, Cursor{At: int64(entries[i].Score), ID: ids[i]}) {
+				return true
+			}
+		}
+		return false
+	})
+	if err == nil {
+		err = loadErr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list held emails: %w", err)
+	}
+	if p.next == nil {
+		p.next = stop
+	}
+	return p.items, p.next, nil
+}
@@ -0,0 +1,182 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	batchKeyPrefix = "batch:"
+
+	// batchIndexKey orders batches by the time their first task was queued.
+	batchIndexKey = "batch_index"
+)
+
+var ErrBatchNotFound = errors.New("batch not found")
+
+const (
+	BatchQueueing  = "queueing"
+	BatchRunning   = "running"
+	BatchCompleted = "completed"
+	BatchCanceled  = "canceled"
+)
+
+// BatchProgress summarizes a bulk send. Remaining counts tasks still queued
+// or waiting for a retry; the ETA extrapolates the rate since the first task
+// of the batch was processed.
+type BatchProgress struct {
+	ID                  string     `json:"id"`
+	Status              string     `json:"status"`
+	Tenant              string     `json:"tenant,omitempty"`
+	CreatedAt           *time.Time `json:"createdAt,omitempty"`
+	Queued              int64      `json:"queued"`
+	Sent                int64      `json:"sent"`
+	Failed              int64      `json:"failed"`
+	Canceled            int64      `json:"canceled,omitempty"`
+	Expired             int64      `json:"expired,omitempty"`
+	Rejected            int64      `json:"rejected,omitempty"`
+	Remaining           int64      `json:"remaining"`
+	Error               string     `json:"error,omitempty"`
+	StartedAt           *time.Time `json:"startedAt,omitempty"`
+	CanceledAt          *time.Time `json:"canceledAt,omitempty"`
+	ETASeconds          *float64   `json:"etaSeconds,omitempty"`
+	EstimatedCompletion *time.Time `json:"estimatedCompletion,omitempty"`
+}
+
+// NewBatchID returns a random ID for grouping tasks into a batch.
+func NewBatchID() string {
+	return randomHex(16)
+}
+
+// recordBatch increments one of the queued, sent, failed, canceled or
+// expired counters of the task's batch.
+func (q *RedisQueue) recordBatch(ctx context.Context, task EmailTask, field string) {
+	if task.BatchID == "" {
+		return
+	}
+
+	key := batchKeyPrefix + task.BatchID
+	pipe := q.client.TxPipeline()
+	pipe.HIncrBy(ctx, key, field, 1)
+	now := time.Now().UnixMilli()
+	switch field {
+	case "queued":
+		pipe.HSetNX(ctx, key, "createdAt", now)
+		if task.Tenant != "" {
+			pipe.HSetNX(ctx, key, "tenant", task.Tenant)
+		}
+		pipe.ZAddNX(ctx, batchIndexKey, &redis.Z{Score: float64(now), Member: task.BatchID})
+	case "sent", "failed":
+		pipe.HSetNX(ctx, key, "startedAt", now)
+	}
+	if q.batchTTL > 0 {
+		pipe.Expire(ctx, key, q.batchTTL)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		q.logger.Warn("Failed to update batch progress", "batch", task.BatchID, "field", field, "error", err)
+	}
+}
+
+// StartBatchQueueing creates the batch's progress record before a
+// background send queues its tasks, so it reports queueing rather than
+// completed while fewer tasks are queued than will be. The batch belongs to
+// tenant.
+func (q *RedisQueue) StartBatchQueueing(ctx context.Context, id, tenant string) error {
+	key := batchKeyPrefix + id
+	now := time.Now().UnixMilli()
+	pipe := q.client.TxPipeline()
+	pipe.HSet(ctx, key, "queueing", 1)
+	pipe.HSetNX(ctx, key, "createdAt", now)
+	if tenant != "" {
+		pipe.HSetNX(ctx, key, "tenant", tenant)
+	}
+	pipe.ZAddNX(ctx, batchIndexKey, &redis.Z{Score: float64(now), Member: id})
+	if q.batchTTL > 0 {
+		pipe.Expire(ctx, key, q.batchTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to start batch: %w", err)
+	}
+	return nil
+}
+
+// FinishBatchQueueing ends the queueing started by StartBatchQueueing,
+// recording how many emails were rejected and, when queueing stopped
+// early, why.
+func (q *RedisQueue) FinishBatchQueueing(ctx context.Context, id string, rejected int, reason string) error {
+	key := batchKeyPrefix + id
+	pipe := q.client.TxPipeline()
+	pipe.HDel(ctx, key, "queueing")
+	if rejected > 0 {
+		pipe.HIncrBy(ctx, key, "rejected", int64(rejected))
+	}
+	if reason != "" {
+		pipe.HSet(ctx, key, "error", reason)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to finish batch: %w", err)
+	}
+	return nil
+}
+
+func (q *RedisQueue) BatchProgress(ctx context.Context, id string) (*BatchProgress, error) {
+	fields, err := q.client.HGetAll(ctx, batchKeyPrefix+id).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load batch: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, ErrBatchNotFound
+	}
+	return batchProgress(id, fields), nil
+}
+
+func batchProgress(id string, fields map[string]string) *BatchProgress {
+	progress := &BatchProgress{ID: id, Tenant: fields["tenant"]}
+	if createdMillis, err := strconv.ParseInt(fields["createdAt"], 10, 64); err == nil {
+		createdAt := time.UnixMilli(createdMillis).UTC()
+		progress.CreatedAt = &createdAt
+	}
+	progress.Queued, _ = strconv.ParseInt(fields["queued"], 10, 64)
+	progress.Sent, _ = strconv.ParseInt(fields["sent"], 10, 64)
+	progress.Failed, _ = strconv.ParseInt(fields["failed"], 10, 64)
+	progress.Canceled, _ = strconv.ParseInt(fields["canceled"], 10, 64)
+	progress.Expired, _ = strconv.ParseInt(fields["expired"], 10, 64)
+	progress.Rejected, _ = strconv.ParseInt(fields["rejected"], 10, 64)
+	progress.Remaining = max(progress.Queued-progress.Sent-progress.Failed-progress.Canceled-progress.Expired, 0)
+	progress.Error = fields["error"]
+	progress.Status = BatchRunning
+	switch {
+	case fields["queueing"] != "":
+		progress.Status = BatchQueueing
+	case progress.Remaining == 0:
+		progress.Status = BatchCompleted
+	}
+
+	if canceledMillis, err := strconv.ParseInt(fields["canceledAt"], 10, 64); err == nil {
+		canceledAt := time.UnixMilli(canceledMillis).UTC()
+		progress.CanceledAt = &canceledAt
+		progress.Status = BatchCanceled
+	}
+
+	if startedMillis, err := strconv.ParseInt(fields["startedAt"], 10, 64); err == nil {
+		startedAt := time.UnixMilli(startedMillis).UTC()
+		progress.StartedAt = &startedAt
+
+		done := progress.Sent + progress.Failed
+		elapsed := time.Since(startedAt)
+		if done > 0 && elapsed > 0 {
+			eta := float64(progress.Remaining) * elapsed.Seconds() / float64(done)
+			completion := time.Now().Add(time.Duration(eta * float64(time.Second))).UTC()
+			progress.ETASeconds = &eta
+			progress.EstimatedCompletion = &completion
+		}
+	}
+
+	return progress
+}
@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	submissionKeyPrefix = "batch_key"
+
+	// batchKeyLease is how long a claim is held while its submission runs,
+	// longer than the default REQUEST_TIMEOUT. A submission that crashes or
+	// fails to store its response blocks the key for this long rather than
+	// for IDEMPOTENCY_TTL.
+	batchKeyLease = 2 * time.Minute
+)
+
+var (
+	ErrBatchKeyInProgress = errors.New("a submission with this batchKey is still being processed")
+	ErrBatchKeyMismatch   = errors.New("batchKey was already used for a different request")
+)
+
+// BatchSubmission is what is remembered of a bulk submission made with a
+// batchKey: a hash of the request and, once it finished, the response it got.
+type BatchSubmission struct {
+	RequestHash string          `json:"requestHash"`
+	Status      int             `json:"status,omitempty"`
+	Response    json.RawMessage `json:"response,omitempty"`
+}
+
+// ClaimBatchKey reserves key for a submission with requestHash and returns
+// nil, or returns the finished submission that already used it. It fails
+// with ErrBatchKeyInProgress while the first submission is still running and
+// with ErrBatchKeyMismatch when the key was used for a different request.
+// Completed keys are remembered for IDEMPOTENCY_TTL; with a zero TTL every
+// claim succeeds. Keys are scoped to tenant.
+func (q *RedisQueue) ClaimBatchKey(ctx context.Context, tenant, key, requestHash string) (*BatchSubmission, error) {
+	if q.idempotencyTTL <= 0 {
+		return nil, nil
+	}
+
+	pending, err := json.Marshal(BatchSubmission{RequestHash: requestHash})
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := q.client.SetNX(ctx, submissionKey(tenant, key), pending, min(batchKeyLease, q.idempotencyTTL)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim batch key: %w", err)
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	existing, err := q.client.Get(ctx, submissionKey(tenant, key)).Bytes()
+	if err == redis.Nil {
+		// Released or expired between the two calls; try once more.
+		return q.ClaimBatchKey(ctx, tenant, key, requestHash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load batch key: %w", err)
+	}
+
+	var submission BatchSubmission
+	if err := json.Unmarshal(existing, &submission); err != nil {
+		return nil, fmt.Errorf("failed to decode batch key: %w", err)
+	}
+	if submission.RequestHash != requestHash {
+		return nil, ErrBatchKeyMismatch
+	}
+	if submission.Status == 0 {
+		return nil, ErrBatchKeyInProgress
+	}
+	return &submission, nil
+}
+
+// CompleteBatchKey stores the response of the submission that claimed key,
+// to be returned to repeats of it.
+func (q *RedisQueue) CompleteBatchKey(ctx context.Context, tenant, key string, submission BatchSubmission) error {
+	if q.idempotencyTTL <= 0 {
+		return nil
+	}
+	content, err := json.Marshal(submission)
+	if err != nil {
+		return err
+	}
+	if err := q.client.Set(ctx, submissionKey(tenant, key), content, q.idempotencyTTL).Err(); err != nil {
+		return fmt.Errorf("failed to store batch key response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseBatchKey forgets a claimed key whose submission was rejected
+// before anything was queued, so it can be retried.
+func (q *RedisQueue) ReleaseBatchKey(ctx context.Context, tenant, key string) error {
+	return q.client.Del(ctx, submissionKey(tenant, key)).Err()
+}
+
+func submissionKey(tenant, key string) string {
+	return tenantKey(submissionKeyPrefix, tenant) + ":" + key
+}
@@ -0,0 +1,22 @@
+package queue
+
+import email "github.com/sarthakyeole/redis-go-mailing-bulk/internal/senderSide"
+
+// remainingRecipients returns the recipients of a broadcast that are not in
+// delivered and were not refused for good.
+func remainingRecipients(recipients, delivered []string, refused []email.RefusedRecipient) []string {
+	done := make(map[string]bool, len(delivered)+len(refused))
+	for _, address := range delivered {
+		done[address] = true
+	}
+	for _, r := range refused {
+		done[r.Address] = true
+	}
+	remaining := make([]string, 0, len(recipients))
+	for _, address := range recipients {
+		if !done[address] {
+			remaining = append(remaining, address)
+		}
+	}
+	return remaining
+}
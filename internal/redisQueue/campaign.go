@@ -0,0 +1,259 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+)
+
+var ErrBatchCanceled = errors.New("batch has been canceled")
+
+// pullBatchWindow is how many tasks one call of the batch pull scripts
+// looks at.
+const pullBatchWindow = 1000
+
+// inBatchLua decodes a queued task and returns it when it belongs to the
+// batch in ARGV[1].
+const inBatchLua = `
+local function inBatch(member)
+	local ok, task = pcall(cjson.decode, member)
+	if ok and type(task) == 'table' and task['batchId'] == ARGV[1] then
+		return task
+	end
+	return nil
+end
+`
+
+// pullBatchListScript takes the tasks of one batch (ARGV[1]) out of a
+// window of ARGV[5] members of the queue list KEYS[3], ending ARGV[4]
+// members before its tail, returning the offset of the next window followed
+// by the tasks, or -1 when the head was reached. With ARGV[2] =
+// "reschedule" they are parked in the delayed ZSET (KEYS[1]) and its job
+// index (KEYS[2]) at ARGV[3]. Windows are counted from the tail because
+// workers pop from the head, which would shift head-based offsets between
+// windows. Matches are overwritten with a marker and removed in one LREM,
+// instead of an O(N) LREM per member.
+var pullBatchListScript = redis.NewScript(inBatchLua + `
+local len = redis.call('LLEN', KEYS[3])
+local offset = tonumber(ARGV[4])
+if offset >= len then
+	return {'-1'}
+end
+
+local window = tonumber(ARGV[5])
+local members = redis.call('LRANGE', KEYS[3], -(offset + window), -(offset + 1))
+local first = -(offset + #members)
+local pulled = {''}
+for j, member in ipairs(members) do
+	local task = inBatch(member)
+	if task then
+		redis.call('LSET', KEYS[3], first + j - 1, '__pulled__')
+		table.insert(pulled, member)
+		if ARGV[2] == 'reschedule' then
+			redis.call('ZADD', KEYS[1], ARGV[3], member)
+			if type(task['id']) == 'string' then
+				redis.call('HSET', KEYS[2], task['id'], member)
+			end
+		end
+	end
+end
+if #pulled > 1 then
+	redis.call('LREM', KEYS[3], -(#pulled - 1), '__pulled__')
+end
+
+if offset + #members >= len then
+	pulled[1] = '-1'
+else
+	pulled[1] = tostring(offset + #members - (#pulled - 1))
+end
+return pulled
+`)
+
+// pullBatchDelayedScript takes the delayed tasks ARGV[4..] of one batch
+// (ARGV[1]) out of the delayed ZSET (KEYS[1]) and its job index (KEYS[2])
+// with ARGV[2] = "cancel", or with "reschedule" moves those due before
+// ARGV[3] to it, returning them. Members that are gone or belong to
+// another batch are skipped.
+var pullBatchDelayedScript = redis.NewScript(inBatchLua + `
+local pulled = {}
+for i = 4, #ARGV do
+	local member = ARGV[i]
+	local score = redis.call('ZSCORE', KEYS[1], member)
+	local task = score and inBatch(member)
+	if task then
+		if ARGV[2] == 'cancel' then
+			redis.call('ZREM', KEYS[1], member)
+			if type(task['id']) == 'string' then
+				redis.call('HDEL', KEYS[2], task['id'])
+			end
+			table.insert(pulled, member)
+		elseif tonumber(score) < tonumber(ARGV[3]) then
+			redis.call('ZADD', KEYS[1], ARGV[3], member)
+			table.insert(pulled, member)
+		end
+	end
+end
+return pulled
+`)
+
+// CancelBatch removes the batch's queued and delayed tasks and marks the
+// batch canceled, so tasks that were being moved or retried at the time,
+// and later pages of the batch, are dropped too. Tasks already being sent
+// finish. It returns the number of tasks removed.
+func (q *RedisQueue) CancelBatch(ctx context.Context, id string) (int, error) {
+	exists, err := q.client.HExists(ctx, batchKeyPrefix+id, "createdAt").Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load batch: %w", err)
+	}
+	if !exists {
+		return 0, ErrBatchNotFound
+	}
+	if err := q.client.HSetNX(ctx, batchKeyPrefix+id, "canceledAt", time.Now().UnixMilli()).Err(); err != nil {
+		return 0, fmt.Errorf("failed to cancel batch: %w", err)
+	}
+
+	pulled, err := q.pullBatch(ctx, id, "cancel", time.Time{})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, taskJSON := range pulled {
+		var task EmailTask
+		if err := json.Unmarshal([]byte(taskJSON), &task); err != nil {
+			continue
+		}
+		q.dropCanceled(ctx, task)
+	}
+
+	q.logger.Info("Batch canceled", "batch", id, "removed", len(pulled))
+	return len(pulled), nil
+}
+
+// RescheduleBatch moves the batch's queued tasks, and delayed tasks due
+// before sendAt, to sendAt. It returns the number of tasks moved.
+func (q *RedisQueue) RescheduleBatch(ctx context.Context, id string, sendAt time.Time) (int, error) {
+	fields, err := q.client.HMGet(ctx, batchKeyPrefix+id, "createdAt", "canceledAt").Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load batch: %w", err)
+	}
+	if fields[0] == nil {
+		return 0, ErrBatchNotFound
+	}
+	if fields[1] != nil {
+		return 0, ErrBatchCanceled
+	}
+
+	// Tasks moved to sendAt get their queue TTL from then.
+	if err := q.client.HSet(ctx, batchKeyPrefix+id, "sendAt", sendAt.UnixMilli()).Err(); err != nil {
+		return 0, fmt.Errorf("failed to reschedule batch: %w", err)
+	}
+	pulled, err := q.pullBatch(ctx, id, "reschedule", sendAt)
+	if err != nil {
+		return 0, err
+	}
+
+	q.logger.Info("Batch rescheduled", "batch", id, "moved", len(pulled), "sendAt", sendAt)
+	return len(pulled), nil
+}
+
+// BatchCanceled reports whether the batch was canceled.
+func (q *RedisQueue) BatchCanceled(ctx context.Context, id string) (bool, error) {
+	return q.client.HExists(ctx, batchKeyPrefix+id, "canceledAt").Result()
+}
+
+// batchSchedule reports whether the batch was canceled, and the time it
+// was last rescheduled to, if ever.
+func (q *RedisQueue) batchSchedule(ctx context.Context, id string) (bool, time.Time, error) {
+	fields, err := q.client.HMGet(ctx, batchKeyPrefix+id, "canceledAt", "sendAt").Result()
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	var sendAt time.Time
+	if millis, ok := fields[1].(string); ok {
+		sendAt = parseMillis(millis)
+	}
+	return fields[0] != nil, sendAt, nil
+}
+
+// pullBatch takes the batch's tasks out of the queue lists and, with mode
+// "cancel", the delayed ZSET, or with "reschedule" parks them at sendAt.
+// Each script call handles at most pullBatchWindow tasks, so Redis is not
+// blocked for the length of a long queue.
+func (q *RedisQueue) pullBatch(ctx context.Context, id, mode string, sendAt time.Time) ([]string, error) {
+	at := strconv.FormatInt(sendAt.UnixMilli(), 10)
+
+	var pulled []string
+	for name := range q.queues {
+		for _, list := range q.queueKeys(name) {
+			for offset := int64(0); offset >= 0; {
+				result, err := pullBatchListScript.Run(ctx, q.client, []string{delayedQueue, delayedJobsKey, list},
+					id, mode, at, offset, pullBatchWindow).StringSlice()
+				if err != nil {
+					return nil, fmt.Errorf("failed to %s batch tasks: %w", mode, err)
+				}
+				if offset, err = strconv.ParseInt(result[0], 10, 64); err != nil {
+					return nil, fmt.Errorf("failed to %s batch tasks: %w", mode, err)
+				}
+				pulled = append(pulled, result[1:]...)
+			}
+		}
+	}
+
+	// Only delayed tasks of the batch are scanned: the MATCH pattern holds
+	// the batchId field as it appears in the task's JSON.
+	encodedID, err := json.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	match := `*"batchId":` + globEscape(string(encodedID)) + "*"
+	var cursor uint64
+	for {
+		members, next, err := q.client.ZScan(ctx, delayedQueue, cursor, match, pullBatchWindow).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan delayed batch tasks: %w", err)
+		}
+		if len(members) > 0 {
+			args := []interface{}{id, mode, at}
+			// ZSCAN returns members and scores in turn.
+			for i := 0; i < len(members); i += 2 {
+				args = append(args, members[i])
+			}
+			result, err := pullBatchDelayedScript.Run(ctx, q.client, []string{delayedQueue, delayedJobsKey}, args...).StringSlice()
+			if err != nil {
+				return nil, fmt.Errorf("failed to %s batch tasks: %w", mode, err)
+			}
+			pulled = append(pulled, result...)
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	return pulled, nil
+}
+
+// globEscape escapes the characters Redis MATCH patterns treat specially.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// dropCanceled records a task of a canceled batch as canceled instead of
+// sending it.
+func (q *RedisQueue) dropCanceled(ctx context.Context, task EmailTask) {
+	q.publish(ctx, events.TypeCanceled, task, nil)
+	q.recordBatch(ctx, task, "canceled")
+	q.releaseBody(ctx, task)
+}
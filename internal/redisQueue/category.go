@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+var categoryDeferred = metrics.NewCounter(
+	"mailqueue_category_deferred_total",
+	"Emails deferred by the rate limit of their send category.",
+	"category",
+)
+
+// sendCategory is the priority and rate limit of one send category. Its
+// rate is kept by handing out send slots an interval apart, per instance
+// across every queue.
+type sendCategory struct {
+	priority string
+	interval time.Duration // 0 when the category is not rate limited
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newSendCategories(categories []config.SendCategoryConfig) map[string]*sendCategory {
+	byName := make(map[string]*sendCategory, len(categories))
+	for _, category := range categories {
+		c := &sendCategory{priority: category.Priority}
+		if category.RateLimit > 0 {
+			c.interval = time.Duration(float64(time.Second) / category.RateLimit)
+		}
+		byName[category.Name] = c
+	}
+	return byName
+}
+
+// reserve takes the category's next send slot: now when it is under its
+// rate, otherwise the earliest time its rate allows.
+func (c *sendCategory) reserve(now time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slot := now
+	if c.next.After(slot) {
+		slot = c.next
+	}
+	c.next = slot.Add(c.interval)
+	return slot
+}
+
+// unreserve gives back a slot taken with reserve for a send that did not
+// happen.
+func (c *sendCategory) unreserve() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next = c.next.Add(-c.interval)
+}
+
+// HasSendCategory reports whether name is a configured send category.
+func (q *RedisQueue) HasSendCategory(name string) bool {
+	_, ok := q.sendCategories[name]
+	return ok
+}
+
+// categoryAllows reserves a send slot for the task under the rate limit of
+// its send category. When the slot is later it returns false and the slot,
+// recorded on the task, so the task can wait for it in the delayed set
+// instead of holding a worker that other categories need. A task coming
+// back at its slot is not counted again.
+func (q *RedisQueue) categoryAllows(task *EmailTask) (bool, time.Time) {
+	category, ok := q.sendCategories[task.Category]
+	if !ok || category.interval == 0 {
+		return true, time.Time{}
+	}
+	if task.CategorySlot != nil {
+		task.CategorySlot = nil
+		return true, time.Time{}
+	}
+
+	now := time.Now()
+	slot := category.reserve(now)
+	if !slot.After(now) {
+		return true, time.Time{}
+	}
+	task.CategorySlot = &slot
+	return false, slot
+}
+
+// returnCategorySlot gives back the slot categoryAllows let the task send
+// in, when the task is not sent after all.
+func (q *RedisQueue) returnCategorySlot(task EmailTask) {
+	if category, ok := q.sendCategories[task.Category]; ok && category.interval > 0 {
+		category.unreserve()
+	}
+}
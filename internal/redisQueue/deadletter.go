@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// deadLetterIndexKey orders dead letters by failure time in
+	// milliseconds; deadLettersKey holds each entry's JSON by the same ID,
+	// the task's.
+	deadLetterIndexKey = "dead_letter_index"
+	deadLettersKey     = "dead_letters"
+
+	// legacyDeadLetterQueue is the list dead letters were appended to
+	// before they were indexed. Its entries are moved to the index.
+	legacyDeadLetterQueue = "email_dead_letter"
+)
+
+// DeadLetter is a task that exhausted its retries, kept with the last
+// error, the worker that made the final attempt and the attempt count for
+// inspection or manual replay. An offloaded body is deleted once the task is
+// dead-lettered, so Task.BodyRef no longer resolves.
+type DeadLetter struct {
+	Task     EmailTask `json:"task"`
+	Error    string    `json:"error"`
+	Worker   string    `json:"worker,omitempty"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+func (q *RedisQueue) deadLetter(ctx context.Context, task EmailTask, sendErr error) error {
+	entry := DeadLetter{
+		Task:     task,
+		Error:    sendErr.Error(),
+		Worker:   task.Worker,
+		Attempts: task.Retries + 1,
+		FailedAt: time.Now().UTC(),
+	}
+	if err := q.saveDeadLetters(ctx, []DeadLetter{entry}); err != nil {
+		return err
+	}
+	q.releaseBody(ctx, task)
+	return nil
+}
+
+// saveDeadLetters indexes entries by failure time. A task dead-lettered
+// again replaces its earlier entry.
+func (q *RedisQueue) saveDeadLetters(ctx context.Context, entries []DeadLetter) error {
+	pipe := q.client.TxPipeline()
+	for _, entry := range entries {
+		id := entry.Task.ID
+		if id == "" {
+			id = randomHex(16)
+		}
+		entryJSON, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to serialize dead-letter entry: %w", err)
+		}
+		pipe.HSet(ctx, deadLettersKey, id, entryJSON)
+		pipe.ZAdd(ctx, deadLetterIndexKey, &redis.Z{Score: float64(entry.FailedAt.UnixMilli()), Member: id})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// migrateDeadLetters moves entries of the legacy dead-letter list to the
+// index, a chunk at a time. Entries are removed from the list only once
+// saved; instances migrating at the same time save the same entries.
+func (q *RedisQueue) migrateDeadLetters(ctx context.Context) error {
+	if q.deadLettersMigrated.Load() {
+		return nil
+	}
+	for {
+		raw, err := q.client.LRange(ctx, legacyDeadLetterQueue, 0, listScanChunk-1).Result()
+		if err != nil {
+			return fmt.Errorf("failed to migrate dead letters: %w", err)
+		}
+		if len(raw) == 0 {
+			q.deadLettersMigrated.Store(true)
+			return nil
+		}
+
+		entries := make([]DeadLetter, 0, len(raw))
+		for _, member := range raw {
+			var entry DeadLetter
+			if err := json.Unmarshal([]byte(member), &entry); err == nil {
+				entries = append(entries, entry)
+			}
+		}
+		if err := q.saveDeadLetters(ctx, entries); err != nil {
+			return fmt.Errorf("failed to migrate dead letters: %w", err)
+		}
+
+		pipe := q.client.Pipeline()
+		for _, member := range raw {
+			pipe.LRem(ctx, legacyDeadLetterQueue, 1, member)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("failed to migrate dead letters: %w", err)
+		}
+	}
+}
+
+// CleanupDeadLetters removes dead-lettered tasks that failed before the
+// cutoff, a chunk of the index at a time.
+func (q *RedisQueue) CleanupDeadLetters(ctx context.Context, before time.Time) (int64, error) {
+	if err := q.migrateDeadLetters(ctx); err != nil {
+		return 0, err
+	}
+
+	var removed int64
+	for {
+		ids, err := q.client.ZRangeByScore(ctx, deadLetterIndexKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   "(" + strconv.FormatInt(before.UnixMilli(), 10),
+			Count: listScanChunk,
+		}).Result()
+		if err != nil {
+			return removed, err
+		}
+		if len(ids) == 0 {
+			return removed, nil
+		}
+
+		members := make([]interface{}, len(ids))
+		for i, id := range ids {
+			members[i] = id
+		}
+		pipe := q.client.TxPipeline()
+		pipe.HDel(ctx, deadLettersKey, ids...)
+		n := pipe.ZRem(ctx, deadLetterIndexKey, members...)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return removed, err
+		}
+		removed += n.Val()
+	}
+}
@@ -0,0 +1,152 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	idempotencyPrefix  = "idempotency"
+	dedupPrefix        = "dedup"
+	webhookTokenPrefix = "webhook_token:"
+)
+
+// ErrDuplicateTask is returned with the existing job ID when a task repeats
+// an idempotency key or an identical task enqueued within the dedup window.
+var ErrDuplicateTask = errors.New("duplicate email task")
+
+// enqueueScript pushes a task only if none of its guard keys exist, and
+// claims them for the new job in the same step, so concurrent API instances
+// can't both enqueue the same email. KEYS[1] is the queue, KEYS[2] the
+// delayed ZSET, KEYS[3] its job index and KEYS[4..] the guard keys; ARGV[1]
+// is the job ID, ARGV[2] the task, ARGV[3] the time to send it at in
+// milliseconds, 0 for now, and ARGV[4..] the guard TTLs in milliseconds. It
+// returns {1, jobID} when pushed and {0, existingID} for a duplicate.
+var enqueueScript = redis.NewScript(`
+for i = 4, #KEYS do
+	local existing = redis.call('GET', KEYS[i])
+	if existing then
+		return {0, existing}
+	end
+end
+for i = 4, #KEYS do
+	redis.call('SET', KEYS[i], ARGV[1], 'PX', ARGV[i])
+end
+if ARGV[3] ~= '0' then
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[2])
+	redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+else
+	redis.call('RPUSH', KEYS[1], ARGV[2])
+end
+return {1, ARGV[1]}
+`)
+
+// guardKeys returns the keys that must not already exist for task to be
+// enqueued, with the TTL of each. Both are scoped to the task's tenant, so
+// one tenant's sends never suppress another's.
+func (q *RedisQueue) guardKeys(task EmailTask) ([]string, []interface{}, error) {
+	var keys []string
+	var ttls []interface{}
+
+	if task.IdempotencyKey != "" && q.idempotencyTTL > 0 {
+		keys = append(keys, tenantKey(idempotencyPrefix, task.Tenant)+":"+task.IdempotencyKey)
+		ttls = append(ttls, q.idempotencyTTL.Milliseconds())
+	}
+
+	if q.dedupWindow > 0 {
+		fingerprint, err := taskFingerprint(task)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, tenantKey(dedupPrefix, task.Tenant)+":"+fingerprint)
+		ttls = append(ttls, q.dedupWindow.Milliseconds())
+	}
+
+	return keys, ttls, nil
+}
+
+// tenantKey adds tenant to a key prefix. Tasks without a tenant keep the
+// bare prefix, so keys written before tenants existed still match.
+func tenantKey(prefix, tenant string) string {
+	if tenant == "" {
+		return prefix
+	}
+	return prefix + ":tenant:" + tenant
+}
+
+// taskFingerprint hashes what makes two tasks the same email. Map keys are
+// marshalled in sorted order, so equal data always hashes the same.
+func taskFingerprint(task EmailTask) (string, error) {
+	content, err := json.Marshal(struct {
+		To           string                 `json:"to"`
+		Recipients   []string               `json:"recipients,omitempty"`
+		Subject      string                 `json:"subject"`
+		TemplateName string                 `json:"templateName"`
+		Data         map[string]interface{} `json:"data"`
+		Queue        string                 `json:"queue"`
+		Body         string                 `json:"body"`
+	}{
+		To:           strings.ToLower(task.To),
+		Recipients:   task.Recipients,
+		Subject:      task.Subject,
+		TemplateName: task.TemplateName,
+		Data:         task.Data,
+		Queue:        task.Queue,
+		Body:         task.Body,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint email task: %w", err)
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// pushTask enqueues taskJSON, or schedules it for its sendAt, unless a
+// guard key shows it is a duplicate, in which case it returns the ID of the
+// job that claimed the key.
+func (q *RedisQueue) pushTask(ctx context.Context, task EmailTask, taskJSON []byte, guards []string, ttls []interface{}) (string, bool, error) {
+	var sendAt int64
+	if task.SendAt != nil {
+		sendAt = task.SendAt.UnixMilli()
+	}
+	keys := append([]string{q.priorityKey(task.Queue, task.Priority), delayedQueue, delayedJobsKey}, guards...)
+	args := append([]interface{}{task.ID, taskJSON, sendAt}, ttls...)
+
+	result, err := enqueueScript.Run(ctx, q.client, keys, args...).Slice()
+	if err != nil {
+		return "", false, err
+	}
+	if len(result) != 2 {
+		return "", false, fmt.Errorf("unexpected enqueue result")
+	}
+
+	pushed, _ := result[0].(int64)
+	jobID, _ := result[1].(string)
+	return jobID, pushed == 1, nil
+}
+
+// ClaimWebhookToken records token, the one-time token of a signed webhook
+// from source, for ttl, and reports whether this is the first time it was
+// seen. A false result means the webhook is a replay.
+func (q *RedisQueue) ClaimWebhookToken(ctx context.Context, source, token string, ttl time.Duration) (bool, error) {
+	claimed, err := q.client.SetNX(ctx, webhookTokenPrefix+source+":"+token, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook token: %w", err)
+	}
+	return claimed, nil
+}
+
+// ReleaseWebhookToken forgets a token claimed with ClaimWebhookToken, so
+// the provider's retry of a webhook that failed to process is accepted.
+func (q *RedisQueue) ReleaseWebhookToken(ctx context.Context, source, token string) error {
+	return q.client.Del(ctx, webhookTokenPrefix+source+":"+token).Err()
+}
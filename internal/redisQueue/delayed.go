@@ -0,0 +1,152 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	delayedQueue = "email_delayed_queue"
+
+	// delayedJobsKey maps the job IDs of delayed tasks to their ZSET
+	// members, so one can be canceled without scanning the ZSET.
+	delayedJobsKey = "email_delayed_jobs"
+
+	delayedPromoteBatch = 100
+)
+
+// promoteDelayedScript atomically moves due tasks from the delayed ZSET
+// onto the list of the queue and priority they belong to, so a crash
+// between the two steps can never drop or duplicate a task.
+var promoteDelayedScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, member in ipairs(due) do
+	local ok, task = pcall(cjson.decode, member)
+	local name = ARGV[3]
+	if ok and type(task) == 'table' and type(task['queue']) == 'string' and task['queue'] ~= '' then
+		name = task['queue']
+	end
+	local key = ARGV[4] .. ':' .. name
+	if name == ARGV[3] then
+		key = ARGV[4]
+	end
+	if ok and type(task) == 'table' and (task['priority'] == 'high' or task['priority'] == 'low') then
+		key = key .. ':priority:' .. task['priority']
+	end
+	if ok and type(task) == 'table' and type(task['id']) == 'string' then
+		redis.call('HDEL', KEYS[2], task['id'])
+	end
+	redis.call('ZREM', KEYS[1], member)
+	redis.call('RPUSH', key, member)
+end
+return #due
+`)
+
+// cancelScheduledScript removes the delayed task of job ARGV[1] from the
+// ZSET (KEYS[1]) through its index (KEYS[2]). It returns the task when it
+// was still delayed, and nothing when it had already been promoted.
+var cancelScheduledScript = redis.NewScript(`
+local member = redis.call('HGET', KEYS[2], ARGV[1])
+if not member then
+	return false
+end
+redis.call('HDEL', KEYS[2], ARGV[1])
+if redis.call('ZREM', KEYS[1], member) == 0 then
+	return false
+end
+return member
+`)
+
+// ErrNotScheduled is returned when canceling a job that is no longer
+// waiting in the delayed ZSET: it was never scheduled, or has already been
+// moved to its queue to be sent.
+var ErrNotScheduled = errors.New("job is not scheduled")
+
+// scheduleTask parks a task in the delayed ZSET until runAt.
+func (q *RedisQueue) scheduleTask(ctx context.Context, task EmailTask, runAt time.Time) error {
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to serialize email task: %w", err)
+	}
+
+	pipe := q.client.TxPipeline()
+	pipe.ZAdd(ctx, delayedQueue, &redis.Z{
+		Score:  float64(runAt.UnixMilli()),
+		Member: taskJSON,
+	})
+	pipe.HSet(ctx, delayedJobsKey, task.ID, taskJSON)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to schedule email task: %w", err)
+	}
+
+	return nil
+}
+
+// CancelScheduled removes a scheduled or retry-waiting job from the delayed
+// ZSET before it is moved to its queue, recording it as canceled. It
+// returns ErrNotScheduled when the job was not waiting there, including
+// when the promoter won the race to move it.
+func (q *RedisQueue) CancelScheduled(ctx context.Context, id string) error {
+	taskJSON, err := cancelScheduledScript.Run(ctx, q.client, []string{delayedQueue, delayedJobsKey}, id).Text()
+	if err == redis.Nil {
+		return ErrNotScheduled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to cancel scheduled job: %w", err)
+	}
+
+	var task EmailTask
+	if err := json.Unmarshal([]byte(taskJSON), &task); err != nil {
+		return fmt.Errorf("task deserialization error: %w", err)
+	}
+	q.logger.Info("Scheduled email canceled", "id", task.ID, "to", task.To, "queue", task.Queue)
+	q.dropCanceled(ctx, task)
+	return nil
+}
+
+// RunDelayedPromoter moves due delayed tasks onto their queues until ctx is
+// cancelled. Only the elected leader runs it.
+func (q *RedisQueue) RunDelayedPromoter(ctx context.Context) {
+	ticker := time.NewTicker(queueCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.promoteDueTasks(ctx); err != nil && ctx.Err() == nil {
+				q.logger.Error("Delayed task promotion error", "error", err)
+			}
+		}
+	}
+}
+
+func (q *RedisQueue) promoteDueTasks(ctx context.Context) error {
+	for {
+		promoted, err := promoteDelayedScript.Run(ctx, q.client,
+			[]string{delayedQueue, delayedJobsKey},
+			strconv.FormatInt(time.Now().UnixMilli(), 10),
+			delayedPromoteBatch,
+			q.defaultQueue,
+			emailQueue,
+		).Int()
+		if err != nil {
+			return fmt.Errorf("failed to promote delayed tasks: %w", err)
+		}
+
+		if promoted > 0 {
+			q.logger.Debug("Promoted delayed tasks", "count", promoted)
+		}
+
+		if promoted < delayedPromoteBatch {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+)
+
+// ErrTaskExpired is returned when a task is enqueued with an expiry that
+// has already passed.
+var ErrTaskExpired = errors.New("task expiry is in the past")
+
+// expire drops a task that was not sent before its expiresAt, e.g. a
+// one-time code held up by an outage, and dead-letters it for inspection.
+// Its job status becomes expired rather than failed.
+func (q *RedisQueue) expire(ctx context.Context, task EmailTask) {
+	expiredErr := fmt.Errorf("task expired at %s", task.ExpiresAt.Format(time.RFC3339))
+	q.logger.Warn("Task expired, dropping email",
+		"id", task.ID,
+		"requestId", task.RequestID,
+		"to", task.To,
+		"subject", task.Subject,
+		"queue", task.Queue,
+		"expiresAt", task.ExpiresAt,
+	)
+
+	q.publish(ctx, events.TypeExpired, task, expiredErr)
+	q.recordBatch(ctx, task, "expired")
+	if err := q.deadLetter(ctx, task, expiredErr); err != nil {
+		q.logger.Error("Failed to dead-letter expired email", "id", task.ID, "error", err)
+	}
+}
+
+// extendExpiry moves an expiry set from the queue TTL to a TTL after due,
+// when a deferral, hold, overflow or reschedule makes the task wait on
+// purpose: the TTL only counts time spent waiting to be sent. Expiries the
+// sender set are kept.
+func (q *RedisQueue) extendExpiry(task *EmailTask, due time.Time) {
+	qc, ok := q.queues[task.Queue]
+	if !task.TTLExpiry || !ok || qc.TaskTTL <= 0 {
+		return
+	}
+	if expiresAt := due.Add(qc.TaskTTL).UTC(); task.ExpiresAt == nil || expiresAt.After(*task.ExpiresAt) {
+		task.ExpiresAt = &expiresAt
+	}
+}
@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	historyKeyPrefix = "history:"
+
+	// historyMaxEntries caps how many jobs are remembered per recipient.
+	historyMaxEntries = 1000
+
+	// jobIndexKey orders every job by the time it was queued, for listing
+	// jobs across recipients.
+	jobIndexKey = "job_index"
+
+	// jobIndexMaxEntries caps the job index between retention cleanups.
+	jobIndexMaxEntries = 1000000
+)
+
+func historyKey(recipient string) string {
+	return historyKeyPrefix + strings.ToLower(recipient)
+}
+
+// recordHistory indexes a newly queued job under its recipient and in the
+// job index.
+func (q *RedisQueue) recordHistory(ctx context.Context, pipe redis.Pipeliner, task EmailTask) {
+	entry := &redis.Z{Score: float64(time.Now().UnixMilli()), Member: task.ID}
+
+	key := historyKey(task.To)
+	pipe.ZAdd(ctx, key, entry)
+	pipe.ZRemRangeByRank(ctx, key, 0, -historyMaxEntries-1)
+	if q.jobStatusTTL > 0 {
+		pipe.Expire(ctx, key, q.jobStatusTTL)
+	}
+
+	pipe.ZAdd(ctx, jobIndexKey, entry)
+	pipe.ZRemRangeByRank(ctx, jobIndexKey, 0, -jobIndexMaxEntries-1)
+}
+
+// CleanupHistory drops history entries queued before the cutoff from every
+// recipient's index, the job index and the batch index.
+func (q *RedisQueue) CleanupHistory(ctx context.Context, before time.Time) (int64, error) {
+	cutoff := "(" + strconv.FormatInt(before.UnixMilli(), 10)
+	removed, err := q.client.ZRemRangeByScore(ctx, jobIndexKey, "-inf", cutoff).Result()
+	if err != nil {
+		return removed, err
+	}
+	if _, err := q.client.ZRemRangeByScore(ctx, batchIndexKey, "-inf", cutoff).Result(); err != nil {
+		return removed, err
+	}
+
+	iter := q.client.Scan(ctx, 0, historyKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		n, err := q.client.ZRemRangeByScore(ctx, iter.Val(), "-inf", cutoff).Result()
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, iter.Err()
+}
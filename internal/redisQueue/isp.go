@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+)
+
+// ispThrottle limits the sends of this instance to one mailbox provider,
+// across every queue and worker.
+type ispThrottle struct {
+	name    string
+	slots   chan struct{} // nil when concurrency is not capped
+	limiter *rateLimiter
+}
+
+// newISPThrottles indexes a throttle per ISP by each of its domains.
+func newISPThrottles(isps []config.ISPConfig) map[string]*ispThrottle {
+	throttles := make(map[string]*ispThrottle)
+	for _, isp := range isps {
+		throttle := &ispThrottle{name: isp.Name, limiter: newRateLimiter(isp.RateLimit)}
+		if isp.Concurrency > 0 {
+			throttle.slots = make(chan struct{}, isp.Concurrency)
+		}
+		for _, domain := range isp.Domains {
+			throttles[strings.ToLower(domain)] = throttle
+		}
+	}
+	return throttles
+}
+
+// throttle waits until the recipient's ISP allows another send and returns
+// the function that frees its slot once the send is done. Recipients at
+// domains outside every ISP bucket are not throttled.
+func (q *RedisQueue) throttle(ctx context.Context, recipient string) (func(), error) {
+	_, domain, _ := strings.Cut(recipient, "@")
+	throttle, ok := q.ispThrottles[strings.ToLower(domain)]
+	if !ok {
+		return func() {}, nil
+	}
+
+	release := func() {}
+	if throttle.slots != nil {
+		select {
+		case throttle.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		release = func() { <-throttle.slots }
+	}
+
+	if err := throttle.limiter.Wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// returnISPSlot gives back the rate slot throttle took for a send to the
+// recipient that did not happen.
+func (q *RedisQueue) returnISPSlot(recipient string) {
+	_, domain, _ := strings.Cut(recipient, "@")
+	if throttle, ok := q.ispThrottles[strings.ToLower(domain)]; ok {
+		throttle.limiter.Return()
+	}
+}
@@ -0,0 +1,50 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+var redisSeconds = metrics.NewHistogram(
+	"mailqueue_redis_command_seconds",
+	"Redis round trips by command; pipelines and transactions are observed once, as pipeline and multi. Blocking pops include their wait.",
+	[]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 5},
+	"command",
+)
+
+type latencyStartKey struct{}
+
+// latencyHook times every round trip of the Redis client into
+// mailqueue_redis_command_seconds.
+type latencyHook struct{}
+
+func (latencyHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, latencyStartKey{}, time.Now()), nil
+}
+
+func (latencyHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(latencyStartKey{}).(time.Time); ok {
+		redisSeconds.Observe(time.Since(start).Seconds(), cmd.Name())
+	}
+	return nil
+}
+
+func (latencyHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, latencyStartKey{}, time.Now()), nil
+}
+
+func (latencyHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	start, ok := ctx.Value(latencyStartKey{}).(time.Time)
+	if !ok {
+		return nil
+	}
+	command := "pipeline"
+	if len(cmds) > 0 && cmds[0].Name() == "multi" {
+		command = "multi"
+	}
+	redisSeconds.Observe(time.Since(start).Seconds(), command)
+	return nil
+}
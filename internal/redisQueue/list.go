@@ -0,0 +1,343 @@
+package queue
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// listScanChunk is how many index entries are loaded per round trip
+	// while filling a page.
+	listScanChunk = 200
+
+	// maxListScan bounds the entries examined for one page, so a filter
+	// matching little of a large index cannot scan all of it. A page that
+	// hits the bound may be short; its cursor continues the scan.
+	maxListScan = 5000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the last item of a page: its position in time and an ID that
+// orders items sharing a millisecond. Pages continue strictly after it, so
+// items added or removed meanwhile do not shift later pages.
+type Cursor struct {
+	At int64
+	ID string
+}
+
+// String encodes the cursor as an opaque token for API responses.
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.At, 10) + ":" + c.ID))
+}
+
+// ParseCursor decodes a token returned by Cursor.String.
+func ParseCursor(token string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	at, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	millis, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{At: millis, ID: id}, nil
+}
+
+// after reports whether the item at (at, id) comes after the cursor in the
+// listing order.
+func (c *Cursor) after(at int64, id string, oldest bool) bool {
+	if c == nil {
+		return true
+	}
+	if oldest {
+		return at > c.At || at == c.At && id > c.ID
+	}
+	return at < c.At || at == c.At && id < c.ID
+}
+
+// ListQuery selects one page of jobs, dead letters or campaigns created in
+// [From, Until], newest first unless Oldest is set. Zero times leave that end
+// open and empty filters match everything; filters that do not apply to a
+// listing are ignored by it. A Tenant limits every listing to that tenant's
+// items.
+type ListQuery struct {
+	Tenant    string
+	Recipient string
+	Status    string
+	Template  string
+	Domain    string
+	Tag       string
+	Metadata  map[string]string
+	From      time.Time
+	Until     time.Time
+	Oldest    bool
+	After     *Cursor
+	Limit     int
+}
+
+func (l ListQuery) matchesTenant(tenant string) bool {
+	return l.Tenant == "" || tenant == l.Tenant
+}
+
+func (l ListQuery) matchesRecipient(recipient string) bool {
+	if l.Recipient != "" && !strings.EqualFold(recipient, l.Recipient) {
+		return false
+	}
+	return l.Domain == "" || strings.HasSuffix(strings.ToLower(recipient), "@"+strings.ToLower(l.Domain))
+}
+
+// matchesLabels reports whether an email carries Tag and every Metadata
+// pair of the query.
+func (l ListQuery) matchesLabels(tags []string, metadata map[string]string) bool {
+	if l.Tag != "" && !slices.Contains(tags, l.Tag) {
+		return false
+	}
+	for key, value := range l.Metadata {
+		if got, ok := metadata[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (l ListQuery) inRange(at time.Time) bool {
+	return (l.From.IsZero() || !at.Before(l.From)) && (l.Until.IsZero() || !at.After(l.Until))
+}
+
+// page collects up to Limit items, and the cursor of the last one once an
+// item beyond Limit shows more follow.
+type page[T any] struct {
+	limit int
+	items []T
+	last  Cursor
+	next  *Cursor
+}
+
+// add appends an item at position and reports whether the page is full.
+func (p *page[T]) add(item T, position Cursor) bool {
+	if len(p.items) == p.limit {
+		p.next = &p.last
+		return true
+	}
+	p.items = append(p.items, item)
+	p.last = position
+	return false
+}
+
+// scanIndex walks a sorted set of IDs scored by creation time in
+// milliseconds, in query order from just after query.After and within
+// [From, Until], handing each chunk to visit until it reports the page
+// full. When it stops at maxListScan with entries left, it returns the
+// position of the last entry examined so the next page continues there.
+func (q *RedisQueue) scanIndex(ctx context.Context, key string, query ListQuery, visit func([]redis.Z) bool) (*Cursor, error) {
+	lower, upper := "-inf", "+inf"
+	if !query.From.IsZero() {
+		lower = strconv.FormatInt(query.From.UnixMilli(), 10)
+	}
+	if !query.Until.IsZero() {
+		upper = strconv.FormatInt(query.Until.UnixMilli(), 10)
+	}
+	if after := query.After; after != nil {
+		bound := strconv.FormatInt(after.At, 10)
+		if query.Oldest {
+			lower = bound
+		} else {
+			upper = bound
+		}
+	}
+
+	var offset int64
+	for scanned := 0; ; {
+		by := &redis.ZRangeBy{Min: lower, Max: upper, Offset: offset, Count: listScanChunk}
+		var entries []redis.Z
+		var err error
+		if query.Oldest {
+			entries, err = q.client.ZRangeByScoreWithScores(ctx, key, by).Result()
+		} else {
+			entries, err = q.client.ZRevRangeByScoreWithScores(ctx, key, by).Result()
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(len(entries))
+
+		wanted := entries[:0:0]
+		for _, entry := range entries {
+			if query.After.after(int64(entry.Score), entry.Member.(string), query.Oldest) {
+				wanted = append(wanted, entry)
+			}
+		}
+		if len(wanted) > 0 && visit(wanted) {
+			return nil, nil
+		}
+
+		scanned += len(entries)
+		if len(entries) < listScanChunk {
+			return nil, nil
+		}
+		if scanned >= maxListScan {
+			last := entries[len(entries)-1]
+			return &Cursor{At: int64(last.Score), ID: last.Member.(string)}, nil
+		}
+	}
+}
+
+// Jobs returns one page of jobs and the cursor of the next page, if any.
+// With a Recipient set it reads that recipient's history instead of the
+// index of every job. Jobs whose status records have expired are skipped.
+func (q *RedisQueue) Jobs(ctx context.Context, query ListQuery) ([]JobStatus, *Cursor, error) {
+	key := jobIndexKey
+	if query.Recipient != "" {
+		key = historyKey(query.Recipient)
+	}
+
+	p := &page[JobStatus]{limit: query.Limit, items: []JobStatus{}}
+	var loadErr error
+	stop, err := q.scanIndex(ctx, key, query, func(entries []redis.Z) bool {
+		ids := make([]string, len(entries))
+		scores := make(map[string]int64, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.Member.(string)
+			scores[ids[i]] = int64(entry.Score)
+		}
+		statuses, err := q.JobStatuses(ctx, ids)
+		if err != nil {
+			loadErr = err
+			return true
+		}
+		for _, status := range statuses {
+			if !query.matchesTenant(status.Tenant) ||
+				query.Status != "" && status.Status != query.Status ||
+				query.Template != "" && status.Template != query.Template ||
+				!query.matchesRecipient(status.Recipient) ||
+				!query.matchesLabels(status.Tags, status.Metadata) {
+				continue
+			}
+			if p.add(status, Cursor{At: scores[status.ID], ID: status.ID}) {
+				return true
+			}
+		}
+		return false
+	})
+	if err == nil {
+		err = loadErr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	if p.next == nil {
+		p.next = stop
+	}
+	return p.items, p.next, nil
+}
+
+// DeadLetters returns one page of dead-lettered tasks, ordered by when they
+// failed, and the cursor of the next page, if any.
+func (q *RedisQueue) DeadLetters(ctx context.Context, query ListQuery) ([]DeadLetter, *Cursor, error) {
+	if err := q.migrateDeadLetters(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	p := &page[DeadLetter]{limit: query.Limit, items: []DeadLetter{}}
+	var loadErr error
+	stop, err := q.scanIndex(ctx, deadLetterIndexKey, query, func(entries []redis.Z) bool {
+		ids := make([]string, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.Member.(string)
+		}
+		raw, err := q.client.HMGet(ctx, deadLettersKey, ids...).Result()
+		if err != nil {
+			loadErr = err
+			return true
+		}
+		for i, value := range raw {
+			entryJSON, ok := value.(string)
+			if !ok {
+				continue
+			}
+			var entry DeadLetter
+			if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+				continue
+			}
+			if !query.matchesTenant(entry.Task.Tenant) ||
+				query.Template != "" && entry.Task.TemplateName != query.Template ||
+				!query.matchesRecipient(entry.Task.To) ||
+				!query.matchesLabels(entry.Task.Tags, entry.Task.Metadata) {
+				continue
+			}
+			if p.add(entry, Cursor{At: int64(entries[i].Score), ID: ids[i]}) {
+				return true
+			}
+		}
+		return false
+	})
+	if err == nil {
+		err = loadErr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	if p.next == nil {
+		p.next = stop
+	}
+	return p.items, p.next, nil
+}
+
+// Campaigns returns one page of batches, ordered by when their first task
+// was queued, and the cursor of the next page, if any. Status filters on
+// running, completed or canceled; batches whose progress records expired
+// are skipped.
+func (q *RedisQueue) Campaigns(ctx context.Context, query ListQuery) ([]BatchProgress, *Cursor, error) {
+	p := &page[BatchProgress]{limit: query.Limit, items: []BatchProgress{}}
+	var loadErr error
+	stop, err := q.scanIndex(ctx, batchIndexKey, query, func(entries []redis.Z) bool {
+		pipe := q.client.Pipeline()
+		cmds := make([]*redis.StringStringMapCmd, len(entries))
+		for i, entry := range entries {
+			cmds[i] = pipe.HGetAll(ctx, batchKeyPrefix+entry.Member.(string))
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			loadErr = err
+			return true
+		}
+		for i, entry := range entries {
+			fields := cmds[i].Val()
+			if len(fields) == 0 {
+				continue
+			}
+			progress := batchProgress(entry.Member.(string), fields)
+			if !query.matchesTenant(progress.Tenant) ||
+				query.Status != "" && progress.Status != query.Status {
+				continue
+			}
+			if p.add(*progress, Cursor{At: int64(entry.Score), ID: progress.ID}) {
+				return true
+			}
+		}
+		return false
+	})
+	if err == nil {
+		err = loadErr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list campaigns: %w", err)
+	}
+	if p.next == nil {
+		p.next = stop
+	}
+	return p.items, p.next, nil
+}
@@ -0,0 +1,45 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+const bodyBlobPrefix = "bodies/"
+
+// offloadBody moves a pre-rendered body above the configured threshold to
+// the object store, leaving only its reference in the task so large
+// campaigns don't fill Redis memory.
+func (q *RedisQueue) offloadBody(ctx context.Context, task *EmailTask) error {
+	if q.bodyOffloadThreshold <= 0 || len(task.Body) <= q.bodyOffloadThreshold || !q.store.CanStore() {
+		return nil
+	}
+
+	ref, err := q.store.Put(ctx, bodyBlobPrefix+randomHex(16)+".html", []byte(task.Body), "text/html; charset=UTF-8")
+	if err != nil {
+		return fmt.Errorf("failed to offload email body: %w", err)
+	}
+
+	task.BodyRef = ref
+	task.Body = ""
+	return nil
+}
+
+// releaseBody deletes an offloaded body once the task no longer needs it.
+func (q *RedisQueue) releaseBody(ctx context.Context, task EmailTask) {
+	if task.BodyRef == "" {
+		return
+	}
+
+	if err := q.store.Delete(ctx, task.BodyRef); err != nil {
+		q.logger.Warn("Failed to delete offloaded email body", "ref", task.BodyRef, "error", err)
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
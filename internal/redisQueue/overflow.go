@@ -0,0 +1,148 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+const (
+	// overflowQueue holds the tasks popped while no SMTP account was in
+	// rotation, with SMTP_OVERFLOW on.
+	overflowQueue = "email_overflow"
+
+	overflowReleaseBatch = 100
+)
+
+var (
+	overflowHeld = metrics.NewCounter(
+		"mailqueue_overflow_held_total",
+		"Tasks moved to the overflow queue because no SMTP account was in rotation, by queue.",
+		"queue",
+	)
+	overflowReleased = metrics.NewCounter(
+		"mailqueue_overflow_released_total",
+		"Tasks released from the overflow queue once an SMTP account was back in rotation.",
+	)
+	overflowDepth = metrics.NewGauge(
+		"mailqueue_overflow_depth",
+		"Tasks waiting in the overflow queue.",
+	)
+)
+
+// releaseOverflowScript atomically moves up to ARGV[2] tasks from the head
+// of the overflow list (KEYS[1]) into the delayed ZSET (KEYS[2]) and its
+// index (KEYS[3]), due at ARGV[1], for the promoter to put back on their
+// queues.
+var releaseOverflowScript = redis.NewScript(`
+local tasks = redis.call('LRANGE', KEYS[1], 0, tonumber(ARGV[2]) - 1)
+if #tasks == 0 then
+	return 0
+end
+redis.call('LTRIM', KEYS[1], #tasks, -1)
+for _, member in ipairs(tasks) do
+	redis.call('ZADD', KEYS[2], ARGV[1], member)
+	local ok, task = pcall(cjson.decode, member)
+	if ok and type(task) == 'table' and type(task['id']) == 'string' then
+		redis.call('HSET', KEYS[3], task['id'], member)
+	end
+end
+return #tasks
+`)
+
+// overflow holds a task in the overflow queue until an SMTP account is back
+// in rotation. It does not count as an attempt.
+func (q *RedisQueue) overflow(ctx context.Context, task EmailTask) error {
+	task.Overflowed = true
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to serialize email task: %w", err)
+	}
+	if err := q.client.RPush(ctx, overflowQueue, taskJSON).Err(); err != nil {
+		return fmt.Errorf("failed to move email task to the overflow queue: %w", err)
+	}
+
+	overflowHeld.Inc(task.Queue)
+	q.logger.Info("No SMTP account in rotation, holding email in the overflow queue",
+		"id", task.ID,
+		"queue", task.Queue,
+	)
+	q.publish(ctx, events.TypeOverflowed, task, nil)
+	return nil
+}
+
+// runOverflowReleaser releases overflowed tasks every second while this
+// instance has an SMTP account in rotation, until ctx is cancelled. Every
+// worker instance runs it, since account health is tracked per instance,
+// and each only releases what it can send itself, so instances without an
+// account don't just overflow the tasks again.
+func (q *RedisQueue) runOverflowReleaser(ctx context.Context) {
+	ticker := time.NewTicker(queueCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.releaseOverflow(ctx); err != nil && ctx.Err() == nil {
+				q.logger.Error("Overflow release error", "error", err)
+			}
+		}
+	}
+}
+
+func (q *RedisQueue) releaseOverflow(ctx context.Context) error {
+	depth, err := q.client.LLen(ctx, overflowQueue).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read overflow depth: %w", err)
+	}
+	overflowDepth.Set(float64(depth))
+	if depth == 0 || !q.sender.Available() {
+		return nil
+	}
+
+	released, err := releaseOverflowScript.Run(ctx, q.client,
+		[]string{overflowQueue, delayedQueue, delayedJobsKey},
+		strconv.FormatInt(time.Now().UnixMilli(), 10),
+		q.overflowReleaseCount(),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("failed to release overflowed tasks: %w", err)
+	}
+
+	if released > 0 {
+		overflowReleased.Add(float64(released))
+		overflowDepth.Set(float64(depth - int64(released)))
+		q.logger.Info("Released overflowed emails", "count", released, "remaining", depth-int64(released))
+	}
+	return nil
+}
+
+// overflowReleaseCount is how many overflowed tasks this instance releases
+// per queueCheckInterval: what its workers can take on, one task each, and
+// no more than the rate of its SMTP accounts in rotation allows, capped at
+// overflowReleaseBatch.
+func (q *RedisQueue) overflowReleaseCount() int {
+	q.poolsMu.Lock()
+	workers := 0
+	for _, pool := range q.pools {
+		pool.mu.Lock()
+		workers += len(pool.cancels)
+		pool.mu.Unlock()
+	}
+	q.poolsMu.Unlock()
+
+	count := min(max(workers, 1), overflowReleaseBatch)
+	if rate := q.sender.AvailableRate(); rate > 0 {
+		count = min(count, max(int(math.Ceil(rate*queueCheckInterval.Seconds())), 1))
+	}
+	return count
+}
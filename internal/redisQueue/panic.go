@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+var workerPanics = metrics.NewCounter(
+	"mailqueue_worker_panics_total",
+	"Tasks whose processing panicked, by queue.",
+	"queue",
+)
+
+// recoverTask recovers a panic while processing a task, so one bad task
+// cannot take the worker down. The panic is logged with its stack and the
+// task, and the task is dead-lettered rather than retried, since it would
+// most likely panic again. It is deferred by processNextTask and sets its
+// error.
+func (q *RedisQueue) recoverTask(ctx context.Context, qc config.QueueConfig, task *EmailTask, taskJSON string, errp *error) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	panicErr := fmt.Errorf("panic while processing task: %v", recovered)
+	workerPanics.Inc(qc.Name)
+	q.logger.Error("Task processing panic",
+		"id", task.ID,
+		"requestId", task.RequestID,
+		"to", task.To,
+		"template", task.TemplateName,
+		"batch", task.BatchID,
+		"queue", qc.Name,
+		"worker", q.instanceID,
+		"attempt", task.Retries+1,
+		"panic", recovered,
+		"stack", string(debug.Stack()),
+	)
+
+	if task.ID == "" {
+		// The panic came before the task was decoded; there is nothing to
+		// dead-letter but the log entry.
+		q.logger.Error("Dropped undecoded task after panic", "queue", qc.Name, "task", taskJSON)
+		*errp = panicErr
+		return
+	}
+	q.publish(ctx, events.TypeFailed, *task, panicErr)
+	q.recordBatch(ctx, *task, "failed")
+	if dlqErr := q.deadLetter(ctx, *task, panicErr); dlqErr != nil {
+		*errp = fmt.Errorf("failed to dead-letter email: %w (original error: %v)", dlqErr, panicErr)
+		return
+	}
+	q.publish(ctx, events.TypeDeadLettered, *task, panicErr)
+	*errp = panicErr
+}
@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+)
+
+// workerPool runs the workers of one queue. Its size and rate limit can be
+// changed while it runs; a worker that is stopped finishes the send it is
+// on first.
+type workerPool struct {
+	queue   *RedisQueue
+	config  config.QueueConfig
+	limiter *rateLimiter
+	ctx     context.Context
+	wg      *sync.WaitGroup
+
+	mu      sync.Mutex
+	cancels []context.CancelFunc
+}
+
+func (p *workerPool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ctx.Err() != nil {
+		return
+	}
+
+	for len(p.cancels) < n {
+		ctx, cancel := context.WithCancel(p.ctx)
+		p.cancels = append(p.cancels, cancel)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.queue.runWorker(ctx, p.config, p.limiter)
+		}()
+	}
+
+	for len(p.cancels) > n {
+		last := len(p.cancels) - 1
+		p.cancels[last]()
+		p.cancels = p.cancels[:last]
+	}
+}
+
+// Reconfigure applies new concurrency and rate limits to running queues.
+// Queues that are not running yet are left out; adding a queue needs a
+// restart.
+func (q *RedisQueue) Reconfigure(queues []config.QueueConfig) {
+	q.poolsMu.Lock()
+	defer q.poolsMu.Unlock()
+
+	for _, qc := range queues {
+		pool, ok := q.pools[qc.Name]
+		if !ok {
+			q.logger.Warn("Ignoring reload of unknown queue; restart to add it", "queue", qc.Name)
+			continue
+		}
+
+		pool.limiter.SetRate(qc.RateLimit)
+		pool.resize(max(qc.Concurrency, 1))
+		q.logger.Info("Queue workers reconfigured",
+			"queue", qc.Name,
+			"concurrency", qc.Concurrency,
+			"rateLimit", qc.RateLimit,
+		)
+	}
+}
+
+// SetMaxSendRate changes the deployment-wide send rate cap; 0 disables it.
+func (q *RedisQueue) SetMaxSendRate(perSecond float64) {
+	q.sendLimiter.SetRate(perSecond)
+	q.logger.Info("Send rate cap reconfigured", "maxSendRate", perSecond)
+}
@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Task priorities. Each queue keeps one list per priority and its workers
+// always take from the highest non-empty one, so high priority mail skips
+// ahead of a backlog. Tasks without a priority are normal.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// priorities lists the priorities in the order workers take from them.
+var priorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+// ValidPriority reports whether p names a priority; "" means normal.
+func ValidPriority(p string) bool {
+	return p == "" || p == PriorityHigh || p == PriorityNormal || p == PriorityLow
+}
+
+// priorityKey maps a queue and priority to its Redis list. Normal priority
+// uses the queue's original list, so tasks enqueued before priorities
+// existed are still consumed.
+func (q *RedisQueue) priorityKey(name, priority string) string {
+	if priority == PriorityHigh || priority == PriorityLow {
+		return q.queueKey(name) + ":priority:" + priority
+	}
+	return q.queueKey(name)
+}
+
+// taskPriority reads the priority of a serialized task without decoding
+// all of it.
+func taskPriority(taskJSON string) string {
+	var task struct {
+		Priority string `json:"priority"`
+	}
+	json.Unmarshal([]byte(taskJSON), &task)
+	return task.Priority
+}
+
+// popTask moves the next task of the queue onto this instance's processing
+// list, taking from the high, normal and low lists in that order. When all
+// are empty it blocks on the normal list for up to queueCheckInterval, so a
+// high or low priority task reaching an idle queue waits at most that long.
+func (q *RedisQueue) popTask(ctx context.Context, name string) (string, error) {
+	processing := q.processingKey(name)
+	for _, priority := range priorities {
+		taskJSON, err := q.client.LMove(ctx, q.priorityKey(name, priority), processing, "LEFT", "RIGHT").Result()
+		if err == nil {
+			return taskJSON, nil
+		}
+		if err != redis.Nil {
+			return "", err
+		}
+	}
+	return q.client.BLMove(ctx, q.priorityKey(name, PriorityNormal), processing, "LEFT", "RIGHT", queueCheckInterval).Result()
+}
+
+// queueKeys returns every list of the queue, one per priority.
+func (q *RedisQueue) queueKeys(name string) []string {
+	keys := make([]string, 0, len(priorities))
+	for _, priority := range priorities {
+		keys = append(keys, q.priorityKey(name, priority))
+	}
+	return keys
+}
+
+// Depths returns the number of tasks waiting in each queue, over all
+// priorities. Scheduled and in-flight tasks are not counted.
+func (q *RedisQueue) Depths(ctx context.Context) (map[string]int64, error) {
+	pipe := q.client.Pipeline()
+	cmds := make(map[string][]*redis.IntCmd, len(q.queues))
+	for name := range q.queues {
+		for _, key := range q.queueKeys(name) {
+			cmds[name] = append(cmds[name], pipe.LLen(ctx, key))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read queue depths: %w", err)
+	}
+
+	depths := make(map[string]int64, len(cmds))
+	for name, lengths := range cmds {
+		for _, cmd := range lengths {
+			depths[name] += cmd.Val()
+		}
+	}
+	return depths, nil
+}
+
+// normalizePriority returns the priority tasks are stored with: "" for
+// normal, which keeps the field out of most tasks.
+func normalizePriority(p string) string {
+	if p == PriorityNormal {
+		return ""
+	}
+	return p
+}
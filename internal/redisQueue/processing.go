@@ -0,0 +1,132 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const processingPrefix = "email_processing"
+
+// reclaimScript moves every task left in a dead instance's processing list
+// (KEYS[1]) back to the head of its queue's list for its priority (KEYS[2]
+// normal, KEYS[4] high, KEYS[5] low), in their original order. It does
+// nothing while the instance's heartbeat (KEYS[3]) is alive, so a slow send
+// is never reclaimed.
+var reclaimScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return 0
+end
+local moved = 0
+local task = redis.call('RPOP', KEYS[1])
+while task do
+	local key = KEYS[2]
+	local ok, decoded = pcall(cjson.decode, task)
+	if ok and type(decoded) == 'table' then
+		if decoded['priority'] == 'high' then
+			key = KEYS[4]
+		elseif decoded['priority'] == 'low' then
+			key = KEYS[5]
+		end
+	end
+	redis.call('LPUSH', key, task)
+	moved = moved + 1
+	task = redis.call('RPOP', KEYS[1])
+end
+return moved
+`)
+
+// processingKey is the list holding tasks this instance has popped from a
+// queue but not finished with. Each instance has its own, so a crash leaves
+// its in-flight tasks where the reclaimer can find them.
+func (q *RedisQueue) processingKey(queueName string) string {
+	return processingPrefix + ":" + q.instanceID + ":" + queueName
+}
+
+// ack removes a finished task from the processing list.
+func (q *RedisQueue) ack(ctx context.Context, queueName, taskJSON string) {
+	if err := q.client.LRem(ctx, q.processingKey(queueName), 1, taskJSON).Err(); err != nil {
+		q.logger.Error("Failed to remove task from processing list", "queue", queueName, "error", err)
+	}
+}
+
+// requeueInFlight returns a popped but unsent task to the head of its queue
+// so a rolling deploy never drops it. It runs after the worker context is
+// cancelled, so it uses its own.
+func (q *RedisQueue) requeueInFlight(queueName, taskJSON string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, q.processingKey(queueName), 1, taskJSON)
+		pipe.LPush(ctx, q.priorityKey(queueName, taskPriority(taskJSON)), taskJSON)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to requeue in-flight task: %w", err)
+	}
+
+	q.logger.Info("Requeued in-flight task on shutdown", "queue", queueName)
+	return nil
+}
+
+// RunOrphanReclaimer periodically returns tasks held by instances whose
+// heartbeat has expired to their queues. Only the elected leader runs it.
+// A task whose send was cut short by a crash may be delivered twice; a task
+// is never lost.
+func (q *RedisQueue) RunOrphanReclaimer(ctx context.Context) {
+	ticker := time.NewTicker(q.heartbeatTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.reclaimOrphans(ctx); err != nil && ctx.Err() == nil {
+				q.logger.Error("Orphan reclaim error", "error", err)
+			}
+		}
+	}
+}
+
+func (q *RedisQueue) reclaimOrphans(ctx context.Context) error {
+	iter := q.client.Scan(ctx, 0, processingPrefix+":*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		instanceID, queueName, ok := q.parseProcessingKey(key)
+		if !ok {
+			continue
+		}
+
+		moved, err := reclaimScript.Run(ctx, q.client,
+			[]string{key, q.queueKey(queueName), heartbeatKey(instanceID),
+				q.priorityKey(queueName, PriorityHigh), q.priorityKey(queueName, PriorityLow)},
+		).Int()
+		if err != nil {
+			return fmt.Errorf("failed to reclaim tasks from %s: %w", key, err)
+		}
+		if moved > 0 {
+			q.logger.Warn("Reclaimed orphaned tasks", "instance", instanceID, "queue", queueName, "count", moved)
+		}
+	}
+
+	return iter.Err()
+}
+
+// parseProcessingKey splits a processing list key into its instance ID and
+// queue name, matching against the configured queues since either part may
+// contain colons.
+func (q *RedisQueue) parseProcessingKey(key string) (string, string, bool) {
+	rest := strings.TrimPrefix(key, processingPrefix+":")
+	for name := range q.queues {
+		instanceID, found := strings.CutSuffix(rest, ":"+name)
+		if found && instanceID != "" {
+			return instanceID, name, true
+		}
+	}
+	return "", "", false
+}
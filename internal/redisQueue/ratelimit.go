@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces sends evenly so a queue never exceeds its configured
+// rate. The rate can be changed while workers are waiting on it.
+type rateLimiter struct {
+	mu       sync.Mutex
+	ticker   *time.Ticker
+	changed  chan struct{}
+	returned int // ticks given back with Return, handed out before waiting
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	l := &rateLimiter{}
+	l.SetRate(perSecond)
+	return l
+}
+
+// SetRate changes the rate; 0 disables limiting.
+func (l *rateLimiter) SetRate(perSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ticker != nil {
+		l.ticker.Stop()
+		l.ticker = nil
+	}
+	if perSecond > 0 {
+		l.ticker = time.NewTicker(time.Duration(float64(time.Second) / perSecond))
+	}
+
+	// Wake workers waiting on the old ticker
+	if l.changed != nil {
+		close(l.changed)
+	}
+	l.changed = make(chan struct{})
+	l.returned = 0
+}
+
+// Return gives back a tick taken with Wait for a send that did not happen,
+// so the next Wait goes through at once.
+func (l *rateLimiter) Return() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ticker != nil {
+		l.returned++
+	}
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		ticker, changed := l.ticker, l.changed
+		if ticker != nil && l.returned > 0 {
+			l.returned--
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+		l.mu.Unlock()
+
+		if ticker == nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+func (l *rateLimiter) Stop() {
+	l.SetRate(0)
+}
@@ -3,35 +3,123 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	storage "github.com/sarthakyeole/redis-go-mailing-bulk/internal/objectStore"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/pacer"
+	recipient "github.com/sarthakyeole/redis-go-mailing-bulk/internal/recipientCheck"
 	email "github.com/sarthakyeole/redis-go-mailing-bulk/internal/senderSide"
 )
 
 const (
 	emailQueue = "email_queue"
 
-	maxRetries         = 3
-	retryDelay         = 5 * time.Second
 	queueCheckInterval = 1 * time.Second
+
+	// sendRateKey paces MAX_SEND_RATE across every worker instance.
+	sendRateKey = "send_rate"
 )
 
 type EmailTask struct {
-	To           string                 `json:"to"`
-	Subject      string                 `json:"subject"`
-	TemplateName string                 `json:"templateName"`
-	Data         map[string]interface{} `json:"data"`
-	Retries      int                    `json:"retries,omitempty"`
+	Version        int                    `json:"version"`
+	ID             string                 `json:"id,omitempty"`
+	BatchID        string                 `json:"batchId,omitempty"`
+	From           string                 `json:"from,omitempty"`
+	FromName       string                 `json:"fromName,omitempty"`
+	To             string                 `json:"to"`
+	Recipients     []string               `json:"recipients,omitempty"`
+	Subject        string                 `json:"subject"`
+	TemplateName   string                 `json:"templateName"`
+	Data           map[string]interface{} `json:"data"`
+	Queue          string                 `json:"queue,omitempty"`
+	Flags          []string               `json:"flags,omitempty"`
+	Body           string                 `json:"body,omitempty"`
+	BodyRef        string                 `json:"bodyRef,omitempty"`
+	Attachments    []email.Attachment     `json:"attachments,omitempty"`
+	Event          *email.Event           `json:"event,omitempty"`
+	Preheader      string                 `json:"preheader,omitempty"`
+	Locale         string                 `json:"locale,omitempty"`
+	IdempotencyKey string                 `json:"idempotencyKey,omitempty"`
+	Worker         string                 `json:"worker,omitempty"`
+	InReplyTo      string                 `json:"inReplyTo,omitempty"`
+	References     []string               `json:"references,omitempty"`
+	Retries        int                    `json:"retries,omitempty"`
+	SoftBounces    int                    `json:"softBounces,omitempty"`
+	UTM            *email.UTM             `json:"utm,omitempty"`
+	Variants       []Variant              `json:"variants,omitempty"`
+	Variant        string                 `json:"variant,omitempty"`
+	Category       string                 `json:"category,omitempty"`
+	CategorySlot   *time.Time             `json:"categorySlot,omitempty"`
+	Tags           []string               `json:"tags,omitempty"`
+	Metadata       map[string]string      `json:"metadata,omitempty"`
+	Pool           string                 `json:"pool,omitempty"`
+	Priority       string                 `json:"priority,omitempty"`
+	SendAt         *time.Time             `json:"sendAt,omitempty"`
+	ExpiresAt      *time.Time             `json:"expiresAt,omitempty"`
+	TTLExpiry      bool                   `json:"ttlExpiry,omitempty"`
+	Overflowed     bool                   `json:"overflowed,omitempty"`
+	Tenant         string                 `json:"tenant,omitempty"`
+	ApprovedRules  []string               `json:"approvedRules,omitempty"`
+	RequestID      string                 `json:"requestId,omitempty"`
+	TraceParent    string                 `json:"traceparent,omitempty"`
 }
 
 type RedisQueue struct {
-	client *redis.Client
-	sender *email.Sender
-	logger *slog.Logger
+	client       *redis.Client
+	sender       *email.Sender
+	store        *storage.Store
+	recipients   *recipient.Validator
+	events       events.Publisher
+	logger       *slog.Logger
+	queues       map[string]config.QueueConfig
+	defaultQueue string
+
+	deferralDefaultDelay time.Duration
+	deferralMaxDelay     time.Duration
+
+	softBounceDelay      time.Duration
+	softBounceMaxDelay   time.Duration
+	softBounceMaxRetries int
+
+	warmupSchedule []int64
+	warmupStart    time.Time
+	warmupDomain   string
+
+	ispThrottles map[string]*ispThrottle
+	sendLimiter  *pacer.Pacer // MAX_SEND_RATE, shared by every queue and instance
+
+	sendCategories map[string]*sendCategory
+
+	overflowEnabled bool // SMTP_OVERFLOW
+
+	approvalNewTemplates bool
+	approvalRecipients   int // 0 when the recipients rule is off
+
+	bodyOffloadThreshold int
+
+	batchTTL     time.Duration
+	jobStatusTTL time.Duration
+
+	deadLettersMigrated atomic.Bool // the legacy dead-letter list is empty
+
+	instanceID   string
+	heartbeatTTL time.Duration
+	lastPoll     atomic.Int64 // Unix milliseconds a worker loop last polled its queue
+
+	idempotencyTTL time.Duration
+	dedupWindow    time.Duration
+
+	poolsMu sync.Mutex
+	pools   map[string]*workerPool
 }
 
 func NewRedisClient(cfg *config.ApplicationConfig) (*redis.Client, error) {
@@ -50,6 +138,7 @@ func NewRedisClient(cfg *config.ApplicationConfig) (*redis.Client, error) {
 		IdleTimeout:        5 * time.Minute,
 		MaxConnAge:         30 * time.Minute,
 	})
+	client.AddHook(latencyHook{})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -73,33 +162,150 @@ func validateRedisConfig(cfg *config.ApplicationConfig) error {
 	return nil
 }
 
-func NewRedisQueue(client *redis.Client, sender *email.Sender, logger *slog.Logger) *RedisQueue {
+func NewRedisQueue(cfg *config.ApplicationConfig, client *redis.Client, sender *email.Sender, store *storage.Store, recipients *recipient.Validator, publisher events.Publisher, logger *slog.Logger) *RedisQueue {
+	queues := make(map[string]config.QueueConfig, len(cfg.Queues))
+	for _, qc := range cfg.Queues {
+		queues[qc.Name] = qc
+	}
+
 	return &RedisQueue{
-		client: client,
-		sender: sender,
-		logger: logger,
+		client:       client,
+		sender:       sender,
+		store:        store,
+		recipients:   recipients,
+		events:       publisher,
+		logger:       logger,
+		queues:       queues,
+		defaultQueue: cfg.DefaultQueue,
+
+		deferralDefaultDelay: cfg.DeferralDefaultDelay,
+		deferralMaxDelay:     cfg.DeferralMaxDelay,
+
+		softBounceDelay:      cfg.SoftBounceDelay,
+		softBounceMaxDelay:   cfg.SoftBounceMaxDelay,
+		softBounceMaxRetries: cfg.SoftBounceMaxRetries,
+
+		warmupSchedule: cfg.WarmupSchedule,
+		warmupStart:    cfg.WarmupStart,
+		warmupDomain:   sendingDomain(cfg.EmailSenderAddress),
+
+		ispThrottles: newISPThrottles(cfg.ISPs),
+		sendLimiter:  pacer.New(client, sendRateKey, cfg.MaxSendRate),
+
+		sendCategories: newSendCategories(cfg.SendCategories),
+
+		overflowEnabled: cfg.SMTPOverflow,
+
+		approvalNewTemplates: slices.Contains(cfg.ApprovalRules, config.ApprovalNewTemplate),
+		approvalRecipients:   approvalRecipients(cfg),
+
+		bodyOffloadThreshold: cfg.BodyOffloadThreshold,
+
+		batchTTL:     cfg.BatchTTL,
+		jobStatusTTL: cfg.JobStatusTTL,
+
+		instanceID:   cfg.InstanceID,
+		heartbeatTTL: cfg.WorkerHeartbeatTTL,
+
+		idempotencyTTL: cfg.IdempotencyTTL,
+		dedupWindow:    cfg.EnqueueDedupWindow,
 	}
 }
 
-func (q *RedisQueue) EnqueueEmail(ctx context.Context, task EmailTask) error {
-	if err := validateEmailTask(task); err != nil {
-		return fmt.Errorf("invalid email task: %w", err)
+// approvalRecipients is APPROVAL_RECIPIENT_THRESHOLD when APPROVAL_RULES
+// includes recipients, else 0.
+func approvalRecipients(cfg *config.ApplicationConfig) int {
+	if !slices.Contains(cfg.ApprovalRules, config.ApprovalRecipients) {
+		return 0
+	}
+	return cfg.ApprovalRecipientThreshold
+}
+
+// HasQueue reports whether name is a configured queue. An empty name selects
+// the default queue and is always accepted.
+func (q *RedisQueue) HasQueue(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, ok := q.queues[name]
+	return ok
+}
+
+func (q *RedisQueue) DefaultQueue() string {
+	return q.defaultQueue
+}
+
+// queueKey maps a queue name to its Redis list. The default queue keeps the
+// original key so tasks enqueued by older deployments are still consumed.
+func (q *RedisQueue) queueKey(name string) string {
+	if name == q.defaultQueue {
+		return emailQueue
+	}
+	return emailQueue + ":" + name
+}
+
+// EnqueueEmail validates and queues a task and returns its job ID. A task
+// that repeats an idempotency key, or matches one enqueued within the dedup
+// window, is not queued again: the original job ID is returned along with
+// ErrDuplicateTask.
+func (q *RedisQueue) EnqueueEmail(ctx context.Context, task EmailTask) (string, error) {
+	if task.Queue == "" {
+		task.Queue = q.defaultQueue
+	}
+	if task.ID == "" {
+		task.ID = randomHex(16)
+	}
+	if qc, ok := q.queues[task.Queue]; ok && task.ExpiresAt == nil && qc.TaskTTL > 0 {
+		// A scheduled task's TTL starts when it is due.
+		start := time.Now()
+		if task.SendAt != nil && task.SendAt.After(start) {
+			start = *task.SendAt
+		}
+		expiresAt := start.Add(qc.TaskTTL).UTC()
+		task.ExpiresAt = &expiresAt
+		task.TTLExpiry = true
+	}
+
+	if category, ok := q.sendCategories[task.Category]; ok && task.Priority == "" {
+		task.Priority = category.priority
+	}
+
+	if err := q.validateEmailTask(task); err != nil {
+		return "", fmt.Errorf("invalid email task: %w", err)
+	}
+	task.Priority = normalizePriority(task.Priority)
+
+	if err := q.offloadBody(ctx, &task); err != nil {
+		return "", err
 	}
 
 	taskJSON, err := json.Marshal(task)
 	if err != nil {
-		return fmt.Errorf("failed to serialize email task: %w", err)
+		return "", fmt.Errorf("failed to serialize email task: %w", err)
 	}
 
-	if err := q.client.RPush(ctx, emailQueue, taskJSON).Err(); err != nil {
-		return fmt.Errorf("failed to enqueue email task: %w", err)
+	guards, ttls, err := q.guardKeys(task)
+	if err != nil {
+		return "", err
 	}
 
-	q.logger.Info("Email task enqueued", "to", task.To, "subject", task.Subject)
-	return nil
+	jobID, pushed, err := q.pushTask(ctx, task, taskJSON, guards, ttls)
+	if err != nil {
+		return "", fmt.Errorf("failed to enqueue email task: %w", err)
+	}
+	if !pushed {
+		q.releaseBody(ctx, task)
+		q.logger.Info("Duplicate email task skipped", "id", jobID, "to", task.To, "subject", task.Subject, "queue", task.Queue)
+		return jobID, ErrDuplicateTask
+	}
+
+	q.logger.Info("Email task enqueued", "id", task.ID, "to", task.To, "subject", task.Subject, "queue", task.Queue, "priority", task.Priority, "sendAt", task.SendAt)
+	q.publish(ctx, events.TypeQueued, task, nil)
+	q.recordBatch(ctx, task, "queued")
+	return task.ID, nil
 }
 
-func validateEmailTask(task EmailTask) error {
+func (q *RedisQueue) validateEmailTask(task EmailTask) error {
 	if task.To == "" {
 		return fmt.Errorf("recipient email is required")
 	}
@@ -112,28 +318,89 @@ func validateEmailTask(task EmailTask) error {
 		return fmt.Errorf("email template name is required")
 	}
 
+	if _, ok := q.queues[task.Queue]; !ok {
+		return fmt.Errorf("unknown queue %q", task.Queue)
+	}
+
+	if task.ExpiresAt != nil && !task.ExpiresAt.After(time.Now()) {
+		return ErrTaskExpired
+	}
+
+	if !ValidPriority(task.Priority) {
+		return fmt.Errorf("unknown priority %q", task.Priority)
+	}
+
 	return nil
 }
 
 func (q *RedisQueue) StartWorker(ctx context.Context) {
-	q.logger.Info("Starting email queue worker...")
+	q.logger.Info("Starting email queue workers...", "instance", q.instanceID)
+
+	// The heartbeat outlives ctx so tasks still being sent during shutdown
+	// are not reclaimed by another instance.
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.WithoutCancel(ctx))
+	go q.runHeartbeat(heartbeatCtx)
+	if q.overflowEnabled {
+		go q.runOverflowReleaser(ctx)
+	}
+
+	var wg sync.WaitGroup
+
+	q.poolsMu.Lock()
+	q.pools = make(map[string]*workerPool, len(q.queues))
+	for _, qc := range q.queues {
+		pool := &workerPool{
+			queue:   q,
+			config:  qc,
+			limiter: newRateLimiter(qc.RateLimit),
+			ctx:     ctx,
+			wg:      &wg,
+		}
+		defer pool.limiter.Stop()
+
+		pool.resize(qc.Concurrency)
+		q.pools[qc.Name] = pool
 
+		q.logger.Info("Queue workers started",
+			"queue", qc.Name,
+			"concurrency", qc.Concurrency,
+			"rateLimit", qc.RateLimit,
+		)
+	}
+	q.poolsMu.Unlock()
+
+	wg.Wait()
+	stopHeartbeat()
+	q.deregister(context.Background())
+	q.logger.Info("Email queue workers stopped")
+}
+
+func (q *RedisQueue) runWorker(ctx context.Context, qc config.QueueConfig, limiter *rateLimiter) {
 	for {
 		select {
 		case <-ctx.Done():
-			q.logger.Info("Email queue worker stopped")
 			return
 		default:
-			if err := q.processNextTask(ctx); err != nil {
-				q.logger.Error("Task processing error", "error", err)
+			if err := limiter.Wait(ctx); err != nil {
+				return
+			}
+
+			if err := q.processNextTask(ctx, qc); err != nil {
+				q.logger.Error("Task processing error", "queue", qc.Name, "error", err)
 				time.Sleep(queueCheckInterval)
 			}
 		}
 	}
 }
 
-func (q *RedisQueue) processNextTask(ctx context.Context) error {
-	result, err := q.client.BLPop(ctx, 0, emailQueue).Result()
+// processNextTask moves one task onto this instance's processing list and
+// sends it. BLMOVE uses a short timeout so workers notice shutdown; a task
+// popped after shutdown began is pushed back to the head of its queue, and
+// one already being sent is allowed to finish. The task leaves the
+// processing list once it is sent, rescheduled or dead-lettered.
+func (q *RedisQueue) processNextTask(ctx context.Context, qc config.QueueConfig) (err error) {
+	q.lastPoll.Store(time.Now().UnixMilli())
+	taskJSON, err := q.popTask(ctx, qc.Name)
 	if err != nil {
 		if err == redis.Nil || err == context.Canceled {
 			return nil
@@ -141,50 +408,321 @@ func (q *RedisQueue) processNextTask(ctx context.Context) error {
 		return fmt.Errorf("queue retrieval error: %w", err)
 	}
 
-	if len(result) < 2 {
-		return fmt.Errorf("invalid queue result")
+	if ctx.Err() != nil {
+		return q.requeueInFlight(qc.Name, taskJSON)
 	}
 
+	sendCtx := context.WithoutCancel(ctx)
+	defer q.ack(sendCtx, qc.Name, taskJSON)
+
 	var task EmailTask
-	if err := json.Unmarshal([]byte(result[1]), &task); err != nil {
+	defer q.recoverTask(sendCtx, qc, &task, taskJSON, &err)
+
+	if err := json.Unmarshal([]byte(taskJSON), &task); err != nil {
 		return fmt.Errorf("task deserialization error: %w", err)
 	}
+	if task.Version > CurrentTaskVersion {
+		q.logger.Warn("Task written by a newer release, fields it added are ignored",
+			"id", task.ID, "version", task.Version, "supported", CurrentTaskVersion)
+	}
+	task.Queue = qc.Name
+	task.Worker = q.instanceID
+	task = assignVariant(task)
+
+	if task.BatchID != "" {
+		canceled, sendAt, err := q.batchSchedule(sendCtx, task.BatchID)
+		if err == nil && canceled {
+			q.logger.Info("Batch canceled, dropping email", "id", task.ID, "batch", task.BatchID)
+			q.dropCanceled(sendCtx, task)
+			return nil
+		}
+		if err == nil && !sendAt.IsZero() {
+			q.extendExpiry(&task, sendAt)
+		}
+	}
+	// The TTL restarts once an SMTP account took the task out of overflow.
+	if task.Overflowed {
+		q.extendExpiry(&task, time.Now())
+		task.Overflowed = false
+	}
+
+	if task.ExpiresAt != nil && time.Now().After(*task.ExpiresAt) {
+		q.expire(sendCtx, task)
+		return nil
+	}
+
+	if !q.dropBlockedRecipients(sendCtx, &task) {
+		return nil
+	}
+
+	// An email that may need approval is never sent unchecked; it waits in
+	// the delayed queue until the check can be made.
+	reason, err := q.approvalRequired(sendCtx, task)
+	if err != nil {
+		q.logger.Warn("Approval check failed, deferring email", "id", task.ID, "error", err)
+		return q.scheduleTask(sendCtx, task, time.Now().Add(approvalRetryDelay))
+	}
+	if reason != "" {
+		return q.hold(sendCtx, task, reason, nil)
+	}
+
+	if !q.sender.Available() {
+		return q.overflow(sendCtx, task)
+	}
+
+	if allowed, slot := q.categoryAllows(&task); !allowed {
+		categoryDeferred.Inc(task.Category)
+		q.logger.Debug("Send category over its rate limit, deferring email",
+			"id", task.ID,
+			"category", task.Category,
+			"until", slot,
+		)
+		q.extendExpiry(&task, slot)
+		return q.scheduleTask(sendCtx, task, slot)
+	}
+
+	allowed, retryAt, returnWarmup, err := q.warmupAllows(sendCtx)
+	if err != nil {
+		q.logger.Warn("Warm-up check failed, sending anyway", "id", task.ID, "error", err)
+	} else if !allowed {
+		q.logger.Info("Warm-up cap reached, deferring email",
+			"id", task.ID,
+			"queue", task.Queue,
+			"domain", q.warmupDomain,
+			"until", retryAt,
+		)
+		q.returnCategorySlot(task)
+		q.extendExpiry(&task, retryAt)
+		return q.scheduleTask(sendCtx, task, retryAt)
+	}
+
+	// Slots taken above are given back whenever the task is not sent after
+	// all, so requeueing or overflowing it does not use up the rate it will
+	// need.
+	ispTaken := false
+	returnSlots := func(ctx context.Context) {
+		q.returnCategorySlot(task)
+		returnWarmup(ctx)
+		if ispTaken {
+			q.returnISPSlot(task.To)
+		}
+	}
 
-	return q.sendEmailWithRetry(ctx, task)
+	release, err := q.throttle(ctx, task.To)
+	if err != nil {
+		returnSlots(sendCtx)
+		return q.requeueInFlight(qc.Name, taskJSON)
+	}
+	defer release()
+	ispTaken = true
+
+	// The deployment-wide cap is taken last, so tasks deferred or dropped
+	// above don't use up its slots.
+	if err := q.sendLimiter.Wait(ctx); err != nil {
+		returnSlots(sendCtx)
+		return q.requeueInFlight(qc.Name, taskJSON)
+	}
+
+	defer q.recordProcessed(sendCtx, task)
+
+	return q.sendEmailWithRetry(sendCtx, qc, task, returnSlots)
 }
 
-func (q *RedisQueue) sendEmailWithRetry(ctx context.Context, task EmailTask) error {
-	err := q.sender.SendEmail(task.To, task.Subject, task.TemplateName, task.Data)
+// sendEmailWithRetry sends the task, scheduling a retry or dead-lettering
+// it when that fails. returnSlots gives back the rate slots the task took,
+// when no SMTP account was left to send it.
+func (q *RedisQueue) sendEmailWithRetry(ctx context.Context, qc config.QueueConfig, task EmailTask, returnSlots func(context.Context)) error {
+	q.publish(ctx, events.TypeSending, task, nil)
+
+	result, err := q.sender.Send(ctx, email.Message{
+		From:          task.From,
+		FromName:      task.FromName,
+		To:            task.To,
+		Recipients:    task.Recipients,
+		Subject:       task.Subject,
+		TemplateName:  task.TemplateName,
+		Data:          task.Data,
+		Body:          task.Body,
+		BodyRef:       task.BodyRef,
+		Attachments:   task.Attachments,
+		Event:         task.Event,
+		Preheader:     task.Preheader,
+		Locale:        task.Locale,
+		JobID:         task.ID,
+		Tenant:        task.Tenant,
+		InReplyTo:     task.InReplyTo,
+		References:    task.References,
+		UTM:           task.UTM,
+		BatchID:       task.BatchID,
+		Variant:       task.Variant,
+		Category:      task.Category,
+		Tags:          task.Tags,
+		Metadata:      task.Metadata,
+		Pool:          task.Pool,
+		ApprovedRules: task.ApprovedRules,
+	})
+	q.recordSendResult(ctx, task, result)
+
+	// Every account went out of rotation since the check before the send
+	if errors.Is(err, email.ErrNoAccountAvailable) {
+		returnSlots(ctx)
+		return q.overflow(ctx, task)
+	}
+	if errors.Is(err, email.ErrApprovalRequired) {
+		return q.hold(ctx, task, config.ApprovalSpamFlagged, err)
+	}
 
 	if err == nil {
-		q.logger.Info("Email sent successfully", "to", task.To, "subject", task.Subject)
+		q.logger.Info("Email sent successfully",
+			"id", task.ID,
+			"requestId", task.RequestID,
+			"to", task.To,
+			"subject", task.Subject,
+			"queue", task.Queue,
+			"worker", task.Worker,
+			"attempt", task.Retries+1,
+		)
+		q.releaseBody(ctx, task)
+		q.publish(ctx, events.TypeSent, task, nil)
+		q.recordBatch(ctx, task, "sent")
 		return nil
 	}
 
-	if task.Retries < maxRetries {
+	// A broadcast that failed part way through, or had recipients deferred,
+	// is retried, or dead-lettered, for the recipients that may still get it.
+	if len(task.Recipients) > 0 {
+		if remaining := remainingRecipients(task.Recipients, result.Delivered, result.Refused); len(remaining) > 0 {
+			task.Recipients = remaining
+		}
+	}
+
+	bounce := email.ClassifyBounce(err)
+	if delay, ok := q.retryDelay(qc, task, err); ok {
+		attempted := task
 		task.Retries++
-		q.logger.Warn("Email send failed, requeueing",
+		if bounce == events.BounceSoft {
+			task.SoftBounces++
+		}
+		q.logger.Warn("Email send failed, scheduling retry",
+			"id", task.ID,
+			"requestId", task.RequestID,
 			"to", task.To,
 			"subject", task.Subject,
-			"retries", task.Retries,
+			"queue", task.Queue,
+			"worker", task.Worker,
+			"attempt", attempted.Retries+1,
+			"retryIn", delay,
+			"deferred", email.IsDeferral(err),
+			"bounce", bounce,
 			"error", err,
 		)
 
-		time.Sleep(retryDelay)
-
-		requeueErr := q.EnqueueEmail(ctx, task)
+		requeueErr := q.scheduleTask(ctx, task, time.Now().Add(delay))
 		if requeueErr != nil {
 			return fmt.Errorf("failed to requeue email: %w (original error: %v)", requeueErr, err)
 		}
 
+		q.publish(ctx, events.TypeRetried, attempted, err)
 		return nil
 	}
 
 	q.logger.Error("Email send failed after max retries",
+		"id", task.ID,
+		"requestId", task.RequestID,
 		"to", task.To,
 		"subject", task.Subject,
+		"queue", task.Queue,
+		"worker", task.Worker,
+		"attempt", task.Retries+1,
 		"error", err,
 	)
+	q.publish(ctx, events.TypeFailed, task, err)
+	q.recordBatch(ctx, task, "failed")
+
+	if dlqErr := q.deadLetter(ctx, task, err); dlqErr != nil {
+		return fmt.Errorf("failed to dead-letter email: %w (original error: %v)", dlqErr, err)
+	}
+	q.publish(ctx, events.TypeDeadLettered, task, err)
 
 	return err
 }
+
+func (q *RedisQueue) publish(ctx context.Context, eventType string, task EmailTask, err error) {
+	q.recordStatus(ctx, eventType, task, err)
+
+	if q.events == nil {
+		return
+	}
+
+	event := events.Event{
+		Type:        eventType,
+		JobID:       task.ID,
+		Recipient:   task.To,
+		Subject:     task.Subject,
+		Template:    task.TemplateName,
+		Queue:       task.Queue,
+		BatchID:     task.BatchID,
+		Tenant:      task.Tenant,
+		Variant:     task.Variant,
+		Tags:        task.Tags,
+		Metadata:    task.Metadata,
+		Attempt:     task.Retries + 1,
+		RequestID:   task.RequestID,
+		TraceParent: task.TraceParent,
+		Timestamp:   time.Now().UTC(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+		event.Bounce = email.ClassifyBounce(err)
+	}
+	q.events.Publish(ctx, event)
+}
+
+// retryDelay decides whether a failed attempt is retried and how long to
+// wait first. Hard bounces are rejected again, messages held by the spam,
+// link or clipping check would be held again, and messages over the SMTP
+// server's size limit would be refused again, so none of them is retried. Soft
+// bounces (mailbox full or unavailable) have their own retry budget and an
+// exponential schedule in hours, since the mailbox rarely recovers within
+// seconds. SMTP 4xx deferrals (e.g. greylisting) honor the server's
+// suggested delay, or the configured deferral delay when none is given,
+// instead of the queue's short retry delay. Other errors count against the
+// queue's retries.
+func (q *RedisQueue) retryDelay(qc config.QueueConfig, task EmailTask, err error) (time.Duration, bool) {
+	if errors.Is(err, email.ErrSpamHeld) || errors.Is(err, email.ErrBrokenLinks) || errors.Is(err, email.ErrHTMLTooLarge) ||
+		errors.Is(err, email.ErrMessageTooLarge) {
+		return 0, false
+	}
+
+	switch email.ClassifyBounce(err) {
+	case events.BounceHard:
+		return 0, false
+	case events.BounceSoft:
+		if task.SoftBounces >= q.softBounceMaxRetries {
+			return 0, false
+		}
+		delay := q.softBounceDelay << task.SoftBounces
+		if q.softBounceMaxDelay > 0 && (delay > q.softBounceMaxDelay || delay <= 0) {
+			delay = q.softBounceMaxDelay
+		}
+		return delay, true
+	}
+
+	if task.Retries-task.SoftBounces >= qc.MaxRetries {
+		return 0, false
+	}
+	if !email.IsDeferral(err) {
+		return qc.RetryDelay, true
+	}
+
+	delay, ok := email.DeferralDelay(err)
+	if !ok {
+		delay = q.deferralDefaultDelay
+	}
+
+	if q.deferralMaxDelay > 0 && delay > q.deferralMaxDelay {
+		delay = q.deferralMaxDelay
+	}
+
+	return max(delay, qc.RetryDelay), true
+}
@@ -0,0 +1,256 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	email "github.com/sarthakyeole/redis-go-mailing-bulk/internal/senderSide"
+)
+
+const jobKeyPrefix = "job:"
+
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStatus is the latest known state of a job, keyed by its ID. SpamScore,
+// BrokenLinks, HTMLBytes and Clipped are the pre-send checks of the last
+// attempt, when enabled.
+type JobStatus struct {
+	ID          string                   `json:"id"`
+	Status      string                   `json:"status"`
+	Recipient   string                   `json:"recipient"`
+	Subject     string                   `json:"subject"`
+	Template    string                   `json:"template"`
+	Queue       string                   `json:"queue"`
+	Tenant      string                   `json:"tenant,omitempty"`
+	BatchID     string                   `json:"batchId,omitempty"`
+	RequestID   string                   `json:"requestId,omitempty"`
+	TraceParent string                   `json:"traceparent,omitempty"`
+	Tags        []string                 `json:"tags,omitempty"`
+	Metadata    map[string]string        `json:"metadata,omitempty"`
+	Worker      string                   `json:"worker,omitempty"`
+	Account     string                   `json:"account,omitempty"`
+	Attempts    int                      `json:"attempts"`
+	Error       string                   `json:"error,omitempty"`
+	SpamScore   *float64                 `json:"spamScore,omitempty"`
+	SpamFlagged bool                     `json:"spamFlagged,omitempty"`
+	BrokenLinks []string                 `json:"brokenLinks,omitempty"`
+	HTMLBytes   int                      `json:"htmlBytes,omitempty"`
+	Clipped     bool                     `json:"clipped,omitempty"`
+	Refused     []email.RefusedRecipient `json:"refused,omitempty"`
+	CreatedAt   time.Time                `json:"createdAt"`
+	UpdatedAt   time.Time                `json:"updatedAt"`
+}
+
+// recordStatus stores the job's latest lifecycle event in its status hash.
+func (q *RedisQueue) recordStatus(ctx context.Context, eventType string, task EmailTask, err error) {
+	if task.ID == "" {
+		return
+	}
+
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	key := jobKeyPrefix + task.ID
+
+	fields := map[string]interface{}{
+		"status":    eventType,
+		"recipient": task.To,
+		"subject":   task.Subject,
+		"template":  task.TemplateName,
+		"queue":     task.Queue,
+		"batchId":   task.BatchID,
+		"updatedAt": now,
+	}
+	if eventType == events.TypeQueued && task.Tenant != "" {
+		fields["tenant"] = task.Tenant
+	}
+	if eventType == events.TypeQueued && task.RequestID != "" {
+		fields["requestId"] = task.RequestID
+		fields["traceparent"] = task.TraceParent
+	}
+	if eventType == events.TypeQueued && len(task.Tags) > 0 {
+		fields["tags"] = strings.Join(task.Tags, "\n")
+	}
+	if eventType == events.TypeQueued && len(task.Metadata) > 0 {
+		if metadata, err := json.Marshal(task.Metadata); err == nil {
+			fields["metadata"] = string(metadata)
+		}
+	}
+	if eventType != events.TypeQueued && eventType != events.TypeCanceled {
+		fields["attempts"] = task.Retries + 1
+		fields["worker"] = task.Worker
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+
+	pipe := q.client.TxPipeline()
+	pipe.HSet(ctx, key, fields)
+	pipe.HSetNX(ctx, key, "createdAt", now)
+	if eventType == events.TypeSent {
+		pipe.HDel(ctx, key, "error")
+	}
+	if eventType == events.TypeQueued {
+		q.recordHistory(ctx, pipe, task)
+	}
+	if q.jobStatusTTL > 0 {
+		pipe.Expire(ctx, key, q.jobStatusTTL)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		q.logger.Warn("Failed to record job status", "id", task.ID, "status", eventType, "error", err)
+	}
+}
+
+// recordSendResult stores the pre-send checks, refused recipients and SMTP
+// account of the latest attempt on the job's status.
+func (q *RedisQueue) recordSendResult(ctx context.Context, task EmailTask, result email.SendResult) {
+	if task.ID == "" || (result.Spam == nil && len(result.BrokenLinks) == 0 && result.HTMLBytes == 0 && len(result.Refused) == 0 && result.Account == "") {
+		return
+	}
+
+	fields := map[string]interface{}{}
+	if result.Account != "" {
+		fields["account"] = result.Account
+	}
+	if result.HTMLBytes > 0 {
+		fields["htmlBytes"] = result.HTMLBytes
+		fields["clipped"] = "0"
+		if result.Clipped {
+			fields["clipped"] = "1"
+			q.logger.Warn("Email HTML is over the clipping limit", "id", task.ID, "template", task.TemplateName, "bytes", result.HTMLBytes)
+		}
+	}
+	if len(result.BrokenLinks) > 0 {
+		fields["brokenLinks"] = strings.Join(result.BrokenLinks, "\n")
+		q.logger.Warn("Email has broken links", "id", task.ID, "template", task.TemplateName, "links", result.BrokenLinks)
+	}
+
+	if len(result.Refused) > 0 {
+		refused, _ := json.Marshal(result.Refused)
+		fields["refused"] = string(refused)
+		q.logger.Warn("SMTP server refused recipients", "id", task.ID, "refused", len(result.Refused), "delivered", len(result.Delivered))
+	}
+
+	if report := result.Spam; report != nil {
+		fields["spamScore"] = strconv.FormatFloat(report.Score, 'f', -1, 64)
+		fields["spamFlagged"] = "0"
+		if report.Flagged {
+			fields["spamFlagged"] = "1"
+			q.logger.Warn("Email scored above the spam threshold", "id", task.ID, "template", task.TemplateName, "score", report.Score)
+		}
+	}
+
+	if err := q.client.HSet(ctx, jobKeyPrefix+task.ID, fields).Err(); err != nil {
+		q.logger.Warn("Failed to record pre-send checks", "id", task.ID, "error", err)
+	}
+}
+
+func (q *RedisQueue) JobStatus(ctx context.Context, id string) (*JobStatus, error) {
+	statuses, err := q.JobStatuses(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return nil, ErrJobNotFound
+	}
+	return &statuses[0], nil
+}
+
+// JobStatuses looks up many jobs in one round trip. Unknown or expired IDs
+// are left out of the result.
+func (q *RedisQueue) JobStatuses(ctx context.Context, ids []string) ([]JobStatus, error) {
+	pipe := q.client.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, jobKeyPrefix+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to load job statuses: %w", err)
+	}
+
+	statuses := make([]JobStatus, 0, len(ids))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			continue
+		}
+
+		status := JobStatus{
+			ID:          ids[i],
+			Status:      fields["status"],
+			Recipient:   fields["recipient"],
+			Subject:     fields["subject"],
+			Template:    fields["template"],
+			Queue:       fields["queue"],
+			Tenant:      fields["tenant"],
+			BatchID:     fields["batchId"],
+			RequestID:   fields["requestId"],
+			TraceParent: fields["traceparent"],
+			Worker:      fields["worker"],
+			Account:     fields["account"],
+			Error:       fields["error"],
+			CreatedAt:   parseMillis(fields["createdAt"]),
+			UpdatedAt:   parseMillis(fields["updatedAt"]),
+		}
+		status.Attempts, _ = strconv.Atoi(fields["attempts"])
+		status.HTMLBytes, _ = strconv.Atoi(fields["htmlBytes"])
+		status.Clipped = fields["clipped"] == "1"
+		if links := fields["brokenLinks"]; links != "" {
+			status.BrokenLinks = strings.Split(links, "\n")
+		}
+		if refused := fields["refused"]; refused != "" {
+			_ = json.Unmarshal([]byte(refused), &status.Refused)
+		}
+		if tags := fields["tags"]; tags != "" {
+			status.Tags = strings.Split(tags, "\n")
+		}
+		if metadata := fields["metadata"]; metadata != "" {
+			_ = json.Unmarshal([]byte(metadata), &status.Metadata)
+		}
+		if score, err := strconv.ParseFloat(fields["spamScore"], 64); err == nil {
+			status.SpamScore = &score
+			status.SpamFlagged = fields["spamFlagged"] == "1"
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func parseMillis(value string) time.Time {
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis).UTC()
+}
+
+// markScript updates a job's status only while its status hash exists, so
+// feedback about an expired or unknown job leaves no partial record behind.
+var markScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "status", ARGV[1], "updatedAt", ARGV[2])
+return 1
+`)
+
+// MarkJob records an outcome reported after delivery, such as a complaint,
+// as the job's status and returns the updated status.
+func (q *RedisQueue) MarkJob(ctx context.Context, id, status string) (*JobStatus, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	marked, err := markScript.Run(ctx, q.client, []string{jobKeyPrefix + id}, status, now).Int()
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark job: %w", err)
+	}
+	if marked == 0 {
+		return nil, ErrJobNotFound
+	}
+	return q.JobStatus(ctx, id)
+}
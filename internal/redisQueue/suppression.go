@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+)
+
+// dropBlockedRecipients checks the task's recipients again before sending,
+// since they may have unsubscribed, complained or opted out of its category
+// while it was scheduled, held or waiting for a retry. A broadcast loses
+// the blocked recipients; a task left with nobody to send to is dropped as
+// canceled and dropBlockedRecipients returns false.
+func (q *RedisQueue) dropBlockedRecipients(ctx context.Context, task *EmailTask) bool {
+	if q.recipients == nil {
+		return true
+	}
+
+	if len(task.Recipients) == 0 {
+		err := q.recipients.Blocked(ctx, task.To, task.Queue, task.Category)
+		if err == nil {
+			return true
+		}
+		q.dropBlocked(ctx, *task, err)
+		return false
+	}
+
+	remaining := task.Recipients[:0:0]
+	var blockedErr error
+	for _, address := range task.Recipients {
+		if err := q.recipients.Blocked(ctx, address, task.Queue, task.Category); err != nil {
+			q.logger.Info("Recipient blocked since the email was queued, skipping",
+				"id", task.ID, "recipient", address, "reason", err)
+			blockedErr = err
+			continue
+		}
+		remaining = append(remaining, address)
+	}
+	if len(remaining) == 0 {
+		q.dropBlocked(ctx, *task, blockedErr)
+		return false
+	}
+	task.Recipients = remaining
+	return true
+}
+
+// dropBlocked records a task whose recipients are all blocked as canceled
+// instead of sending it.
+func (q *RedisQueue) dropBlocked(ctx context.Context, task EmailTask, err error) {
+	q.logger.Info("Recipient blocked since the email was queued, dropping email",
+		"id", task.ID,
+		"requestId", task.RequestID,
+		"to", task.To,
+		"queue", task.Queue,
+		"reason", err,
+	)
+	q.publish(ctx, events.TypeCanceled, task, err)
+	q.recordBatch(ctx, task, "canceled")
+	q.releaseBody(ctx, task)
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+)
+
+// CurrentTaskVersion is the EmailTask format this release writes. Bump it
+// when a field is renamed or changes meaning, and add the step migrating
+// the previous version to taskMigrations, so tasks still sitting in Redis
+// from older releases keep decoding during a rolling upgrade. Adding an
+// optional field needs no new version.
+const CurrentTaskVersion = 2
+
+// taskMigrations[v] rewrites the fields of a version v task into version
+// v+1. Tasks written before versioning have no version field and decode as
+// version 0.
+var taskMigrations = []func(fields map[string]json.RawMessage) error{
+	// 0 -> 1: the format the version field was introduced with; its fields
+	// are unchanged.
+	func(fields map[string]json.RawMessage) error { return nil },
+	// 1 -> 2: approved, which skipped every approval rule, became
+	// approvedRules, the rules an admin released the task from.
+	func(fields map[string]json.RawMessage) error {
+		approved, ok := fields["approved"]
+		if !ok {
+			return nil
+		}
+		delete(fields, "approved")
+		if string(approved) != "true" {
+			return nil
+		}
+		rules, err := json.Marshal([]string{config.ApprovalNewTemplate, config.ApprovalSpamFlagged, config.ApprovalRecipients})
+		if err != nil {
+			return err
+		}
+		fields["approvedRules"] = rules
+		return nil
+	},
+}
+
+// emailTaskFields has EmailTask's fields without its JSON methods.
+type emailTaskFields EmailTask
+
+// MarshalJSON stamps the task with CurrentTaskVersion.
+func (t EmailTask) MarshalJSON() ([]byte, error) {
+	fields := emailTaskFields(t)
+	fields.Version = CurrentTaskVersion
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON decodes a task of any version up to CurrentTaskVersion,
+// migrating older ones. Tasks from a newer release are decoded as they
+// are, dropping fields this release doesn't know, and keep their version
+// so callers can tell.
+func (t *EmailTask) UnmarshalJSON(data []byte) error {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+
+	if header.Version < CurrentTaskVersion {
+		migrated, err := migrateTask(data, header.Version)
+		if err != nil {
+			return err
+		}
+		data = migrated
+	}
+
+	var fields emailTaskFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*t = EmailTask(fields)
+	if header.Version < CurrentTaskVersion {
+		t.Version = CurrentTaskVersion
+	}
+	return nil
+}
+
+func migrateTask(data []byte, version int) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if version < 0 {
+		return nil, fmt.Errorf("invalid task version %d", version)
+	}
+	for v := version; v < CurrentTaskVersion; v++ {
+		if err := taskMigrations[v](fields); err != nil {
+			return nil, fmt.Errorf("failed to migrate task from version %d: %w", v, err)
+		}
+	}
+	return json.Marshal(fields)
+}
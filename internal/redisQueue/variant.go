@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+// Variant is one arm of an A/B test across a batch. A non-empty Subject or
+// TemplateName replaces the task's own; Weight is the percentage of the
+// batch's recipients that get the variant.
+type Variant struct {
+	Name         string `json:"name"`
+	Subject      string `json:"subject,omitempty"`
+	TemplateName string `json:"templateName,omitempty"`
+	Weight       int    `json:"weight"`
+}
+
+// assignVariant applies the task's variant. The choice hashes the batch ID
+// and recipient, so a recipient gets the same variant on every attempt and
+// on every page of the batch, and the split follows the weights.
+func assignVariant(task EmailTask) EmailTask {
+	if len(task.Variants) == 0 || task.Variant != "" {
+		return task
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(task.BatchID + "\x00" + strings.ToLower(task.To)))
+	bucket := int(h.Sum32() % 100)
+
+	variant := task.Variants[len(task.Variants)-1]
+	for _, v := range task.Variants {
+		if bucket < v.Weight {
+			variant = v
+			break
+		}
+		bucket -= v.Weight
+	}
+
+	task.Variant = variant.Name
+	if variant.Subject != "" {
+		task.Subject = variant.Subject
+	}
+	if variant.TemplateName != "" {
+		task.TemplateName = variant.TemplateName
+	}
+	return task
+}
@@ -0,0 +1,100 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+// warmupKeyPrefix keys the warm-up state of a sending domain:
+// warmup:<domain>:start holds the first day and warmup:<domain>:<date> the
+// number of emails sent that day.
+const warmupKeyPrefix = "warmup:"
+
+var warmupDeferred = metrics.NewCounter(
+	"mailqueue_warmup_deferred_total",
+	"Emails deferred to the next day by the warm-up schedule.",
+	"domain",
+)
+
+// warmupTakeScript takes one send from the day's cap, leaving the counter
+// untouched when the cap is reached.
+var warmupTakeScript = redis.NewScript(`
+local sent = redis.call('INCR', KEYS[1])
+if sent == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+if sent > tonumber(ARGV[1]) then
+	redis.call('DECR', KEYS[1])
+	return 0
+end
+return 1
+`)
+
+// warmupAllows takes a send from today's warm-up cap of the sending domain
+// and returns the function that gives it back if the send does not happen.
+// When the cap is reached it returns false and the start of the next day,
+// when the task should be tried again. Once the schedule has run out,
+// sending is no longer capped. Only the domain is capped, whichever account,
+// pool or IP the send goes out through.
+func (q *RedisQueue) warmupAllows(ctx context.Context) (bool, time.Time, func(context.Context), error) {
+	untaken := func(context.Context) {}
+	if len(q.warmupSchedule) == 0 {
+		return true, time.Time{}, untaken, nil
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	start, err := q.warmupStartDay(ctx, today)
+	if err != nil {
+		return false, time.Time{}, untaken, err
+	}
+
+	day := int(today.Sub(start) / (24 * time.Hour))
+	if day >= len(q.warmupSchedule) {
+		return true, time.Time{}, untaken, nil
+	}
+	day = max(day, 0)
+
+	key := warmupKeyPrefix + q.warmupDomain + ":" + today.Format(time.DateOnly)
+	allowed, err := warmupTakeScript.Run(ctx, q.client, []string{key}, q.warmupSchedule[day], int((48 * time.Hour).Seconds())).Int()
+	if err != nil {
+		return false, time.Time{}, untaken, err
+	}
+	if allowed == 1 {
+		return true, time.Time{}, func(ctx context.Context) {
+			if err := q.client.Decr(ctx, key).Err(); err != nil {
+				q.logger.Warn("Failed to give back a warm-up send", "domain", q.warmupDomain, "error", err)
+			}
+		}, nil
+	}
+
+	warmupDeferred.Inc(q.warmupDomain)
+	return false, today.Add(24 * time.Hour), untaken, nil
+}
+
+// warmupStartDay is WARMUP_START, or the day the domain first sent mail as
+// recorded in Redis, so every instance counts days from the same start.
+func (q *RedisQueue) warmupStartDay(ctx context.Context, today time.Time) (time.Time, error) {
+	if !q.warmupStart.IsZero() {
+		return q.warmupStart, nil
+	}
+
+	key := warmupKeyPrefix + q.warmupDomain + ":start"
+	if err := q.client.SetNX(ctx, key, today.Format(time.DateOnly), 0).Err(); err != nil {
+		return time.Time{}, err
+	}
+	value, err := q.client.Get(ctx, key).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// sendingDomain is the domain of the sender address, lowercased.
+func sendingDomain(address string) string {
+	_, domain, _ := strings.Cut(address, "@")
+	return strings.ToLower(domain)
+}
@@ -0,0 +1,157 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	heartbeatPrefix = "worker_heartbeat"
+	workerKeyPrefix = "worker:"
+	workerRegistry  = "workers"
+
+	// workerInfoTTL keeps the record of a worker that stopped heartbeating
+	// long enough for operators to notice it as stale.
+	workerInfoTTL = 24 * time.Hour
+)
+
+// WorkerInfo describes one worker instance. Stale is set when its heartbeat
+// is older than the heartbeat TTL, i.e. the instance crashed or hangs.
+type WorkerInfo struct {
+	ID         string    `json:"id"`
+	Host       string    `json:"host"`
+	StartedAt  time.Time `json:"startedAt"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Processed  int64     `json:"processed"`
+	LastTask   string    `json:"lastTask,omitempty"`
+	LastTaskAt time.Time `json:"lastTaskAt"`
+	Stale      bool      `json:"stale"`
+}
+
+// heartbeatKey expires with the instance; the orphan reclaimer treats its
+// absence as the instance being gone.
+func heartbeatKey(instanceID string) string {
+	return heartbeatPrefix + ":" + instanceID
+}
+
+// runHeartbeat registers this instance and keeps its heartbeat alive until
+// ctx is cancelled. StartWorker stops it only after in-flight sends have
+// drained.
+func (q *RedisQueue) runHeartbeat(ctx context.Context) {
+	hostname, _ := os.Hostname()
+	if err := q.client.HSet(ctx, workerKeyPrefix+q.instanceID,
+		"host", hostname,
+		"startedAt", time.Now().UnixMilli(),
+	).Err(); err != nil {
+		q.logger.Error("Worker registration error", "instance", q.instanceID, "error", err)
+	}
+
+	ticker := time.NewTicker(q.heartbeatTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		if err := q.heartbeat(ctx); err != nil && ctx.Err() == nil {
+			q.logger.Error("Worker heartbeat error", "instance", q.instanceID, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *RedisQueue) heartbeat(ctx context.Context) error {
+	now := time.Now().UnixMilli()
+	key := workerKeyPrefix + q.instanceID
+
+	pipe := q.client.TxPipeline()
+	pipe.Set(ctx, heartbeatKey(q.instanceID), now, q.heartbeatTTL)
+	pipe.HSet(ctx, key, "lastSeen", now)
+	pipe.Expire(ctx, key, workerInfoTTL)
+	pipe.ZAdd(ctx, workerRegistry, &redis.Z{Score: float64(now), Member: q.instanceID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LastPoll returns when a worker loop of this instance last polled its
+// queue, or the zero time if none has (yet). Unlike the heartbeat, which
+// runs on its own ticker, it stops advancing when every loop is stuck.
+func (q *RedisQueue) LastPoll() time.Time {
+	if millis := q.lastPoll.Load(); millis != 0 {
+		return time.UnixMilli(millis)
+	}
+	return time.Time{}
+}
+
+// recordProcessed counts a finished task against this instance.
+func (q *RedisQueue) recordProcessed(ctx context.Context, task EmailTask) {
+	key := workerKeyPrefix + q.instanceID
+
+	pipe := q.client.TxPipeline()
+	pipe.HIncrBy(ctx, key, "processed", 1)
+	pipe.HSet(ctx, key, "lastTask", task.ID, "lastTaskAt", time.Now().UnixMilli())
+	if _, err := pipe.Exec(ctx); err != nil {
+		q.logger.Warn("Failed to record processed task", "instance", q.instanceID, "error", err)
+	}
+}
+
+// deregister removes this instance from the registry on a clean shutdown.
+func (q *RedisQueue) deregister(ctx context.Context) {
+	pipe := q.client.TxPipeline()
+	pipe.Del(ctx, heartbeatKey(q.instanceID), workerKeyPrefix+q.instanceID)
+	pipe.ZRem(ctx, workerRegistry, q.instanceID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		q.logger.Warn("Failed to deregister worker", "instance", q.instanceID, "error", err)
+	}
+}
+
+// Workers lists registered worker instances, most recently seen first.
+// Instances whose record has expired are dropped from the registry.
+func (q *RedisQueue) Workers(ctx context.Context) ([]WorkerInfo, error) {
+	ids, err := q.client.ZRevRange(ctx, workerRegistry, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+
+	pipe := q.client.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, workerKeyPrefix+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to load workers: %w", err)
+	}
+
+	staleBefore := time.Now().Add(-q.heartbeatTTL)
+	workers := make([]WorkerInfo, 0, len(ids))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			q.client.ZRem(ctx, workerRegistry, ids[i])
+			continue
+		}
+
+		worker := WorkerInfo{
+			ID:         ids[i],
+			Host:       fields["host"],
+			StartedAt:  parseMillis(fields["startedAt"]),
+			LastSeen:   parseMillis(fields["lastSeen"]),
+			LastTask:   fields["lastTask"],
+			LastTaskAt: parseMillis(fields["lastTaskAt"]),
+		}
+		worker.Processed, _ = strconv.ParseInt(fields["processed"], 10, 64)
+		worker.Stale = worker.LastSeen.Before(staleBefore)
+		workers = append(workers, worker)
+	}
+
+	return workers, nil
+}
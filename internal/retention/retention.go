@@ -0,0 +1,75 @@
+package retention
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+var removedRecords = metrics.NewCounter(
+	"mailqueue_retention_removed_total",
+	"Records removed by retention cleanup, by kind.",
+	"kind",
+)
+
+// Policy removes records of one kind that are older than MaxAge. Cleanup
+// receives the cutoff and returns how many records it removed.
+type Policy struct {
+	Kind    string
+	MaxAge  time.Duration
+	Cleanup func(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Cleaner periodically applies retention policies. Keys that carry their own
+// Redis TTL (job statuses, batches) need no policy here.
+type Cleaner struct {
+	interval time.Duration
+	policies []Policy
+	logger   *slog.Logger
+}
+
+// New drops policies with a zero MaxAge, which keep records forever.
+func New(interval time.Duration, logger *slog.Logger, policies ...Policy) *Cleaner {
+	c := &Cleaner{interval: interval, logger: logger}
+	for _, policy := range policies {
+		if policy.MaxAge > 0 {
+			c.policies = append(c.policies, policy)
+		}
+	}
+	return c
+}
+
+// Run cleans up once at start and then every interval until ctx is cancelled.
+func (c *Cleaner) Run(ctx context.Context) {
+	if len(c.policies) == 0 || c.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.cleanup(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Cleaner) cleanup(ctx context.Context) {
+	for _, policy := range c.policies {
+		removed, err := policy.Cleanup(ctx, time.Now().Add(-policy.MaxAge))
+		if removed > 0 {
+			removedRecords.Add(float64(removed), policy.Kind)
+			c.logger.Info("Retention cleanup", "kind", policy.Kind, "removed", removed)
+		}
+		if err != nil && ctx.Err() == nil {
+			c.logger.Error("Retention cleanup failed", "kind", policy.Kind, "error", err)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/pacer"
+)
+
+// accountRateKey prefixes the Redis key pacing each account's RateLimit
+// across every instance.
+const accountRateKey = "account_send_rate:"
+
+var (
+	accountSends = metrics.NewCounter(
+		"mailqueue_smtp_account_sends_total",
+		"Sends handed to each SMTP account, by outcome.",
+		"account", "outcome",
+	)
+	accountUp = metrics.NewGauge(
+		"mailqueue_smtp_account_up",
+		"Whether each SMTP account is in rotation (1) or out of it, cooling down or over its error budget (0).",
+		"account",
+	)
+	accountErrorRate = metrics.NewGauge(
+		"mailqueue_smtp_account_error_rate",
+		"Share of each SMTP account's sends that failed within SMTP_ACCOUNT_ERROR_WINDOW.",
+		"account",
+	)
+	accountTransitions = metrics.NewCounter(
+		"mailqueue_smtp_account_transitions_total",
+		"Changes of each SMTP account's state, by the state entered: up, cooldown or over_budget.",
+		"account", "state",
+	)
+)
+
+// Account states. Only accounts that are up are in rotation.
+const (
+	accountStateUp         = "up"
+	accountStateCooldown   = "cooldown"
+	accountStateOverBudget = "over_budget"
+)
+
+// ErrNoAccountAvailable is returned by Send when SMTP_OVERFLOW is on and
+// every SMTP account is out of rotation.
+var ErrNoAccountAvailable = errors.New("no SMTP account is in rotation")
+
+// errorWindowBuckets is the number of buckets an error window is counted
+// in; sends age out of the window a bucket at a time.
+const errorWindowBuckets = 10
+
+// errorWindow counts the sends of an account and those that failed over a
+// rolling window.
+type errorWindow struct {
+	width   time.Duration // of one bucket
+	buckets [errorWindowBuckets]errorBucket
+}
+
+type errorBucket struct {
+	slot     int64 // start of the bucket, in widths since the epoch
+	sends    int
+	failures int
+}
+
+func (w *errorWindow) add(now time.Time, failed bool) {
+	slot := now.UnixNano() / int64(w.width)
+	bucket := &w.buckets[slot%errorWindowBuckets]
+	if bucket.slot != slot {
+		*bucket = errorBucket{slot: slot}
+	}
+	bucket.sends++
+	if failed {
+		bucket.failures++
+	}
+}
+
+// counts returns the sends and failures within the window ending at now.
+func (w *errorWindow) counts(now time.Time) (sends, failures int) {
+	slot := now.UnixNano() / int64(w.width)
+	for _, bucket := range w.buckets {
+		if slot-bucket.slot < errorWindowBuckets {
+			sends += bucket.sends
+			failures += bucket.failures
+		}
+	}
+	return sends, failures
+}
+
+// smtpAccount is an SMTP account in rotation, with its idle sessions, its
+// send pacing and its health.
+type smtpAccount struct {
+	config.SMTPAccountConfig
+	sessions sessionPool
+	current  int          // smooth weighted round-robin state, guarded by the rotation
+	pacer    *pacer.Pacer // RateLimit, shared by every instance; nil without Redis
+
+	mu        sync.Mutex
+	failures  int       // consecutive connection or login failures
+	downUntil time.Time // out of rotation until then
+	errors    errorWindow
+	state     string
+}
+
+// accountRotation hands out the accounts for sends, skipping those that are
+// cooling down or over their error budget.
+type accountRotation struct {
+	accounts    []*smtpAccount
+	weighted    bool
+	maxFailures int
+	cooldown    time.Duration
+	minSends    int  // sends in the error window before the budget applies
+	overflow    bool // hand out no account when none is in rotation
+
+	mu   sync.Mutex
+	next int
+}
+
+func newAccountRotation(cfg *config.ApplicationConfig, client *redis.Client) *accountRotation {
+	r := &accountRotation{
+		weighted:    cfg.SMTPAccountRotation == config.RotationWeighted,
+		maxFailures: cfg.SMTPAccountMaxFailures,
+		cooldown:    cfg.SMTPAccountCooldown,
+		minSends:    cfg.SMTPAccountMinSends,
+		overflow:    cfg.SMTPOverflow,
+	}
+	width := max(cfg.SMTPAccountErrorWindow/errorWindowBuckets, time.Millisecond)
+	for _, account := range cfg.SMTPAccounts {
+		a := &smtpAccount{
+			SMTPAccountConfig: account,
+			errors:            errorWindow{width: width},
+			state:             accountStateUp,
+		}
+		if client != nil {
+			a.pacer = pacer.New(client, accountRateKey+account.Name, account.RateLimit)
+		}
+		r.accounts = append(r.accounts, a)
+		accountUp.Set(1, account.Name)
+	}
+	return r
+}
+
+// pick returns the account for the next send: the next one in turn, or with
+// weighted rotation the one furthest behind its share (smooth weighted
+// round-robin, which interleaves accounts rather than sending in runs).
+// Accounts out of rotation are skipped, and standby accounts are only
+// picked while no other account is in rotation. When every account is out,
+// pick returns nil with SMTP_OVERFLOW on, and otherwise picks among all of
+// them.
+func (r *accountRotation) pick() *smtpAccount {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	candidates := r.inRotation(time.Now())
+	if len(candidates) == 0 {
+		if r.overflow {
+			return nil
+		}
+		candidates = r.accounts
+	}
+
+	total := 0
+	if r.weighted {
+		for _, account := range candidates {
+			total += account.Weight
+		}
+	}
+	if total == 0 {
+		account := candidates[r.next%len(candidates)]
+		r.next++
+		return account
+	}
+
+	var best *smtpAccount
+	for _, account := range candidates {
+		account.current += account.Weight
+		if best == nil || account.current > best.current {
+			best = account
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// inRotation returns the accounts pick chooses among: those in rotation,
+// or while none of them is, the standby accounts in rotation.
+func (r *accountRotation) inRotation(now time.Time) []*smtpAccount {
+	var primaries, standbys []*smtpAccount
+	for _, account := range r.accounts {
+		if r.refresh(account, now) != accountStateUp {
+			continue
+		}
+		if account.Standby {
+			standbys = append(standbys, account)
+		} else {
+			primaries = append(primaries, account)
+		}
+	}
+	if len(primaries) == 0 {
+		return standbys
+	}
+	return primaries
+}
+
+// availableRate is the emails per second the accounts pick chooses among
+// can send, the sum of their RateLimit. It is 0, unlimited, when one of
+// them has no RateLimit or none is configured.
+func (r *accountRotation) availableRate() float64 {
+	rate := 0.0
+	for _, account := range r.inRotation(time.Now()) {
+		if account.RateLimit <= 0 {
+			return 0
+		}
+		rate += account.RateLimit
+	}
+	return rate
+}
+
+// available reports whether pick would return an account.
+func (r *accountRotation) available() bool {
+	if !r.overflow {
+		return true
+	}
+	now := time.Now()
+	for _, account := range r.accounts {
+		if r.refresh(account, now) == accountStateUp {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh works out the state of the account, counting the transition when
+// it changed. An account is cooling down after repeated connection or login
+// failures, and over budget while the failed share of its sends within the
+// error window is above its error budget. With the account out of
+// rotation, its failures age out of the window until it is back within
+// budget.
+func (r *accountRotation) refresh(a *smtpAccount, now time.Time) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	state := accountStateUp
+	sends, failures := a.errors.counts(now)
+	rate := 0.0
+	if sends > 0 {
+		rate = float64(failures) / float64(sends)
+	}
+	switch {
+	case now.Before(a.downUntil):
+		state = accountStateCooldown
+	case a.ErrorBudget > 0 && sends >= r.minSends && rate > a.ErrorBudget:
+		state = accountStateOverBudget
+	}
+	accountErrorRate.Set(rate, a.Name)
+
+	if state != a.state {
+		a.state = state
+		accountTransitions.Inc(a.Name, state)
+		if state == accountStateUp {
+			accountUp.Set(1, a.Name)
+		} else {
+			accountUp.Set(0, a.Name)
+		}
+	}
+	return state
+}
+
+// wait paces sends through the account to RateLimit per second across
+// every instance. Without Redis (the self-test) sends are not paced.
+func (a *smtpAccount) wait(ctx context.Context) error {
+	if a.pacer == nil {
+		return nil
+	}
+	return a.pacer.Wait(ctx)
+}
+
+// record updates the account's health with the outcome of a send. Only
+// failures of the account itself take it out of rotation for the cooldown:
+// the connection failing, the server closing it, or the login or TLS being
+// refused. Those and temporary failures count against its error budget.
+// Rejections of a message or recipient say nothing about the account.
+func (r *accountRotation) record(a *smtpAccount, err error) {
+	outcome := "sent"
+	if err != nil {
+		outcome = "failed"
+	}
+	accountSends.Inc(a.Name, outcome)
+
+	now := time.Now()
+	a.mu.Lock()
+	a.errors.add(now, providerFailure(err))
+	if accountFault(err) {
+		a.failures++
+		if r.maxFailures > 0 && a.failures >= r.maxFailures {
+			a.failures = 0
+			a.downUntil = now.Add(r.cooldown)
+		}
+	} else {
+		a.failures = 0
+	}
+	a.mu.Unlock()
+
+	r.refresh(a, now)
+}
+
+// accountFault reports whether err is a failure of the SMTP account rather
+// than of the message: failing to connect or to set up TLS, the connection
+// failing during the session, or a 421 (service closing), 454 (TLS or
+// authentication unavailable), 530 (authentication required) or 535
+// (credentials rejected) reply. Local errors, such as an invalid address or
+// the send's context ending, are not.
+func accountFault(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		switch reply.Code {
+		case 421, 454, 530, 535:
+			return true
+		}
+		return false
+	}
+	var connErr connectionError
+	var netErr net.Error
+	return errors.As(err, &connErr) || errors.As(err, &netErr)
+}
+
+// providerFailure reports whether err counts against the account's error
+// budget: an account fault, or a temporary 4xx reply, which relays give
+// while they are throttling or degraded.
+func providerFailure(err error) bool {
+	if accountFault(err) {
+		return true
+	}
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code >= 400 && reply.Code < 500
+}
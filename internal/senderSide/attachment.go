@@ -0,0 +1,83 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	storage "github.com/sarthakyeole/redis-go-mailing-bulk/internal/objectStore"
+)
+
+// Attachment references a file by https:// or s3:// URL. The content is
+// fetched at send time so it never passes through the API or sits in Redis.
+type Attachment struct {
+	URL         string `json:"url"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type attachmentFile struct {
+	filename    string
+	contentType string
+	content     []byte
+}
+
+func (s *Sender) fetchAttachments(ctx context.Context, attachments []Attachment) ([]attachmentFile, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	var files []attachmentFile
+	var total int64
+
+	for _, att := range attachments {
+		content, contentType, err := s.store.Fetch(ctx, att.URL, s.config.AttachmentMaxBytes)
+		if err != nil {
+			if errors.Is(err, storage.ErrTooLarge) {
+				return nil, fmt.Errorf("attachment %s exceeds %d bytes", att.URL, s.config.AttachmentMaxBytes)
+			}
+			return nil, fmt.Errorf("failed to fetch attachment: %w", err)
+		}
+
+		total += int64(len(content))
+		if s.config.AttachmentMaxTotalBytes > 0 && total > s.config.AttachmentMaxTotalBytes {
+			return nil, fmt.Errorf("attachments exceed %d bytes in total", s.config.AttachmentMaxTotalBytes)
+		}
+
+		files = append(files, attachmentFile{
+			filename:    attachmentFilename(att),
+			contentType: attachmentContentType(att, contentType),
+			content:     content,
+		})
+	}
+
+	return files, nil
+}
+
+func attachmentFilename(att Attachment) string {
+	if att.Filename != "" {
+		return att.Filename
+	}
+
+	name := path.Base(strings.SplitN(att.URL, "?", 2)[0])
+	if name == "" || name == "/" || name == "." {
+		return "attachment"
+	}
+	return name
+}
+
+func attachmentContentType(att Attachment, fetched string) string {
+	if att.ContentType != "" {
+		return att.ContentType
+	}
+	if fetched != "" {
+		return fetched
+	}
+	if byExt := mime.TypeByExtension(path.Ext(attachmentFilename(att))); byExt != "" {
+		return byExt
+	}
+	return "application/octet-stream"
+}
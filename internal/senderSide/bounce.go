@@ -0,0 +1,57 @@
+package email
+
+import (
+	"errors"
+	"net/textproto"
+	"regexp"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+)
+
+// enhancedStatusPattern matches the RFC 3463 enhanced status code at the
+// start of an SMTP reply, e.g. "5.1.1" in "550 5.1.1 User unknown".
+var enhancedStatusPattern = regexp.MustCompile(`^([245])\.(\d{1,3})\.(\d{1,3})\b`)
+
+// unknownMailboxPattern matches the reply texts servers without enhanced
+// status codes use for a mailbox that does not exist. Without one, a bare
+// 550 is as likely a spam or policy rejection as a bad address.
+var unknownMailboxPattern = regexp.MustCompile(`(?i)user unknown|unknown user|no such (user|mailbox|recipient)|` +
+	`(mailbox|user|recipient|address) (does not|doesn't) exist|(mailbox|user|recipient) not found|` +
+	`unknown (recipient|mailbox)|invalid (recipient|mailbox)|mailbox is disabled|account (is )?disabled`)
+
+// ClassifyBounce reports whether err is an SMTP rejection of the recipient:
+// events.BounceHard for unknown or disabled mailboxes and domains,
+// events.BounceSoft for full or temporarily unavailable mailboxes, and ""
+// for everything else (connection failures, policy or content rejections).
+func ClassifyBounce(err error) string {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		return ""
+	}
+
+	if match := enhancedStatusPattern.FindStringSubmatch(protoErr.Msg); match != nil {
+		class, subject, detail := match[1], match[2], match[3]
+		switch {
+		case class == "5" && subject == "1":
+			return events.BounceHard
+		case class == "5" && subject == "2" && detail == "1":
+			return events.BounceHard
+		case subject == "2" && (detail == "0" || detail == "1" || detail == "2"):
+			return events.BounceSoft
+		}
+		return ""
+	}
+
+	// Servers without enhanced status codes: 550/551/553 are only a hard
+	// bounce when the text says the mailbox is unknown; 452 reports
+	// insufficient storage.
+	switch protoErr.Code {
+	case 550, 551, 553:
+		if unknownMailboxPattern.MatchString(protoErr.Msg) {
+			return events.BounceHard
+		}
+	case 452:
+		return events.BounceSoft
+	}
+	return ""
+}
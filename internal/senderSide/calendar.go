@@ -0,0 +1,120 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+// Event describes a meeting invite sent as a text/calendar METHOD:REQUEST
+// part, which Outlook and Gmail render as a native invitation.
+type Event struct {
+	UID           string    `json:"uid,omitempty"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description,omitempty"`
+	Location      string    `json:"location,omitempty"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Organizer     string    `json:"organizer,omitempty"`
+	OrganizerName string    `json:"organizerName,omitempty"`
+}
+
+// buildICS renders the event as an iCalendar (RFC 5545) REQUEST addressed
+// to the recipient. The organizer defaults to the email's sender.
+func (s *Sender) buildICS(event *Event, to, from, fromName string) string {
+	organizer := event.Organizer
+	organizerName := event.OrganizerName
+	if organizer == "" {
+		organizer = from
+		if organizerName == "" {
+			organizerName = fromName
+		}
+	}
+
+	uid := event.UID
+	if uid == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		uid = hex.EncodeToString(b) + "@" + domainOf(s.config.EmailSenderAddress)
+	}
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"PRODID:-//redis-go-mailing-bulk//EN",
+		"VERSION:2.0",
+		"CALSCALE:GREGORIAN",
+		"METHOD:REQUEST",
+		"BEGIN:VEVENT",
+		"UID:" + uid,
+		"DTSTAMP:" + time.Now().UTC().Format(icsTimeFormat),
+		"DTSTART:" + event.Start.UTC().Format(icsTimeFormat),
+		"DTEND:" + event.End.UTC().Format(icsTimeFormat),
+		"SUMMARY:" + escapeICSText(event.Title),
+	}
+	if event.Description != "" {
+		lines = append(lines, "DESCRIPTION:"+escapeICSText(event.Description))
+	}
+	if event.Location != "" {
+		lines = append(lines, "LOCATION:"+escapeICSText(event.Location))
+	}
+
+	organizerLine := "ORGANIZER"
+	if organizerName != "" {
+		// Parameter values are quoted and may not contain DQUOTE.
+		organizerLine += fmt.Sprintf(`;CN="%s"`, strings.ReplaceAll(organizerName, `"`, "'"))
+	}
+	lines = append(lines,
+		organizerLine+":mailto:"+organizer,
+		"ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:"+to,
+		"SEQUENCE:0",
+		"STATUS:CONFIRMED",
+		"TRANSP:OPAQUE",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	)
+
+	var ics strings.Builder
+	for _, line := range lines {
+		ics.WriteString(foldICSLine(line))
+	}
+	return ics.String()
+}
+
+func escapeICSText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// foldICSLine splits content lines longer than 75 octets, continuing them
+// with a leading space as RFC 5545 requires, without breaking UTF-8 runes.
+func foldICSLine(line string) string {
+	var folded strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			folded.WriteString("\r\n ")
+			width = 1
+		}
+		folded.WriteRune(r)
+		width += size
+	}
+	folded.WriteString("\r\n")
+	return folded.String()
+}
+
+func domainOf(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
@@ -0,0 +1,41 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ClipModeOff  = "off"
+	ClipModeWarn = "warn"
+	ClipModeFail = "fail"
+)
+
+// ErrHTMLTooLarge is returned with HTML_CLIP_MODE=fail for messages whose
+// HTML is over HTML_CLIP_LIMIT. They are not sent or retried.
+var ErrHTMLTooLarge = errors.New("message HTML exceeds the clipping limit")
+
+func validateClipMode(mode string) error {
+	switch mode {
+	case ClipModeOff, ClipModeWarn, ClipModeFail:
+		return nil
+	default:
+		return fmt.Errorf("invalid HTML clip mode %q", mode)
+	}
+}
+
+// checkClipping measures the final HTML against HTML_CLIP_LIMIT. Gmail
+// clips messages over about 102KB behind a "View entire message" link,
+// hiding the footer with the unsubscribe link and the open pixel.
+func (s *Sender) checkClipping(body string, result *SendResult) error {
+	if s.config.HTMLClipMode == ClipModeOff {
+		return nil
+	}
+
+	result.HTMLBytes = len(body)
+	result.Clipped = s.config.HTMLClipLimit > 0 && len(body) > s.config.HTMLClipLimit
+	if result.Clipped && s.config.HTMLClipMode == ClipModeFail {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrHTMLTooLarge, len(body), s.config.HTMLClipLimit)
+	}
+	return nil
+}
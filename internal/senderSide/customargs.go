@@ -0,0 +1,172 @@
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Formats for passing an email's tags, metadata and pool on to the
+// provider, set by EMAIL_CUSTOM_ARGS.
+const (
+	CustomArgsNone     = "none"
+	CustomArgsHeaders  = "headers"
+	CustomArgsMailgun  = "mailgun"
+	CustomArgsSendGrid = "sendgrid"
+	CustomArgsPostmark = "postmark"
+	CustomArgsSES      = "ses"
+)
+
+const (
+	// mailgunMaxTags is how many X-Mailgun-Tag headers Mailgun accepts.
+	mailgunMaxTags = 3
+
+	// foldWidth is the line length past which JSON header values are
+	// folded, well inside the 998 bytes SMTP allows.
+	foldWidth = 900
+)
+
+func validateCustomArgs(format string) error {
+	switch format {
+	case CustomArgsNone, CustomArgsHeaders, CustomArgsMailgun, CustomArgsSendGrid, CustomArgsPostmark, CustomArgsSES:
+		return nil
+	default:
+		return fmt.Errorf("invalid custom args format %q", format)
+	}
+}
+
+// setCustomArgs adds the headers that carry the email's tags and metadata
+// in the provider's format, so they show up in its logs, analytics and
+// webhooks, and the one that routes it to its pool: a SendGrid IP pool, a
+// Postmark message stream, a Mailgun tag or an SES configuration set. Plain
+// SMTP relays get X-Mailqueue-Tags, X-Mailqueue-Metadata and
+// X-Mailqueue-Pool.
+func (s *Sender) setCustomArgs(headers textproto.MIMEHeader, msg Message) {
+	if len(msg.Tags) == 0 && len(msg.Metadata) == 0 && msg.Pool == "" {
+		return
+	}
+
+	switch s.config.EmailCustomArgs {
+	case CustomArgsHeaders:
+		if len(msg.Tags) > 0 {
+			headers.Set("X-Mailqueue-Tags", strings.Join(msg.Tags, ", "))
+		}
+		if len(msg.Metadata) > 0 {
+			headers.Set("X-Mailqueue-Metadata", jsonObject(metadataMembers(msg.Metadata)))
+		}
+		if msg.Pool != "" {
+			headers.Set("X-Mailqueue-Pool", msg.Pool)
+		}
+
+	case CustomArgsMailgun:
+		// The pool tag comes first so it is never the one left out.
+		tags := msg.Tags
+		if msg.Pool != "" {
+			tags = append([]string{msg.Pool}, tags...)
+		}
+		for i, tag := range tags {
+			if i == mailgunMaxTags {
+				break
+			}
+			headers.Add("X-Mailgun-Tag", tag)
+		}
+		if len(msg.Metadata) > 0 {
+			headers.Set("X-Mailgun-Variables", jsonObject(metadataMembers(msg.Metadata)))
+		}
+
+	case CustomArgsSendGrid:
+		var members []string
+		if len(msg.Tags) > 0 {
+			categories, _ := json.Marshal(msg.Tags)
+			members = append(members, `"categories":`+string(categories))
+		}
+		if len(msg.Metadata) > 0 {
+			members = append(members, `"unique_args":`+jsonObject(metadataMembers(msg.Metadata)))
+		}
+		if msg.Pool != "" {
+			pool, _ := json.Marshal(msg.Pool)
+			members = append(members, `"ip_pool":`+string(pool))
+		}
+		headers.Set("X-SMTPAPI", jsonObject(members))
+
+	case CustomArgsPostmark:
+		if len(msg.Tags) > 0 {
+			headers.Set("X-PM-Tag", msg.Tags[0])
+		}
+		// Assigned directly: Set would canonicalize the key's case.
+		for key, value := range msg.Metadata {
+			headers["X-PM-Metadata-"+key] = []string{value}
+		}
+		if msg.Pool != "" {
+			headers.Set("X-PM-Message-Stream", msg.Pool)
+		}
+
+	case CustomArgsSES:
+		// SES has no free-form tags; metadata pairs it accepts as message
+		// tags are passed, the others left out.
+		var pairs []string
+		for _, key := range sortedKeys(msg.Metadata) {
+			if value := msg.Metadata[key]; sesTagPattern.MatchString(key) && sesTagPattern.MatchString(value) {
+				pairs = append(pairs, key+"="+value)
+			}
+		}
+		if len(pairs) > 0 {
+			headers.Set("X-SES-MESSAGE-TAGS", strings.Join(pairs, ", "))
+		}
+		if msg.Pool != "" {
+			headers.Set("X-SES-CONFIGURATION-SET", msg.Pool)
+		}
+	}
+}
+
+// sesTagPattern matches the message tag names and values SES accepts.
+var sesTagPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// sortedKeys returns the keys of metadata in order.
+func sortedKeys(metadata map[string]string) []string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metadataMembers encodes each metadata pair as a JSON object member,
+// ordered by key.
+func metadataMembers(metadata map[string]string) []string {
+	keys := sortedKeys(metadata)
+	members := make([]string, len(keys))
+	for i, key := range keys {
+		name, _ := json.Marshal(key)
+		value, _ := json.Marshal(metadata[key])
+		members[i] = string(name) + ":" + string(value)
+	}
+	return members
+}
+
+// jsonObject joins members into a JSON object, folding the header line
+// between members once it grows past foldWidth. Unfolding leaves a space
+// there, which JSON ignores.
+func jsonObject(members []string) string {
+	var b strings.Builder
+	b.WriteString("{")
+	line := len("{")
+	for i, member := range members {
+		if i > 0 {
+			b.WriteString(",")
+			line++
+		}
+		if line > 1 && line+len(member) > foldWidth {
+			b.WriteString("\r\n ")
+			line = 1
+		}
+		b.WriteString(member)
+		line += len(member)
+	}
+	b.WriteString("}")
+	return b.String()
+}
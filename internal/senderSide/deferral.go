@@ -0,0 +1,53 @@
+package email
+
+import (
+	"errors"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryHintPattern matches phrases such as "try again in 300 seconds" or
+// "retry after 5 minutes" that greylisting servers put in 4xx replies.
+var retryHintPattern = regexp.MustCompile(`(?i)(?:in|after|wait)\s+(\d+)\s*(seconds?|secs?|s|minutes?|mins?|m|hours?|hrs?|h)\b`)
+
+// IsDeferral reports whether err is a transient SMTP 4xx reply (421, 450,
+// 451, 452, ...) where the server asks the client to come back later.
+func IsDeferral(err error) bool {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		return false
+	}
+	return protoErr.Code >= 400 && protoErr.Code < 500
+}
+
+// DeferralDelay returns the delay suggested by a 4xx deferral reply. The
+// boolean is false when err is not a deferral or carries no usable hint.
+func DeferralDelay(err error) (time.Duration, bool) {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code < 400 || protoErr.Code >= 500 {
+		return 0, false
+	}
+
+	match := retryHintPattern.FindStringSubmatch(protoErr.Msg)
+	if match == nil {
+		return 0, false
+	}
+
+	amount, err := strconv.Atoi(match[1])
+	if err != nil || amount <= 0 {
+		return 0, false
+	}
+
+	unit := time.Second
+	switch strings.ToLower(match[2])[0] {
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	}
+
+	return time.Duration(amount) * unit, true
+}
@@ -0,0 +1,36 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// CheckSMTP connects to each SMTP account the way a send does (EHLO,
+// STARTTLS when offered, AUTH) and quits without sending, so connection
+// and credential problems show up before the first email.
+func (s *Sender) CheckSMTP(ctx context.Context) error {
+	if err := s.validateSMTPConfig(); err != nil {
+		return fmt.Errorf("invalid SMTP configuration: %w", err)
+	}
+
+	var errs []error
+	for _, account := range s.accounts.accounts {
+		if err := s.checkAccount(ctx, account); err != nil {
+			if account.Name != "" {
+				err = fmt.Errorf("SMTP account %s: %w", account.Name, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Sender) checkAccount(ctx context.Context, account *smtpAccount) error {
+	session, err := s.dialSMTP(ctx, account)
+	if err != nil {
+		return err
+	}
+	defer session.client.Close()
+	return session.client.Quit()
+}
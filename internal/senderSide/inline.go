@@ -0,0 +1,56 @@
+package email
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// dataImagePattern matches <img src="data:image/...;base64,..."> attributes,
+// such as those produced by the qrcode template function.
+var dataImagePattern = regexp.MustCompile(`(?i)(src=["'])data:(image/[a-z0-9.+-]+);base64,([a-z0-9+/=\s]+)(["'])`)
+
+type inlineImage struct {
+	contentID   string
+	contentType string
+	content     []byte
+}
+
+// extractInlineImages replaces base64 data URI images in body with cid:
+// references and returns the images to attach as multipart/related parts.
+// Identical images share one part.
+func extractInlineImages(body, senderAddress string) (string, []inlineImage) {
+	var images []inlineImage
+	seen := make(map[string]string)
+
+	body = dataImagePattern.ReplaceAllStringFunc(body, func(match string) string {
+		groups := dataImagePattern.FindStringSubmatch(match)
+		encoded := strings.Join(strings.Fields(groups[3]), "")
+
+		content, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return match
+		}
+
+		sum := sha256.Sum256(content)
+		digest := hex.EncodeToString(sum[:8])
+
+		contentID, ok := seen[digest]
+		if !ok {
+			contentID = fmt.Sprintf("img-%s@%s", digest, domainOf(senderAddress))
+			seen[digest] = contentID
+			images = append(images, inlineImage{
+				contentID:   contentID,
+				contentType: strings.ToLower(groups[2]),
+				content:     content,
+			})
+		}
+
+		return groups[1] + "cid:" + contentID + groups[4]
+	})
+
+	return body, images
+}
@@ -0,0 +1,172 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/netguard"
+)
+
+const (
+	LinkCheckOff  = "off"
+	LinkCheckFlag = "flag"
+	LinkCheckFail = "fail"
+
+	// linkCheckParallelism bounds the requests made for one message.
+	linkCheckParallelism = 8
+
+	// linkCacheMaxEntries bounds the result cache; it is cleared when full.
+	linkCacheMaxEntries = 10000
+)
+
+// ErrBrokenLinks is returned with LINK_CHECK_MODE=fail for messages with a
+// link answering 4xx/5xx. They are not sent or retried.
+var ErrBrokenLinks = errors.New("message has broken links")
+
+var linkChecks = metrics.NewCounter(
+	"mailqueue_link_checks_total",
+	"Pre-send link checks by result.",
+	"result",
+)
+
+// linkChecker HEAD-checks the links of rendered messages, caching each URL's
+// status so links shared by a broadcast are requested once. Requests go
+// through a netguard client, so links to the host itself, the internal
+// network or metadata endpoints are never requested.
+type linkChecker struct {
+	httpClient *http.Client
+	ttl        time.Duration
+	skipPrefix string
+
+	mu    sync.Mutex
+	cache map[string]linkCacheEntry
+}
+
+type linkCacheEntry struct {
+	status  int
+	expires time.Time
+}
+
+func newLinkChecker(cfg *config.ApplicationConfig) (*linkChecker, error) {
+	switch cfg.LinkCheckMode {
+	case LinkCheckOff:
+		return nil, nil
+	case LinkCheckFlag, LinkCheckFail:
+	default:
+		return nil, fmt.Errorf("invalid link check mode %q", cfg.LinkCheckMode)
+	}
+
+	skipPrefix := ""
+	if cfg.TrackingBaseURL != "" {
+		skipPrefix = cfg.TrackingBaseURL + "/"
+	}
+
+	return &linkChecker{
+		httpClient: netguard.New(nil).Client(cfg.LinkCheckTimeout),
+		ttl:        cfg.LinkCheckCacheTTL,
+		skipPrefix: skipPrefix,
+		cache:      make(map[string]linkCacheEntry),
+	}, nil
+}
+
+// checkLinks returns the links in body that answer 4xx/5xx, each followed by
+// its status. Links that can't be reached at all are not reported, since
+// the failure is as likely to be on this side, and neither are links the
+// guard refused, so a body can't be used to probe internal hosts.
+func (s *Sender) checkLinks(ctx context.Context, body string) ([]string, error) {
+	if s.links == nil {
+		return nil, nil
+	}
+
+	seen := make(map[string]struct{})
+	var urls []string
+	for _, groups := range linkPattern.FindAllStringSubmatch(body, -1) {
+		link := html.UnescapeString(groups[3])
+		if _, ok := seen[link]; ok || (s.links.skipPrefix != "" && strings.HasPrefix(link, s.links.skipPrefix)) {
+			continue
+		}
+		seen[link] = struct{}{}
+		urls = append(urls, link)
+	}
+
+	statuses := make([]int, len(urls))
+	slots := make(chan struct{}, linkCheckParallelism)
+	var wg sync.WaitGroup
+	for i, link := range urls {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			statuses[i] = s.links.status(ctx, link)
+		}()
+	}
+	wg.Wait()
+
+	var broken []string
+	for i, status := range statuses {
+		if status >= 400 {
+			broken = append(broken, fmt.Sprintf("%s (%d)", urls[i], status))
+		}
+	}
+
+	switch {
+	case len(broken) == 0:
+		linkChecks.Inc("ok")
+	case s.config.LinkCheckMode == LinkCheckFail:
+		linkChecks.Inc("failed")
+		return broken, fmt.Errorf("%w: %s", ErrBrokenLinks, strings.Join(broken, ", "))
+	default:
+		linkChecks.Inc("flagged")
+	}
+	return broken, nil
+}
+
+// status returns the HTTP status of link, or 0 when it could not be
+// requested. Servers that don't support HEAD are asked with GET.
+func (c *linkChecker) status(ctx context.Context, link string) int {
+	c.mu.Lock()
+	entry, ok := c.cache[link]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.status
+	}
+
+	status := c.request(ctx, http.MethodHead, link)
+	if status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented {
+		status = c.request(ctx, http.MethodGet, link)
+	}
+	if status == 0 {
+		return 0
+	}
+
+	c.mu.Lock()
+	if len(c.cache) >= linkCacheMaxEntries {
+		c.cache = make(map[string]linkCacheEntry)
+	}
+	c.cache[link] = linkCacheEntry{status: status, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+
+	return status
+}
+
+func (c *linkChecker) request(ctx context.Context, method, link string) int {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return 0
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
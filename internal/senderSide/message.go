@@ -0,0 +1,225 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+)
+
+// sender returns the address and display name msg is sent from: its own,
+// else the configured ones.
+func (s *Sender) sender(msg Message) (string, string) {
+	if msg.From == "" {
+		return s.config.EmailSenderAddress, s.config.EmailSenderDisplayName
+	}
+	if msg.FromName == "" {
+		return msg.From, s.config.EmailSenderDisplayName
+	}
+	return msg.From, msg.FromName
+}
+
+// envelopeRecipients are the addresses msg is delivered to: Recipients for
+// a broadcast, where To only appears in the header, else To.
+func (msg Message) envelopeRecipients() []string {
+	if len(msg.Recipients) > 0 {
+		return msg.Recipients
+	}
+	return []string{msg.To}
+}
+
+// buildMessage assembles the raw RFC 5322 message. Plain emails keep the
+// original single-part text/html layout; inline images wrap the HTML in
+// multipart/related, calendar invites add a multipart/alternative
+// text/calendar part and attachments wrap everything in multipart/mixed.
+// headers are written after the standard ones in sorted order.
+func (s *Sender) buildMessage(msg Message, body string, headers textproto.MIMEHeader, files []attachmentFile) ([]byte, error) {
+	var message bytes.Buffer
+	from, fromName := s.sender(msg)
+	message.WriteString(fmt.Sprintf("From: %s <%s>\r\n", mime.QEncoding.Encode("UTF-8", fromName), from))
+	message.WriteString(fmt.Sprintf("To: %s\r\n", msg.To))
+	message.WriteString(fmt.Sprintf("Subject: %s\r\n", msg.Subject))
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			message.WriteString(fmt.Sprintf("%s: %s\r\n", name, value))
+		}
+	}
+
+	message.WriteString("MIME-Version: 1.0\r\n")
+
+	calendar := ""
+	if msg.Event != nil {
+		calendar = s.buildICS(msg.Event, msg.To, from, fromName)
+	}
+
+	var images []inlineImage
+	if s.config.EmailInlineImages {
+		body, images = extractInlineImages(body, s.config.EmailSenderAddress)
+	}
+
+	switch {
+	case len(files) == 0 && calendar == "" && len(images) == 0:
+		message.WriteString("Content-Type: text/html; charset=UTF-8\r\n\r\n")
+		message.WriteString(body)
+		return message.Bytes(), nil
+
+	case len(files) == 0 && calendar == "":
+		writer := multipart.NewWriter(&message)
+		message.WriteString(fmt.Sprintf("Content-Type: multipart/related; boundary=%q; type=\"text/html\"\r\n\r\n", writer.Boundary()))
+		if err := writeRelatedParts(writer, body, images); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return message.Bytes(), nil
+
+	case len(files) == 0:
+		writer := multipart.NewWriter(&message)
+		message.WriteString(fmt.Sprintf("Content-Type: multipart/alternative; boundary=%q\r\n\r\n", writer.Boundary()))
+		if err := writeAlternativeParts(writer, body, calendar, images); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return message.Bytes(), nil
+	}
+
+	writer := multipart.NewWriter(&message)
+	message.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\r\n\r\n", writer.Boundary()))
+
+	if calendar == "" {
+		if err := writeHTMLPart(writer, body, images); err != nil {
+			return nil, err
+		}
+	} else {
+		inner := multipart.NewWriter(io.Discard)
+		alternativePart, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {fmt.Sprintf("multipart/alternative; boundary=%q", inner.Boundary())},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		nested := multipart.NewWriter(alternativePart)
+		nested.SetBoundary(inner.Boundary())
+		if err := writeAlternativeParts(nested, body, calendar, images); err != nil {
+			return nil, err
+		}
+		if err := nested.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, file := range files {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(file.contentType, map[string]string{"name": file.filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": file.filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, file.content)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return message.Bytes(), nil
+}
+
+// writeHTMLPart writes the HTML body as a text/html part, or as a nested
+// multipart/related part when it references inline images.
+func writeHTMLPart(writer *multipart.Writer, body string, images []inlineImage) error {
+	if len(images) == 0 {
+		htmlPart, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"text/html; charset=UTF-8"},
+		})
+		if err != nil {
+			return err
+		}
+		htmlPart.Write([]byte(body))
+		return nil
+	}
+
+	inner := multipart.NewWriter(io.Discard)
+	relatedPart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {fmt.Sprintf("multipart/related; boundary=%q; type=\"text/html\"", inner.Boundary())},
+	})
+	if err != nil {
+		return err
+	}
+
+	nested := multipart.NewWriter(relatedPart)
+	nested.SetBoundary(inner.Boundary())
+	if err := writeRelatedParts(nested, body, images); err != nil {
+		return err
+	}
+	return nested.Close()
+}
+
+// writeRelatedParts writes the HTML body followed by the images it
+// references by Content-ID.
+func writeRelatedParts(writer *multipart.Writer, body string, images []inlineImage) error {
+	if err := writeHTMLPart(writer, body, nil); err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {image.contentType},
+			"Content-ID":                {"<" + image.contentID + ">"},
+			"Content-Disposition":       {"inline"},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		writeBase64Lines(part, image.content)
+	}
+
+	return nil
+}
+
+// writeAlternativeParts writes the HTML body followed by the calendar
+// request; clients pick the last part they understand.
+func writeAlternativeParts(writer *multipart.Writer, body, calendar string, images []inlineImage) error {
+	if err := writeHTMLPart(writer, body, images); err != nil {
+		return err
+	}
+
+	calendarPart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`text/calendar; charset=UTF-8; method=REQUEST`},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64Lines(calendarPart, []byte(calendar))
+
+	return nil
+}
+
+// writeBase64Lines encodes content in 76-character lines as required by
+// RFC 2045.
+func writeBase64Lines(w io.Writer, content []byte) {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
@@ -1,94 +1,302 @@
 package email
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"net/smtp"
+	"net/textproto"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
 	templates "github.com/sarthakyeole/redis-go-mailing-bulk/internal/emailTemplate"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+	storage "github.com/sarthakyeole/redis-go-mailing-bulk/internal/objectStore"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/token"
+)
+
+var smtpSeconds = metrics.NewHistogram(
+	"mailqueue_smtp_send_seconds",
+	"Time to deliver a message over SMTP, connection and handshake included, by provider (SMTP server), template and result.",
+	[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	"provider", "template", "result",
 )
 
 type Sender struct {
 	config    *config.ApplicationConfig
 	templates *templates.Manager
+	store     *storage.Store
+	tokens    *token.Signer
+	spam      spamChecker
+	links     *linkChecker
+	dryRun    bool // build messages but never connect to the SMTP server
+	accounts  *accountRotation
+}
+
+// Message is a single email ready for delivery. When Body is set it is sent
+// as-is; otherwise TemplateName is rendered with Data.
+type Message struct {
+	From          string // sender address, EMAIL_SENDER_ADDRESS when empty
+	FromName      string // sender display name, EMAIL_SENDER_NAME when empty
+	To            string
+	Recipients    []string // envelope recipients of a broadcast; To is then only the header
+	Subject       string
+	TemplateName  string
+	Data          map[string]interface{}
+	Body          string
+	BodyRef       string
+	Attachments   []Attachment
+	Event         *Event
+	Preheader     string
+	Locale        string
+	JobID         string
+	Tenant        string
+	InReplyTo     string
+	References    []string
+	UTM           *UTM
+	BatchID       string
+	Variant       string
+	Category      string
+	Tags          []string
+	Metadata      map[string]string
+	Pool          string   // provider IP pool, message stream or configuration set
+	ApprovedRules []string // approval rules an admin released the message from
 }
 
-func NewSender(cfg *config.ApplicationConfig, tmpl *templates.Manager) *Sender {
+// SendResult reports what happened to a message besides delivery. Spam is
+// nil when spam checking is off or the checker could not be reached;
+// BrokenLinks lists the links that answered 4xx/5xx; HTMLBytes is the size
+// of the final HTML when the clipping check is on, and Clipped is set when
+// it is over HTML_CLIP_LIMIT. Delivered lists the envelope recipients the
+// server accepted the message for, and Refused those it turned down.
+// Account names the SMTP account the send went through, when SMTP_ACCOUNTS
+// is set.
+type SendResult struct {
+	Account     string
+	Spam        *SpamReport
+	BrokenLinks []string
+	HTMLBytes   int
+	Clipped     bool
+	Delivered   []string
+	Refused     []RefusedRecipient
+}
+
+func NewSender(cfg *config.ApplicationConfig, client *redis.Client, tmpl *templates.Manager, store *storage.Store, tokens *token.Signer) (*Sender, error) {
+	spam, err := newSpamChecker(cfg)
+	if err != nil {
+		return nil, err
+	}
+	links, err := newLinkChecker(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateClipMode(cfg.HTMLClipMode); err != nil {
+		return nil, err
+	}
+	if err := validateCustomArgs(cfg.EmailCustomArgs); err != nil {
+		return nil, err
+	}
+
 	return &Sender{
 		config:    cfg,
 		templates: tmpl,
+		store:     store,
+		tokens:    tokens,
+		spam:      spam,
+		links:     links,
+		accounts:  newAccountRotation(cfg, client),
+	}, nil
+}
+
+// NewDryRunSender returns a Sender that renders, tracks and builds every
+// message like a real one but skips the SMTP delivery, and the link and spam
+// checks that would call out to other services. The load test uses it to
+// measure the queue without sending mail.
+func NewDryRunSender(cfg *config.ApplicationConfig, client *redis.Client, tmpl *templates.Manager, store *storage.Store, tokens *token.Signer) (*Sender, error) {
+	s, err := NewSender(cfg, client, tmpl, store, tokens)
+	if err != nil {
+		return nil, err
 	}
+	s.spam = nil
+	s.links = nil
+	s.dryRun = true
+	return s, nil
 }
 
 func (s *Sender) SendEmail(to, subject, templateName string, data map[string]interface{}) error {
+	_, err := s.Send(context.Background(), Message{
+		To:           to,
+		Subject:      subject,
+		TemplateName: templateName,
+		Data:         data,
+	})
+	return err
+}
+
+func (s *Sender) Send(ctx context.Context, msg Message) (SendResult, error) {
 	// Validate inputs
-	if to == "" {
-		return fmt.Errorf("recipient email address cannot be empty")
+	if msg.To == "" {
+		return SendResult{}, fmt.Errorf("recipient email address cannot be empty")
 	}
-	if subject == "" {
-		return fmt.Errorf("email subject cannot be empty")
+	if msg.Subject == "" {
+		return SendResult{}, fmt.Errorf("email subject cannot be empty")
 	}
-	if templateName == "" {
-		return fmt.Errorf("email template name cannot be empty")
+	if msg.Body == "" && msg.BodyRef == "" && msg.TemplateName == "" {
+		return SendResult{}, fmt.Errorf("email template name cannot be empty")
 	}
 
 	// Validate SMTP configuration
-	if err := s.validateSMTPConfig(); err != nil {
-		return fmt.Errorf("invalid SMTP configuration: %w", err)
+	if !s.dryRun {
+		if err := s.validateSMTPConfig(); err != nil {
+			return SendResult{}, fmt.Errorf("invalid SMTP configuration: %w", err)
+		}
+	}
+
+	// Hydrate a body that was offloaded to the object store
+	body := msg.Body
+	if body == "" && msg.BodyRef != "" {
+		if s.store == nil {
+			return SendResult{}, fmt.Errorf("cannot load offloaded body: object store is not configured")
+		}
+		content, _, err := s.store.Fetch(ctx, msg.BodyRef, 0)
+		if err != nil {
+			return SendResult{}, fmt.Errorf("failed to load offloaded body: %w", err)
+		}
+		body = string(content)
+	}
+
+	// Render email template unless the body was rendered at enqueue time
+	if body == "" {
+		rendered, err := s.templates.RenderWithSafeURLs(msg.TemplateName, msg.Data, templates.WithLocale(msg.Locale))
+		if err != nil {
+			return SendResult{}, fmt.Errorf("failed to render email template: %w", err)
+		}
+		body = rendered
+	}
+
+	body = templates.InjectPreheader(body, msg.Preheader)
+
+	// Check links before they are rewritten through the click redirect
+	brokenLinks, err := s.checkLinks(ctx, body)
+	result := SendResult{BrokenLinks: brokenLinks}
+	if err != nil {
+		return result, err
+	}
+
+	// Sign the unsubscribe link and rewrite links for open/click tracking
+	body, unsubscribeURL, err := s.applyTracking(body, msg)
+	if err != nil {
+		return result, fmt.Errorf("failed to apply tracking: %w", err)
+	}
+
+	if err := s.checkClipping(body, &result); err != nil {
+		return result, err
+	}
+
+	headers := make(textproto.MIMEHeader)
+	s.setThreadingHeaders(headers, msg)
+	s.setCustomArgs(headers, msg)
+	if unsubscribeURL != "" {
+		headers.Set("List-Unsubscribe", "<"+unsubscribeURL+">")
+		// One-click unsubscribe needs an HTTPS link; a mailto has none.
+		if !strings.HasPrefix(unsubscribeURL, "mailto:") {
+			headers.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+		}
 	}
 
-	// Render email template
-	body, err := s.templates.RenderWithSafeURLs(templateName, data)
+	// Download attachments referenced by URL
+	files, err := s.fetchAttachments(ctx, msg.Attachments)
 	if err != nil {
-		return fmt.Errorf("failed to render email template: %w", err)
+		return result, err
 	}
 
 	// Prepare email message
-	var message bytes.Buffer
-	message.WriteString(fmt.Sprintf("From: %s <%s>\r\n", s.config.EmailSenderDisplayName, s.config.EmailSenderAddress))
-	message.WriteString(fmt.Sprintf("To: %s\r\n", to))
-	message.WriteString(fmt.Sprintf("Subject: %s\r\n", subject))
-	message.WriteString("MIME-Version: 1.0\r\n")
-	message.WriteString("Content-Type: text/html; charset=UTF-8\r\n\r\n")
-	message.WriteString(body)
-
-	// Prepare SMTP connection
-	addr := fmt.Sprintf("%s:%d", s.config.EmailSMTPServer, s.config.EmailSMTPServerPort)
-
-	// Create authentication
-	auth := smtp.PlainAuth(
-		"",
-		s.config.EmailSMTPUsername,
-		s.config.EmailSMTPPassword,
-		s.config.EmailSMTPServer,
-	)
-
-	// Send email using standard library method with TLS
-	return smtp.SendMail(
-		addr,
-		auth,
-		s.config.EmailSenderAddress,
-		[]string{to},
-		message.Bytes(),
-	)
+	message, err := s.buildMessage(msg, body, headers, files)
+	if err != nil {
+		return result, fmt.Errorf("failed to build email message: %w", err)
+	}
+
+	// Score the rendered message before it leaves
+	result.Spam, err = s.checkSpam(ctx, message, slices.Contains(msg.ApprovedRules, config.ApprovalSpamFlagged))
+	if err != nil {
+		return result, err
+	}
+	if s.dryRun {
+		return result, nil
+	}
+
+	// Take the next SMTP account in rotation only once the message passed
+	// the checks, so rejected messages don't shift the rotation. Its sender
+	// identity is used when the send doesn't name one
+	account := s.accounts.pick()
+	if account == nil {
+		return result, ErrNoAccountAvailable
+	}
+	result.Account = account.Name
+	if msg.From == "" && account.SenderAddress != "" {
+		msg.From = account.SenderAddress
+		if msg.FromName == "" {
+			msg.FromName = account.SenderName
+		}
+		if message, err = s.buildMessage(msg, body, headers, files); err != nil {
+			return result, fmt.Errorf("failed to build email message: %w", err)
+		}
+	}
+
+	// Send over SMTP with STARTTLS, batching a broadcast's recipients
+	if err := account.wait(ctx); err != nil {
+		return result, err
+	}
+	start := time.Now()
+	err = s.deliver(ctx, account, msg, message, &result)
+	s.accounts.record(account, err)
+	outcome := "sent"
+	if err != nil {
+		outcome = "failed"
+	}
+	smtpSeconds.Observe(time.Since(start).Seconds(), account.Server, msg.TemplateName, outcome)
+	return result, err
+}
+
+// Available reports whether an SMTP account is in rotation to send
+// through. It is always true unless SMTP_OVERFLOW is on.
+func (s *Sender) Available() bool {
+	return s.accounts.available()
+}
+
+// AvailableRate is the emails per second the SMTP accounts in rotation can
+// send, from their RATE_LIMIT; 0 when it is not limited.
+func (s *Sender) AvailableRate() float64 {
+	return s.accounts.availableRate()
 }
 
 func (s *Sender) validateSMTPConfig() error {
-	if strings.TrimSpace(s.config.EmailSMTPServer) == "" {
+	if strings.TrimSpace(s.config.EmailSenderAddress) == "" {
+		return fmt.Errorf("sender email address is not configured")
+	}
+	for _, account := range s.accounts.accounts {
+		if err := validateSMTPAccount(account.SMTPAccountConfig); err != nil {
+			if account.Name != "" {
+				return fmt.Errorf("SMTP account %s: %w", account.Name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func validateSMTPAccount(account config.SMTPAccountConfig) error {
+	if strings.TrimSpace(account.Server) == "" {
 		return fmt.Errorf("SMTP server is not configured")
 	}
-	if s.config.EmailSMTPServerPort <= 0 {
+	if account.Port <= 0 {
 		return fmt.Errorf("invalid SMTP server port")
 	}
-	if strings.TrimSpace(s.config.EmailSenderAddress) == "" {
-		return fmt.Errorf("sender email address is not configured")
-	}
-	if strings.TrimSpace(s.config.EmailSMTPUsername) == "" {
+	if strings.TrimSpace(account.Username) == "" {
 		return fmt.Errorf("SMTP username is not configured")
 	}
-	if strings.TrimSpace(s.config.EmailSMTPPassword) == "" {
+	if strings.TrimSpace(account.Password) == "" {
 		return fmt.Errorf("SMTP password is not configured")
 	}
 	return nil
@@ -0,0 +1,451 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+// smtpTimeout bounds the handshake, and then each transaction, of an SMTP
+// session.
+const smtpTimeout = 2 * time.Minute
+
+// ErrMessageTooLarge is returned for messages over the size the SMTP server
+// advertises with the SIZE extension. They are not sent or retried.
+var ErrMessageTooLarge = errors.New("message exceeds the SMTP server's size limit")
+
+// RefusedRecipient is a recipient the SMTP server refused at RCPT TO.
+type RefusedRecipient struct {
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+
+	reply *textproto.Error
+}
+
+// temporary reports whether the server only deferred the recipient (4xx),
+// so a later attempt may still reach it.
+func (r RefusedRecipient) temporary() bool {
+	return r.reply != nil && r.reply.Code < 500
+}
+
+var smtpSessions = metrics.NewCounter(
+	"mailqueue_smtp_sessions_total",
+	"SMTP sessions used for a send, by whether they were newly dialed or an idle one was reused.",
+	"state",
+)
+
+// smtpSession is an authenticated connection to the SMTP server, with the
+// extensions it advertised that transact makes use of.
+type smtpSession struct {
+	conn       net.Conn
+	client     *smtp.Client
+	pipelining bool // PIPELINING: a transaction's commands go out in one write
+	size       bool // SIZE: MAIL FROM declares the message size
+	maxSize    int  // largest message the server accepts, 0 when it gives no limit
+	eightBit   bool // 8BITMIME
+	utf8       bool // SMTPUTF8
+	reused     bool
+	lastUsed   time.Time
+	dataSent   bool // the last transaction got past DATA, so its message may have been delivered
+}
+
+// sessionPool keeps authenticated sessions between sends, so consecutive
+// sends skip the connect, TLS and AUTH round trips.
+type sessionPool struct {
+	mu   sync.Mutex
+	idle []*smtpSession
+}
+
+// acquireSMTP returns an idle session of account that answers RSET, or
+// dials a new one. Sessions idle for longer than EMAIL_SMTP_IDLE_TIMEOUT are
+// closed instead of reused, before the server drops them itself.
+func (s *Sender) acquireSMTP(ctx context.Context, account *smtpAccount) (*smtpSession, error) {
+	for {
+		account.sessions.mu.Lock()
+		var session *smtpSession
+		if n := len(account.sessions.idle); n > 0 {
+			session = account.sessions.idle[n-1]
+			account.sessions.idle = account.sessions.idle[:n-1]
+		}
+		account.sessions.mu.Unlock()
+
+		if session == nil {
+			smtpSessions.Inc("dialed")
+			return s.dialSMTP(ctx, account)
+		}
+		if time.Since(session.lastUsed) > s.config.EmailSMTPIdleTimeout {
+			session.close()
+			continue
+		}
+		session.conn.SetDeadline(time.Now().Add(smtpTimeout))
+		if err := session.client.Reset(); err != nil {
+			session.client.Close()
+			continue
+		}
+		session.reused = true
+		smtpSessions.Inc("reused")
+		return session, nil
+	}
+}
+
+// releaseSMTP keeps a session of account that ended cleanly for the next
+// send, or closes it when sessions are not kept.
+func (s *Sender) releaseSMTP(account *smtpAccount, session *smtpSession) {
+	if s.config.EmailSMTPIdleTimeout <= 0 {
+		session.close()
+		return
+	}
+	session.lastUsed = time.Now()
+	account.sessions.mu.Lock()
+	account.sessions.idle = append(account.sessions.idle, session)
+	account.sessions.mu.Unlock()
+}
+
+// Close quits the idle SMTP sessions. Call it once the workers have stopped.
+func (s *Sender) Close() {
+	for _, account := range s.accounts.accounts {
+		account.sessions.mu.Lock()
+		idle := account.sessions.idle
+		account.sessions.idle = nil
+		account.sessions.mu.Unlock()
+
+		for _, session := range idle {
+			session.close()
+		}
+	}
+}
+
+// connectionError wraps a failure to open a session with the account's
+// server: dialing, the greeting, EHLO or the TLS handshake.
+type connectionError struct{ err error }
+
+func (e connectionError) Error() string { return e.err.Error() }
+func (e connectionError) Unwrap() error { return e.err }
+
+// dialSMTP connects to the account's SMTP server and runs the handshake:
+// EHLO, STARTTLS when offered and AUTH when offered.
+func (s *Sender) dialSMTP(ctx context.Context, account *smtpAccount) (*smtpSession, error) {
+	host := account.Server
+	addr := fmt.Sprintf("%s:%d", host, account.Port)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, connectionError{err})
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(smtpTimeout))
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SMTP greeting failed: %w", connectionError{err})
+	}
+	session := &smtpSession{conn: conn, client: client}
+
+	if err := client.Hello(s.config.EmailSMTPHeloName); err != nil {
+		session.close()
+		return nil, fmt.Errorf("EHLO failed: %w", connectionError{err})
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			session.close()
+			return nil, fmt.Errorf("STARTTLS failed: %w", connectionError{err})
+		}
+	}
+	if ok, _ := client.Extension("AUTH"); ok {
+		auth := smtp.PlainAuth("", account.Username, account.Password, host)
+		if err := client.Auth(auth); err != nil {
+			session.close()
+			return nil, fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	// The capabilities advertised after STARTTLS replace those from before.
+	session.pipelining, _ = client.Extension("PIPELINING")
+	if ok, param := client.Extension("SIZE"); ok {
+		session.size = true
+		session.maxSize, _ = strconv.Atoi(param)
+	}
+	session.eightBit, _ = client.Extension("8BITMIME")
+	session.utf8, _ = client.Extension("SMTPUTF8")
+	return session, nil
+}
+
+// transact sends message to rcpts in one transaction: MAIL FROM, a RCPT TO
+// per recipient and DATA. Recipients the server refuses are returned and
+// the others still get the message. When every recipient is refused nothing
+// is sent, and the last refusal is the error.
+func (ss *smtpSession) transact(from string, rcpts []string, message []byte) ([]RefusedRecipient, error) {
+	for _, address := range append([]string{from}, rcpts...) {
+		if strings.ContainsAny(address, "\r\n") {
+			return nil, fmt.Errorf("invalid address %q: contains CR or LF", address)
+		}
+	}
+
+	ss.conn.SetDeadline(time.Now().Add(smtpTimeout))
+	ss.dataSent = false
+	if ss.pipelining {
+		return ss.transactPipelined(from, rcpts, message)
+	}
+
+	if err := ss.cmd(250, "%s", ss.mailCommand(from, rcpts, message)); err != nil {
+		return nil, err
+	}
+
+	var refused []RefusedRecipient
+	var refusal error
+	for _, rcpt := range rcpts {
+		err := ss.client.Rcpt(rcpt)
+		var reply *textproto.Error
+		switch {
+		case errors.As(err, &reply):
+			refused = append(refused, RefusedRecipient{Address: rcpt, Reason: reply.Error(), reply: reply})
+			refusal = err
+		case err != nil:
+			return refused, err
+		}
+	}
+	if len(refused) == len(rcpts) {
+		ss.client.Reset()
+		return refused, refusal
+	}
+
+	w, err := ss.client.Data()
+	if err != nil {
+		return refused, err
+	}
+	ss.dataSent = true
+	if _, err := w.Write(message); err != nil {
+		w.Close()
+		return refused, err
+	}
+	return refused, w.Close()
+}
+
+// transactPipelined is transact for servers with PIPELINING (RFC 2920):
+// MAIL FROM, every RCPT TO and DATA are written at once and their replies
+// read afterwards, so a transaction costs two round trips instead of one
+// per command. The server answers DATA with 554 when it accepted no
+// recipient.
+func (ss *smtpSession) transactPipelined(from string, rcpts []string, message []byte) ([]RefusedRecipient, error) {
+	text := ss.client.Text
+	fmt.Fprintf(text.W, "%s\r\n", ss.mailCommand(from, rcpts, message))
+	for _, rcpt := range rcpts {
+		fmt.Fprintf(text.W, "RCPT TO:<%s>\r\n", rcpt)
+	}
+	fmt.Fprintf(text.W, "DATA\r\n")
+	if err := text.W.Flush(); err != nil {
+		return nil, err
+	}
+
+	// Every reply is read, even after a failure, to keep the session in step.
+	_, _, mailErr := text.ReadResponse(250)
+	var refused []RefusedRecipient
+	var refusal error
+	for _, rcpt := range rcpts {
+		_, _, err := text.ReadResponse(25)
+		var reply *textproto.Error
+		switch {
+		case err == nil:
+		case mailErr != nil:
+		case errors.As(err, &reply):
+			refused = append(refused, RefusedRecipient{Address: rcpt, Reason: reply.Error(), reply: reply})
+			refusal = err
+		default:
+			return refused, err
+		}
+	}
+	_, _, dataErr := text.ReadResponse(354)
+	switch {
+	case mailErr != nil:
+		return nil, mailErr
+	case len(refused) == len(rcpts):
+		if dataErr == nil {
+			// DATA went ahead although nobody will get the message; end
+			// it empty and throw it away.
+			if err := ss.writeData(nil); err != nil {
+				return refused, err
+			}
+			ss.client.Reset()
+		}
+		return refused, refusal
+	case dataErr != nil:
+		return refused, dataErr
+	}
+	return refused, ss.writeData(message)
+}
+
+// writeData sends the message after a 354 reply to DATA and reads the
+// server's verdict on it.
+func (ss *smtpSession) writeData(message []byte) error {
+	ss.dataSent = true
+	w := ss.client.Text.DotWriter()
+	if _, err := w.Write(message); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	_, _, err := ss.client.Text.ReadResponse(250)
+	return err
+}
+
+// mailCommand returns the MAIL FROM command for message, declaring its
+// size to servers with SIZE so they can refuse it up front. BODY=8BITMIME
+// is only declared for an 8-bit body, and SMTPUTF8 only when an envelope
+// address or a header is not ASCII.
+func (ss *smtpSession) mailCommand(from string, rcpts []string, message []byte) string {
+	command := "MAIL FROM:<" + from + ">"
+	if ss.size {
+		command += " SIZE=" + strconv.Itoa(len(message))
+	}
+
+	header, body := message, []byte(nil)
+	if i := bytes.Index(message, []byte("\r\n\r\n")); i >= 0 {
+		header, body = message[:i], message[i+4:]
+	}
+	if ss.eightBit && !isASCII(body) {
+		command += " BODY=8BITMIME"
+	}
+	if ss.utf8 && (!isASCII(header) || !isASCII([]byte(from+strings.Join(rcpts, "")))) {
+		command += " SMTPUTF8"
+	}
+	return command
+}
+
+// isASCII reports whether b holds only 7-bit bytes.
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// cmd sends one command and reads its reply, which must start with code.
+func (ss *smtpSession) cmd(code int, format string, args ...any) error {
+	id, err := ss.client.Text.Cmd(format, args...)
+	if err != nil {
+		return err
+	}
+	ss.client.Text.StartResponse(id)
+	defer ss.client.Text.EndResponse(id)
+	_, _, err = ss.client.Text.ReadResponse(code)
+	return err
+}
+
+// close ends the session politely, then drops the connection either way.
+func (ss *smtpSession) close() {
+	ss.client.Quit()
+	ss.client.Close()
+}
+
+// deliver sends message to every envelope recipient of msg, in transactions
+// of at most EMAIL_SMTP_RCPT_BATCH_SIZE recipients over one session of
+// account, which is kept for the next send unless it failed. It fills in
+// result's Delivered and, with the recipients refused for good (5xx),
+// Refused. An error after some transactions went through leaves their
+// recipients in Delivered, so a retry can skip them. Recipients deferred
+// with 4xx make the last deferral the error, so they are retried; when
+// every recipient was refused, the last refusal is returned.
+func (s *Sender) deliver(ctx context.Context, account *smtpAccount, msg Message, message []byte, result *SendResult) error {
+	session, err := s.acquireSMTP(ctx, account)
+	if err != nil {
+		return err
+	}
+
+	if session.maxSize > 0 && len(message) > session.maxSize {
+		s.releaseSMTP(account, session)
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrMessageTooLarge, len(message), session.maxSize)
+	}
+
+	rcpts := msg.envelopeRecipients()
+	from := s.returnPath(msg)
+	batchSize := max(s.config.EmailSMTPRcptBatchSize, 1)
+
+	var refusal, deferral error
+	for start := 0; start < len(rcpts); start += batchSize {
+		chunk := rcpts[start:min(start+batchSize, len(rcpts))]
+		refused, err := session.transact(from, chunk, message)
+		if err != nil && len(refused) < len(chunk) && session.reused && start == 0 && !session.dataSent && sessionLost(err) {
+			// The server may have given up on the idle session (e.g. a
+			// per-connection message limit); start over on a new one. Once
+			// the message went out after DATA it may have been delivered,
+			// so it is never sent again.
+			session.client.Close()
+			if session, err = s.dialSMTP(ctx, account); err != nil {
+				return err
+			}
+			smtpSessions.Inc("dialed")
+			refused, err = session.transact(from, chunk, message)
+		}
+		for _, r := range refused {
+			if r.temporary() {
+				deferral = r.reply
+			} else {
+				result.Refused = append(result.Refused, r)
+			}
+		}
+		if err != nil && len(refused) < len(chunk) {
+			session.client.Close()
+			return err
+		}
+		if err != nil {
+			refusal = err
+			continue
+		}
+		result.Delivered = append(result.Delivered, accepted(chunk, refused)...)
+	}
+
+	s.releaseSMTP(account, session)
+	if deferral != nil {
+		return deferral
+	}
+	if len(result.Delivered) == 0 {
+		return refusal
+	}
+	return nil
+}
+
+// sessionLost reports whether err ended the session rather than rejecting
+// the transaction: the connection failed or the server closed it with 421.
+func sessionLost(err error) bool {
+	var reply *textproto.Error
+	return !errors.As(err, &reply) || reply.Code == 421
+}
+
+// accepted returns the recipients of rcpts that are not in refused.
+func accepted(rcpts []string, refused []RefusedRecipient) []string {
+	if len(refused) == 0 {
+		return rcpts
+	}
+	skip := make(map[string]bool, len(refused))
+	for _, r := range refused {
+		skip[r.Address] = true
+	}
+	var out []string
+	for _, rcpt := range rcpts {
+		if !skip[rcpt] {
+			out = append(out, rcpt)
+		}
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+const (
+	SpamProviderOff          = "off"
+	SpamProviderRspamd       = "rspamd"
+	SpamProviderSpamAssassin = "spamassassin"
+
+	SpamActionFlag = "flag"
+	SpamActionHold = "hold"
+)
+
+// ErrSpamHeld is returned for messages scoring above SPAM_CHECK_THRESHOLD
+// with SPAM_CHECK_ACTION=hold. They are not sent or retried.
+var ErrSpamHeld = errors.New("message held by spam check")
+
+// ErrApprovalRequired is returned for messages scoring above
+// SPAM_CHECK_THRESHOLD when APPROVAL_RULES includes spam_flagged, unless an
+// admin already approved them. The queue holds them for approval.
+var ErrApprovalRequired = errors.New("message requires approval")
+
+var spamChecks = metrics.NewCounter(
+	"mailqueue_spam_checks_total",
+	"Pre-send spam checks by result.",
+	"result",
+)
+
+// SpamReport is the score a rendered message got from the spam checker.
+// Flagged is set when the score is above the threshold.
+type SpamReport struct {
+	Score   float64
+	Flagged bool
+}
+
+type spamChecker interface {
+	Score(ctx context.Context, message []byte) (float64, error)
+}
+
+func newSpamChecker(cfg *config.ApplicationConfig) (spamChecker, error) {
+	switch cfg.SpamCheckAction {
+	case SpamActionFlag, SpamActionHold:
+	default:
+		return nil, fmt.Errorf("invalid spam check action %q", cfg.SpamCheckAction)
+	}
+
+	switch cfg.SpamCheckProvider {
+	case SpamProviderOff:
+		return nil, nil
+	case SpamProviderRspamd:
+		endpoint := cfg.SpamCheckEndpoint
+		if endpoint == "" {
+			endpoint = "http://localhost:11333"
+		}
+		return &rspamdChecker{
+			url:        strings.TrimSuffix(endpoint, "/") + "/checkv2",
+			httpClient: &http.Client{Timeout: cfg.SpamCheckTimeout},
+		}, nil
+	case SpamProviderSpamAssassin:
+		endpoint := cfg.SpamCheckEndpoint
+		if endpoint == "" {
+			endpoint = "localhost:783"
+		}
+		return &spamdChecker{addr: endpoint, timeout: cfg.SpamCheckTimeout}, nil
+	default:
+		return nil, fmt.Errorf("invalid spam check provider %q", cfg.SpamCheckProvider)
+	}
+}
+
+// checkSpam scores a built message. A checker that is down lets the message
+// through unscored rather than stopping all mail. A flagged message an admin
+// approved is sent.
+func (s *Sender) checkSpam(ctx context.Context, message []byte, approved bool) (*SpamReport, error) {
+	if s.spam == nil {
+		return nil, nil
+	}
+
+	score, err := s.spam.Score(ctx, message)
+	if err != nil {
+		spamChecks.Inc("error")
+		return nil, nil
+	}
+
+	report := &SpamReport{Score: score, Flagged: score > s.config.SpamCheckThreshold}
+	switch {
+	case !report.Flagged:
+		spamChecks.Inc("pass")
+	case approved:
+		spamChecks.Inc("flagged")
+	case slices.Contains(s.config.ApprovalRules, config.ApprovalSpamFlagged):
+		spamChecks.Inc("approval")
+		return report, fmt.Errorf("%w: spam score %.1f exceeds threshold %.1f", ErrApprovalRequired, score, s.config.SpamCheckThreshold)
+	case s.config.SpamCheckAction == SpamActionHold:
+		spamChecks.Inc("held")
+		return report, fmt.Errorf("%w: score %.1f exceeds threshold %.1f", ErrSpamHeld, score, s.config.SpamCheckThreshold)
+	default:
+		spamChecks.Inc("flagged")
+	}
+	return report, nil
+}
+
+// rspamdChecker uses the Rspamd HTTP protocol (/checkv2).
+type rspamdChecker struct {
+	url        string
+	httpClient *http.Client
+}
+
+func (c *rspamdChecker) Score(ctx context.Context, message []byte) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(message))
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("rspamd returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Score float64 `json:"score"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return 0, fmt.Errorf("invalid rspamd response: %w", err)
+	}
+	return result.Score, nil
+}
+
+// spamdChecker speaks the spamd protocol (SPAMC/1.5) of SpamAssassin.
+type spamdChecker struct {
+	addr    string
+	timeout time.Duration
+}
+
+func (c *spamdChecker) Score(ctx context.Context, message []byte) (float64, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if _, err := fmt.Fprintf(conn, "CHECK SPAMC/1.5\r\nContent-length: %d\r\n\r\n", len(message)); err != nil {
+		return 0, err
+	}
+	if _, err := conn.Write(message); err != nil {
+		return 0, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+
+	// The reply carries a header such as "Spam: True ; 15.3 / 5.0".
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if name, value, ok := strings.Cut(strings.TrimSpace(line), ":"); ok && strings.EqualFold(name, "Spam") {
+			_, scores, _ := strings.Cut(value, ";")
+			score, _, _ := strings.Cut(scores, "/")
+			return strconv.ParseFloat(strings.TrimSpace(score), 64)
+		}
+		if err != nil {
+			return 0, errors.New("spamd reply has no Spam header")
+		}
+	}
+}
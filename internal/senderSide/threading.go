@@ -0,0 +1,50 @@
+package email
+
+import (
+	"net/textproto"
+	"strings"
+)
+
+// setThreadingHeaders adds Message-ID, derived from the job ID so callers
+// can reference a job they sent earlier, and the In-Reply-To / References
+// headers that thread a follow-up under the original message.
+func (s *Sender) setThreadingHeaders(headers textproto.MIMEHeader, msg Message) {
+	if msg.JobID != "" {
+		headers.Set("Message-ID", s.MessageID(msg.JobID))
+	}
+
+	if inReplyTo := formatMessageID(msg.InReplyTo); inReplyTo != "" {
+		headers.Set("In-Reply-To", inReplyTo)
+	}
+
+	var references []string
+	for _, id := range msg.References {
+		if id = formatMessageID(id); id != "" {
+			references = append(references, id)
+		}
+	}
+	if len(references) > 0 {
+		headers.Set("References", strings.Join(references, " "))
+	}
+}
+
+// MessageID is the Message-ID of the email sent for a job:
+// <jobID@sender-domain>.
+func (s *Sender) MessageID(jobID string) string {
+	domain := "localhost"
+	if _, d, ok := strings.Cut(s.config.EmailSenderAddress, "@"); ok && d != "" {
+		domain = d
+	}
+	return "<" + jobID + "@" + domain + ">"
+}
+
+// formatMessageID wraps an ID in angle brackets, accepting it with or
+// without them. Whitespace is dropped so an ID can't break the header.
+func formatMessageID(id string) string {
+	id = strings.Join(strings.Fields(id), "")
+	id = strings.TrimSuffix(strings.TrimPrefix(id, "<"), ">")
+	if id == "" {
+		return ""
+	}
+	return "<" + id + ">"
+}
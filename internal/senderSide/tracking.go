@@ -0,0 +1,124 @@
+package email
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	templates "github.com/sarthakyeole/redis-go-mailing-bulk/internal/emailTemplate"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/token"
+)
+
+// linkPattern matches absolute http(s) href attributes.
+var linkPattern = regexp.MustCompile(`(?i)(href=)(["'])(https?://[^"']+)(["'])`)
+
+// applyTracking appends UTM parameters, fills in the unsubscribe and
+// preference links and, when enabled, rewrites links through the click
+// redirect and appends the open pixel. It returns the new body and the
+// unsubscribe URL for the List-Unsubscribe header, which is empty when
+// tracking is not configured.
+func (s *Sender) applyTracking(body string, msg Message) (string, string, error) {
+	body = s.appendUTM(body, msg)
+
+	// A broadcast is one message for every recipient, so it cannot carry
+	// links signed for one of them; it offers the unsubscribe mailbox.
+	if len(msg.Recipients) > 0 {
+		body, unsubscribeURL := s.applyBroadcastOptOut(body)
+		return body, unsubscribeURL, nil
+	}
+
+	base := s.config.TrackingBaseURL
+	if s.tokens == nil || base == "" {
+		body = strings.ReplaceAll(body, templates.UnsubscribePlaceholder, "#")
+		return strings.ReplaceAll(body, templates.PreferencesPlaceholder, "#"), "", nil
+	}
+
+	unsubscribeToken, err := s.tokens.Sign(token.Claims{
+		Purpose:   token.PurposeUnsubscribe,
+		Recipient: msg.To,
+		Batch:     msg.BatchID,
+		Variant:   msg.Variant,
+		Category:  msg.Category,
+		Job:       msg.JobID,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	unsubscribeURL := base + "/unsubscribe/" + unsubscribeToken
+
+	preferencesToken, err := s.tokens.Sign(token.Claims{
+		Purpose:   token.PurposePreferences,
+		Recipient: msg.To,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	if s.config.TrackingClicks {
+		var signErr error
+		body = linkPattern.ReplaceAllStringFunc(body, func(match string) string {
+			groups := linkPattern.FindStringSubmatch(match)
+			if groups[2] != groups[4] || strings.HasPrefix(groups[3], base+"/") {
+				return match
+			}
+
+			clickToken, err := s.tokens.Sign(token.Claims{
+				Purpose:   token.PurposeClick,
+				Recipient: msg.To,
+				Template:  msg.TemplateName,
+				Batch:     msg.BatchID,
+				Variant:   msg.Variant,
+				Job:       msg.JobID,
+				URL:       html.UnescapeString(groups[3]),
+			})
+			if err != nil {
+				signErr = err
+				return match
+			}
+			return groups[1] + groups[2] + base + "/t/click/" + clickToken + groups[4]
+		})
+		if signErr != nil {
+			return "", "", signErr
+		}
+	}
+
+	body = strings.ReplaceAll(body, templates.UnsubscribePlaceholder, unsubscribeURL)
+	body = strings.ReplaceAll(body, templates.PreferencesPlaceholder, base+"/preferences/"+preferencesToken)
+
+	if s.config.TrackingOpens {
+		openToken, err := s.tokens.Sign(token.Claims{
+			Purpose:   token.PurposeOpen,
+			Recipient: msg.To,
+			Template:  msg.TemplateName,
+			Batch:     msg.BatchID,
+			Variant:   msg.Variant,
+			Job:       msg.JobID,
+		})
+		if err != nil {
+			return "", "", err
+		}
+		pixel := `<img src="` + base + "/t/open/" + openToken + `" width="1" height="1" alt="" style="display:none">`
+
+		if i := strings.LastIndex(strings.ToLower(body), "</body>"); i >= 0 {
+			body = body[:i] + pixel + body[i:]
+		} else {
+			body += pixel
+		}
+	}
+
+	return body, unsubscribeURL, nil
+}
+
+// applyBroadcastOptOut points a broadcast's unsubscribe links at
+// EMAIL_UNSUBSCRIBE_MAILTO and returns the mailto URL for the
+// List-Unsubscribe header. Without it the links render as "#" and no URL
+// is returned.
+func (s *Sender) applyBroadcastOptOut(body string) (string, string) {
+	body = strings.ReplaceAll(body, templates.PreferencesPlaceholder, "#")
+	mailbox := s.config.EmailUnsubscribeMailto
+	if mailbox == "" {
+		return strings.ReplaceAll(body, templates.UnsubscribePlaceholder, "#"), ""
+	}
+	unsubscribeURL := "mailto:" + mailbox + "?subject=unsubscribe"
+	return strings.ReplaceAll(body, templates.UnsubscribePlaceholder, unsubscribeURL), unsubscribeURL
+}
@@ -0,0 +1,116 @@
+package email
+
+import (
+	"html"
+	"net/url"
+	"strings"
+)
+
+// UTM holds the analytics parameters appended to the links of a message.
+// Empty fields fall back to UTM_SOURCE, UTM_MEDIUM and UTM_CAMPAIGN; the
+// campaign finally falls back to the template name.
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+}
+
+// utmParams merges the message's UTM settings over the configured defaults.
+// It returns nil when no source is set, which leaves links alone.
+func (s *Sender) utmParams(msg Message) url.Values {
+	utm := UTM{
+		Source:   s.config.UTMSource,
+		Medium:   s.config.UTMMedium,
+		Campaign: s.config.UTMCampaign,
+	}
+	if msg.UTM != nil {
+		if msg.UTM.Source != "" {
+			utm.Source = msg.UTM.Source
+		}
+		if msg.UTM.Medium != "" {
+			utm.Medium = msg.UTM.Medium
+		}
+		if msg.UTM.Campaign != "" {
+			utm.Campaign = msg.UTM.Campaign
+		}
+	}
+	if utm.Source == "" {
+		return nil
+	}
+	if utm.Campaign == "" {
+		utm.Campaign = msg.TemplateName
+	}
+
+	params := url.Values{"utm_source": {utm.Source}}
+	if utm.Medium != "" {
+		params.Set("utm_medium", utm.Medium)
+	}
+	if utm.Campaign != "" {
+		params.Set("utm_campaign", utm.Campaign)
+	}
+	return params
+}
+
+// appendUTM adds the UTM parameters to every qualifying link in body: links
+// to UTM_DOMAINS (or any link when unset) other than tracking URLs. A
+// parameter the template already sets on a link is kept.
+func (s *Sender) appendUTM(body string, msg Message) string {
+	params := s.utmParams(msg)
+	if params == nil {
+		return body
+	}
+
+	skipPrefix := ""
+	if s.config.TrackingBaseURL != "" {
+		skipPrefix = s.config.TrackingBaseURL + "/"
+	}
+
+	return linkPattern.ReplaceAllStringFunc(body, func(match string) string {
+		groups := linkPattern.FindStringSubmatch(match)
+		raw := html.UnescapeString(groups[3])
+		if groups[2] != groups[4] || (skipPrefix != "" && strings.HasPrefix(raw, skipPrefix)) {
+			return match
+		}
+
+		link, err := url.Parse(raw)
+		if err != nil || !s.utmDomain(link.Hostname()) {
+			return match
+		}
+
+		existing := link.Query()
+		missing := url.Values{}
+		for name, values := range params {
+			if !existing.Has(name) {
+				missing[name] = values
+			}
+		}
+		if len(missing) == 0 {
+			return match
+		}
+
+		// Appending keeps the link's own query string byte for byte.
+		if link.RawQuery == "" {
+			link.RawQuery = missing.Encode()
+		} else {
+			link.RawQuery += "&" + missing.Encode()
+		}
+		return groups[1] + groups[2] + html.EscapeString(link.String()) + groups[4]
+	})
+}
+
+// utmDomain reports whether links to host get UTM parameters: any host
+// when UTM_DOMAINS is empty, otherwise the listed domains and their
+// subdomains.
+func (s *Sender) utmDomain(host string) bool {
+	if len(s.config.UTMDomains) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, domain := range s.config.UTMDomains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package email
+
+import "strings"
+
+// returnPath is the envelope sender (SMTP MAIL FROM) for msg: the tenant's
+// TENANT_<NAME>_RETURN_PATH, else EMAIL_RETURN_PATH, else the sender
+// address. With EMAIL_RETURN_PATH_DOMAIN set, each job gets a VERP address
+// instead, such as bounce+<jobID>@bounces.example.com, so a bounce names the
+// job it belongs to even when the remote server drops the original headers;
+// a configured return path is tagged the same way, e.g.
+// bounces+<jobID>@bounce.example.com.
+func (s *Sender) returnPath(msg Message) string {
+	address := s.config.EmailReturnPath
+	for _, tenant := range s.config.Tenants {
+		if tenant.Name == msg.Tenant && tenant.ReturnPath != "" {
+			address = tenant.ReturnPath
+			break
+		}
+	}
+
+	verp := s.config.EmailReturnPathDomain != "" && msg.JobID != ""
+	switch {
+	case address == "" && !verp:
+		from, _ := s.sender(msg)
+		return from
+	case address == "":
+		return s.config.EmailReturnPathPrefix + "+" + msg.JobID + "@" + s.config.EmailReturnPathDomain
+	case !verp:
+		return address
+	}
+	at := strings.LastIndex(address, "@")
+	return address[:at] + "+" + msg.JobID + address[at:]
+}
@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+)
+
+const campaignKeyPrefix = "stats:campaign:"
+
+// CampaignCounted lists the event types rolled up per campaign.
+var CampaignCounted = []string{
+	events.TypeSent,
+	events.TypeFailed,
+	events.TypeOpened,
+	events.TypeClicked,
+	events.TypeBounced,
+	events.TypeUnsubscribed,
+	events.TypeComplained,
+}
+
+// campaignUnique lists the event types also counted per recipient.
+var campaignUnique = []string{
+	events.TypeOpened,
+	events.TypeClicked,
+	events.TypeBounced,
+	events.TypeUnsubscribed,
+}
+
+// CampaignAnalytics is the rollup of one campaign (a batch ID). Counts are
+// events; Unique counts recipients, so repeated opens count once. The rates
+// are unique recipients per sent message, except BounceRate, which is per
+// attempted (sent or failed) message.
+type CampaignAnalytics struct {
+	ID              string           `json:"id"`
+	Counts          map[string]int64 `json:"counts"`
+	Unique          map[string]int64 `json:"unique"`
+	OpenRate        float64          `json:"openRate"`
+	ClickRate       float64          `json:"clickRate"`
+	BounceRate      float64          `json:"bounceRate"`
+	UnsubscribeRate float64          `json:"unsubscribeRate"`
+	FirstEventAt    *time.Time       `json:"firstEventAt,omitempty"`
+	LastEventAt     *time.Time       `json:"lastEventAt,omitempty"`
+	Variants        []VariantRow     `json:"variants,omitempty"`
+}
+
+// campaignEventTypes returns the rollup columns event counts towards. A send
+// that finally failed with a bounce counts as failed and as bounced, like a
+// bounce reported later by the provider.
+func campaignEventTypes(event events.Event) []string {
+	for _, eventType := range CampaignCounted {
+		if eventType != event.Type {
+			continue
+		}
+		if event.Type == events.TypeFailed && event.Bounce != "" {
+			return []string{events.TypeFailed, events.TypeBounced}
+		}
+		return []string{eventType}
+	}
+	return nil
+}
+
+func campaignUniqueKey(campaign, eventType string) string {
+	return campaignKeyPrefix + campaign + ":" + eventType
+}
+
+func (r *Recorder) recordCampaign(ctx context.Context, pipe redis.Pipeliner, event events.Event) {
+	key := campaignKeyPrefix + event.BatchID
+	at := strconv.FormatInt(event.Timestamp.UnixMilli(), 10)
+
+	pipe.HSetNX(ctx, key, "firstEventAt", at)
+	pipe.HSet(ctx, key, "lastEventAt", at)
+	for _, eventType := range campaignEventTypes(event) {
+		pipe.HIncrBy(ctx, key, eventType, 1)
+
+		for _, unique := range campaignUnique {
+			if unique != eventType {
+				continue
+			}
+			uniqueKey := campaignUniqueKey(event.BatchID, eventType)
+			pipe.PFAdd(ctx, uniqueKey, strings.ToLower(event.Recipient))
+			if r.retention > 0 {
+				pipe.Expire(ctx, uniqueKey, r.retention)
+			}
+		}
+	}
+	if r.retention > 0 {
+		pipe.Expire(ctx, key, r.retention)
+	}
+}
+
+// Campaign returns the rollup of a campaign, or nil when it has no events.
+func (r *Recorder) Campaign(ctx context.Context, id string) (*CampaignAnalytics, error) {
+	pipe := r.client.Pipeline()
+	fields := pipe.HGetAll(ctx, campaignKeyPrefix+id)
+	uniques := make([]*redis.IntCmd, len(campaignUnique))
+	for i, eventType := range campaignUnique {
+		uniques[i] = pipe.PFCount(ctx, campaignUniqueKey(id, eventType))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load campaign stats: %w", err)
+	}
+	if len(fields.Val()) == 0 {
+		return nil, nil
+	}
+
+	analytics := &CampaignAnalytics{
+		ID:     id,
+		Counts: make(map[string]int64, len(CampaignCounted)),
+		Unique: make(map[string]int64, len(campaignUnique)),
+	}
+	for _, eventType := range CampaignCounted {
+		analytics.Counts[eventType], _ = strconv.ParseInt(fields.Val()[eventType], 10, 64)
+	}
+	for i, eventType := range campaignUnique {
+		analytics.Unique[eventType] = uniques[i].Val()
+	}
+	analytics.FirstEventAt = parseMillis(fields.Val()["firstEventAt"])
+	analytics.LastEventAt = parseMillis(fields.Val()["lastEventAt"])
+
+	if sent := analytics.Counts[events.TypeSent]; sent > 0 {
+		analytics.OpenRate = float64(analytics.Unique[events.TypeOpened]) / float64(sent)
+		analytics.ClickRate = float64(analytics.Unique[events.TypeClicked]) / float64(sent)
+		analytics.UnsubscribeRate = float64(analytics.Unique[events.TypeUnsubscribed]) / float64(sent)
+	}
+	if attempted := analytics.Counts[events.TypeSent] + analytics.Counts[events.TypeFailed]; attempted > 0 {
+		analytics.BounceRate = float64(analytics.Unique[events.TypeBounced]) / float64(attempted)
+	}
+
+	variants, err := r.Variants(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	analytics.Variants = variants
+
+	return analytics, nil
+}
+
+func parseMillis(value string) *time.Time {
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	at := time.UnixMilli(millis).UTC()
+	return &at
+}
@@ -0,0 +1,257 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+)
+
+const (
+	dailyKeyPrefix   = "stats:daily:"
+	tagKeyInfix      = ":tag:"
+	variantKeyPrefix = "stats:variants:"
+	dateLayout       = "2006-01-02"
+
+	// fieldSeparator joins template and event type in hash fields; it cannot
+	// appear in a template name.
+	fieldSeparator = "|"
+)
+
+// Counted lists the event types kept in daily stats, in export column order.
+var Counted = []string{
+	events.TypeSent,
+	events.TypeFailed,
+	events.TypeOpened,
+	events.TypeClicked,
+	events.TypeUnsubscribed,
+	events.TypeComplained,
+}
+
+// VariantCounted lists the event types counted per A/B test variant.
+var VariantCounted = []string{
+	events.TypeSent,
+	events.TypeOpened,
+	events.TypeClicked,
+}
+
+// Row is one day's counts for one template.
+type Row struct {
+	Date     string           `json:"date"`
+	Template string           `json:"template"`
+	Counts   map[string]int64 `json:"counts"`
+}
+
+// VariantRow is the counts of one variant of a batch's A/B test. Unique
+// counts recipients rather than events, so repeated opens count once;
+// OpenRate and ClickRate are unique opens and clicks per sent message.
+type VariantRow struct {
+	Variant   string           `json:"variant"`
+	Counts    map[string]int64 `json:"counts"`
+	Unique    map[string]int64 `json:"unique"`
+	OpenRate  float64          `json:"openRate"`
+	ClickRate float64          `json:"clickRate"`
+}
+
+// Recorder keeps per-day, per-template event counts in Redis hashes
+// (stats:daily:<date>, and stats:daily:<date>:tag:<tag> for each tag of a
+// tagged email), fed as an events.Publisher. Events of a batch are
+// also rolled up per campaign (stats:campaign:<batch>) and, for A/B tests,
+// per variant (stats:variants:<batch>), with HyperLogLogs of recipients for
+// unique counts.
+type Recorder struct {
+	client    *redis.Client
+	logger    *slog.Logger
+	retention time.Duration
+	counted   map[string]struct{}
+}
+
+func NewRecorder(client *redis.Client, retention time.Duration, logger *slog.Logger) *Recorder {
+	counted := make(map[string]struct{}, len(Counted))
+	for _, eventType := range Counted {
+		counted[eventType] = struct{}{}
+	}
+	return &Recorder{client: client, logger: logger, retention: retention, counted: counted}
+}
+
+func (r *Recorder) Publish(ctx context.Context, event events.Event) {
+	_, daily := r.counted[event.Type]
+	campaign := event.BatchID != "" && campaignEventTypes(event) != nil
+	if !daily && !campaign {
+		return
+	}
+
+	pipe := r.client.TxPipeline()
+	if daily {
+		template := event.Template
+		if template == "" {
+			template = "(none)"
+		}
+
+		date := event.Timestamp.UTC().Format(dateLayout)
+		keys := []string{dailyKey(date, "")}
+		for _, tag := range event.Tags {
+			keys = append(keys, dailyKey(date, tag))
+		}
+		for _, key := range keys {
+			pipe.HIncrBy(ctx, key, template+fieldSeparator+event.Type, 1)
+			if r.retention > 0 {
+				pipe.Expire(ctx, key, r.retention)
+			}
+		}
+		if event.BatchID != "" && event.Variant != "" {
+			r.recordVariant(ctx, pipe, event)
+		}
+	}
+	if campaign {
+		r.recordCampaign(ctx, pipe, event)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		r.logger.Warn("Failed to record stats", "type", event.Type, "error", err)
+	}
+}
+
+// dailyKey names the hash of a day's counts, or of its counts for emails
+// tagged tag when tag is set.
+func dailyKey(date, tag string) string {
+	if tag == "" {
+		return dailyKeyPrefix + date
+	}
+	return dailyKeyPrefix + date + tagKeyInfix + tag
+}
+
+func (r *Recorder) recordVariant(ctx context.Context, pipe redis.Pipeliner, event events.Event) {
+	counted := false
+	for _, eventType := range VariantCounted {
+		counted = counted || eventType == event.Type
+	}
+	if !counted {
+		return
+	}
+
+	key := variantKeyPrefix + event.BatchID
+	unique := variantUniqueKey(event.BatchID, event.Variant, event.Type)
+	pipe.HIncrBy(ctx, key, event.Variant+fieldSeparator+event.Type, 1)
+	pipe.PFAdd(ctx, unique, strings.ToLower(event.Recipient))
+	if r.retention > 0 {
+		pipe.Expire(ctx, key, r.retention)
+		pipe.Expire(ctx, unique, r.retention)
+	}
+}
+
+func variantUniqueKey(batch, variant, eventType string) string {
+	return variantKeyPrefix + batch + ":" + variant + ":" + eventType
+}
+
+// Variants returns the A/B test counts of a batch, ordered by variant, or
+// nil when the batch has no variant events.
+func (r *Recorder) Variants(ctx context.Context, batch string) ([]VariantRow, error) {
+	fields, err := r.client.HGetAll(ctx, variantKeyPrefix+batch).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load variant stats: %w", err)
+	}
+
+	byVariant := make(map[string]map[string]int64)
+	for field, value := range fields {
+		variant, eventType, ok := strings.Cut(field, fieldSeparator)
+		if !ok {
+			continue
+		}
+		if byVariant[variant] == nil {
+			byVariant[variant] = make(map[string]int64, len(VariantCounted))
+		}
+		byVariant[variant][eventType], _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	variants := make([]string, 0, len(byVariant))
+	for variant := range byVariant {
+		variants = append(variants, variant)
+	}
+	sort.Strings(variants)
+
+	pipe := r.client.Pipeline()
+	uniques := make([][]*redis.IntCmd, len(variants))
+	for i, variant := range variants {
+		uniques[i] = make([]*redis.IntCmd, len(VariantCounted))
+		for j, eventType := range VariantCounted {
+			uniques[i][j] = pipe.PFCount(ctx, variantUniqueKey(batch, variant, eventType))
+		}
+	}
+	if len(variants) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("failed to load variant stats: %w", err)
+		}
+	}
+
+	rows := make([]VariantRow, 0, len(variants))
+	for i, variant := range variants {
+		row := VariantRow{
+			Variant: variant,
+			Counts:  byVariant[variant],
+			Unique:  make(map[string]int64, len(VariantCounted)),
+		}
+		for j, eventType := range VariantCounted {
+			row.Unique[eventType] = uniques[i][j].Val()
+		}
+		if sent := row.Counts[events.TypeSent]; sent > 0 {
+			row.OpenRate = float64(row.Unique[events.TypeOpened]) / float64(sent)
+			row.ClickRate = float64(row.Unique[events.TypeClicked]) / float64(sent)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return rows, nil
+}
+
+// Daily returns the rows for the last days days up to and including today
+// (UTC), ordered by date and template. With a tag set, only emails carrying
+// that tag are counted.
+func (r *Recorder) Daily(ctx context.Context, days int, tag string) ([]Row, error) {
+	today := time.Now().UTC()
+
+	pipe := r.client.Pipeline()
+	dates := make([]string, days)
+	cmds := make([]*redis.StringStringMapCmd, days)
+	for i := 0; i < days; i++ {
+		dates[i] = today.AddDate(0, 0, i-days+1).Format(dateLayout)
+		cmds[i] = pipe.HGetAll(ctx, dailyKey(dates[i], tag))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to load stats: %w", err)
+	}
+
+	var rows []Row
+	for i, cmd := range cmds {
+		byTemplate := make(map[string]map[string]int64)
+		for field, value := range cmd.Val() {
+			template, eventType, ok := strings.Cut(field, fieldSeparator)
+			if !ok {
+				continue
+			}
+			if byTemplate[template] == nil {
+				byTemplate[template] = make(map[string]int64, len(Counted))
+			}
+			byTemplate[template][eventType], _ = strconv.ParseInt(value, 10, 64)
+		}
+
+		templates := make([]string, 0, len(byTemplate))
+		for template := range byTemplate {
+			templates = append(templates, template)
+		}
+		sort.Strings(templates)
+
+		for _, template := range templates {
+			rows = append(rows, Row{Date: dates[i], Template: template, Counts: byTemplate[template]})
+		}
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,143 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Token purposes. A token signed for one purpose is rejected for another, so
+// an open-pixel token cannot be replayed as an unsubscribe link.
+const (
+	PurposeOpen        = "open"
+	PurposeClick       = "click"
+	PurposeUnsubscribe = "unsubscribe"
+	PurposePreferences = "preferences"
+)
+
+var (
+	ErrInvalidToken = errors.New("token is invalid")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Claims is the signed content of a token.
+type Claims struct {
+	Purpose   string `json:"p"`
+	Recipient string `json:"r,omitempty"`
+	Template  string `json:"t,omitempty"`
+	URL       string `json:"u,omitempty"`
+	Batch     string `json:"b,omitempty"`
+	Variant   string `json:"v,omitempty"`
+	Category  string `json:"c,omitempty"`
+	Job       string `json:"j,omitempty"`
+	Expires   int64  `json:"e,omitempty"`
+}
+
+type key struct {
+	id     string
+	secret []byte
+}
+
+// Signer issues and verifies HMAC-SHA256 tokens of the form
+// <payload>.<key id>.<signature>. The first key signs; every key verifies,
+// so a new key can be put in front while links signed with the old one keep
+// working until it is dropped.
+type Signer struct {
+	keys []key
+	ttl  time.Duration
+}
+
+// New parses keys given as "id:secret". A zero ttl issues tokens that never
+// expire. It returns nil when no keys are configured.
+func New(keys []string, ttl time.Duration) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	s := &Signer{ttl: ttl}
+	seen := make(map[string]struct{})
+	for _, raw := range keys {
+		id, secret, ok := strings.Cut(raw, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" || secret == "" || strings.Contains(id, ".") {
+			return nil, fmt.Errorf("invalid token key %q: expected id:secret", id)
+		}
+		if _, dup := seen[id]; dup {
+			return nil, fmt.Errorf("duplicate token key id %q", id)
+		}
+		seen[id] = struct{}{}
+		s.keys = append(s.keys, key{id: id, secret: []byte(secret)})
+	}
+
+	return s, nil
+}
+
+// Sign encodes and signs claims with the active key.
+func (s *Signer) Sign(claims Claims) (string, error) {
+	if s.ttl > 0 && claims.Expires == 0 {
+		claims.Expires = time.Now().Add(s.ttl).Unix()
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	active := s.keys[0]
+	unsigned := base64.RawURLEncoding.EncodeToString(payload) + "." + active.id
+	return unsigned + "." + sign(active.secret, unsigned), nil
+}
+
+// Verify checks the signature, purpose and expiry of a token and returns its
+// claims.
+func (s *Signer) Verify(token, purpose string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalidToken
+	}
+
+	var secret []byte
+	for _, k := range s.keys {
+		if k.id == parts[1] {
+			secret = k.secret
+			break
+		}
+	}
+	if secret == nil {
+		return Claims{}, ErrInvalidToken
+	}
+
+	unsigned := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(secret, unsigned))) {
+		return Claims{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	if claims.Purpose != purpose {
+		return Claims{}, ErrInvalidToken
+	}
+	if claims.Expires > 0 && time.Now().Unix() > claims.Expires {
+		return Claims{}, ErrExpiredToken
+	}
+
+	return claims, nil
+}
+
+func sign(secret []byte, data string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,297 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/config"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/events"
+	"github.com/sarthakyeole/redis-go-mailing-bulk/internal/metrics"
+)
+
+const (
+	webhookQueue      = "webhook_queue"
+	webhookDelayed    = "webhook_delayed"
+	webhookDeadLetter = "webhook_dead_letter"
+
+	maxBackoff      = time.Hour
+	promoteInterval = time.Second
+	popTimeout      = time.Second
+)
+
+var webhookDeliveries = metrics.NewCounter(
+	"mailqueue_webhook_deliveries_total",
+	"Webhook delivery attempts by result.",
+	"result",
+)
+
+// delivery is one event bound for one endpoint, as stored in Redis.
+type delivery struct {
+	URL       string       `json:"url"`
+	Event     events.Event `json:"event"`
+	Attempt   int          `json:"attempt"`
+	LastError string       `json:"lastError,omitempty"`
+
+	DeadLetteredAt time.Time `json:"deadLetteredAt,omitempty"`
+}
+
+// Dispatcher queues events in Redis and POSTs them to every WEBHOOK_URLS
+// endpoint, signed with WEBHOOK_SECRET. Failed deliveries are retried with
+// exponential backoff and moved to webhook_dead_letter after
+// WEBHOOK_MAX_ATTEMPTS.
+type Dispatcher struct {
+	client      *redis.Client
+	httpClient  *http.Client
+	logger      *slog.Logger
+	urls        []string
+	secret      []byte
+	events      map[string]struct{}
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// defaultEvents are sent when WEBHOOK_EVENTS is unset; the per-attempt
+// lifecycle events are left to the Redis event channel.
+var defaultEvents = []string{
+	events.TypeSent,
+	events.TypeFailed,
+	events.TypeOpened,
+	events.TypeClicked,
+	events.TypeUnsubscribed,
+	events.TypeComplained,
+}
+
+// New returns nil when no webhook URLs are configured.
+func New(cfg *config.ApplicationConfig, client *redis.Client, logger *slog.Logger) *Dispatcher {
+	if len(cfg.WebhookURLs) == 0 {
+		return nil
+	}
+
+	d := &Dispatcher{
+		client:      client,
+		httpClient:  &http.Client{Timeout: cfg.WebhookTimeout},
+		logger:      logger,
+		urls:        cfg.WebhookURLs,
+		secret:      []byte(cfg.WebhookSecret),
+		maxAttempts: max(cfg.WebhookMaxAttempts, 1),
+		backoff:     cfg.WebhookBackoff,
+	}
+	eventTypes := cfg.WebhookEvents
+	if len(eventTypes) == 0 {
+		eventTypes = defaultEvents
+	}
+	d.events = make(map[string]struct{}, len(eventTypes))
+	for _, eventType := range eventTypes {
+		d.events[eventType] = struct{}{}
+	}
+
+	return d
+}
+
+// Publish queues event for every endpoint. It is safe to call on a nil
+// Dispatcher.
+func (d *Dispatcher) Publish(ctx context.Context, event events.Event) {
+	if d == nil {
+		return
+	}
+	if _, ok := d.events[event.Type]; !ok {
+		return
+	}
+
+	for _, url := range d.urls {
+		payload, err := json.Marshal(delivery{URL: url, Event: event})
+		if err != nil {
+			d.logger.Error("Failed to serialize webhook event", "type", event.Type, "error", err)
+			continue
+		}
+		if err := d.client.RPush(ctx, webhookQueue, payload).Err(); err != nil {
+			d.logger.Error("Failed to queue webhook event", "type", event.Type, "url", url, "error", err)
+		}
+	}
+}
+
+// Run delivers queued events until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		result, err := d.client.BLPop(ctx, popTimeout, webhookQueue).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logger.Error("Webhook queue error", "error", err)
+				time.Sleep(popTimeout)
+			}
+			continue
+		}
+
+		var item delivery
+		if err := json.Unmarshal([]byte(result[1]), &item); err != nil {
+			d.logger.Error("Discarding malformed webhook delivery", "error", err)
+			continue
+		}
+
+		d.deliver(ctx, item)
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, item delivery) {
+	item.Attempt++
+
+	err := d.post(ctx, item)
+	if err == nil {
+		webhookDeliveries.Inc("delivered")
+		return
+	}
+	item.LastError = err.Error()
+
+	if item.Attempt >= d.maxAttempts {
+		item.DeadLetteredAt = time.Now().UTC()
+	}
+	payload, _ := json.Marshal(item)
+	if item.Attempt >= d.maxAttempts {
+		webhookDeliveries.Inc("dead_lettered")
+		d.logger.Error("Webhook delivery failed permanently",
+			"url", item.URL, "type", item.Event.Type, "attempts", item.Attempt, "error", err)
+		if err := d.client.RPush(ctx, webhookDeadLetter, payload).Err(); err != nil {
+			d.logger.Error("Failed to dead-letter webhook delivery", "error", err)
+		}
+		return
+	}
+
+	delay := min(d.backoff<<min(item.Attempt-1, 20), maxBackoff)
+	webhookDeliveries.Inc("retried")
+	d.logger.Warn("Webhook delivery failed, scheduling retry",
+		"url", item.URL, "type", item.Event.Type, "attempt", item.Attempt, "retryIn", delay, "error", err)
+
+	if err := d.client.ZAdd(ctx, webhookDelayed, &redis.Z{
+		Score:  float64(time.Now().Add(delay).UnixMilli()),
+		Member: payload,
+	}).Err(); err != nil {
+		d.logger.Error("Failed to schedule webhook retry", "error", err)
+	}
+}
+
+// post sends the event with an X-Signature header of
+// sha256=hex(HMAC-SHA256(secret, "<timestamp>.<body>")); receivers should
+// reject stale X-Signature-Timestamp values to prevent replays.
+func (d *Dispatcher) post(ctx context.Context, item delivery) error {
+	body, err := json.Marshal(item.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, item.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature", "sha256="+Sign(d.secret, timestamp, body))
+	if item.Event.RequestID != "" {
+		req.Header.Set("X-Request-ID", item.Event.RequestID)
+	}
+	if item.Event.TraceParent != "" {
+		req.Header.Set("traceparent", item.Event.TraceParent)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign computes the hex signature of a webhook body, for use by receivers
+// written in Go.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RunPromoter moves due retries back onto the delivery queue. Only the
+// elected leader runs it; ZREM still decides who claims a retry during a
+// leadership handover, so each is delivered once.
+func (d *Dispatcher) RunPromoter(ctx context.Context) {
+	ticker := time.NewTicker(promoteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		due, err := d.client.ZRangeByScore(ctx, webhookDelayed, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+			Count: 100,
+		}).Result()
+		if err != nil {
+			continue
+		}
+
+		for _, member := range due {
+			if removed, err := d.client.ZRem(ctx, webhookDelayed, member).Result(); err != nil || removed == 0 {
+				continue
+			}
+			if err := d.client.RPush(ctx, webhookQueue, member).Err(); err != nil {
+				d.logger.Error("Failed to requeue webhook retry", "error", err)
+			}
+		}
+	}
+}
+
+// CleanupDeadLetters removes dead-lettered deliveries older than the cutoff.
+// Entries are appended in order, so it stops at the first newer one.
+func (d *Dispatcher) CleanupDeadLetters(ctx context.Context, before time.Time) (int64, error) {
+	var removed int64
+	for {
+		head, err := d.client.LIndex(ctx, webhookDeadLetter, 0).Result()
+		if err == redis.Nil {
+			return removed, nil
+		}
+		if err != nil {
+			return removed, err
+		}
+
+		var item delivery
+		if err := json.Unmarshal([]byte(head), &item); err == nil && !item.DeadLetteredAt.Before(before) {
+			return removed, nil
+		}
+
+		n, err := d.client.LRem(ctx, webhookDeadLetter, 1, head).Result()
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+}